@@ -0,0 +1,293 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type failingSink struct{}
+
+func (f failingSink) Write(ctx context.Context, event *Event) error { return errors.New("failure") }
+func (f failingSink) Close() error                                  { return nil }
+
+// droppingSink fails to write the events of the given action, and stores the others
+type droppingSink struct {
+	MemorySink
+	action string
+}
+
+func (s *droppingSink) Write(ctx context.Context, event *Event) error {
+	if event.Action == s.action {
+		return errors.New("failure")
+	}
+	return s.MemorySink.Write(ctx, event)
+}
+
+// contextSink records the error of the context it receives
+type contextSink struct {
+	MemorySink
+	ctxErr error
+}
+
+func (s *contextSink) Write(ctx context.Context, event *Event) error {
+	s.ctxErr = ctx.Err()
+	return s.MemorySink.Write(ctx, event)
+}
+
+func readEvents(t *testing.T, data []byte) []*Event {
+	events := []*Event{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Unable to decode event line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, &event)
+	}
+	return events
+}
+
+func Test_Logger_WriterSink(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(false, NewWriterSink(&buffer))
+
+	logger.Log(context.Background(), &Event{Action: "UpdateUser", ActorID: "1234", ActorType: ActorUser, TargetUserID: "5678", Fields: []string{"emails"}})
+
+	events := readEvents(t, buffer.Bytes())
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if event.ID == "" || event.Time.IsZero() {
+		t.Fatalf("Event id and time should be set: %#v", event)
+	}
+	if event.Outcome != OutcomeSuccess {
+		t.Fatalf("Default outcome should be %q, got %q", OutcomeSuccess, event.Outcome)
+	}
+	if event.Action != "UpdateUser" || event.ActorID != "1234" || event.TargetUserID != "5678" || len(event.Fields) != 1 {
+		t.Fatalf("Unexpected event content: %#v", event)
+	}
+	if event.Hash != "" || event.PrevHash != "" {
+		t.Fatalf("Event should not be hashed when chaining is disabled: %#v", event)
+	}
+}
+
+func Test_Logger_HashChain(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(true, NewWriterSink(&buffer))
+
+	for _, action := range []string{"Login", "UpdateUser", "Logout"} {
+		logger.Log(context.Background(), &Event{Action: action, ActorType: ActorAnonymous})
+	}
+
+	events := readEvents(t, buffer.Bytes())
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	if events[0].PrevHash != "" || events[1].PrevHash != events[0].Hash || events[2].PrevHash != events[1].Hash {
+		t.Fatalf("Events are not chained: %#v", events)
+	}
+	if err := VerifyChain(events); err != nil {
+		t.Fatalf("Unexpected chain verification error: %v", err)
+	}
+
+	events[1].TargetUserID = "tampered"
+	if err := VerifyChain(events); err == nil {
+		t.Fatalf("Altered event should be detected")
+	}
+}
+
+func Test_VerifyChain_RemovedEvent(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(true, NewWriterSink(&buffer))
+	for _, action := range []string{"Login", "UpdateUser", "Logout"} {
+		logger.Log(context.Background(), &Event{Action: action, ActorType: ActorAnonymous})
+	}
+
+	events := readEvents(t, buffer.Bytes())
+	if err := VerifyChain([]*Event{events[0], events[2]}); err == nil {
+		t.Fatalf("Removed event should be detected")
+	}
+	if err := VerifyChain(events[1:]); err == nil {
+		t.Fatalf("Removed first event should be detected")
+	}
+	if err := VerifyChainFrom(events[0].Hash, events[1:]); err != nil {
+		t.Fatalf("Unexpected verification error of the chain end: %v", err)
+	}
+}
+
+func Test_VerifyChains_Replicas(t *testing.T) {
+	var buffer bytes.Buffer
+	sink := NewWriterSink(&buffer)
+	// two replicas (or two starts) writing to the same sink
+	first, second := NewLogger(true, sink), NewLogger(true, sink)
+	for _, action := range []string{"Login", "UpdateUser", "Logout"} {
+		first.Log(context.Background(), &Event{Action: action, ActorType: ActorAnonymous})
+		second.Log(context.Background(), &Event{Action: action, ActorType: ActorAnonymous})
+	}
+
+	events := readEvents(t, buffer.Bytes())
+	if events[0].ChainID == "" || events[0].ChainID == events[1].ChainID {
+		t.Fatalf("Each logger should have its own chain: %#v", events)
+	}
+	if err := VerifyChain(events); err == nil {
+		t.Fatalf("The interleaved chains are not one chain")
+	}
+	if err := VerifyChains(events); err != nil {
+		t.Fatalf("Unexpected chains verification error: %v", err)
+	}
+	if err := VerifyChains(append(events[:2], events[3:]...)); err == nil {
+		t.Fatalf("Removed event should be detected")
+	}
+}
+
+func Test_Logger_FailingSink(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewLogger(false, failingSink{}, NewWriterSink(&buffer))
+	logger.errorLog.SetOutput(ioutil.Discard)
+
+	logger.Log(context.Background(), &Event{Action: "Login"})
+
+	if events := readEvents(t, buffer.Bytes()); len(events) != 1 {
+		t.Fatalf("A failing sink should not prevent other sinks to receive the event")
+	}
+}
+
+func Test_Logger_ChainGap(t *testing.T) {
+	sink := &droppingSink{action: "UpdateUser"}
+	logger := NewLogger(true, sink)
+	logger.errorLog.SetOutput(ioutil.Discard)
+	for _, action := range []string{"Login", "UpdateUser", "Logout"} {
+		logger.Log(context.Background(), &Event{Action: action, ActorType: ActorAnonymous})
+	}
+
+	events := sink.Events()
+	if len(events) != 3 || events[1].Action != ActionChainGap || events[1].MissingHash != events[1].PrevHash {
+		t.Fatalf("Expected a gap event for the event not written: %#v", events)
+	}
+	if err := VerifyChains(events); err != nil {
+		t.Fatalf("The gap should be recorded in the chain: %v", err)
+	}
+	if err := VerifyChains([]*Event{events[0], events[2]}); err == nil {
+		t.Fatalf("An unrecorded gap should be detected")
+	}
+}
+
+func Test_Logger_DetachedContext(t *testing.T) {
+	sink := &contextSink{}
+	logger := NewLogger(false, sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	logger.Log(ctx, &Event{Action: "Logout"})
+
+	if sink.ctxErr != nil || len(sink.Events()) != 1 {
+		t.Fatalf("The sinks should not be written with the request context: %v", sink.ctxErr)
+	}
+}
+
+func Test_FileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	for i := 0; i < 2; i++ {
+		logger, err := NewLoggerFromConfig(&Config{Sinks: []string{"file"}, FilePath: path}, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		logger.Log(context.Background(), &Event{Action: "Login"})
+		if err := logger.Close(); err != nil {
+			t.Fatalf("Unexpected error on close: %v", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := readEvents(t, data); len(events) != 2 {
+		t.Fatalf("Expected the file to be appended, got %d events", len(events))
+	}
+}
+
+func Test_NewLoggerFromConfig_Errors(t *testing.T) {
+	if _, err := NewLoggerFromConfig(&Config{Sinks: []string{"unknown"}}, nil); err == nil {
+		t.Fatalf("Unknown sink should be rejected")
+	}
+	if _, err := NewLoggerFromConfig(&Config{Sinks: []string{"mongo"}}, nil); err == nil {
+		t.Fatalf("Mongo sink without store should be rejected")
+	}
+	if _, err := NewLoggerFromConfig(&Config{Sinks: []string{"file"}}, nil); err == nil {
+		t.Fatalf("File sink without path should be rejected")
+	}
+	if logger, err := NewLoggerFromConfig(&Config{}, nil); err != nil || len(logger.sinks) != 1 {
+		t.Fatalf("Expected a default stdout sink")
+	}
+}
+
+func Test_MemorySink_Find(t *testing.T) {
+	sink := NewMemorySink()
+	logger := NewLogger(false, sink)
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 5; i++ {
+		logger.Log(context.Background(), &Event{Action: "Login", TargetUserID: "1234", Time: start.Add(time.Duration(i) * time.Second)})
+		logger.Log(context.Background(), &Event{Action: "Login", TargetUserID: "5678", Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	if logger.Reader() == nil {
+		t.Fatalf("Memory sink should be a reader")
+	}
+
+	events, next, err := sink.Find(context.Background(), &Query{TargetUserID: "1234", Limit: 2})
+	if err != nil || len(events) != 2 || next == "" {
+		t.Fatalf("Unexpected first page: %d events, next %q, err %v", len(events), next, err)
+	}
+	if !events[0].Time.Equal(start) || !events[1].Time.Equal(start.Add(time.Second)) {
+		t.Fatalf("Events should be sorted by time")
+	}
+
+	all := events
+	for next != "" {
+		events, next, err = sink.Find(context.Background(), &Query{TargetUserID: "1234", Limit: 2, Cursor: next})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		all = append(all, events...)
+	}
+	if len(all) != 5 {
+		t.Fatalf("Expected 5 events through pagination, got %d", len(all))
+	}
+
+	events, _, _ = sink.Find(context.Background(), &Query{From: start.Add(time.Second), To: start.Add(3 * time.Second)})
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events in time range, got %d", len(events))
+	}
+
+	if _, _, err := sink.Find(context.Background(), &Query{Cursor: "not a cursor"}); err != ErrInvalidCursor {
+		t.Fatalf("Expected invalid cursor error, got %v", err)
+	}
+}
+
+func Test_WriteCSV(t *testing.T) {
+	var buffer bytes.Buffer
+	event := &Event{ID: "1", Time: time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC), ActorType: ActorServer, ActorID: "portal", TargetUserID: "1234", Action: "UpdateUser", Fields: []string{"emails", "username"}, Outcome: OutcomeSuccess}
+	if err := WriteCSV(&buffer, []*Event{event}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "id,time,actorId,actorType,targetUserId,action,fields,outcome,reason,traceId,remoteAddr,organizationId,organizationRole,roles,previousRoles,tenantId,chainId,prevHash,hash,missingHash,missingPrevHash\n" +
+		"1,2021-06-01T10:00:00Z,portal,server,1234,UpdateUser,emails;username,success,,,,,,,,,,,,,\n"
+	if buffer.String() != expected {
+		t.Fatalf("Unexpected CSV export:\n%s", buffer.String())
+	}
+}
@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// ActorUser is used when the action was performed with a user session token
+	ActorUser = "user"
+	// ActorServer is used when the action was performed with a server session token
+	ActorServer = "server"
+	// ActorAnonymous is used when no session token was involved (login, signup...)
+	ActorAnonymous = "anonymous"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+
+	// ActionChainGap is logged when a chained event could not be written to a sink
+	ActionChainGap = "AuditChainGap"
+)
+
+// Event is a structured audit trail entry
+type Event struct {
+	ID           string    `json:"id" bson:"_id"`
+	Time         time.Time `json:"time" bson:"time"`
+	ActorID      string    `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	ActorType    string    `json:"actorType" bson:"actorType"`
+	TargetUserID string    `json:"targetUserId,omitempty" bson:"targetUserId,omitempty"`
+	Action       string    `json:"action" bson:"action"`
+	Fields       []string  `json:"fields,omitempty" bson:"fields,omitempty"`
+	// Roles of the target user set by the action, and its PreviousRoles when they were changed
+	Roles         []string `json:"roles,omitempty" bson:"roles,omitempty"`
+	PreviousRoles []string `json:"previousRoles,omitempty" bson:"previousRoles,omitempty"`
+	// OrganizationID of the organization the action was performed on, and the OrganizationRole it gave
+	OrganizationID   string `json:"organizationId,omitempty" bson:"organizationId,omitempty"`
+	OrganizationRole string `json:"organizationRole,omitempty" bson:"organizationRole,omitempty"`
+	Outcome          string `json:"outcome" bson:"outcome"`
+	Reason           string `json:"reason,omitempty" bson:"reason,omitempty"`
+	TraceID          string `json:"traceId,omitempty" bson:"traceId,omitempty"`
+	RemoteAddr       string `json:"remoteAddr,omitempty" bson:"remoteAddr,omitempty"`
+	// TenantID of the tenant the action was performed on, empty for the default tenant
+	TenantID string `json:"tenantId,omitempty" bson:"tenantId,omitempty"`
+	// ChainID identifies the chain of the logger which logged the event when hash chaining is enabled:
+	// each process (and so each replica) starts its own chain
+	ChainID string `json:"chainId,omitempty" bson:"chainId,omitempty"`
+	// PrevHash is the hash of the previous event when hash chaining is enabled
+	PrevHash string `json:"prevHash,omitempty" bson:"prevHash,omitempty"`
+	// Hash of this event content (including PrevHash), empty when hash chaining is disabled
+	Hash string `json:"hash,omitempty" bson:"hash,omitempty"`
+	// MissingHash and MissingPrevHash of a gap event are the Hash and PrevHash of the event which could not be written
+	MissingHash     string `json:"missingHash,omitempty" bson:"missingHash,omitempty"`
+	MissingPrevHash string `json:"missingPrevHash,omitempty" bson:"missingPrevHash,omitempty"`
+}
+
+// ComputeHash returns the sha256 of the event content, the Hash field excluded
+func (e *Event) ComputeHash() (string, error) {
+	content := *e
+	content.Hash = ""
+	content.Time = content.Time.UTC()
+	data, err := json.Marshal(&content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyChain checks that the events of a chain, from its first one, have not been altered:
+// each event hash must match its content and reference the previous event hash,
+// or an event which could not be written and is recorded by a gap event of the list.
+// The first event must start the chain, see VerifyChainFrom to verify a part of a chain.
+func VerifyChain(events []*Event) error {
+	return VerifyChainFrom("", events)
+}
+
+// VerifyChainFrom checks the consecutive events of a chain following the event of hash prevHash
+func VerifyChainFrom(prevHash string, events []*Event) error {
+	missing := map[string]string{}
+	for _, event := range events {
+		if event.Action == ActionChainGap && event.MissingHash != "" {
+			missing[event.MissingHash] = event.MissingPrevHash
+		}
+	}
+
+	for index, event := range events {
+		hash, err := event.ComputeHash()
+		if err != nil {
+			return err
+		}
+		if hash != event.Hash {
+			return fmt.Errorf("audit event %s (index %d) hash mismatch", event.ID, index)
+		}
+		if !followsGaps(event.PrevHash, prevHash, missing) {
+			return fmt.Errorf("audit event %s (index %d) does not follow the previous event", event.ID, index)
+		}
+		prevHash = event.Hash
+	}
+	return nil
+}
+
+// followsGaps tells whether linkedHash is prevHash, possibly through events recorded as missing
+func followsGaps(linkedHash, prevHash string, missing map[string]string) bool {
+	for seen := 0; linkedHash != prevHash; seen++ {
+		missingPrevHash, found := missing[linkedHash]
+		if !found || seen > len(missing) {
+			return false
+		}
+		linkedHash = missingPrevHash
+	}
+	return true
+}
+
+// VerifyChains checks the events of several chains, e.g. the events of a sink shared by replicas
+// or written across restarts: the events are grouped by ChainID, ordered by time and id
+// (the order they were chained in), and each chain is verified.
+func VerifyChains(events []*Event) error {
+	chains := map[string][]*Event{}
+	chainIDs := []string{}
+	for _, event := range events {
+		if _, found := chains[event.ChainID]; !found {
+			chainIDs = append(chainIDs, event.ChainID)
+		}
+		chains[event.ChainID] = append(chains[event.ChainID], event)
+	}
+	for _, chainID := range chainIDs {
+		chain := chains[chainID]
+		sort.SliceStable(chain, func(i, j int) bool {
+			if !chain[i].Time.Equal(chain[j].Time) {
+				return chain[i].Time.Before(chain[j].Time)
+			}
+			return chain[i].ID < chain[j].ID
+		})
+		if err := VerifyChain(chain); err != nil {
+			return fmt.Errorf("audit chain %q: %v", chainID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// CSVHeader is the first line of a CSV export
+var CSVHeader = []string{"id", "time", "actorId", "actorType", "targetUserId", "action", "fields", "outcome", "reason", "traceId", "remoteAddr", "organizationId", "organizationRole", "roles", "previousRoles", "tenantId", "chainId", "prevHash", "hash", "missingHash", "missingPrevHash"}
+
+// WriteNDJSON writes the events as newline delimited JSON
+func WriteNDJSON(w io.Writer, events []*Event) error {
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes the events as CSV, the header line included
+func WriteCSV(w io.Writer, events []*Event) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(CSVHeader); err != nil {
+		return err
+	}
+	for _, event := range events {
+		record := []string{
+			event.ID,
+			event.Time.UTC().Format(time.RFC3339Nano),
+			event.ActorID,
+			event.ActorType,
+			event.TargetUserID,
+			event.Action,
+			strings.Join(event.Fields, ";"),
+			event.Outcome,
+			event.Reason,
+			event.TraceID,
+			event.RemoteAddr,
+			event.OrganizationID,
+			event.OrganizationRole,
+			strings.Join(event.Roles, ";"),
+			strings.Join(event.PreviousRoles, ";"),
+			event.TenantID,
+			event.ChainID,
+			event.PrevHash,
+			event.Hash,
+			event.MissingHash,
+			event.MissingPrevHash,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	goComMgo "github.com/tidepool-org/go-common/clients/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type (
+	// Config of the audit trail
+	Config struct {
+		// Sinks to write the events to: "stdout", "file" and/or "mongo"
+		Sinks []string `json:"sinks"`
+		// FilePath of the "file" sink
+		FilePath string `json:"filePath"`
+		// HashChain links each event to the previous one for tamper evidence
+		HashChain bool `json:"hashChain"`
+	}
+
+	// Logger dispatches the audit events to the configured sinks
+	Logger struct {
+		mutex    sync.Mutex
+		writes   sync.WaitGroup
+		sinks    []Sink
+		chain    bool
+		chainID  string
+		lastHash string
+		errorLog *log.Logger
+	}
+)
+
+// sinkWriteTimeout bounds the write of an event to a sink, independently of the request which logged it
+const sinkWriteTimeout = 10 * time.Second
+
+// NewLogger creates an audit logger writing to the given sinks
+func NewLogger(hashChain bool, sinks ...Sink) *Logger {
+	logger := &Logger{
+		sinks:    sinks,
+		chain:    hashChain,
+		errorLog: log.New(os.Stderr, "audit ", log.LstdFlags),
+	}
+	if hashChain {
+		// the chain of this process, which the replicas and the next starts do not continue
+		logger.chainID = primitive.NewObjectID().Hex()
+	}
+	return logger
+}
+
+// NewLoggerFromConfig creates an audit logger from the configuration.
+// The store is only needed by the "mongo" sink.
+func NewLoggerFromConfig(config *Config, store goComMgo.Storage) (*Logger, error) {
+	sinkNames := config.Sinks
+	if len(sinkNames) == 0 {
+		sinkNames = []string{"stdout"}
+	}
+	sinks := make([]Sink, 0, len(sinkNames))
+	for _, name := range sinkNames {
+		switch strings.TrimSpace(name) {
+		case "stdout":
+			sinks = append(sinks, NewStdoutSink())
+		case "file":
+			sink, err := NewFileSink(config.FilePath)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "mongo":
+			if store == nil {
+				return nil, fmt.Errorf("audit sink %q requires a store", name)
+			}
+			sinks = append(sinks, NewMongoSink(store))
+		default:
+			return nil, fmt.Errorf("unknown audit sink %q", name)
+		}
+	}
+	return NewLogger(config.HashChain, sinks...), nil
+}
+
+// Log completes the event (id, time, hash) and writes it to every sink.
+// A failing sink does not prevent the others from receiving the event.
+// The sinks are written outside of the lock, with their own timeout, so the events
+// of concurrent requests may reach them out of order (see VerifyChains).
+// When a chained event can not be written, a gap event referencing it is logged
+// so the chain can still be verified.
+func (l *Logger) Log(ctx context.Context, event *Event) {
+	l.mutex.Lock()
+	if event.ID == "" {
+		// ObjectIDs are increasing within the process, which keeps the events
+		// logged during the same millisecond in order
+		event.ID = primitive.NewObjectID().Hex()
+	}
+	if event.Time.IsZero() {
+		// Millisecond precision so the hash can still be verified once stored in Mongo
+		event.Time = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	if event.Outcome == "" {
+		event.Outcome = OutcomeSuccess
+	}
+	if l.chain {
+		event.ChainID = l.chainID
+		event.PrevHash = l.lastHash
+		hash, err := event.ComputeHash()
+		if err != nil {
+			l.errorLog.Printf("unable to hash event %s: %v", event.ID, err)
+		} else {
+			event.Hash = hash
+			l.lastHash = hash
+		}
+	}
+	l.writes.Add(1)
+	l.mutex.Unlock()
+	defer l.writes.Done()
+
+	writeCtx, cancel := context.WithTimeout(context.Background(), sinkWriteTimeout)
+	defer cancel()
+	var writeErr error
+	for _, sink := range l.sinks {
+		if err := sink.Write(writeCtx, event); err != nil {
+			l.errorLog.Printf("unable to write event %s: %v", event.ID, err)
+			writeErr = err
+		}
+	}
+
+	if writeErr != nil && event.Hash != "" && event.Action != ActionChainGap {
+		l.Log(ctx, &Event{
+			ActorType:       ActorServer,
+			Action:          ActionChainGap,
+			Outcome:         OutcomeFailure,
+			Reason:          fmt.Sprintf("event %s was not written: %v", event.ID, writeErr),
+			TenantID:        event.TenantID,
+			MissingHash:     event.Hash,
+			MissingPrevHash: event.PrevHash,
+		})
+	}
+}
+
+// Close waits for the pending writes and closes every sink
+func (l *Logger) Close() error {
+	l.writes.Wait()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var firstErr error
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
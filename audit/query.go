@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+type (
+	// Query selects the audit events to return, all criteria are optional but the tenant
+	Query struct {
+		// TenantID of the events, always matched: the events of the default tenant have none
+		TenantID     string
+		TargetUserID string
+		ActorID      string
+		Action       string
+		// OrganizationID of the organization the actions were performed on
+		OrganizationID string
+		From           time.Time
+		To             time.Time
+		// Cursor returned by a previous query, to get the next page
+		Cursor string
+		Limit  int
+	}
+
+	// Reader is implemented by the sinks from which the events can be queried back
+	Reader interface {
+		// Find returns the events matching the query in chronological order,
+		// and the cursor of the next page (empty on the last page)
+		Find(ctx context.Context, query *Query) ([]*Event, string, error)
+	}
+
+	// cursor is the position of the last returned event
+	cursor struct {
+		time time.Time
+		id   string
+	}
+)
+
+var (
+	ErrInvalidCursor = errors.New("audit: invalid cursor")
+)
+
+// Reader returns the first sink events can be queried from, nil if none
+func (l *Logger) Reader() Reader {
+	for _, sink := range l.sinks {
+		if reader, ok := sink.(Reader); ok {
+			return reader
+		}
+	}
+	return nil
+}
+
+func (q *Query) limit() int {
+	if q.Limit <= 0 {
+		return DefaultQueryLimit
+	}
+	if q.Limit > MaxQueryLimit {
+		return MaxQueryLimit
+	}
+	return q.Limit
+}
+
+func encodeCursor(event *Event) string {
+	value := strconv.FormatInt(event.Time.UnixNano()/int64(time.Millisecond), 10) + "|" + event.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func decodeCursor(value string) (*cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(decoded), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, ErrInvalidCursor
+	}
+	millis, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor{time: time.Unix(0, millis*int64(time.Millisecond)).UTC(), id: parts[1]}, nil
+}
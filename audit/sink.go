@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	goComMgo "github.com/tidepool-org/go-common/clients/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AUDIT_COLLECTION = "audit"
+)
+
+type (
+	// Sink is where the audit events are written to
+	Sink interface {
+		Write(ctx context.Context, event *Event) error
+		Close() error
+	}
+
+	// WriterSink writes the events as JSON lines
+	WriterSink struct {
+		mutex  sync.Mutex
+		writer io.Writer
+	}
+
+	// FileSink appends the events as JSON lines to a file
+	FileSink struct {
+		WriterSink
+		file *os.File
+	}
+
+	// MongoSink stores the events in the audit collection
+	MongoSink struct {
+		store goComMgo.Storage
+	}
+
+	// MemorySink keeps the events in memory, for tests and development
+	MemorySink struct {
+		mutex  sync.RWMutex
+		events []*Event
+	}
+)
+
+// NewWriterSink creates a sink writing JSON lines to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{writer: w}
+}
+
+// NewStdoutSink creates a sink writing JSON lines to the standard output
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink(os.Stdout)
+}
+
+func (s *WriterSink) Write(ctx context.Context, event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.writer.Write(data)
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// NewFileSink opens (or creates) the file at path in append mode
+func NewFileSink(path string) (*FileSink, error) {
+	if path == "" {
+		return nil, errors.New("audit file path is missing")
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{WriterSink: WriterSink{writer: file}, file: file}, nil
+}
+
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+// NewMemorySink creates an empty in memory sink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{events: []*Event{}}
+}
+
+func (s *MemorySink) Write(ctx context.Context, event *Event) error {
+	stored := *event
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, &stored)
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// Events returns a copy of all the stored events
+func (s *MemorySink) Events() []*Event {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	events := make([]*Event, len(s.events))
+	copy(events, s.events)
+	return events
+}
+
+// Find implements Reader
+func (s *MemorySink) Find(ctx context.Context, query *Query) ([]*Event, string, error) {
+	var after *cursor
+	if query.Cursor != "" {
+		var err error
+		if after, err = decodeCursor(query.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	s.mutex.RLock()
+	candidates := make([]*Event, len(s.events))
+	copy(candidates, s.events)
+	s.mutex.RUnlock()
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Time.Equal(candidates[j].Time) {
+			return candidates[i].ID < candidates[j].ID
+		}
+		return candidates[i].Time.Before(candidates[j].Time)
+	})
+
+	limit := query.limit()
+	events := []*Event{}
+	for _, event := range candidates {
+		switch {
+		case event.TenantID != query.TenantID:
+		case query.TargetUserID != "" && event.TargetUserID != query.TargetUserID:
+		case query.ActorID != "" && event.ActorID != query.ActorID:
+		case query.Action != "" && event.Action != query.Action:
+		case query.OrganizationID != "" && event.OrganizationID != query.OrganizationID:
+		case !query.From.IsZero() && event.Time.Before(query.From):
+		case !query.To.IsZero() && !event.Time.Before(query.To):
+		case after != nil && (event.Time.Before(after.time) || (event.Time.Equal(after.time) && event.ID <= after.id)):
+		default:
+			if len(events) == limit {
+				return events, encodeCursor(events[limit-1]), nil
+			}
+			events = append(events, event)
+		}
+	}
+	return events, "", nil
+}
+
+// NewMongoSink creates a sink storing the events in the audit collection of the store
+func NewMongoSink(store goComMgo.Storage) *MongoSink {
+	return &MongoSink{store: store}
+}
+
+func (s *MongoSink) Write(ctx context.Context, event *Event) error {
+	collection := s.store.Collection(AUDIT_COLLECTION)
+	if collection == nil {
+		return errors.New("audit collection is not available")
+	}
+	_, err := collection.InsertOne(ctx, event)
+	return err
+}
+
+// Close does nothing, the store lifecycle is managed by its owner
+func (s *MongoSink) Close() error {
+	return nil
+}
+
+// Find implements Reader
+func (s *MongoSink) Find(ctx context.Context, query *Query) ([]*Event, string, error) {
+	collection := s.store.Collection(AUDIT_COLLECTION)
+	if collection == nil {
+		return nil, "", errors.New("audit collection is not available")
+	}
+
+	// the events of the default tenant have no tenantId, which is matched by null
+	filter := bson.M{"tenantId": nil}
+	if query.TenantID != "" {
+		filter["tenantId"] = query.TenantID
+	}
+	if query.TargetUserID != "" {
+		filter["targetUserId"] = query.TargetUserID
+	}
+	if query.ActorID != "" {
+		filter["actorId"] = query.ActorID
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	if query.OrganizationID != "" {
+		filter["organizationId"] = query.OrganizationID
+	}
+	timeFilter := bson.M{}
+	if !query.From.IsZero() {
+		timeFilter["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		timeFilter["$lt"] = query.To
+	}
+	if len(timeFilter) > 0 {
+		filter["time"] = timeFilter
+	}
+	if query.Cursor != "" {
+		after, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		filter["$or"] = []bson.M{
+			{"time": bson.M{"$gt": after.time}},
+			{"time": after.time, "_id": bson.M{"$gt": after.id}},
+		}
+	}
+
+	limit := query.limit()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit + 1))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	events := []*Event{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if len(events) > limit {
+		events = events[:limit]
+		next = encodeCursor(events[limit-1])
+	}
+	return events, next, nil
+}
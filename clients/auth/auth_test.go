@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -60,7 +61,7 @@ func TestAuthenticate(t *testing.T) {
 	if err2 != nil {
 		t.Errorf("Authenticate should not fail, error:%v", err2)
 	}
-	if *tkn != tknData {
+	if !reflect.DeepEqual(*tkn, tknData) {
 		t.Error("Unexpected token returned")
 	}
 
@@ -0,0 +1,85 @@
+package shoreline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/mdblp/shoreline/schema"
+	"github.com/tidepool-org/go-common/clients/status"
+)
+
+const (
+	// acceptHeader asks the service for RFC 7807 error responses
+	acceptHeader = "application/json, application/problem+json"
+	// usersNextCursorHeader holds the cursor of the next page of users
+	usersNextCursorHeader = "x-users-next-cursor"
+	// externalSessionTokenHeader holds the 3rd party tokens
+	externalSessionTokenHeader = "x-external-session-token"
+)
+
+// Error is returned when the service answers with an error.
+// Callers can switch on ErrorCode, or use errors.Is with the Err* values below.
+type Error struct {
+	status.Status
+	// ErrorCode is one of the schema.Error* codes, empty if the service did not send one
+	ErrorCode string
+	Details   map[string]interface{}
+}
+
+// The errors the callers are the most likely to handle, matched by code
+var (
+	ErrUnauthorized       = &Error{ErrorCode: schema.ErrorUnauthorized}
+	ErrInvalidToken       = &Error{ErrorCode: schema.ErrorInvalidToken}
+	ErrPasswordMismatch   = &Error{ErrorCode: schema.ErrorPasswordMismatch}
+	ErrAccountLocked      = &Error{ErrorCode: schema.ErrorAccountLocked}
+	ErrEmailNotVerified   = &Error{ErrorCode: schema.ErrorEmailNotVerified}
+	ErrTooManyLogins      = &Error{ErrorCode: schema.ErrorTooManyLogins}
+	ErrUserNotFound       = &Error{ErrorCode: schema.ErrorUserNotFound}
+	ErrUserAlreadyExists  = &Error{ErrorCode: schema.ErrorUserAlreadyExists}
+	ErrInvalidUserDetails = &Error{ErrorCode: schema.ErrorInvalidUserDetails}
+)
+
+func (e *Error) Error() string {
+	if e.ErrorCode == "" {
+		return e.Status.String()
+	}
+	return fmt.Sprintf("%s (%s)", e.Status.String(), e.ErrorCode)
+}
+
+// Is reports whether both errors have the same code
+func (e *Error) Is(target error) bool {
+	other, ok := target.(*Error)
+	return ok && other.ErrorCode != "" && other.ErrorCode == e.ErrorCode
+}
+
+// DecodeError returns the *Error of an error response of the service,
+// for the callers which do not go through the Client (e.g. the in-process user.UserClient)
+func DecodeError(res *http.Response) error {
+	return decodeError(res, res.Request)
+}
+
+// decodeError reads the error response of the service.
+// Responses without a readable body keep the generic reason.
+func decodeError(res *http.Response, req *http.Request) error {
+	err := &Error{Status: status.NewStatus(res.StatusCode, "Unknown response code from service")}
+	if req != nil {
+		err.Reason = fmt.Sprintf("Unknown response code from service[%s]", req.URL)
+	}
+
+	body, readErr := ioutil.ReadAll(res.Body)
+	if readErr != nil || len(body) == 0 {
+		return err
+	}
+	var problem schema.Problem
+	if json.Unmarshal(body, &problem) != nil {
+		return err
+	}
+	if message := problem.Message(); message != "" {
+		err.Reason = message
+	}
+	err.ErrorCode = problem.ErrorCode
+	err.Details = problem.Details
+	return err
+}
@@ -0,0 +1,173 @@
+package shoreline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the service while the circuit breaker is open
+var ErrCircuitOpen = errors.New("shoreline circuit breaker is open")
+
+// Defaults of the resilience settings of the client config
+const (
+	defaultMaxRetries       = 2
+	defaultRetryBaseDelay   = 100 * time.Millisecond
+	defaultRetryMaxDelay    = 2 * time.Second
+	defaultRequestTimeout   = 10 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// circuitBreaker stops calling the service after threshold consecutive failures.
+// Once the cooldown is over a single trial call is let through: its success closes the circuit,
+// its failure opens it again for another cooldown.
+type circuitBreaker struct {
+	mut       sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// allow returns false while the circuit is open, or when the trial call is already in flight
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.failures = 0
+	b.trial = false
+}
+
+// abort releases the trial call which was cancelled by the caller, without judging the service
+func (b *circuitBreaker) abort() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.trial = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.failures++
+	b.trial = false
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// isTransient returns true for the service responses worth retrying
+func isTransient(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError && statusCode != http.StatusNotImplemented
+}
+
+// backoff returns the delay before the given retry (starting at 1): a random duration
+// up to the base delay doubled at each retry, capped to the max delay ("full jitter")
+func backoff(retry int, baseDelay, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if retry < 32 && baseDelay<<uint(retry-1) < maxDelay {
+		delay = baseDelay << uint(retry-1)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// cancelBody releases the timeout of the call when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// do sends the request built by newRequest through the circuit breaker, with the per-call timeout.
+// The idempotent calls are retried with a jittered exponential backoff on transport errors and transient responses;
+// the response of the last attempt is returned, the caller must close its body.
+func (client *Client) do(ctx context.Context, idempotent bool, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	attempts := 1
+	if idempotent {
+		attempts += client.config.MaxRetries
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			timer := time.NewTimer(backoff(attempt-1, time.Duration(client.config.RetryBaseDelay), time.Duration(client.config.RetryMaxDelay)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if !client.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		callCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout := time.Duration(client.config.RequestTimeout); timeout > 0 {
+			callCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		req, err := newRequest(callCtx)
+		if err != nil {
+			cancel()
+			client.breaker.abort()
+			return nil, err
+		}
+
+		res, err := client.httpClient.Do(req)
+		if err != nil {
+			cancel()
+			if ctx.Err() != nil {
+				client.breaker.abort()
+				return nil, ctx.Err()
+			}
+			client.breaker.failure()
+			lastErr = err
+			continue
+		}
+		if !isTransient(res.StatusCode) {
+			client.breaker.success()
+			res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+			return res, nil
+		}
+
+		client.breaker.failure()
+		if attempt == attempts {
+			res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+			return res, nil
+		}
+		// drain the body so that the connection is reused by the next attempt
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		cancel()
+	}
+	return nil, lastErr
+}
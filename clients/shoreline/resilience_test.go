@@ -0,0 +1,141 @@
+package shoreline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mdblp/shoreline/schema"
+)
+
+var _ ClientInterface = &Client{}
+var _ ClientInterface = &ShorelineMockClient{}
+
+func newResilienceTestClient(url string) *ClientBuilder {
+	return NewShorelineClientBuilder().
+		WithHost(url).
+		WithName(name).
+		WithSecret(secret).
+		WithRetries(2, time.Millisecond, 5*time.Millisecond)
+}
+
+func TestBackoff(t *testing.T) {
+	for retry := 1; retry < 40; retry++ {
+		maxDelay := 100 * time.Millisecond << uint(retry-1)
+		if maxDelay > time.Second || maxDelay <= 0 {
+			maxDelay = time.Second
+		}
+		if delay := backoff(retry, 100*time.Millisecond, time.Second); delay < 0 || delay > maxDelay {
+			t.Fatalf("Unexpected delay %v for retry %d", delay, retry)
+		}
+	}
+	if delay := backoff(1, 0, 0); delay != 0 {
+		t.Fatalf("Unexpected delay %v without delays", delay)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls int32
+	srvr := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/user/1234abc":
+			if atomic.AddInt32(&calls, 1) < 3 {
+				res.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(res, `{"userid": "1234abc", "username": "billy"}`)
+		case "/login", "/user/5678":
+			atomic.AddInt32(&calls, 1)
+			res.WriteHeader(http.StatusBadGateway)
+		default:
+			t.Errorf("Unknown path[%s]", req.URL.Path)
+		}
+	}))
+	defer srvr.Close()
+	client := newResilienceTestClient(srvr.URL).Build()
+
+	ud, err := client.GetUser("1234abc", TOKEN)
+	if err != nil || ud.UserID != "1234abc" || calls != 3 {
+		t.Fatalf("The user should be found after 2 retries, got [%v] [%v] after %d calls", ud, err, calls)
+	}
+
+	calls = 0
+	err = client.UpdateUserContext(context.Background(), "5678", schema.UserUpdate{}, TOKEN)
+	var shorelineErr *Error
+	if !errors.As(err, &shorelineErr) || shorelineErr.Code != http.StatusBadGateway || calls != 3 {
+		t.Fatalf("The last error should be returned after 2 retries, got [%v] after %d calls", err, calls)
+	}
+
+	calls = 0
+	if _, _, err = client.Login("billy", "howdy"); !errors.As(err, &shorelineErr) || calls != 1 {
+		t.Fatalf("The login should not be retried, got [%v] after %d calls", err, calls)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls int32
+	var healthy atomic.Value
+	healthy.Store(false)
+	srvr := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if !healthy.Load().(bool) {
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(res, `{"userid": "1234abc"}`)
+	}))
+	defer srvr.Close()
+	client := newResilienceTestClient(srvr.URL).
+		WithRetries(0, 0, 0).
+		WithCircuitBreaker(2, 50*time.Millisecond).
+		Build()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetUser("1234abc", TOKEN); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Unexpected error [%v]", err)
+		}
+	}
+	if _, err := client.GetUser("1234abc", TOKEN); !errors.Is(err, ErrCircuitOpen) || calls != 2 {
+		t.Fatalf("The circuit should be open, got [%v] after %d calls", err, calls)
+	}
+
+	healthy.Store(true)
+	time.Sleep(60 * time.Millisecond)
+	if ud, err := client.GetUser("1234abc", TOKEN); err != nil || ud.UserID != "1234abc" {
+		t.Fatalf("The trial call should succeed after the cooldown, got [%v] [%v]", ud, err)
+	}
+	if _, err := client.GetUser("1234abc", TOKEN); err != nil || calls != 4 {
+		t.Fatalf("The circuit should be closed, got [%v] after %d calls", err, calls)
+	}
+}
+
+func TestContextAndTimeout(t *testing.T) {
+	srvr := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srvr.Close()
+
+	client := newResilienceTestClient(srvr.URL).WithRequestTimeout(20 * time.Millisecond).Build()
+	start := time.Now()
+	if _, err := client.GetUser("1234abc", TOKEN); err == nil || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("Each call should time out, got [%v] after %v", err, time.Since(start))
+	}
+
+	client = newResilienceTestClient(srvr.URL).WithRequestTimeout(0).Build()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetUserContext(ctx, "1234abc", TOKEN); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("The call should be cancelled with its context, got [%v]", err)
+	}
+	if !client.breaker.allow() || client.breaker.failures != 0 {
+		t.Fatalf("The cancelled calls should not open the circuit")
+	}
+}
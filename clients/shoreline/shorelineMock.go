@@ -1,7 +1,9 @@
 package shoreline
 
 import (
+	"context"
 	"log"
+	"net/url"
 	"strings"
 
 	"github.com/mdblp/shoreline/schema"
@@ -69,3 +71,91 @@ func (client *ShorelineMockClient) GetUser(userID, token string) (*schema.UserDa
 func (client *ShorelineMockClient) UpdateUser(userID string, userUpdate schema.UserUpdate, token string) error {
 	return nil
 }
+
+func (client *ShorelineMockClient) GetUsers(query schema.UsersQuery, token string) (*schema.UsersPage, error) {
+	users := []*schema.UserData{}
+	for _, userID := range query.IDs {
+		if user, _ := client.GetUser(userID, token); user != nil {
+			users = append(users, user)
+		}
+	}
+	return &schema.UsersPage{Users: users}, nil
+}
+
+func (client *ShorelineMockClient) DeleteUser(userID, password, token string) error {
+	return nil
+}
+
+func (client *ShorelineMockClient) LongtermLogin(username, password, longtermKey string) (*schema.UserData, string, error) {
+	return client.Login(username, password)
+}
+
+func (client *ShorelineMockClient) RefreshSession(tkn string) (string, *token.TokenData, error) {
+	if client.Unauthorized {
+		return "", nil, ErrUnauthorized
+	}
+	return client.ServerToken, client.CheckToken(tkn), nil
+}
+
+func (client *ShorelineMockClient) Logout(tkn string) error {
+	return nil
+}
+
+func (client *ShorelineMockClient) ExternalToken(service, tkn string) (string, error) {
+	if client.Unauthorized {
+		return "", ErrUnauthorized
+	}
+	return client.ServerToken, nil
+}
+
+func (client *ShorelineMockClient) AnonymousIdHashPair(params url.Values) (*schema.IdHashPair, error) {
+	return &schema.IdHashPair{ID: "0123456789", Hash: "0123456789abcdef01234567"}, nil
+}
+
+func (client *ShorelineMockClient) LoginContext(ctx context.Context, username, password string) (*schema.UserData, string, error) {
+	return client.Login(username, password)
+}
+
+func (client *ShorelineMockClient) SignupContext(ctx context.Context, username, password, email string) (*schema.UserData, error) {
+	return client.Signup(username, password, email)
+}
+
+func (client *ShorelineMockClient) CheckTokenContext(ctx context.Context, tkn string) *token.TokenData {
+	return client.CheckToken(tkn)
+}
+
+func (client *ShorelineMockClient) GetUserContext(ctx context.Context, userID, token string) (*schema.UserData, error) {
+	return client.GetUser(userID, token)
+}
+
+func (client *ShorelineMockClient) UpdateUserContext(ctx context.Context, userID string, userUpdate schema.UserUpdate, token string) error {
+	return client.UpdateUser(userID, userUpdate, token)
+}
+
+func (client *ShorelineMockClient) GetUsersContext(ctx context.Context, query schema.UsersQuery, token string) (*schema.UsersPage, error) {
+	return client.GetUsers(query, token)
+}
+
+func (client *ShorelineMockClient) DeleteUserContext(ctx context.Context, userID, password, token string) error {
+	return client.DeleteUser(userID, password, token)
+}
+
+func (client *ShorelineMockClient) LongtermLoginContext(ctx context.Context, username, password, longtermKey string) (*schema.UserData, string, error) {
+	return client.LongtermLogin(username, password, longtermKey)
+}
+
+func (client *ShorelineMockClient) RefreshSessionContext(ctx context.Context, tkn string) (string, *token.TokenData, error) {
+	return client.RefreshSession(tkn)
+}
+
+func (client *ShorelineMockClient) LogoutContext(ctx context.Context, tkn string) error {
+	return client.Logout(tkn)
+}
+
+func (client *ShorelineMockClient) ExternalTokenContext(ctx context.Context, service, tkn string) (string, error) {
+	return client.ExternalToken(service, tkn)
+}
+
+func (client *ShorelineMockClient) AnonymousIdHashPairContext(ctx context.Context, params url.Values) (*schema.IdHashPair, error) {
+	return client.AnonymousIdHashPair(params)
+}
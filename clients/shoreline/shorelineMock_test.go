@@ -1,6 +1,7 @@
 package shoreline
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/mdblp/shoreline/schema"
@@ -65,4 +66,17 @@ func TestMock(t *testing.T) {
 		t.Errorf("Signup not return err[%s]", se.Error())
 	}
 
+	if page, err := client.GetUsers(schema.UsersQuery{IDs: []string{"billy@howdy.org", "NotFound"}}, tokenMock); err != nil || len(page.Users) != 1 {
+		t.Errorf("Should give us the found mock users [%v]", page)
+	}
+
+	if tok, td, err := client.RefreshSession(tokenMock); err != nil || tok != tokenMock || td == nil {
+		t.Error("Should give us a refreshed token")
+	}
+
+	client.Unauthorized = true
+	if _, err := client.ExternalToken("zendesk", tokenMock); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Should not give an external token to an unauthorized client [%v]", err)
+	}
+
 }
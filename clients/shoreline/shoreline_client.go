@@ -2,6 +2,7 @@ package shoreline
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"sync"
 	"time"
 
@@ -30,12 +32,35 @@ type (
 		TokenProvide() string
 		GetUser(userID, token string) (*schema.UserData, error)
 		UpdateUser(userID string, userUpdate schema.UserUpdate, token string) error
+		GetUsers(query schema.UsersQuery, token string) (*schema.UsersPage, error)
+		DeleteUser(userID, password, token string) error
+		LongtermLogin(username, password, longtermKey string) (*schema.UserData, string, error)
+		RefreshSession(token string) (string, *token.TokenData, error)
+		Logout(token string) error
+		ExternalToken(service, token string) (string, error)
+		AnonymousIdHashPair(params url.Values) (*schema.IdHashPair, error)
+
+		// Context-aware variants of the calls above, cancelled with their context
+		LoginContext(ctx context.Context, username, password string) (*schema.UserData, string, error)
+		SignupContext(ctx context.Context, username, password, email string) (*schema.UserData, error)
+		CheckTokenContext(ctx context.Context, token string) *token.TokenData
+		GetUserContext(ctx context.Context, userID, token string) (*schema.UserData, error)
+		UpdateUserContext(ctx context.Context, userID string, userUpdate schema.UserUpdate, token string) error
+		GetUsersContext(ctx context.Context, query schema.UsersQuery, token string) (*schema.UsersPage, error)
+		DeleteUserContext(ctx context.Context, userID, password, token string) error
+		LongtermLoginContext(ctx context.Context, username, password, longtermKey string) (*schema.UserData, string, error)
+		RefreshSessionContext(ctx context.Context, token string) (string, *token.TokenData, error)
+		LogoutContext(ctx context.Context, token string) error
+		ExternalTokenContext(ctx context.Context, service, token string) (string, error)
+		AnonymousIdHashPairContext(ctx context.Context, params url.Values) (*schema.IdHashPair, error)
 	}
 
 	Client struct {
 		host       string        // host url
 		httpClient *http.Client  // store a reference to the http client so we can reuse it
 		config     *ClientConfig // Configuration for the client
+		breaker    *circuitBreaker
+		tokenCache *tokenCache // nil when the token cache is disabled
 
 		mut            sync.Mutex
 		serverToken    string         // stores the most recently received server token
@@ -51,17 +76,34 @@ type (
 	}
 
 	ClientConfig struct {
-		Name                 string          `json:"name"`                 // The name of this server for use in obtaining a server token
-		Secret               string          `json:"secret"`               // The secret used along with the name to obtain a server token
-		TokenRefreshInterval jepson.Duration `json:"tokenRefreshInterval"` // The amount of time between refreshes of the server token
-		TokenGetInterval     time.Duration   `json:"tokenGetInterval"`     // The amount of time between attempts to get the server token
+		Name                  string          `json:"name"`                  // The name of this server for use in obtaining a server token
+		Secret                string          `json:"secret"`                // The secret used along with the name to obtain a server token
+		TokenRefreshInterval  jepson.Duration `json:"tokenRefreshInterval"`  // The amount of time between refreshes of the server token
+		TokenGetInterval      time.Duration   `json:"tokenGetInterval"`      // The amount of time between attempts to get the server token
+		MaxRetries            int             `json:"maxRetries"`            // The number of retries of the idempotent calls on transient errors
+		RetryBaseDelay        jepson.Duration `json:"retryBaseDelay"`        // The maximum delay before the first retry, doubled at each retry
+		RetryMaxDelay         jepson.Duration `json:"retryMaxDelay"`         // The maximum delay between two retries
+		RequestTimeout        jepson.Duration `json:"requestTimeout"`        // The timeout of each call to the service, none when 0
+		BreakerThreshold      int             `json:"breakerThreshold"`      // The number of consecutive failures opening the circuit breaker, disabled when 0
+		BreakerCooldown       jepson.Duration `json:"breakerCooldown"`       // The amount of time the circuit breaker stays open before a trial call
+		TokenCacheSize        int             `json:"tokenCacheSize"`        // The number of checked tokens kept in cache, disabled when 0
+		TokenCacheTTL         jepson.Duration `json:"tokenCacheTTL"`         // The amount of time a valid token is cached, bounded by its expiration
+		TokenCacheNegativeTTL jepson.Duration `json:"tokenCacheNegativeTTL"` // The amount of time an invalid token is cached
 	}
 )
 
 func NewShorelineClientBuilder() *ClientBuilder {
 	return &ClientBuilder{
 		config: &ClientConfig{
-			TokenRefreshInterval: jepson.Duration(6 * time.Hour),
+			TokenRefreshInterval:  jepson.Duration(6 * time.Hour),
+			MaxRetries:            defaultMaxRetries,
+			RetryBaseDelay:        jepson.Duration(defaultRetryBaseDelay),
+			RetryMaxDelay:         jepson.Duration(defaultRetryMaxDelay),
+			RequestTimeout:        jepson.Duration(defaultRequestTimeout),
+			BreakerThreshold:      defaultBreakerThreshold,
+			BreakerCooldown:       jepson.Duration(defaultBreakerCooldown),
+			TokenCacheTTL:         jepson.Duration(defaultTokenCacheTTL),
+			TokenCacheNegativeTTL: jepson.Duration(defaultTokenCacheNegativeTTL),
 		},
 	}
 }
@@ -102,9 +144,64 @@ func (b *ClientBuilder) WithTokenGetInterval(val time.Duration) *ClientBuilder {
 	return b
 }
 
-// WithConfig sets the whole config
+// WithRetries sets the number of retries of the idempotent calls and their backoff delays (config), 0 disables the retries
+func (b *ClientBuilder) WithRetries(maxRetries int, baseDelay, maxDelay time.Duration) *ClientBuilder {
+	b.config.MaxRetries = maxRetries
+	b.config.RetryBaseDelay = jepson.Duration(baseDelay)
+	b.config.RetryMaxDelay = jepson.Duration(maxDelay)
+	return b
+}
+
+// WithRequestTimeout sets the timeout of each call to the service (config), 0 disables it
+func (b *ClientBuilder) WithRequestTimeout(val time.Duration) *ClientBuilder {
+	b.config.RequestTimeout = jepson.Duration(val)
+	return b
+}
+
+// WithCircuitBreaker sets the consecutive failures opening the circuit breaker and its cooldown (config), a 0 threshold disables it
+func (b *ClientBuilder) WithCircuitBreaker(threshold int, cooldown time.Duration) *ClientBuilder {
+	b.config.BreakerThreshold = threshold
+	b.config.BreakerCooldown = jepson.Duration(cooldown)
+	return b
+}
+
+// WithTokenCache sets the number of checked tokens kept in cache and how long the valid and invalid ones are kept (config), a 0 size disables the cache
+func (b *ClientBuilder) WithTokenCache(size int, ttl, negativeTTL time.Duration) *ClientBuilder {
+	b.config.TokenCacheSize = size
+	b.config.TokenCacheTTL = jepson.Duration(ttl)
+	b.config.TokenCacheNegativeTTL = jepson.Duration(negativeTTL)
+	return b
+}
+
+// WithConfig sets the whole config, the unset resilience settings keep their defaults
 func (b *ClientBuilder) WithConfig(val *ClientConfig) *ClientBuilder {
-	return b.WithName(val.Name).WithSecret(val.Secret).WithTokenRefreshInterval(time.Duration(val.TokenRefreshInterval)).WithTokenGetInterval(val.TokenGetInterval)
+	b.WithName(val.Name).WithSecret(val.Secret).WithTokenRefreshInterval(time.Duration(val.TokenRefreshInterval)).WithTokenGetInterval(val.TokenGetInterval)
+	if val.MaxRetries != 0 {
+		b.config.MaxRetries = val.MaxRetries
+	}
+	if val.RetryBaseDelay != 0 {
+		b.config.RetryBaseDelay = val.RetryBaseDelay
+	}
+	if val.RetryMaxDelay != 0 {
+		b.config.RetryMaxDelay = val.RetryMaxDelay
+	}
+	if val.RequestTimeout != 0 {
+		b.config.RequestTimeout = val.RequestTimeout
+	}
+	if val.BreakerThreshold != 0 {
+		b.config.BreakerThreshold = val.BreakerThreshold
+	}
+	if val.BreakerCooldown != 0 {
+		b.config.BreakerCooldown = val.BreakerCooldown
+	}
+	b.config.TokenCacheSize = val.TokenCacheSize
+	if val.TokenCacheTTL != 0 {
+		b.config.TokenCacheTTL = val.TokenCacheTTL
+	}
+	if val.TokenCacheNegativeTTL != 0 {
+		b.config.TokenCacheNegativeTTL = val.TokenCacheNegativeTTL
+	}
+	return b
 }
 
 // Build return client from builder
@@ -123,10 +220,17 @@ func (b *ClientBuilder) Build() *Client {
 		b.httpClient = http.DefaultClient
 	}
 
+	var cache *tokenCache
+	if b.config.TokenCacheSize > 0 {
+		cache = newTokenCache(b.config.TokenCacheSize, time.Duration(b.config.TokenCacheTTL), time.Duration(b.config.TokenCacheNegativeTTL))
+	}
+
 	return &Client{
+		tokenCache: cache,
 		httpClient: b.httpClient,
 		host:       b.host,
 		config:     b.config,
+		breaker:    &circuitBreaker{threshold: b.config.BreakerThreshold, cooldown: time.Duration(b.config.BreakerCooldown)},
 
 		closed: make(chan chan bool),
 	}
@@ -142,13 +246,32 @@ func NewShorelineClientFromEnv(httpClient *http.Client) *Client {
 	tokenGetInterval, _ := os.LookupEnv("SHORELINE_TOKEN_GET_INTERVAL")
 	tokenRefreshDuration, _ := time.ParseDuration(tokenRefreshInterval)
 	tokenGetDuration, _ := time.ParseDuration(tokenGetInterval)
-	return builder.WithHost(host).
+	builder.WithHost(host).
 		WithHTTPClient(httpClient).
 		WithName(name).
 		WithSecret(secret).
 		WithTokenRefreshInterval(tokenRefreshDuration).
-		WithTokenGetInterval(tokenGetDuration).
-		Build()
+		WithTokenGetInterval(tokenGetDuration)
+	// the resilience settings keep their defaults when not set
+	if maxRetries, err := strconv.Atoi(os.Getenv("SHORELINE_MAX_RETRIES")); err == nil {
+		builder.config.MaxRetries = maxRetries
+	}
+	if requestTimeout, err := time.ParseDuration(os.Getenv("SHORELINE_REQUEST_TIMEOUT")); err == nil {
+		builder.WithRequestTimeout(requestTimeout)
+	}
+	if breakerThreshold, err := strconv.Atoi(os.Getenv("SHORELINE_BREAKER_THRESHOLD")); err == nil {
+		builder.config.BreakerThreshold = breakerThreshold
+	}
+	if breakerCooldown, err := time.ParseDuration(os.Getenv("SHORELINE_BREAKER_COOLDOWN")); err == nil {
+		builder.config.BreakerCooldown = jepson.Duration(breakerCooldown)
+	}
+	if tokenCacheSize, err := strconv.Atoi(os.Getenv("SHORELINE_TOKEN_CACHE_SIZE")); err == nil {
+		builder.config.TokenCacheSize = tokenCacheSize
+	}
+	if tokenCacheTTL, err := time.ParseDuration(os.Getenv("SHORELINE_TOKEN_CACHE_TTL")); err == nil {
+		builder.config.TokenCacheTTL = jepson.Duration(tokenCacheTTL)
+	}
+	return builder.Build()
 }
 
 func (client *Client) getHost() (*url.URL, error) {
@@ -255,20 +378,24 @@ func (client *Client) serverLogin() error {
 
 	host.Path = path.Join(host.Path, "serverlogin")
 
-	req, _ := http.NewRequest("POST", host.String(), nil)
-	req.Header.Add("x-tidepool-server-name", client.config.Name)
-	req.Header.Add("x-tidepool-server-secret", client.config.Secret)
-
-	res, err := client.httpClient.Do(req)
+	// a server login has no side effect, it is retried as the idempotent calls
+	res, err := client.do(context.Background(), true, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-tidepool-server-name", client.config.Name)
+		req.Header.Add("x-tidepool-server-secret", client.config.Secret)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "Failure to obtain a server token")
+		return fmt.Errorf("Failure to obtain a server token: %w", err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		return &status.StatusError{
-			Status: status.NewStatusf(res.StatusCode, "Unknown response code from service[%s]", req.URL),
-		}
+		return decodeError(res, res.Request)
 	}
 	token := res.Header.Get("x-tidepool-session-token")
 
@@ -290,6 +417,11 @@ func extractUserData(r io.Reader) (*schema.UserData, error) {
 // Signs up a new platfrom user
 // Returns a UserData object if successful
 func (client *Client) Signup(username, password, email string) (*schema.UserData, error) {
+	return client.SignupContext(context.Background(), username, password, email)
+}
+
+// SignupContext is Signup with a context, it is not retried
+func (client *Client) SignupContext(ctx context.Context, username, password, email string) (*schema.UserData, error) {
 	host, err := client.getHost()
 	if err != nil {
 		return nil, errors.New("No known user-api hosts.")
@@ -298,9 +430,14 @@ func (client *Client) Signup(username, password, email string) (*schema.UserData
 	host.Path = path.Join(host.Path, "user")
 	data := []byte(fmt.Sprintf(`{"username": "%s", "password": "%s","emails":["%s"]}`, username, password, email))
 
-	req, _ := http.NewRequest("POST", host.String(), bytes.NewBuffer(data))
-
-	res, err := client.httpClient.Do(req)
+	res, err := client.do(ctx, false, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", host.String(), bytes.NewBuffer(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -315,15 +452,18 @@ func (client *Client) Signup(username, password, email string) (*schema.UserData
 
 		return ud, nil
 	default:
-		return nil, &status.StatusError{
-			Status: status.NewStatus(res.StatusCode, "There was an issue trying to signup a new user"),
-		}
+		return nil, decodeError(res, res.Request)
 	}
 }
 
 // Login logs in a user with a username and password. Returns a UserData object if successful
 // and also stores the returned login token into ClientToken.
 func (client *Client) Login(username, password string) (*schema.UserData, string, error) {
+	return client.LoginContext(context.Background(), username, password)
+}
+
+// LoginContext is Login with a context, it is not retried since the failed logins lock the accounts
+func (client *Client) LoginContext(ctx context.Context, username, password string) (*schema.UserData, string, error) {
 	host, err := client.getHost()
 	if err != nil {
 		return nil, "", errors.New("No known user-api hosts.")
@@ -331,10 +471,15 @@ func (client *Client) Login(username, password string) (*schema.UserData, string
 
 	host.Path = path.Join(host.Path, "login")
 
-	req, _ := http.NewRequest("POST", host.String(), nil)
-	req.SetBasicAuth(username, password)
-
-	res, err := client.httpClient.Do(req)
+	res, err := client.do(ctx, false, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(username, password)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
 	if err != nil {
 		return nil, "", err
 	}
@@ -351,29 +496,69 @@ func (client *Client) Login(username, password string) (*schema.UserData, string
 	case 404:
 		return nil, "", nil
 	default:
-		return nil, "", &status.StatusError{
-			Status: status.NewStatusf(res.StatusCode, "Unknown response code from service[%s]", req.URL),
-		}
+		return nil, "", decodeError(res, res.Request)
 	}
 }
 
 // CheckToken tests a token with the user-api to make sure it's current;
 // if so, it returns the data encoded in the token.
 func (client *Client) CheckToken(tkn string) *token.TokenData {
+	return client.CheckTokenContext(context.Background(), tkn)
+}
+
+// CheckTokenContext is CheckToken with a context, it is retried on the transient errors.
+// When the token cache is enabled the results are served from it, the transient errors are not cached.
+func (client *Client) CheckTokenContext(ctx context.Context, tkn string) *token.TokenData {
+	if client.tokenCache == nil {
+		td, _ := client.checkToken(ctx, tkn)
+		return td
+	}
+	if td, found := client.tokenCache.get(tkn); found {
+		return td
+	}
+	td, definitive := client.checkToken(ctx, tkn)
+	if definitive {
+		client.tokenCache.put(tkn, td)
+	}
+	return td
+}
+
+// InvalidateToken removes a token from the cache, to be called on the logout events
+// so that the token is checked again by shoreline
+func (client *Client) InvalidateToken(tkn string) {
+	if client.tokenCache != nil {
+		client.tokenCache.invalidate(tkn)
+	}
+}
+
+// InvalidateUserTokens removes all the cached tokens of a user, to be called when all its sessions are revoked
+func (client *Client) InvalidateUserTokens(userID string) {
+	if client.tokenCache != nil {
+		client.tokenCache.invalidateUser(userID)
+	}
+}
+
+// checkToken returns the data of the token, nil when it is invalid.
+// definitive is false when the token could not be checked because of an error.
+func (client *Client) checkToken(ctx context.Context, tkn string) (td *token.TokenData, definitive bool) {
 	host, err := client.getHost()
 	if err != nil {
-		return nil
+		return nil, false
 	}
 
 	host.Path = path.Join(host.Path, "token", tkn)
 
-	req, _ := http.NewRequest("GET", host.String(), nil)
-	req.Header.Add("x-tidepool-session-token", client.serverToken)
-
-	res, err := client.httpClient.Do(req)
+	res, err := client.do(ctx, true, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-tidepool-session-token", client.TokenProvide())
+		return req, nil
+	})
 	if err != nil {
 		log.Println("Error checking token", err)
-		return nil
+		return nil, false
 	}
 	defer res.Body.Close()
 
@@ -382,14 +567,14 @@ func (client *Client) CheckToken(tkn string) *token.TokenData {
 		var td token.TokenData
 		if err = json.NewDecoder(res.Body).Decode(&td); err != nil {
 			log.Println("Error parsing JSON results", err)
-			return nil
+			return nil, false
 		}
-		return &td
+		return &td, true
 	case 404:
-		return nil
+		return nil, true
 	default:
-		log.Printf("Unknown response code[%d] from service[%s]", res.StatusCode, req.URL)
-		return nil
+		log.Printf("Unknown response code[%d] from service[%s]", res.StatusCode, res.Request.URL)
+		return nil, false
 	}
 }
 
@@ -403,6 +588,11 @@ func (client *Client) TokenProvide() string {
 // Get user details for the given user
 // In this case the userID could be the actual ID or an email address
 func (client *Client) GetUser(userID, token string) (*schema.UserData, error) {
+	return client.GetUserContext(context.Background(), userID, token)
+}
+
+// GetUserContext is GetUser with a context, it is retried on the transient errors
+func (client *Client) GetUserContext(ctx context.Context, userID, token string) (*schema.UserData, error) {
 	host, err := client.getHost()
 	if err != nil {
 		return nil, errors.New("No known user-api hosts.")
@@ -410,12 +600,17 @@ func (client *Client) GetUser(userID, token string) (*schema.UserData, error) {
 
 	host.Path = path.Join(host.Path, "user", userID)
 
-	req, _ := http.NewRequest("GET", host.String(), nil)
-	req.Header.Add("x-tidepool-session-token", token)
-
-	res, err := client.httpClient.Do(req)
+	res, err := client.do(ctx, true, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-tidepool-session-token", token)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "Failure to get a user")
+		return nil, fmt.Errorf("Failure to get a user: %w", err)
 	}
 	defer res.Body.Close()
 
@@ -429,15 +624,19 @@ func (client *Client) GetUser(userID, token string) (*schema.UserData, error) {
 	case http.StatusNoContent:
 		return &schema.UserData{}, nil
 	default:
-		return nil, &status.StatusError{
-			Status: status.NewStatusf(res.StatusCode, "Unknown response code from service[%s]", req.URL),
-		}
+		return nil, decodeError(res, res.Request)
 	}
 }
 
 // Get user details for the given user
 // In this case the userID could be the actual ID or an email address
 func (client *Client) UpdateUser(userID string, userUpdate schema.UserUpdate, token string) error {
+	return client.UpdateUserContext(context.Background(), userID, userUpdate, token)
+}
+
+// UpdateUserContext is UpdateUser with a context, it is retried on the transient errors
+// since applying the same updates twice gives the same user
+func (client *Client) UpdateUserContext(ctx context.Context, userID string, userUpdate schema.UserUpdate, token string) error {
 	host, err := client.getHost()
 	if err != nil {
 		return errors.New("No known user-api hosts.")
@@ -456,21 +655,299 @@ func (client *Client) UpdateUser(userID string, userUpdate schema.UserUpdate, to
 		}
 	}
 
-	req, _ := http.NewRequest("PUT", host.String(), bytes.NewBuffer(jsonUser))
-	req.Header.Add("x-tidepool-session-token", token)
+	res, err := client.do(ctx, true, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "PUT", host.String(), bytes.NewBuffer(jsonUser))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-tidepool-session-token", token)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failure to get a user: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	default:
+		return decodeError(res, res.Request)
+	}
+}
 
-	res, err := client.httpClient.Do(req)
+// GetUsers searches the users with a server token, see UsersPage.NextCursor for the following pages
+func (client *Client) GetUsers(query schema.UsersQuery, token string) (*schema.UsersPage, error) {
+	return client.GetUsersContext(context.Background(), query, token)
+}
+
+// GetUsersContext is GetUsers with a context, it is retried on the transient errors
+func (client *Client) GetUsersContext(ctx context.Context, query schema.UsersQuery, token string) (*schema.UsersPage, error) {
+	host, err := client.getHost()
 	if err != nil {
-		return errors.Wrap(err, "Failure to get a user")
+		return nil, errors.New("No known user-api hosts.")
+	}
+
+	host.Path = path.Join(host.Path, "users")
+	host.RawQuery = query.Values().Encode()
+
+	res, err := client.do(ctx, true, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-tidepool-session-token", token)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failure to get the users: %w", err)
 	}
 	defer res.Body.Close()
 
 	switch res.StatusCode {
 	case http.StatusOK:
+		page := &schema.UsersPage{NextCursor: res.Header.Get(usersNextCursorHeader)}
+		if err := json.NewDecoder(res.Body).Decode(&page.Users); err != nil {
+			return nil, err
+		}
+		return page, nil
+	default:
+		return nil, decodeError(res, res.Request)
+	}
+}
+
+// DeleteUser deletes (anonymizes) a user, with its password or a server token
+func (client *Client) DeleteUser(userID, password, token string) error {
+	return client.DeleteUserContext(context.Background(), userID, password, token)
+}
+
+// DeleteUserContext is DeleteUser with a context, it is not retried
+func (client *Client) DeleteUserContext(ctx context.Context, userID, password, token string) error {
+	host, err := client.getHost()
+	if err != nil {
+		return errors.New("No known user-api hosts.")
+	}
+
+	host.Path = path.Join(host.Path, "user", userID)
+	data, err := json.Marshal(map[string]string{"password": password})
+	if err != nil {
+		return err
+	}
+
+	res, err := client.do(ctx, false, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "DELETE", host.String(), bytes.NewBuffer(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-tidepool-session-token", token)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failure to delete a user: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusAccepted:
 		return nil
 	default:
-		return &status.StatusError{
-			Status: status.NewStatusf(res.StatusCode, "Unknown response code from service[%s]", req.URL),
+		return decodeError(res, res.Request)
+	}
+}
+
+// LongtermLogin logs in a user as Login does, for the long-term duration given by the long-term key
+func (client *Client) LongtermLogin(username, password, longtermKey string) (*schema.UserData, string, error) {
+	return client.LongtermLoginContext(context.Background(), username, password, longtermKey)
+}
+
+// LongtermLoginContext is LongtermLogin with a context, it is not retried
+func (client *Client) LongtermLoginContext(ctx context.Context, username, password, longtermKey string) (*schema.UserData, string, error) {
+	host, err := client.getHost()
+	if err != nil {
+		return nil, "", errors.New("No known user-api hosts.")
+	}
+
+	host.Path = path.Join(host.Path, "login", longtermKey)
+
+	res, err := client.do(ctx, false, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(username, password)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		ud, err := extractUserData(res.Body)
+		if err != nil {
+			return nil, "", err
 		}
+		return ud, res.Header.Get("x-tidepool-session-token"), nil
+	default:
+		return nil, "", decodeError(res, res.Request)
+	}
+}
+
+// RefreshSession returns a new session token with the up to date user information,
+// and the data of the token which was refreshed
+func (client *Client) RefreshSession(tkn string) (string, *token.TokenData, error) {
+	return client.RefreshSessionContext(context.Background(), tkn)
+}
+
+// RefreshSessionContext is RefreshSession with a context, it is not retried
+func (client *Client) RefreshSessionContext(ctx context.Context, tkn string) (string, *token.TokenData, error) {
+	host, err := client.getHost()
+	if err != nil {
+		return "", nil, errors.New("No known user-api hosts.")
+	}
+
+	host.Path = path.Join(host.Path, "login")
+
+	res, err := client.do(ctx, false, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-tidepool-session-token", tkn)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("Failure to refresh a session: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var td token.TokenData
+		if err := json.NewDecoder(res.Body).Decode(&td); err != nil {
+			return "", nil, err
+		}
+		return res.Header.Get("x-tidepool-session-token"), &td, nil
+	default:
+		return "", nil, decodeError(res, res.Request)
+	}
+}
+
+// Logout revokes a session token, it is also removed from the token cache
+func (client *Client) Logout(tkn string) error {
+	return client.LogoutContext(context.Background(), tkn)
+}
+
+// LogoutContext is Logout with a context, it is not retried
+func (client *Client) LogoutContext(ctx context.Context, tkn string) error {
+	host, err := client.getHost()
+	if err != nil {
+		return errors.New("No known user-api hosts.")
+	}
+
+	host.Path = path.Join(host.Path, "logout")
+	client.InvalidateToken(tkn)
+
+	res, err := client.do(ctx, false, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-tidepool-session-token", tkn)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failure to logout: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	default:
+		return decodeError(res, res.Request)
+	}
+}
+
+// ExternalToken returns a token authenticating the user of the session token to a 3rd party service
+func (client *Client) ExternalToken(service, tkn string) (string, error) {
+	return client.ExternalTokenContext(context.Background(), service, tkn)
+}
+
+// ExternalTokenContext is ExternalToken with a context, it is not retried
+func (client *Client) ExternalTokenContext(ctx context.Context, service, tkn string) (string, error) {
+	host, err := client.getHost()
+	if err != nil {
+		return "", errors.New("No known user-api hosts.")
+	}
+
+	host.Path = path.Join(host.Path, "ext-token", service)
+
+	res, err := client.do(ctx, false, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-tidepool-session-token", tkn)
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failure to get an external token: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Header.Get(externalSessionTokenHeader), nil
+	default:
+		return "", decodeError(res, res.Request)
+	}
+}
+
+// AnonymousIdHashPair returns an anonymous id and hash, derived from the given parameters
+func (client *Client) AnonymousIdHashPair(params url.Values) (*schema.IdHashPair, error) {
+	return client.AnonymousIdHashPairContext(context.Background(), params)
+}
+
+// AnonymousIdHashPairContext is AnonymousIdHashPair with a context, it is retried on the transient errors
+func (client *Client) AnonymousIdHashPairContext(ctx context.Context, params url.Values) (*schema.IdHashPair, error) {
+	host, err := client.getHost()
+	if err != nil {
+		return nil, errors.New("No known user-api hosts.")
+	}
+
+	host.Path = path.Join(host.Path, "private")
+	host.RawQuery = params.Encode()
+
+	res, err := client.do(ctx, true, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", host.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", acceptHeader)
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failure to get an id hash pair: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var pair schema.IdHashPair
+		if err := json.NewDecoder(res.Body).Decode(&pair); err != nil {
+			return nil, err
+		}
+		return &pair, nil
+	default:
+		return nil, decodeError(res, res.Request)
 	}
 }
@@ -1,11 +1,15 @@
 package shoreline
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/mdblp/shoreline/schema"
 )
 
 const name = "test"
@@ -164,3 +168,131 @@ func TestSignup(t *testing.T) {
 	}
 
 }
+
+func TestLoginErrors(t *testing.T) {
+	srvr := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/serverlogin":
+			res.Header().Set("x-tidepool-session-token", TOKEN)
+		case "/login":
+			if accept := req.Header.Get("Accept"); accept != acceptHeader {
+				t.Errorf("Bad Accept Header[%v]", accept)
+			}
+			username, _, _ := req.BasicAuth()
+			switch username {
+			case "locked":
+				res.Header().Set("content-type", "application/problem+json")
+				res.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(res, `{"type":"urn:shoreline:error:account_locked","title":"No user matched the given details","status":401,"errorCode":"account_locked","details":{"nextLoginAttemptTime":"2021-06-01T10:00:00Z"}}`)
+			case "legacy":
+				res.Header().Set("content-type", "application/json")
+				res.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(res, `{"code":403,"reason":"The user hasn't verified this account yet","errorCode":"email_not_verified"}`)
+			default:
+				res.WriteHeader(http.StatusBadGateway)
+			}
+		default:
+			t.Errorf("Unknown path[%s]", req.URL.Path)
+		}
+	}))
+	defer srvr.Close()
+
+	shorelineClient := NewShorelineClientBuilder().
+		WithHost(srvr.URL).
+		WithName("test").
+		WithSecret("howdy ho, neighbor joe").
+		Build()
+
+	if err := shorelineClient.Start(); err != nil {
+		t.Errorf("Failed start with error[%v]", err)
+	}
+	defer shorelineClient.Close()
+
+	_, _, err := shorelineClient.Login("locked", "howdy")
+	if !errors.Is(err, ErrAccountLocked) || errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("Expected an account locked error, got [%v]", err)
+	}
+	var shorelineErr *Error
+	if !errors.As(err, &shorelineErr) || shorelineErr.Code != http.StatusUnauthorized || shorelineErr.Details["nextLoginAttemptTime"] != "2021-06-01T10:00:00Z" {
+		t.Fatalf("Unexpected error [%#v]", err)
+	}
+
+	_, _, err = shorelineClient.Login("legacy", "howdy")
+	if !errors.As(err, &shorelineErr) || shorelineErr.ErrorCode != "email_not_verified" || shorelineErr.Reason != "The user hasn't verified this account yet" {
+		t.Fatalf("Unexpected error [%#v]", err)
+	}
+
+	_, _, err = shorelineClient.Login("other", "howdy")
+	if !errors.As(err, &shorelineErr) || shorelineErr.ErrorCode != "" || shorelineErr.Code != http.StatusBadGateway {
+		t.Fatalf("Unexpected error [%#v]", err)
+	}
+}
+
+func TestServerApis(t *testing.T) {
+	srvr := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		route := req.Method + " " + req.URL.Path
+		if tok := req.Header.Get("x-tidepool-session-token"); tok != TOKEN && route != "GET /private" && route != "POST /login/longterm" {
+			t.Errorf("Bad session token[%v] for %s", tok, route)
+		}
+		switch route {
+		case "GET /users":
+			if query := req.URL.Query(); query.Get("role") != "hcp" || query.Get("id") != "1,2" || query.Get("emailVerified") != "true" || query.Get("createdFrom") != "2021-01-01T00:00:00Z" {
+				t.Errorf("Bad query[%v]", query)
+			}
+			res.Header().Set("x-users-next-cursor", "next")
+			fmt.Fprint(res, `[{"userid": "1"}, {"userid": "2"}]`)
+		case "DELETE /user/1234abc":
+			body, _ := ioutil.ReadAll(req.Body)
+			if string(body) != `{"password":"howdy"}` {
+				t.Errorf("Bad body[%s]", body)
+			}
+			res.WriteHeader(http.StatusAccepted)
+		case "POST /login/longterm":
+			if username, _, _ := req.BasicAuth(); username != "billy" {
+				t.Errorf("Bad username[%v]", username)
+			}
+			res.Header().Set("x-tidepool-session-token", TOKEN)
+			fmt.Fprint(res, `{"userid": "1234abc"}`)
+		case "GET /login":
+			res.Header().Set("x-tidepool-session-token", "refreshed")
+			fmt.Fprint(res, `{"userid": "1234abc"}`)
+		case "POST /logout":
+		case "POST /ext-token/zendesk":
+			res.Header().Set("x-external-session-token", "external")
+		case "GET /private":
+			fmt.Fprint(res, `{"name": "", "id": "0123456789", "hash": "0123456789abcdef01234567"}`)
+		default:
+			t.Errorf("Unknown route[%s]", route)
+		}
+	}))
+	defer srvr.Close()
+	client := NewShorelineClientBuilder().
+		WithHost(srvr.URL).
+		WithName(name).
+		WithSecret(secret).
+		Build()
+
+	verified := true
+	page, err := client.GetUsers(schema.UsersQuery{Role: "hcp", IDs: []string{"1", "2"}, EmailVerified: &verified, CreatedFrom: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}, TOKEN)
+	if err != nil || len(page.Users) != 2 || page.Users[1].UserID != "2" || page.NextCursor != "next" {
+		t.Errorf("Unexpected users page[%+v] [%v]", page, err)
+	}
+	if err := client.DeleteUser("1234abc", "howdy", TOKEN); err != nil {
+		t.Errorf("Error on delete[%v]", err)
+	}
+	if ud, tok, err := client.LongtermLogin("billy", "howdy", "longterm"); err != nil || ud.UserID != "1234abc" || tok != TOKEN {
+		t.Errorf("Unexpected long-term login[%+v] [%s] [%v]", ud, tok, err)
+	}
+	if tok, td, err := client.RefreshSession(TOKEN); err != nil || tok != "refreshed" || td.UserId != "1234abc" {
+		t.Errorf("Unexpected refresh[%s] [%+v] [%v]", tok, td, err)
+	}
+	if err := client.Logout(TOKEN); err != nil {
+		t.Errorf("Error on logout[%v]", err)
+	}
+	if tok, err := client.ExternalToken("zendesk", TOKEN); err != nil || tok != "external" {
+		t.Errorf("Unexpected external token[%s] [%v]", tok, err)
+	}
+	if pair, err := client.AnonymousIdHashPair(nil); err != nil || pair.ID != "0123456789" {
+		t.Errorf("Unexpected id hash pair[%+v] [%v]", pair, err)
+	}
+}
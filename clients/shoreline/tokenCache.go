@@ -0,0 +1,142 @@
+package shoreline
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/mdblp/shoreline/token"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Results of the token cache lookups
+const (
+	TOKEN_CACHE_HIT          = "hit"
+	TOKEN_CACHE_NEGATIVE_HIT = "negative_hit"
+	TOKEN_CACHE_MISS         = "miss"
+)
+
+// Defaults of the token cache settings of the client config
+const (
+	defaultTokenCacheTTL         = time.Minute
+	defaultTokenCacheNegativeTTL = 10 * time.Second
+)
+
+var tokenCacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "shoreline_client",
+	Name:      "token_cache_lookups_total",
+	Help:      "The total number of token cache lookups, by result (hit, negative_hit or miss)",
+}, []string{"result"})
+
+// tokenCache is a LRU cache of the CheckToken results, keyed by the hash of the tokens
+// so that the memory holds no usable token. The invalid tokens are cached too (negative caching),
+// with a nil TokenData and their own TTL.
+type tokenCache struct {
+	mut         sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[string]*list.Element
+	lru         *list.List // most recently used first
+}
+
+type tokenCacheEntry struct {
+	key       string
+	data      *token.TokenData
+	expiresAt time.Time
+}
+
+func newTokenCache(size int, ttl, negativeTTL time.Duration) *tokenCache {
+	return &tokenCache{size: size, ttl: ttl, negativeTTL: negativeTTL, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func tokenCacheKey(tkn string) string {
+	hash := sha256.Sum256([]byte(tkn))
+	return hex.EncodeToString(hash[:])
+}
+
+// get returns the cached token data, found is false when the token has to be checked
+func (c *tokenCache) get(tkn string) (data *token.TokenData, found bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	element, ok := c.entries[tokenCacheKey(tkn)]
+	if !ok {
+		tokenCacheLookupsTotal.WithLabelValues(TOKEN_CACHE_MISS).Inc()
+		return nil, false
+	}
+	entry := element.Value.(*tokenCacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.remove(element)
+		tokenCacheLookupsTotal.WithLabelValues(TOKEN_CACHE_MISS).Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	if entry.data == nil {
+		tokenCacheLookupsTotal.WithLabelValues(TOKEN_CACHE_NEGATIVE_HIT).Inc()
+		return nil, true
+	}
+	tokenCacheLookupsTotal.WithLabelValues(TOKEN_CACHE_HIT).Inc()
+	// a copy, so that the callers cannot alter the cached data
+	copied := *entry.data
+	return &copied, true
+}
+
+// put caches the result of a token check, nil for an invalid token.
+// A valid token is not cached beyond its expiration.
+func (c *tokenCache) put(tkn string, data *token.TokenData) {
+	ttl := c.ttl
+	if data == nil {
+		ttl = c.negativeTTL
+	}
+	expiresAt := time.Now().Add(ttl)
+	if data != nil {
+		if tokenExpiresAt, ok := token.UnverifiedExpiresAt(tkn); ok && tokenExpiresAt.Before(expiresAt) {
+			expiresAt = tokenExpiresAt
+		}
+	}
+	if ttl <= 0 || !time.Now().Before(expiresAt) {
+		return
+	}
+
+	key := tokenCacheKey(tkn)
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&tokenCacheEntry{key: key, data: data, expiresAt: expiresAt})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate removes a token from the cache
+func (c *tokenCache) invalidate(tkn string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if element, ok := c.entries[tokenCacheKey(tkn)]; ok {
+		c.remove(element)
+	}
+}
+
+// invalidateUser removes all the valid tokens of a user from the cache
+func (c *tokenCache) invalidateUser(userID string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if data := element.Value.(*tokenCacheEntry).data; data != nil && data.UserId == userID {
+			c.remove(element)
+		}
+		element = next
+	}
+}
+
+// remove must be called with the lock held
+func (c *tokenCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*tokenCacheEntry).key)
+}
@@ -0,0 +1,107 @@
+package shoreline
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mdblp/shoreline/token"
+)
+
+func TestTokenCache(t *testing.T) {
+	cache := newTokenCache(2, time.Hour, time.Hour)
+	cache.put("a", &token.TokenData{UserId: "1"})
+	cache.put("b", &token.TokenData{UserId: "2"})
+	cache.put("invalid", nil)
+
+	if _, found := cache.get("a"); found {
+		t.Fatal("The least recently used token should have been evicted")
+	}
+	if td, found := cache.get("invalid"); !found || td != nil {
+		t.Fatalf("The invalid token should be cached, got [%v] [%v]", td, found)
+	}
+	td, found := cache.get("b")
+	if !found || td.UserId != "2" {
+		t.Fatalf("Unexpected cached token [%v] [%v]", td, found)
+	}
+	td.UserId = "altered"
+	if td, _ := cache.get("b"); td.UserId != "2" {
+		t.Fatal("The cached token should not be altered by the callers")
+	}
+	if len(cache.entries) != 2 {
+		t.Fatalf("The tokens should be kept by hash: %v", cache.entries)
+	}
+
+	cache.invalidateUser("2")
+	if _, found := cache.get("b"); found {
+		t.Fatal("The tokens of the user should have been invalidated")
+	}
+	cache.invalidate("invalid")
+	if _, found := cache.get("invalid"); found || cache.lru.Len() != 0 {
+		t.Fatal("The token should have been invalidated")
+	}
+}
+
+func TestTokenCache_Expiration(t *testing.T) {
+	cache := newTokenCache(10, time.Hour, 0)
+	expiring, _ := token.CreateSessionToken(&token.TokenData{UserId: "1", DurationSecs: 1}, token.TokenConfig{Secret: "secret"})
+	cache.put(expiring.ID, &token.TokenData{UserId: "1"})
+	cache.put("invalid", nil)
+
+	if _, found := cache.get(expiring.ID); !found {
+		t.Fatal("The token should be cached until its expiration")
+	}
+	if _, found := cache.get("invalid"); found {
+		t.Fatal("The invalid tokens should not be cached without negative TTL")
+	}
+	if expiresAt := cache.entries[tokenCacheKey(expiring.ID)].Value.(*tokenCacheEntry).expiresAt; expiresAt.Unix() != expiring.ExpiresAt {
+		t.Fatalf("The cache TTL should be bounded by the token expiration: %v", expiresAt)
+	}
+}
+
+func TestCheckToken_Cache(t *testing.T) {
+	var calls int32
+	srvr := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		switch req.URL.Path {
+		case "/token/valid":
+			fmt.Fprint(res, `{"userid": "1234abc", "isserver": false}`)
+		case "/token/invalid":
+			res.WriteHeader(http.StatusNotFound)
+		default:
+			res.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srvr.Close()
+	client := newResilienceTestClient(srvr.URL).
+		WithRetries(0, 0, 0).
+		WithTokenCache(10, time.Minute, time.Minute).
+		Build()
+
+	for i := 0; i < 3; i++ {
+		if td := client.CheckToken("valid"); td == nil || td.UserId != "1234abc" {
+			t.Fatalf("Unexpected token data [%v]", td)
+		}
+		if td := client.CheckToken("invalid"); td != nil {
+			t.Fatalf("Unexpected token data [%v]", td)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("The checked tokens should be served from the cache, got %d calls", calls)
+	}
+
+	client.CheckToken("unavailable")
+	client.CheckToken("unavailable")
+	if calls != 4 {
+		t.Fatalf("The transient errors should not be cached, got %d calls", calls)
+	}
+
+	client.InvalidateToken("valid")
+	client.CheckToken("valid")
+	if calls != 5 {
+		t.Fatalf("The invalidated token should be checked again, got %d calls", calls)
+	}
+}
@@ -14,8 +14,11 @@ require (
 	github.com/go-playground/assert/v2 v2.0.1
 	github.com/google/uuid v1.1.2
 	github.com/gorilla/mux v1.7.3
+	github.com/lib/pq v1.10.9
 	github.com/prometheus/client_golang v1.4.1
+	github.com/sirupsen/logrus v1.8.1
 	github.com/swaggo/swag v1.6.9
 	github.com/tidepool-org/go-common v0.0.0-00010101000000-000000000000
+	go.etcd.io/bbolt v1.3.6
 	go.mongodb.org/mongo-driver v1.4.0
 )
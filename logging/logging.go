@@ -0,0 +1,135 @@
+// Package logging configures the structured logger of the service
+// and makes sure no secret ends up in the logs.
+package logging
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RedactedValue replaces the sensitive values in the logs
+	RedactedValue = "[REDACTED]"
+)
+
+// sensitiveHeaders are never logged in clear
+var sensitiveHeaders = []string{
+	"x-tidepool-session-token",
+	"x-tidepool-server-secret",
+	"x-external-session-token",
+	"authorization",
+}
+
+// sensitiveFields are the log field names (or part of) whose values are redacted
+var sensitiveFields = []string{
+	"password",
+	"secret",
+	"token",
+	"authorization",
+	"pwhash",
+}
+
+// Configure sets the level and format of the logger from the environment:
+// LOG_LEVEL (trace, debug, info, warn, error; default info) and
+// LOG_FORMAT (json or text; default json)
+func Configure(logger *logrus.Logger) {
+	level := logrus.InfoLevel
+	if value, found := os.LookupEnv("LOG_LEVEL"); found {
+		if parsed, err := logrus.ParseLevel(value); err == nil {
+			level = parsed
+		} else {
+			logger.Warnf("Invalid LOG_LEVEL %q, using %s", value, level)
+		}
+	}
+	logger.SetLevel(level)
+
+	if format, _ := os.LookupEnv("LOG_FORMAT"); format == "text" {
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	} else {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	logger.AddHook(&redactHook{})
+}
+
+// New creates a logger configured from the environment
+func New() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(os.Stdout)
+	Configure(logger)
+	return logger
+}
+
+// RedactHeaders returns a copy of the headers safe to be logged
+func RedactHeaders(headers http.Header) http.Header {
+	redacted := make(http.Header, len(headers))
+	for key, values := range headers {
+		if isSensitiveHeader(key) {
+			redacted[key] = []string{RedactedValue}
+		} else {
+			redacted[key] = values
+		}
+	}
+	return redacted
+}
+
+// RedactMap returns a copy of a decoded JSON object safe to be logged
+func RedactMap(data map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if IsSensitiveField(key) {
+			redacted[key] = RedactedValue
+		} else if nested, ok := value.(map[string]interface{}); ok {
+			redacted[key] = RedactMap(nested)
+		} else {
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
+
+// IsSensitiveField returns true if the field name denotes a secret
+func IsSensitiveField(name string) bool {
+	lower := strings.ToLower(name)
+	for _, sensitive := range sensitiveFields {
+		if strings.Contains(lower, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSensitiveHeader(name string) bool {
+	lower := strings.ToLower(name)
+	for _, sensitive := range sensitiveHeaders {
+		if lower == sensitive {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHook is the last line of defense: it hides the value of any sensitive field
+type redactHook struct{}
+
+func (h *redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *redactHook) Fire(entry *logrus.Entry) error {
+	for key, value := range entry.Data {
+		switch typed := value.(type) {
+		case http.Header:
+			entry.Data[key] = RedactHeaders(typed)
+		case map[string]interface{}:
+			entry.Data[key] = RedactMap(typed)
+		default:
+			if key != logrus.ErrorKey && IsSensitiveField(key) {
+				entry.Data[key] = RedactedValue
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestLogger(buffer *bytes.Buffer) *logrus.Logger {
+	logger := logrus.New()
+	Configure(logger)
+	logger.SetOutput(buffer)
+	return logger
+}
+
+func Test_Configure(t *testing.T) {
+	os.Setenv("LOG_LEVEL", "debug")
+	os.Setenv("LOG_FORMAT", "text")
+	defer os.Unsetenv("LOG_LEVEL")
+	defer os.Unsetenv("LOG_FORMAT")
+
+	logger := logrus.New()
+	Configure(logger)
+	if logger.GetLevel() != logrus.DebugLevel {
+		t.Fatalf("Expected debug level, got %s", logger.GetLevel())
+	}
+	if _, ok := logger.Formatter.(*logrus.TextFormatter); !ok {
+		t.Fatalf("Expected text formatter, got %T", logger.Formatter)
+	}
+
+	os.Setenv("LOG_LEVEL", "verbose")
+	os.Unsetenv("LOG_FORMAT")
+	logger = logrus.New()
+	logger.SetOutput(&bytes.Buffer{})
+	Configure(logger)
+	if logger.GetLevel() != logrus.InfoLevel {
+		t.Fatalf("Invalid level should default to info, got %s", logger.GetLevel())
+	}
+	if _, ok := logger.Formatter.(*logrus.JSONFormatter); !ok {
+		t.Fatalf("Expected json formatter, got %T", logger.Formatter)
+	}
+}
+
+func Test_RedactHook(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := newTestLogger(&buffer)
+
+	headers := http.Header{}
+	headers.Set("x-tidepool-session-token", "session")
+	headers.Set("x-tidepool-server-secret", "secret")
+	headers.Set("Authorization", "Basic abc")
+	headers.Set("x-tidepool-trace-session", "trace")
+	logger.WithFields(logrus.Fields{
+		"headers":  headers,
+		"password": "p4ssw0rd",
+		"body":     map[string]interface{}{"username": "jdoe", "password": "p4ssw0rd"},
+		"userId":   "1234",
+	}).Info("message")
+
+	output := buffer.String()
+	for _, secret := range []string{"session", "\"secret\"", "Basic abc", "p4ssw0rd"} {
+		if strings.Contains(output, secret) {
+			t.Fatalf("Secret %s found in log output %s", secret, output)
+		}
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		t.Fatalf("Log output is not JSON: %v", err)
+	}
+	if entry["userId"] != "1234" || entry["password"] != RedactedValue {
+		t.Fatalf("Unexpected log entry %#v", entry)
+	}
+	if !strings.Contains(output, "trace") || !strings.Contains(output, "jdoe") {
+		t.Fatalf("Non sensitive values should be kept: %s", output)
+	}
+}
+
+func Test_RedactHeaders(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("x-tidepool-session-token", "session")
+	headers.Set("content-type", "application/json")
+
+	redacted := RedactHeaders(headers)
+	if redacted.Get("x-tidepool-session-token") != RedactedValue || redacted.Get("content-type") != "application/json" {
+		t.Fatalf("Unexpected redacted headers %#v", redacted)
+	}
+	if headers.Get("x-tidepool-session-token") != "session" {
+		t.Fatalf("Original headers should not be modified")
+	}
+}
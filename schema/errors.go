@@ -0,0 +1,65 @@
+package schema
+
+// Stable error codes returned by the service in the "errorCode" field of the error responses.
+// Unlike the reasons, they are not meant to be read by humans and will not change.
+const (
+	ErrorInternal                   = "internal_error"
+	ErrorUnauthorized               = "unauthorized"
+	ErrorForbidden                  = "forbidden"
+	ErrorInvalidToken               = "invalid_token"
+	ErrorServerTokenRequired        = "server_token_required"
+	ErrorMissingCredentials         = "missing_credentials"
+	ErrorPasswordMismatch           = "password_mismatch"
+	ErrorServerSecretMismatch       = "server_secret_mismatch"
+	ErrorAccountLocked              = "account_locked"
+	ErrorEmailNotVerified           = "email_not_verified"
+	ErrorTooManyLogins              = "too_many_logins"
+	ErrorUserNotFound               = "user_not_found"
+	ErrorUserAlreadyExists          = "user_already_exists"
+	ErrorInvalidUserDetails         = "invalid_user_details"
+	ErrorInvalidRole                = "invalid_role"
+	ErrorInvalidClaimToken          = "invalid_claim_token"
+	ErrorOrganizationNotFound       = "organization_not_found"
+	ErrorInvalidOrganizationDetails = "invalid_organization_details"
+	ErrorAccountAlreadyClaimed      = "account_already_claimed"
+	ErrorInvalidQuery               = "invalid_query"
+	ErrorUnknownService             = "unknown_service"
+	ErrorNotImplemented             = "not_implemented"
+	ErrorInvalidConsent             = "invalid_consent"
+)
+
+// ErrorTypePrefix is the prefix of the RFC 7807 problem type, followed by the error code
+const ErrorTypePrefix = "urn:shoreline:error:"
+
+// Problem is an error response body.
+// It is both the RFC 7807 "application/problem+json" document and, through the
+// legacy "code" and "reason" members, the historical status.Status body.
+type Problem struct {
+	// RFC 7807 members, only set for "application/problem+json" responses
+	Type   string `json:"type,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	// Legacy members, only set for "application/json" responses
+	Code   int    `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// ErrorCode is one of the Error* constants
+	ErrorCode string                 `json:"errorCode"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// HTTPStatus returns the status code of the response, whatever the format
+func (p *Problem) HTTPStatus() int {
+	if p.Status != 0 {
+		return p.Status
+	}
+	return p.Code
+}
+
+// Message returns the human readable reason, whatever the format
+func (p *Problem) Message() string {
+	if p.Title != "" {
+		return p.Title
+	}
+	return p.Reason
+}
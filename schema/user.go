@@ -1,27 +1,127 @@
 package schema
 
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type (
 	// UserData is the data structure returned from a successful Login query.
 	UserData struct {
-		UserID         string   `json:"userid,omitempty" bson:"userid,omitempty"` // map userid to id
-		Username       string   `json:"username,omitempty" bson:"username,omitempty"`
-		Emails         []string `json:"emails,omitempty" bson:"emails,omitempty"`
-		PasswordExists bool     `json:"passwordExists,omitempty"` // Does a password exist for the user?
-		Roles          []string `json:"roles,omitempty" bson:"roles,omitempty"`
-		TermsAccepted  string   `json:"termsAccepted,omitempty" bson:"termsAccepted,omitempty"`
-		EmailVerified  bool     `json:"emailVerified" bson:"authenticated"` //tag is name `authenticated` for historical reasons
+		UserID         string       `json:"userid,omitempty" bson:"userid,omitempty"` // map userid to id
+		Username       string       `json:"username,omitempty" bson:"username,omitempty"`
+		Emails         []string     `json:"emails,omitempty" bson:"emails,omitempty"`
+		PasswordExists bool         `json:"passwordExists,omitempty"` // Does a password exist for the user?
+		Roles          []string     `json:"roles,omitempty" bson:"roles,omitempty"`
+		TermsAccepted  string       `json:"termsAccepted,omitempty" bson:"termsAccepted,omitempty"`
+		EmailVerified  bool         `json:"emailVerified" bson:"authenticated"` //tag is name `authenticated` for historical reasons
+		Profile        *UserProfile `json:"profile,omitempty" bson:"profile,omitempty"`
+	}
+
+	// UserProfile holds the optional personal attributes of a user
+	UserProfile struct {
+		FirstName string `json:"firstName,omitempty" bson:"firstName,omitempty"`
+		LastName  string `json:"lastName,omitempty" bson:"lastName,omitempty"`
+		Language  string `json:"language,omitempty" bson:"language,omitempty"`
+		Timezone  string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+		Country   string `json:"country,omitempty" bson:"country,omitempty"`
 	}
 
 	// UserUpdate is the data structure for updating of a users details
 	UserUpdate struct {
-		Username      *string   `json:"username,omitempty"`
-		Emails        *[]string `json:"emails,omitempty"`
-		Password      *string   `json:"password,omitempty"`
-		Roles         *[]string `json:"roles,omitempty"`
-		EmailVerified *bool     `json:"emailVerified,omitempty"`
+		Username        *string            `json:"username,omitempty"`
+		Emails          *[]string          `json:"emails,omitempty"`
+		Password        *string            `json:"password,omitempty"`
+		CurrentPassword *string            `json:"currentPassword,omitempty"` // required from the hcp and caregivers changing their own password
+		Roles           *[]string          `json:"roles,omitempty"`
+		TermsAccepted   *string            `json:"termsAccepted,omitempty"` // time of the acceptance of the terms, with its offset (e.g. 2016-01-01T01:23:45-08:00)
+		EmailVerified   *bool              `json:"emailVerified,omitempty"`
+		Profile         *UserProfileUpdate `json:"profile,omitempty"`
+	}
+
+	// UserProfileUpdate is the data structure for updating the profile of a user, an empty string clears the attribute
+	UserProfileUpdate struct {
+		FirstName *string `json:"firstName,omitempty"`
+		LastName  *string `json:"lastName,omitempty"`
+		Language  *string `json:"language,omitempty"`
+		Timezone  *string `json:"timezone,omitempty"`
+		Country   *string `json:"country,omitempty"`
+	}
+)
+
+type (
+	// UsersQuery holds the search criteria of GET /users, the criteria given are combined
+	UsersQuery struct {
+		Role          string
+		IDs           []string
+		EmailPrefix   string // start of any of the user emails, case insensitive
+		EmailVerified *bool
+		CreatedFrom   time.Time // inclusive
+		CreatedTo     time.Time // exclusive
+		ModifiedFrom  time.Time // inclusive
+		ModifiedTo    time.Time // exclusive
+		Deleted       *bool     // only the deleted users when true, only the others when false
+		Sort          string    // userid, username, createdTime or modifiedTime, prefixed with - for the descending order
+		Cursor        string    // NextCursor of the previous page
+		Limit         int
+	}
+
+	// UsersPage is a page of the users found by GET /users
+	UsersPage struct {
+		Users []*UserData
+		// NextCursor gets the following page, empty on the last page
+		NextCursor string
+	}
+
+	// IdHashPair is an anonymous id and its hash, returned by GET /private
+	IdHashPair struct {
+		Name string `json:"name"`
+		ID   string `json:"id"`
+		Hash string `json:"hash"`
 	}
 )
 
+// Values returns the query parameters of the search
+func (q *UsersQuery) Values() url.Values {
+	values := url.Values{}
+	setTime := func(key string, value time.Time) {
+		if !value.IsZero() {
+			values.Set(key, value.Format(time.RFC3339))
+		}
+	}
+	if q.Role != "" {
+		values.Set("role", q.Role)
+	}
+	if len(q.IDs) > 0 {
+		values.Set("id", strings.Join(q.IDs, ","))
+	}
+	if q.EmailPrefix != "" {
+		values.Set("email", q.EmailPrefix)
+	}
+	if q.EmailVerified != nil {
+		values.Set("emailVerified", strconv.FormatBool(*q.EmailVerified))
+	}
+	setTime("createdFrom", q.CreatedFrom)
+	setTime("createdTo", q.CreatedTo)
+	setTime("modifiedFrom", q.ModifiedFrom)
+	setTime("modifiedTo", q.ModifiedTo)
+	if q.Deleted != nil {
+		values.Set("deleted", strconv.FormatBool(*q.Deleted))
+	}
+	if q.Sort != "" {
+		values.Set("sort", q.Sort)
+	}
+	if q.Cursor != "" {
+		values.Set("cursor", q.Cursor)
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return values
+}
+
 func (u *UserData) IsCustodial() bool {
 	return !u.PasswordExists
 }
@@ -37,7 +137,7 @@ func (u *UserData) HasRole(role string) bool {
 
 func (u *UserData) IsClinic() bool {
 	for _, userRole := range u.Roles {
-		if userRole == "hcp" || userRole == "clinic" {
+		if userRole == "hcp" {
 			return true
 		}
 	}
@@ -45,5 +145,5 @@ func (u *UserData) IsClinic() bool {
 }
 
 func (u *UserUpdate) HasUpdates() bool {
-	return u.Username != nil || u.Emails != nil || u.Password != nil || u.Roles != nil || u.EmailVerified != nil
+	return u.Username != nil || u.Emails != nil || u.Password != nil || u.Roles != nil || u.TermsAccepted != nil || u.EmailVerified != nil || u.Profile != nil
 }
@@ -18,16 +18,22 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 
+	"github.com/mdblp/shoreline/audit"
+	"github.com/mdblp/shoreline/logging"
 	"github.com/mdblp/shoreline/user"
 
 	common "github.com/tidepool-org/go-common"
@@ -44,13 +50,29 @@ type (
 		Service disc.ServiceListing `json:"service"`
 		Mongo   mongo.Config        `json:"mongo"`
 		User    user.ApiConfig      `json:"user"`
+		Audit   audit.Config        `json:"audit"`
+		// Tenants served besides the default one, each with its own user configuration and Mongo collections
+		Tenants []user.TenantConfig `json:"tenants"`
+		// Store is "mongo" (the default), "postgres", "bolt" for a single node,
+		// or "memory" for the local development: the data is lost on exit
+		Store string `json:"store"`
+		// Postgres is the database of the "postgres" store
+		Postgres user.PostgresConfig `json:"postgres"`
+		// Bolt is the database file of the "bolt" store
+		Bolt user.BoltConfig `json:"bolt"`
 	}
 )
 
 func main() {
+	migrate := flag.Bool("migrate", false, "apply the migrations of the stores, then exit")
+	dryRun := flag.Bool("dry-run", false, "with -migrate, list the pending migrations of the mongo store without applying them")
+	flag.Parse()
+
 	var config Config
-	logger := log.New(os.Stdout, user.USER_API_PREFIX, log.LstdFlags|log.Lshortfile)
-	auditLogger := log.New(os.Stdout, user.USER_API_PREFIX, log.LstdFlags)
+	// The standard logger is also used by the helpers without access to the api
+	logger := logrus.StandardLogger()
+	logger.SetOutput(os.Stdout)
+	logging.Configure(logger)
 	// Init random number generator
 	rand.Seed(time.Now().UnixNano())
 
@@ -59,9 +81,13 @@ func main() {
 	config.User.DelayBeforeNextLoginAttempt = 10 // 10 minutes
 	config.User.MaxConcurrentLogin = 100
 	config.User.BlockParallelLogin = true
+	config.Postgres.Timeout = 20 * time.Second
+	config.Postgres.WaitConnectionInterval = 5 * time.Second
+	config.Bolt.Path = "shoreline.db"
+	config.Bolt.Timeout = 10 * time.Second
 
 	if err := common.LoadEnvironmentConfig([]string{"TIDEPOOL_SHORELINE_ENV", "TIDEPOOL_SHORELINE_SERVICE"}, &config); err != nil {
-		logger.Panic("Problem loading Shoreline config", err)
+		logger.WithError(err).Panic("Problem loading Shoreline config")
 	}
 
 	// server secret may be passed via a separate env variable to accomodate easy secrets injection via Kubernetes
@@ -102,6 +128,105 @@ func main() {
 	}
 
 	config.Mongo.FromEnv()
+	config.Postgres.FromEnv()
+	config.Bolt.FromEnv()
+	storeType, found := os.LookupEnv("STORE")
+	if found {
+		config.Store = storeType
+	}
+
+	// audit sinks may be overridden by env variables, e.g. AUDIT_SINKS=stdout,mongo
+	auditSinks, found := os.LookupEnv("AUDIT_SINKS")
+	if found {
+		config.Audit.Sinks = strings.Split(auditSinks, ",")
+	}
+	auditFile, found := os.LookupEnv("AUDIT_FILE")
+	if found {
+		config.Audit.FilePath = auditFile
+	}
+	auditHashChain, found := os.LookupEnv("AUDIT_HASH_CHAIN")
+	if found {
+		config.Audit.HashChain = auditHashChain == "true"
+	}
+
+	rtr := mux.NewRouter()
+
+	/*
+	 * User-Api setup
+	 */
+	var storage user.Storage
+	// forTenant returns the store of a tenant, setup creates the indexes or tables of the stores once reachable,
+	// mongoStores are listed by the dry run of the migrations, and auditStore is the store of the mongo audit sink
+	var forTenant func(tenant user.TenantConfig) user.Storage
+	setup := user.NewStoreSetup()
+	var mongoStores []*user.Client
+	var auditStore mongo.Storage
+	switch config.Store {
+	case "memory":
+		logger.Warn("using the in-memory store, the data is lost on exit")
+		storage = user.NewMemoryStoreClient()
+		forTenant = func(user.TenantConfig) user.Storage { return user.NewMemoryStoreClient() }
+	case "postgres":
+		postgresStorage, err := user.NewPostgresStore(&config.Postgres, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		storage = postgresStorage
+		setup.Add(postgresStorage.Migrate)
+		forTenant = func(tenant user.TenantConfig) user.Storage {
+			tenantStorage := postgresStorage.ForTenant(tenant.Database, tenant.CollectionPrefix)
+			setup.Add(tenantStorage.Migrate)
+			return tenantStorage
+		}
+	case "bolt":
+		boltStorage, err := user.NewBoltStore(&config.Bolt, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		storage = boltStorage
+		forTenant = func(tenant user.TenantConfig) user.Storage {
+			return boltStorage.ForTenant(tenant.Database, tenant.CollectionPrefix)
+		}
+	case "", "mongo":
+		mongoStorage, err := user.NewStore(&config.Mongo, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		storage, auditStore = mongoStorage, mongoStorage
+		setup.Add(mongoStorage.Migrate)
+		mongoStores = append(mongoStores, mongoStorage)
+		forTenant = func(tenant user.TenantConfig) user.Storage {
+			tenantStorage := mongoStorage.ForTenant(tenant.Database, tenant.CollectionPrefix)
+			setup.Add(tenantStorage.Migrate)
+			mongoStores = append(mongoStores, tenantStorage)
+			return tenantStorage
+		}
+	default:
+		logger.Fatalf("unknown store %q", config.Store)
+	}
+	defer storage.Close()
+	storage.Start()
+
+	if *migrate {
+		for _, tenant := range config.Tenants {
+			forTenant(tenant)
+		}
+		storage.WaitUntilStarted()
+		if *dryRun {
+			if len(mongoStores) == 0 {
+				logger.Fatalf("the dry run of the migrations is only supported by the mongo store")
+			}
+			if err := printPendingMigrations(context.Background(), config.Tenants, mongoStores); err != nil {
+				logger.Fatal(err)
+			}
+			return
+		}
+		if err := setup.Run(context.Background(), storage); err != nil {
+			logger.WithError(err).Fatal("Unable to set up the store")
+		}
+		logger.Print("the migrations are applied")
+		return
+	}
 
 	/*
 	 * Hakken setup
@@ -119,19 +244,32 @@ func main() {
 		logger.Print("skipping hakken service")
 	}
 
-	rtr := mux.NewRouter()
-
-	/*
-	 * User-Api setup
-	 */
-	storage, err := user.NewStore(&config.Mongo, logger)
+	auditLogger, err := audit.NewLoggerFromConfig(&config.Audit, auditStore)
 	if err != nil {
 		logger.Fatal(err)
 	}
-	defer storage.Close()
-	storage.Start()
+	defer auditLogger.Close()
 
 	userapi := user.InitApi(config.User, logger, storage, auditLogger)
+	for _, tenant := range config.Tenants {
+		tenantConfig, err := tenant.Merge(config.User)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if err := userapi.AddTenant(tenant.ID, tenant.Hosts, tenantConfig, forTenant(tenant)); err != nil {
+			logger.Fatal(err)
+		}
+		logger.WithField("tenantId", tenant.ID).Info("tenant added")
+	}
+	// the store may only be reachable later on: the status is unavailable until the migrations are applied,
+	// and the service stops when one fails, to apply it again on the next start
+	userapi.SetStoreSetup(setup)
+	go func() {
+		if err := setup.Run(context.Background(), storage); err != nil {
+			logger.WithError(err).Fatal("Unable to set up the store")
+		}
+		logger.Print("the migrations are applied")
+	}()
 	logger.Print("installing handlers")
 	userapi.SetHandlers("", rtr)
 
@@ -168,6 +306,7 @@ func main() {
 	go func() {
 		for {
 			<-sigc
+			auditLogger.Close()
 			storage.Close()
 			server.Close()
 			done <- true
@@ -177,3 +316,28 @@ func main() {
 	<-done
 
 }
+
+// printPendingMigrations prints the migrations which would be applied to the stores of the default tenant,
+// then of the tenants, with the problems they would meet
+func printPendingMigrations(ctx context.Context, tenants []user.TenantConfig, stores []*user.Client) error {
+	for index, store := range stores {
+		name := "default"
+		if index > 0 {
+			name = tenants[index-1].ID
+		}
+		pending, err := store.PendingMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			fmt.Printf("%s: up to date\n", name)
+		}
+		for _, migration := range pending {
+			fmt.Printf("%s: migration %d would %s\n", name, migration.Version, migration.Description)
+			for _, warning := range migration.Warnings {
+				fmt.Printf("%s:   warning: %s\n", name, warning)
+			}
+		}
+	}
+	return nil
+}
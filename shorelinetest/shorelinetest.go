@@ -0,0 +1,161 @@
+// Package shorelinetest runs a shoreline service in-process, for the integration tests of the services using it.
+//
+// The Server serves the real user api on an httptest.Server, with an in-memory store,
+// and has helpers to seed the store and a client of the clients/shoreline package pointing to it:
+//
+//	server := shorelinetest.NewServer()
+//	defer server.Close()
+//	patient := server.CreateUser("patient@example.com", "password1", "patient")
+//	client := server.Client()
+//	defer client.Close()
+//	tokenData := client.CheckToken(server.UserToken(patient))
+package shorelinetest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mdblp/shoreline/audit"
+	"github.com/mdblp/shoreline/clients/shoreline"
+	"github.com/mdblp/shoreline/token"
+	"github.com/mdblp/shoreline/user"
+	"github.com/sirupsen/logrus"
+)
+
+// Settings of the servers, see Config
+const (
+	// ServerName and ServerSecret are the credentials of the server tokens
+	ServerName   = "shorelinetest"
+	ServerSecret = "shorelinetest server secret"
+	// LongTermKey gives the long-term logins
+	LongTermKey = "shorelinetest long-term key"
+	// MaxFailedLogin is the number of consecutive failed logins locking an account
+	MaxFailedLogin = 5
+)
+
+// Server is a shoreline service listening on a local address, see NewServer
+type Server struct {
+	*httptest.Server
+	Api   *user.Api
+	Store *user.MemoryStoreClient
+	// Audit holds the audit events logged by the service
+	Audit *audit.MemorySink
+}
+
+// Config returns the configuration of the servers, before the changes given to NewServer
+func Config() user.ApiConfig {
+	return user.ApiConfig{
+		Secrets:                     []user.Secret{{Secret: "default", Pass: ServerSecret}},
+		LongTermKey:                 LongTermKey,
+		LongTermDaysDuration:        30,
+		TokenDurationSecs:           3600,
+		Salt:                        "shorelinetest salt",
+		Secret:                      "shorelinetest api secret",
+		TokenSecrets:                map[string]string{},
+		MaxFailedLogin:              MaxFailedLogin,
+		DelayBeforeNextLoginAttempt: 10,
+		MaxConcurrentLogin:          100,
+	}
+}
+
+// NewServer starts a server with an empty store, configured by Config then by the configure functions.
+// The caller must Close it.
+func NewServer(configure ...func(*user.ApiConfig)) *Server {
+	config := Config()
+	for _, fn := range configure {
+		fn(&config)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	store := user.NewMemoryStoreClient()
+	sink := audit.NewMemorySink()
+	api := user.InitApi(config, logger, store, audit.NewLogger(false, sink))
+
+	rtr := mux.NewRouter()
+	api.SetHandlers("", rtr)
+	return &Server{Server: httptest.NewServer(rtr), Api: api, Store: store, Audit: sink}
+}
+
+// Client returns a started client of the server, logged in with the server secret.
+// The caller must Close it.
+func (s *Server) Client() *shoreline.Client {
+	client := shoreline.NewShorelineClientBuilder().
+		WithHost(s.URL).
+		WithHTTPClient(s.Server.Client()).
+		WithName(ServerName).
+		WithSecret(ServerSecret).
+		WithTokenGetInterval(time.Second).
+		Build()
+	client.Start()
+	return client
+}
+
+// CreateUser stores a user whose email is verified, with the given roles (patient when none).
+// It panics when the username is not an email or the password is invalid.
+func (s *Server) CreateUser(username, password string, roles ...string) *user.User {
+	details := &user.NewUserDetails{Username: &username, Emails: []string{username}, Password: &password}
+	if len(roles) > 0 {
+		details.Roles = roles
+	}
+	newUser, err := user.NewUser(details, s.Api.ApiConfig.Salt)
+	if err != nil {
+		panic(fmt.Sprintf("shorelinetest: creating the user %q: %v", username, err))
+	}
+	newUser.EmailVerified = true
+	s.upsertUser(newUser)
+	return newUser
+}
+
+// LockUser locks the account as after too many failed logins, until the configured delay is over
+func (s *Server) LockUser(userID string) {
+	lockedUser := s.findUser(userID)
+	nextLoginAttemptTime := time.Now().Add(time.Duration(s.Api.ApiConfig.DelayBeforeNextLoginAttempt) * time.Minute)
+	lockedUser.FailedLogin = &user.FailedLoginInfos{
+		Count:                s.Api.ApiConfig.MaxFailedLogin,
+		Total:                s.Api.ApiConfig.MaxFailedLogin,
+		NextLoginAttemptTime: nextLoginAttemptTime.UTC().Format(time.RFC3339),
+	}
+	s.upsertUser(lockedUser)
+}
+
+// UserToken returns a new session token of the user, with the data of the tokens of its logins
+func (s *Server) UserToken(u *user.User) string {
+	tokenData := &token.TokenData{UserId: u.Id, Email: u.Username, Name: u.DisplayName(), Organizations: u.OrganizationIDs()}
+	if len(u.Roles) > 0 {
+		tokenData.Role = u.Roles[0]
+	}
+	return s.createToken(tokenData)
+}
+
+// ServerToken returns a new server token
+func (s *Server) ServerToken() string {
+	return s.createToken(&token.TokenData{UserId: ServerName, IsServer: true})
+}
+
+func (s *Server) createToken(tokenData *token.TokenData) string {
+	tokenConfig := token.TokenConfig{DurationSecs: s.Api.ApiConfig.TokenDurationSecs, Secret: s.Api.ApiConfig.Secret}
+	sessionToken, err := user.CreateSessionTokenAndSave(context.Background(), tokenData, tokenConfig, s.Store)
+	if err != nil {
+		panic(fmt.Sprintf("shorelinetest: creating a token of %q: %v", tokenData.UserId, err))
+	}
+	return sessionToken.ID
+}
+
+func (s *Server) findUser(userID string) *user.User {
+	found, err := s.Store.FindUser(context.Background(), &user.User{Id: userID})
+	if err != nil || found == nil {
+		panic(fmt.Sprintf("shorelinetest: finding the user %q: %v", userID, err))
+	}
+	return found
+}
+
+func (s *Server) upsertUser(u *user.User) {
+	if err := s.Store.UpsertUser(context.Background(), u); err != nil {
+		panic(fmt.Sprintf("shorelinetest: storing the user %q: %v", u.Id, err))
+	}
+}
@@ -0,0 +1,58 @@
+package shorelinetest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mdblp/shoreline/clients/shoreline"
+	"github.com/mdblp/shoreline/user"
+)
+
+func TestServer_Login_CheckToken(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	clinician := server.CreateUser("clinician@example.com", "password1", "hcp")
+
+	client := server.Client()
+	defer client.Close()
+	if client.TokenProvide() == "" {
+		t.Fatalf("The client should be logged in with the server secret")
+	}
+
+	userData, sessionToken, err := client.Login("clinician@example.com", "password1")
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	if userData.UserID != clinician.Id || sessionToken == "" {
+		t.Fatalf("Unexpected login result %v, token %q", userData, sessionToken)
+	}
+
+	for _, tkn := range []string{sessionToken, server.UserToken(clinician)} {
+		tokenData := client.CheckToken(tkn)
+		if tokenData == nil || tokenData.UserId != clinician.Id || tokenData.Role != "hcp" {
+			t.Errorf("Unexpected token data %v", tokenData)
+		}
+	}
+	if tokenData := client.CheckToken(server.ServerToken()); tokenData == nil || !tokenData.IsServer {
+		t.Errorf("Unexpected server token data %v", tokenData)
+	}
+}
+
+func TestServer_LockUser(t *testing.T) {
+	server := NewServer(func(config *user.ApiConfig) { config.DelayBeforeNextLoginAttempt = 1 })
+	defer server.Close()
+	patient := server.CreateUser("patient@example.com", "password1")
+	if !patient.HasRole("patient") {
+		t.Errorf("The user should be a patient by default, got roles %v", patient.Roles)
+	}
+	server.LockUser(patient.Id)
+
+	client := server.Client()
+	defer client.Close()
+	if _, _, err := client.Login("patient@example.com", "password1"); !errors.Is(err, shoreline.ErrAccountLocked) {
+		t.Errorf("Expected the account to be locked, got %v", err)
+	}
+	if len(server.Audit.Events()) == 0 {
+		t.Errorf("The failed login should be audited")
+	}
+}
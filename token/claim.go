@@ -0,0 +1,92 @@
+package token
+
+import (
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// ClaimData is the content of a claim token, the signed invitation
+// which lets the patient of a custodial account take it over
+type ClaimData struct {
+	// UserID of the custodial account to claim
+	UserID string
+	// CreatorID of the user (or server) who created the custodial account
+	CreatorID string
+	// TenantID of the deployment which issued the token, empty for the default tenant
+	TenantID string
+	// ExpiresAt in seconds since epoch
+	ExpiresAt int64
+}
+
+const (
+	// claimTokenType tells claim tokens apart from session tokens signed with the same secret
+	claimTokenType = "claim"
+	// CLAIM_TOKEN_DEFAULT_DURATION of the claim tokens in seconds (7 days)
+	CLAIM_TOKEN_DEFAULT_DURATION = 7 * 24 * 60 * 60
+)
+
+var (
+	ClaimToken_error_no_userid = errors.New("ClaimToken: userId not set")
+	ClaimToken_invalid         = errors.New("ClaimToken: is invalid")
+)
+
+// CreateClaimToken signs a claim token valid for config.DurationSecs
+// (CLAIM_TOKEN_DEFAULT_DURATION when not set)
+func CreateClaimToken(data *ClaimData, config TokenConfig) (string, error) {
+	if data.UserID == "" {
+		return "", ClaimToken_error_no_userid
+	}
+	duration := config.DurationSecs
+	if duration == 0 {
+		duration = CLAIM_TOKEN_DEFAULT_DURATION
+	}
+	now := time.Now()
+	data.ExpiresAt = now.Add(time.Duration(duration) * time.Second).Unix()
+
+	jwtToken := jwt.New(jwt.GetSigningMethod("HS256"))
+	claims := jwtToken.Claims.(jwt.MapClaims)
+	claims["typ"] = claimTokenType
+	claims["sub"] = data.UserID
+	claims["crt"] = data.CreatorID
+	if data.TenantID != "" {
+		claims["tnt"] = data.TenantID
+	}
+	claims["exp"] = data.ExpiresAt
+	claims["iat"] = now.Unix()
+	claims["jti"] = uuid.New()
+
+	return jwtToken.SignedString([]byte(config.Secret))
+}
+
+// UnpackClaimTokenAndVerify checks the signature, the expiration and the type of the claim token
+func UnpackClaimTokenAndVerify(tokenString string, secret string) (*ClaimData, error) {
+	if tokenString == "" {
+		return nil, ClaimToken_invalid
+	}
+	jwtToken, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ClaimToken_invalid
+		}
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !jwtToken.Valid {
+		return nil, ClaimToken_invalid
+	}
+
+	claims := jwtToken.Claims.(jwt.MapClaims)
+	userID, _ := claims["sub"].(string)
+	if claims["typ"] != claimTokenType || userID == "" {
+		return nil, ClaimToken_invalid
+	}
+	creatorID, _ := claims["crt"].(string)
+	tenantID, _ := claims["tnt"].(string)
+	expiresAt, _ := claims["exp"].(float64)
+
+	return &ClaimData{UserID: userID, CreatorID: creatorID, TenantID: tenantID, ExpiresAt: int64(expiresAt)}, nil
+}
@@ -0,0 +1,48 @@
+package token
+
+import (
+	"testing"
+)
+
+func Test_ClaimToken(t *testing.T) {
+	data := &ClaimData{UserID: "1234567890", CreatorID: "abcdef1234"}
+	claimToken, err := CreateClaimToken(data, TokenConfig{Secret: tokenConfig.Secret})
+	if err != nil {
+		t.Fatalf("unexpected error creating the claim token: %v", err)
+	}
+	if data.ExpiresAt == 0 {
+		t.Fatalf("the expiration should be set")
+	}
+
+	unpacked, err := UnpackClaimTokenAndVerify(claimToken, tokenConfig.Secret)
+	if err != nil {
+		t.Fatalf("unexpected error unpacking the claim token: %v", err)
+	}
+	if *unpacked != *data {
+		t.Fatalf("unexpected claim data %#v, expected %#v", unpacked, data)
+	}
+
+	if _, err := UnpackClaimTokenAndVerify(claimToken, "another secret"); err == nil {
+		t.Fatalf("a claim token signed with another secret should be rejected")
+	}
+}
+
+func Test_ClaimToken_Errors(t *testing.T) {
+	if _, err := CreateClaimToken(&ClaimData{}, tokenConfig); err != ClaimToken_error_no_userid {
+		t.Fatalf("a claim token without user id should not be created")
+	}
+
+	sessionToken, _ := CreateSessionToken(&TokenData{UserId: "1234567890", DurationSecs: 3600}, tokenConfig)
+	if _, err := UnpackClaimTokenAndVerify(sessionToken.ID, tokenConfig.Secret); err != ClaimToken_invalid {
+		t.Fatalf("a session token should not be accepted as claim token")
+	}
+	claimToken, _ := CreateClaimToken(&ClaimData{UserID: "1234567890"}, tokenConfig)
+	if _, err := UnpackSessionTokenAndVerify(claimToken, tokenConfig.Secret); err == nil {
+		t.Fatalf("a claim token should not be accepted as session token")
+	}
+
+	expired, _ := CreateClaimToken(&ClaimData{UserID: "1234567890"}, TokenConfig{Secret: tokenConfig.Secret, DurationSecs: -10})
+	if _, err := UnpackClaimTokenAndVerify(expired, tokenConfig.Secret); err == nil {
+		t.Fatalf("an expired claim token should be rejected")
+	}
+}
@@ -29,6 +29,14 @@ type (
 		Role         string `json:"role"`
 		DurationSecs int64  `json:"-"`
 		Audience     string `json:"audience"`
+		// Organizations the user is a member of, so the services can authorize by clinic
+		Organizations []string `json:"organizations,omitempty"`
+		// TenantID of the deployment which issued the token, empty for the default tenant
+		TenantID string `json:"tenantid,omitempty"`
+		// ConsentRequired is true when the user has not accepted the current version of a required document
+		ConsentRequired bool `json:"consentRequired,omitempty"`
+		// Locale preferred by the user (e.g. "fr-FR"), empty when it has not set its language
+		Locale string `json:"locale,omitempty"`
 	}
 
 	TokenConfig struct {
@@ -67,9 +75,17 @@ func UnpackSessionTokenAndVerify(id string, secret string) (*TokenData, error) {
 	isServer := claims["svr"] == "yes"
 	durationSecs, ok := claims["dur"].(int64)
 	if !ok {
-		durationSecs = int64(claims["dur"].(float64))
+		duration, ok := claims["dur"].(float64)
+		if !ok {
+			return nil, SessionToken_invalid
+		}
+		durationSecs = int64(duration)
+	}
+	// Other tokens signed with the same secret (e.g. claim tokens) have no user claim
+	userId, ok := claims["usr"].(string)
+	if !ok {
+		return nil, SessionToken_invalid
 	}
-	userId := claims["usr"].(string)
 
 	email, ok := claims["email"].(string)
 	if !ok {
@@ -83,14 +99,29 @@ func UnpackSessionTokenAndVerify(id string, secret string) (*TokenData, error) {
 	if !ok {
 		role = ""
 	}
+	tenantID, _ := claims["tnt"].(string)
+	consentRequired, _ := claims["consent_required"].(bool)
+	locale, _ := claims["locale"].(string)
+	var organizations []string
+	if orgs, ok := claims["orgs"].([]interface{}); ok {
+		for _, org := range orgs {
+			if id, ok := org.(string); ok {
+				organizations = append(organizations, id)
+			}
+		}
+	}
 
 	return &TokenData{
-		IsServer:     isServer,
-		DurationSecs: durationSecs,
-		UserId:       userId,
-		Email:        email,
-		Name:         name,
-		Role:         role,
+		IsServer:        isServer,
+		DurationSecs:    durationSecs,
+		UserId:          userId,
+		Email:           email,
+		Name:            name,
+		Role:            role,
+		Organizations:   organizations,
+		TenantID:        tenantID,
+		ConsentRequired: consentRequired,
+		Locale:          locale,
 	}, nil
 }
 
@@ -134,9 +165,21 @@ func CreateSessionToken(data *TokenData, config TokenConfig) (*SessionToken, err
 		claims["role"] = data.Role
 	}
 	claims["usr"] = data.UserId
+	if len(data.Organizations) > 0 {
+		claims["orgs"] = data.Organizations
+	}
+	if data.TenantID != "" {
+		claims["tnt"] = data.TenantID
+	}
+	if data.ConsentRequired {
+		claims["consent_required"] = true
+	}
 	if data.Name != "" {
 		claims["name"] = data.Name
 	}
+	if data.Locale != "" {
+		claims["locale"] = data.Locale
+	}
 	if data.Email != "" {
 		claims["email"] = data.Email
 	}
@@ -167,3 +210,34 @@ func CreateSessionToken(data *TokenData, config TokenConfig) (*SessionToken, err
 
 	return sessionToken, nil
 }
+
+// UnverifiedTenantID returns the tenant claim of a token without verifying it,
+// to find out which tenant secret the token must then be verified with
+func UnverifiedTenantID(tokenString string) string {
+	if tokenString == "" {
+		return ""
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenString, claims); err != nil {
+		return ""
+	}
+	tenantID, _ := claims["tnt"].(string)
+	return tenantID
+}
+
+// UnverifiedExpiresAt returns the expiration time of a token without verifying it,
+// false when the token cannot be parsed or has no expiration
+func UnverifiedExpiresAt(tokenString string) (time.Time, bool) {
+	if tokenString == "" {
+		return time.Time{}, false
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenString, claims); err != nil {
+		return time.Time{}, false
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
@@ -294,6 +294,57 @@ func Test_UnpackedData(t *testing.T) {
 
 }
 
+func Test_UnpackedData_Organizations(t *testing.T) {
+	organizations := []string{"0123456789", "abcdef0123"}
+	token, _ := CreateSessionToken(&TokenData{UserId: "111", DurationSecs: 3600, Role: "hcp", Organizations: organizations}, tokenConfig)
+
+	data, err := UnpackSessionTokenAndVerify(token.ID, tokenConfig.Secret)
+	if err != nil {
+		t.Fatal("unpacked token should be valid", err.Error())
+	}
+	if len(data.Organizations) != 2 || data.Organizations[0] != organizations[0] || data.Organizations[1] != organizations[1] {
+		t.Fatalf("the Organizations should have been what was given: %v", data.Organizations)
+	}
+
+	token, _ = CreateSessionToken(&TokenData{UserId: "111", DurationSecs: 3600}, tokenConfig)
+	if data, _ = UnpackSessionTokenAndVerify(token.ID, tokenConfig.Secret); data.Organizations != nil {
+		t.Fatalf("the Organizations should not be set: %v", data.Organizations)
+	}
+}
+
+func Test_UnpackedData_TenantID(t *testing.T) {
+	token, _ := CreateSessionToken(&TokenData{UserId: "111", DurationSecs: 3600, TenantID: "fr"}, tokenConfig)
+
+	data, err := UnpackSessionTokenAndVerify(token.ID, tokenConfig.Secret)
+	if err != nil {
+		t.Fatal("unpacked token should be valid", err.Error())
+	}
+	if data.TenantID != "fr" {
+		t.Fatalf("the TenantID should have been what was given: %s", data.TenantID)
+	}
+	if tenantID := UnverifiedTenantID(token.ID); tenantID != "fr" {
+		t.Fatalf("the TenantID should be read without the secret: %s", tenantID)
+	}
+
+	token, _ = CreateSessionToken(&TokenData{UserId: "111", DurationSecs: 3600}, tokenConfig)
+	if data, _ = UnpackSessionTokenAndVerify(token.ID, tokenConfig.Secret); data.TenantID != "" {
+		t.Fatalf("the TenantID should not be set: %s", data.TenantID)
+	}
+	if tenantID := UnverifiedTenantID("not a token"); tenantID != "" {
+		t.Fatalf("the TenantID of an invalid token should be empty: %s", tenantID)
+	}
+}
+
+func Test_UnverifiedExpiresAt(t *testing.T) {
+	token, _ := CreateSessionToken(&TokenData{UserId: "111", DurationSecs: 3600}, tokenConfig)
+	if expiresAt, ok := UnverifiedExpiresAt(token.ID); !ok || expiresAt.Unix() != token.ExpiresAt {
+		t.Fatalf("the expiration should be read without the secret: %v %v", expiresAt, ok)
+	}
+	if _, ok := UnverifiedExpiresAt("not a token"); ok {
+		t.Fatal("an invalid token should have no expiration")
+	}
+}
+
 func Test_UnpackTokenExpires(t *testing.T) {
 
 	testData := tokenTestData{
@@ -352,3 +403,28 @@ func Test_getUnpackedToken(t *testing.T) {
 	}
 
 }
+
+func Test_UnpackedData_ConsentRequired(t *testing.T) {
+	token, _ := CreateSessionToken(&TokenData{UserId: "111", DurationSecs: 3600, ConsentRequired: true}, tokenConfig)
+	if data, err := UnpackSessionTokenAndVerify(token.ID, tokenConfig.Secret); err != nil || !data.ConsentRequired {
+		t.Fatalf("the consent should be required: %v %v", data, err)
+	}
+
+	token, _ = CreateSessionToken(&TokenData{UserId: "111", DurationSecs: 3600}, tokenConfig)
+	if data, _ := UnpackSessionTokenAndVerify(token.ID, tokenConfig.Secret); data.ConsentRequired {
+		t.Fatal("the consent should not be required")
+	}
+}
+
+func Test_UnpackedData_Locale(t *testing.T) {
+	token, _ := CreateSessionToken(&TokenData{UserId: "111", DurationSecs: 3600, Name: "Jane Doe", Email: "a@z.co", Locale: "fr-FR"}, tokenConfig)
+	data, err := UnpackSessionTokenAndVerify(token.ID, tokenConfig.Secret)
+	if err != nil || data.Locale != "fr-FR" || data.Name != "Jane Doe" {
+		t.Fatalf("the locale and name should have been what was given: %v %v", data, err)
+	}
+
+	token, _ = CreateSessionToken(&TokenData{UserId: "111", DurationSecs: 3600}, tokenConfig)
+	if data, _ = UnpackSessionTokenAndVerify(token.ID, tokenConfig.Secret); data.Locale != "" {
+		t.Fatalf("the locale should not be set: %s", data.Locale)
+	}
+}
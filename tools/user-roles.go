@@ -8,10 +8,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/codegangsta/cli"
 
-	"github.com/tidepool-org/go-common/clients/shoreline"
+	"github.com/mdblp/shoreline/schema"
 )
 
 const (
@@ -30,12 +32,13 @@ type admin struct {
 func main() {
 	app := cli.NewApp()
 	app.Name = "User Roles"
-	app.Usage = "Manage user roles"
+	app.Usage = "Manage user roles and import users"
 	app.Version = "0.0.1"
 	app.Author = "Jamie"
 	app.Email = "jamie@tidepool.org"
 
 	const environmentUsage = "Target environment (one of: \"prd\", \"stg\", \"dev\", \"local\")"
+	const roleUsage = "(one of: \"patient\", \"caregiver\", \"hcp\")"
 
 	app.Commands = []cli.Command{
 		{
@@ -45,7 +48,7 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "role",
-					Usage: "Role to search for (one of: \"clinic\")",
+					Usage: "Role to search for " + roleUsage,
 				},
 				cli.StringFlag{
 					Name:  "env",
@@ -65,7 +68,7 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "role",
-					Usage: "Role to add to the user (one of: \"clinic\")",
+					Usage: "Role to add to the user " + roleUsage,
 				},
 				cli.StringFlag{
 					Name:  "env",
@@ -85,7 +88,7 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "role",
-					Usage: "Role to remove from the user (one of: \"clinic\")",
+					Usage: "Role to remove from the user " + roleUsage,
 				},
 				cli.StringFlag{
 					Name:  "env",
@@ -94,6 +97,30 @@ func main() {
 			},
 			Action: removeRoleFromUser,
 		},
+		{
+			Name:      "import",
+			ShortName: "i",
+			Usage:     "Create the users of a CSV or NDJSON file",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "file",
+					Usage: "File of the users, its format is taken from its extension (.csv or .ndjson)",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only validate the users",
+				},
+				cli.BoolFlag{
+					Name:  "invite",
+					Usage: "Create the users without password and print their claim tokens",
+				},
+				cli.StringFlag{
+					Name:  "env",
+					Usage: environmentUsage,
+				},
+			},
+			Action: importUsers,
+		},
 	}
 
 	app.Run(os.Args)
@@ -116,6 +143,16 @@ func findUsersWithRole(c *cli.Context) {
 	}
 }
 
+func importUsers(c *cli.Context) {
+	if a, err := NewAdmin(c.String("env")); err != nil {
+		die(err)
+	} else if report, err := a.ImportUsers(c.String("file"), c.Bool("dry-run"), c.Bool("invite")); err != nil {
+		die(err)
+	} else {
+		fmt.Println(report)
+	}
+}
+
 func addRoleToUser(c *cli.Context) {
 	if updater, err := NewAddUserRoleUpdater(c.String("role")); err != nil {
 		die(err)
@@ -145,22 +182,27 @@ func applyUpdatesToUser(c *cli.Context, updaters []UserUpdater) {
 }
 
 type UserUpdater interface {
-	Update(user *shoreline.UserData, updates *shoreline.UserUpdate) error
+	Update(user *schema.UserData, updates *schema.UserUpdate) error
 }
 
 type AddUserRoleUpdater struct {
 	role string
 }
 
+// validRoles are the roles a user can have, the clinics are managed as organizations
+var validRoles = map[string]bool{"patient": true, "caregiver": true, "hcp": true}
+
 func NewAddUserRoleUpdater(role string) (*AddUserRoleUpdater, error) {
 	if role == "" {
 		return nil, errors.New("Role not specified")
+	} else if !validRoles[role] {
+		return nil, fmt.Errorf("Invalid role %q", role)
 	} else {
 		return &AddUserRoleUpdater{role: role}, nil
 	}
 }
 
-func (m *AddUserRoleUpdater) Update(user *shoreline.UserData, updates *shoreline.UserUpdate) error {
+func (m *AddUserRoleUpdater) Update(user *schema.UserData, updates *schema.UserUpdate) error {
 	var originalRoles *[]string
 	if updates.Roles != nil {
 		originalRoles = updates.Roles
@@ -188,12 +230,14 @@ type RemoveUserRoleUpdater struct {
 func NewRemoveUserRoleUpdater(role string) (*RemoveUserRoleUpdater, error) {
 	if role == "" {
 		return nil, errors.New("Role not specified")
+	} else if !validRoles[role] {
+		return nil, fmt.Errorf("Invalid role %q", role)
 	} else {
 		return &RemoveUserRoleUpdater{role: role}, nil
 	}
 }
 
-func (m *RemoveUserRoleUpdater) Update(user *shoreline.UserData, updates *shoreline.UserUpdate) error {
+func (m *RemoveUserRoleUpdater) Update(user *schema.UserData, updates *schema.UserUpdate) error {
 	var originalRoles *[]string
 	if updates.Roles != nil {
 		originalRoles = updates.Roles
@@ -253,7 +297,7 @@ func (a *admin) LoginAsServer() error {
 	return nil
 }
 
-func (a *admin) GetUserByEmail(email string) (*shoreline.UserData, error) {
+func (a *admin) GetUserByEmail(email string) (*schema.UserData, error) {
 	if email == "" {
 		return nil, errors.New("Email not specified")
 	}
@@ -279,14 +323,14 @@ func (a *admin) GetUserByEmail(email string) (*shoreline.UserData, error) {
 		return nil, errors.New(fmt.Sprintf("Unexpected response status code from get user request: [%d] %s", res.StatusCode, body))
 	}
 
-	var user shoreline.UserData
+	var user schema.UserData
 	if err := json.NewDecoder(res.Body).Decode(&user); err != nil {
 		return nil, errors.New(fmt.Sprintf("Error decoding JSON from get user request: %s", err.Error()))
 	}
 	return &user, nil
 }
 
-func (a *admin) GetUsersWithRole(role string) ([]shoreline.UserData, error) {
+func (a *admin) GetUsersWithRole(role string) ([]schema.UserData, error) {
 	if role == "" {
 		return nil, errors.New("Role not specified")
 	}
@@ -312,14 +356,14 @@ func (a *admin) GetUsersWithRole(role string) ([]shoreline.UserData, error) {
 		return nil, errors.New(fmt.Sprintf("Unexpected response status code from get users request: [%d] %s", res.StatusCode, body))
 	}
 
-	var users []shoreline.UserData
+	var users []schema.UserData
 	if err := json.NewDecoder(res.Body).Decode(&users); err != nil {
 		return nil, errors.New(fmt.Sprintf("Error decoding JSON from get users request: %s", err.Error()))
 	}
 	return users, nil
 }
 
-func (a *admin) ApplyUpdatesToUser(user *shoreline.UserData, updaters []UserUpdater) (*shoreline.UserData, error) {
+func (a *admin) ApplyUpdatesToUser(user *schema.UserData, updaters []UserUpdater) (*schema.UserData, error) {
 	if user == nil {
 		return nil, errors.New("User not specified")
 	}
@@ -328,7 +372,7 @@ func (a *admin) ApplyUpdatesToUser(user *shoreline.UserData, updaters []UserUpda
 		return nil, err
 	}
 
-	updates := shoreline.UserUpdate{}
+	updates := schema.UserUpdate{}
 	for _, updater := range updaters {
 		if err := updater.Update(user, &updates); err != nil {
 			return nil, errors.New(fmt.Sprintf("Error updating user: %s", err.Error()))
@@ -340,7 +384,7 @@ func (a *admin) ApplyUpdatesToUser(user *shoreline.UserData, updaters []UserUpda
 	}
 
 	requestBody := &bytes.Buffer{}
-	updateRequest := &map[string]shoreline.UserUpdate{"updates": updates}
+	updateRequest := &map[string]schema.UserUpdate{"updates": updates}
 	if err := json.NewEncoder(requestBody).Encode(updateRequest); err != nil {
 		return nil, errors.New(fmt.Sprintf("Error encoding JSON for update user request: %s", err.Error()))
 	}
@@ -369,6 +413,57 @@ func (a *admin) ApplyUpdatesToUser(user *shoreline.UserData, updaters []UserUpda
 	return user, nil
 }
 
+func (a *admin) ImportUsers(file string, dryRun bool, invite bool) (string, error) {
+	if file == "" {
+		return "", errors.New("File not specified")
+	}
+
+	var contentType string
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".csv":
+		contentType = "text/csv"
+	case ".ndjson", ".jsonl":
+		contentType = "application/x-ndjson"
+	default:
+		return "", errors.New(fmt.Sprintf("Unknown file format: %s", file))
+	}
+
+	requestBody, err := os.Open(file)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error opening the file: %s", err.Error()))
+	}
+	defer requestBody.Close()
+
+	if err := a.LoginAsServer(); err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("/auth/users/import?dryRun=%t&invite=%t", dryRun, invite)
+	req, err := http.NewRequest("POST", a.urlWithHost(url), requestBody)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error creating new import users request: %s", err.Error()))
+	}
+
+	req.Header.Add(TidepoolSessionToken, a.token)
+	req.Header.Add("content-type", contentType)
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Error sending import users request: %s", err.Error()))
+	}
+	body := &bytes.Buffer{}
+	body.ReadFrom(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("Unexpected response status code from import users request: [%d] %s", res.StatusCode, body))
+	}
+
+	report := &bytes.Buffer{}
+	if err := json.Indent(report, body.Bytes(), "", "  "); err != nil {
+		return "", errors.New(fmt.Sprintf("Error decoding JSON from import users request: %s", err.Error()))
+	}
+	return report.String(), nil
+}
+
 func (a *admin) urlWithHost(path string) string {
 	return fmt.Sprintf("%s%s", a.host, path)
 }
@@ -394,7 +489,7 @@ func envToHost(env string) (string, error) {
 	}
 }
 
-func dumpUser(user *shoreline.UserData) {
+func dumpUser(user *schema.UserData) {
 	if dump, err := json.Marshal(user); err != nil {
 		fmt.Printf("Error dumping user: %s\n", err.Error())
 	} else {
@@ -6,142 +6,34 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"math/rand"
 	"net/http"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mdblp/shoreline/audit"
 	"github.com/mdblp/shoreline/token"
+	"github.com/sirupsen/logrus"
 	"github.com/tidepool-org/go-common/clients/status"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var (
-	statusNoUsrDetailsCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusNoUsrDetailsCounter",
-		Help: "The total number of STATUS_NO_USR_DETAILS errors",
-	})
-	statusInvalidUserDetailsCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusInvalidUserDetailsCounter",
-		Help: "The total number of STATUS_INVALID_USER_DETAILS errors",
-	})
-	statusUserNotFoundCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusUserNotFoundCounter",
-		Help: "The total number of STATUS_USER_NOT_FOUND errors",
-	})
-	statusErrFindingUsrCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusErrFindingUsrCounter",
-		Help: "The total number of STATUS_ERR_FINDING_USR errors",
-	})
-	statusErrCreatingUsrCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusErrCreatingUsrCounter",
-		Help: "The total number of STATUS_ERR_CREATING_USR errors",
-	})
-	statusErrUpdatingUsrCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusErrUpdatingUsrCounter",
-		Help: "The total number of STATUS_ERR_UPDATING_USR errors",
-	})
-	statusUsrAlreadyExistsCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusUsrAlreadyExistsCounter",
-		Help: "The total number of STATUS_USR_ALREADY_EXISTS errors",
-	})
-	statusErrGeneratingTokenCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusErrGeneratingTokenCounter",
-		Help: "The total number of STATUS_ERR_GENERATING_TOKEN errors",
-	})
-	statusErrUpdatingTokenCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusErrUpdatingTokenCounter",
-		Help: "The total number of STATUS_ERR_UPDATING_TOKEN errors",
-	})
-	statusMissingUsrDetailsCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusMissingUsrDetailsCounter",
-		Help: "The total number of STATUS_MISSING_USR_DETAILS errors",
-	})
-	statusErrorUpdatingPwCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusErrorUpdatingPwCounter",
-		Help: "The total number of STATUS_ERROR_UPDATING_PW errors",
-	})
-	statusMissingIdPwCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusMissingIdPwCounter",
-		Help: "The total number of STATUS_MISSING_ID_PW errors",
-	})
-	statusNoMatchCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusNoMatchCounter",
-		Help: "The total number of STATUS_NO_MATCH errors",
-	})
-	statusNotVerifiedCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusNotVerifiedCounter",
-		Help: "The total number of STATUS_NOT_VERIFIED errors",
-	})
-	statusNoTokenMatchCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusNoTokenMatchCounter",
-		Help: "The total number of STATUS_NO_TOKEN_MATCH errors",
-	})
-	statusPwWrongCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusPwWrongCounter",
-		Help: "The total number of STATUS_PW_WRONG errors",
-	})
-	statusErrSendingEmailCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusErrSendingEmailCounter",
-		Help: "The total number of STATUS_ERR_SENDING_EMAIL errors",
-	})
-	statusNoTokenCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusNoTokenCounter",
-		Help: "The total number of STATUS_NO_TOKEN errors",
-	})
-	statusServerTokenRequiredCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusServerTokenRequiredCounter",
-		Help: "The total number of STATUS_SERVER_TOKEN_REQUIRED errors",
-	})
-	statusAuthHeaderRequiredCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusAuthHeaderRequiredCounter",
-		Help: "The total number of STATUS_AUTH_HEADER_REQUIRED errors",
-	})
-	statusAuthHeaderInvlaidCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusAuthHeaderInvlaidCounter",
-		Help: "The total number of STATUS_AUTH_HEADER_INVLAID errors",
-	})
-	statusGetstatusErrCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusGetstatusErrCounter",
-		Help: "The total number of STATUS_GETSTATUS_ERR errors",
-	})
-	statusUnauthorizedCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusUnauthorizedCounter",
-		Help: "The total number of STATUS_UNAUTHORIZED errors",
-	})
-	statusNoQueryCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusNoQueryCounter",
-		Help: "The total number of STATUS_NO_QUERY errors",
-	})
-	statusParameterUnknownCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusParameterUnknownCounter",
-		Help: "The total number of STATUS_PARAMETER_UNKNOWN errors",
-	})
-	statusOneQueryParamCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusOneQueryParamCounter",
-		Help: "The total number of STATUS_ONE_QUERY_PARAM errors",
-	})
-	statusInvalidRoleCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "statusInvalidRoleCounter",
-		Help: "The total number of STATUS_INVALID_ROLE errors",
-	})
-)
-
 type (
 	Api struct {
 		Store            Storage
 		ApiConfig        ApiConfig
-		logger           *log.Logger
-		auditLogger      *log.Logger
+		logger           *logrus.Logger
+		auditLogger      *audit.Logger
 		loginLimiter     LoginLimiter
+		// storeSetup is checked by GetStatus, nil when there is nothing to wait for
+		storeSetup       *StoreSetup
+		// tenantID is empty for the default tenant
+		tenantID    string
+		tenants     []*Api
+		tenantHosts map[string]*Api
 	}
 	Secret struct {
 		Secret string `json:"secret"`
@@ -170,6 +62,10 @@ type (
 		MaxConcurrentLogin int `json:"maxConcurrentLogin"`
 		// Block users to do multiple parallel logins (for load tests we desactivate this)
 		BlockParallelLogin bool `json:"blockParallelLogin"`
+		// Lifetime in seconds of the claim tokens of the custodial accounts, 7 days by default
+		ClaimTokenDurationSecs int64 `json:"claimTokenDurationSecs"`
+		// Current versions of the documents (terms, privacy policy...) every user must have accepted
+		RequiredConsents []ConsentVersion `json:"requiredConsents"`
 		//allows for the skipping of verification for testing
 		VerificationSecret string           `json:"verificationSecret"`
 	}
@@ -218,13 +114,27 @@ const (
 	STATUS_UNAUTHORIZED          = "Not authorized for requested operation"
 	STATUS_NO_QUERY              = "A query must be specified"
 	STATUS_PARAMETER_UNKNOWN     = "Unknown query parameter"
-	STATUS_ONE_QUERY_PARAM       = "Only one query parameter is allowed"
 	STATUS_INVALID_ROLE          = "The role specified is invalid"
+	STATUS_INVALID_QUERY         = "Invalid query parameter"
+	STATUS_ERR_FINDING_AUDIT     = "Error finding audit events"
+	STATUS_AUDIT_UNAVAILABLE     = "Audit events are not queryable"
+	STATUS_INVALID_CLAIM         = "Invalid claim token"
+	STATUS_ALREADY_CLAIMED       = "The account has already been claimed"
 	STATUS_OK                    = "OK"
 	STATUS_NO_EXPECTED_PWD       = "No expected password is found"
+
+	STATUS_ORGANIZATION_NOT_FOUND       = "Organization not found"
+	STATUS_INVALID_ORGANIZATION_DETAILS = "Invalid organization details were given"
+	STATUS_ERR_FINDING_ORGANIZATION     = "Error finding organization"
+	STATUS_ERR_UPDATING_ORGANIZATION    = "Error updating organization"
+	STATUS_ERR_UPDATING_MEMBER          = "Error updating organization member"
+	STATUS_MEMBER_NOT_HCP               = "Only hcp users can be members of an organization"
+	STATUS_CREATOR_NOT_HCP              = "Only hcp users can create custodial users"
+
+	STATUS_INVALID_CONSENT = "Invalid consent details were given"
 )
 
-func InitApi(cfg ApiConfig, logger *log.Logger, store Storage, auditLogger *log.Logger) *Api {
+func InitApi(cfg ApiConfig, logger *logrus.Logger, store Storage, auditLogger *audit.Logger) *Api {
 	// Server secrets retrieved from configuration are transformed into a hashtable for ease of access
 	// They are stored in a public property called ServerSecrets
 	cfg.ServerSecrets = make(map[string]string)
@@ -245,11 +155,23 @@ func InitApi(cfg ApiConfig, logger *log.Logger, store Storage, auditLogger *log.
 }
 
 func (a *Api) SetHandlers(prefix string, rtr *mux.Router) {
+	rtr.Use(a.requestLogging, requestMetrics)
+
 	rtr.Handle("/metrics", promhttp.Handler())
 
+	// the tenants routes come first, the requests they do not match are for the default tenant
+	for _, tenant := range a.tenants {
+		tenant.setRoutes(rtr.MatcherFunc(a.tenantMatcher(tenant)).Subrouter())
+	}
+	a.setRoutes(rtr)
+}
+
+func (a *Api) setRoutes(rtr *mux.Router) {
 	rtr.HandleFunc("/status", a.GetStatus).Methods("GET")
 
 	rtr.HandleFunc("/users", a.GetUsers).Methods("GET")
+	rtr.HandleFunc("/users/export", a.ExportUsers).Methods("GET")
+	rtr.HandleFunc("/users/import", a.ImportUsers).Methods("POST")
 
 	rtr.Handle("/user", varsHandler(a.GetUserInfo)).Methods("GET")
 	rtr.Handle("/user/{userid}", varsHandler(a.GetUserInfo)).Methods("GET")
@@ -259,6 +181,13 @@ func (a *Api) SetHandlers(prefix string, rtr *mux.Router) {
 	rtr.Handle("/user/{userid}", varsHandler(a.UpdateUser)).Methods("PUT")
 	rtr.Handle("/user/{userid}", varsHandler(a.DeleteUser)).Methods("DELETE")
 
+	rtr.Handle("/user/{userid}/user", varsHandler(a.CreateCustodialUser)).Methods("POST")
+	rtr.Handle("/user/{userid}/claim", varsHandler(a.CreateClaimToken)).Methods("POST")
+	rtr.Handle("/user/{userid}/export", varsHandler(a.ExportUserData)).Methods("GET")
+	rtr.Handle("/user/{userid}/consents", varsHandler(a.GetConsents)).Methods("GET")
+	rtr.Handle("/user/{userid}/consents", varsHandler(a.AddConsent)).Methods("POST")
+	rtr.HandleFunc("/claim", a.ClaimUser).Methods("POST")
+
 	rtr.HandleFunc("/login", a.Login).Methods("POST")
 	rtr.HandleFunc("/login", a.RefreshSession).Methods("GET")
 	rtr.Handle("/login/{longtermkey}", varsHandler(a.LongtermLogin)).Methods("POST")
@@ -272,6 +201,17 @@ func (a *Api) SetHandlers(prefix string, rtr *mux.Router) {
 	rtr.HandleFunc("/logout", a.Logout).Methods("POST")
 
 	rtr.HandleFunc("/private", a.AnonymousIdHashPair).Methods("GET")
+
+	rtr.HandleFunc("/audit", a.GetAuditEvents).Methods("GET")
+
+	rtr.HandleFunc("/organizations", a.GetOrganizations).Methods("GET")
+	rtr.HandleFunc("/organizations", a.CreateOrganization).Methods("POST")
+	rtr.Handle("/organizations/{organizationid}", varsHandler(a.GetOrganization)).Methods("GET")
+	rtr.Handle("/organizations/{organizationid}", varsHandler(a.UpdateOrganization)).Methods("PUT")
+	rtr.Handle("/organizations/{organizationid}", varsHandler(a.DeleteOrganization)).Methods("DELETE")
+	rtr.Handle("/organizations/{organizationid}/members", varsHandler(a.GetOrganizationMembers)).Methods("GET")
+	rtr.Handle("/organizations/{organizationid}/members/{userid}", varsHandler(a.UpsertOrganizationMember)).Methods("PUT")
+	rtr.Handle("/organizations/{organizationid}/members/{userid}", varsHandler(a.RemoveOrganizationMember)).Methods("DELETE")
 }
 
 func (h varsHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
@@ -286,17 +226,20 @@ func (h varsHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 // @Produce  json
 // @Success 200 "Status ok"
 // @Failure 500 {string} string "error description"
+// @Failure 503 {string} string "the store migrations are not applied, or the error of the failed one"
 // @Router /status [get]
 func (a *Api) GetStatus(res http.ResponseWriter, req *http.Request) {
 	var s status.ApiStatus
 	if err := a.Store.Ping(); err != nil {
-		a.logger.Println(http.StatusInternalServerError, STATUS_GETSTATUS_ERR, err.Error())
+		a.log(req).WithError(err).Error(STATUS_GETSTATUS_ERR)
 		s = status.NewApiStatus(http.StatusInternalServerError, err.Error())
+	} else if err := a.storeSetup.Err(); err != nil {
+		s = status.NewApiStatus(http.StatusServiceUnavailable, err.Error())
 	} else {
 		s = status.NewApiStatus(http.StatusOK, "OK")
 	}
 	if jsonDetails, err := json.Marshal(s); err != nil {
-		log.Printf("Error marshaling StatusApi data [%s]", s)
+		a.log(req).WithError(err).Error("Error marshaling StatusApi data")
 		http.Error(res, "Error marshaling data for response", http.StatusInternalServerError)
 	} else {
 		res.Header().Set("content-type", "application/json")
@@ -306,53 +249,46 @@ func (a *Api) GetStatus(res http.ResponseWriter, req *http.Request) {
 }
 
 // @Summary Get users
-// @Description Get users
+// @Description Search the users, the criteria given are combined. Use the x-users-next-cursor header to get the following page.
 // @ID shoreline-user-api-getusers
 // @Accept  json
 // @Produce  json
-// @Param role query string false "Role" Enums(clinic)
+// @Param role query string false "Role" Enums(patient, caregiver, hcp)
 // @Param id query string false "List of UserId separated by ,"
+// @Param email query string false "Start of an email of the users, case insensitive"
+// @Param emailVerified query bool false "Whether the email of the users is verified"
+// @Param createdFrom query string false "Creation time (RFC3339), inclusive"
+// @Param createdTo query string false "Creation time (RFC3339), exclusive"
+// @Param modifiedFrom query string false "Modification time (RFC3339), inclusive"
+// @Param modifiedTo query string false "Modification time (RFC3339), exclusive"
+// @Param deleted query bool false "Only the deleted users when true, only the others when false"
+// @Param sort query string false "Sort field, prefixed with - for the descending order" Enums(userid, username, createdTime, modifiedTime)
+// @Param cursor query string false "Cursor returned by the previous page"
+// @Param limit query int false "Page size, up to 1000"
 // @Security TidepoolAuth
 // @Success 200 {array} user.User
+// @Header 200 {string} x-users-next-cursor "cursor of the next page, absent on the last page"
 // @Failure 500 {object} status.Status "message returned:\"Error finding user\" "
-// @Failure 400 {object} status.Status "message returned:\"The role specified is invalid\" or \"A query must be specified\" or \"Only one query parameter is allowed\" or \"Unknown query parameter\""
+// @Failure 400 {object} status.Status "message returned:\"The role specified is invalid\" or \"A query must be specified\" or \"Invalid query parameter\" or \"Unknown query parameter\""
 // @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
 // @Router /users [get]
 func (a *Api) GetUsers(res http.ResponseWriter, req *http.Request) {
-	sessionToken := req.Header.Get(TP_SESSION_TOKEN)
-	if tokenData, err := a.authenticateSessionToken(req.Context(), sessionToken); err != nil {
-		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED, err)
-
-	} else if !tokenData.IsServer {
-		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED)
-
-	} else if len(req.URL.Query()) == 0 {
-		a.sendError(res, http.StatusBadRequest, STATUS_NO_QUERY)
-
-	} else if role := req.URL.Query().Get("role"); role != "" && !IsValidRole(role) {
-		a.sendError(res, http.StatusBadRequest, STATUS_INVALID_ROLE)
-
-	} else if userIds := strings.Split(req.URL.Query().Get("id"), ","); len(userIds[0]) > 0 && role != "" {
-		a.sendError(res, http.StatusBadRequest, STATUS_ONE_QUERY_PARAM)
+	ctx := requestContext(req)
+	tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
+	if err != nil {
+		a.sendError(res, req, errUnauthorized, err)
+		return
+	}
 
-	} else {
-		var users []*User
-		switch {
-		case role != "":
-			if users, err = a.Store.FindUsersByRole(req.Context(), role); err != nil {
-				a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USR, err.Error())
-			}
-		case len(userIds[0]) > 0:
-			if users, err = a.Store.FindUsersWithIds(req.Context(), userIds); err != nil {
-				a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USR, err.Error())
-			}
-		default:
-			a.sendError(res, http.StatusBadRequest, STATUS_PARAMETER_UNKNOWN)
-		}
-		// TODO: Verify no return in case of error here ?
-		a.logAudit(req, tokenData, "GetUsers")
-		a.sendUsers(res, users, tokenData.IsServer)
+	users, next, opErr := a.searchUsers(ctx, tokenData, req.URL.Query())
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	if next != "" {
+		res.Header().Set(USERS_NEXT_CURSOR, next)
 	}
+	a.sendUsers(res, users, tokenData.IsServer)
 }
 
 // @Summary Create user
@@ -367,35 +303,19 @@ func (a *Api) GetUsers(res http.ResponseWriter, req *http.Request) {
 // @Failure 400 {object} status.Status "message returned:\"Invalid user details were given\" "
 // @Router /user [post]
 func (a *Api) CreateUser(res http.ResponseWriter, req *http.Request) {
-	// Random sleep to avoid guessing accounts user.
-	time.Sleep(time.Millisecond * time.Duration(rand.Int63n(300)))
-
-	if newUserDetails, err := ParseNewUserDetails(req.Body); err != nil {
-		a.sendError(res, http.StatusBadRequest, STATUS_INVALID_USER_DETAILS, err)
-	} else if err := newUserDetails.Validate(); err != nil { // TODO: Fix this duplicate work!
-		a.sendError(res, http.StatusBadRequest, STATUS_INVALID_USER_DETAILS, err)
-	} else if newUser, err := NewUser(newUserDetails, a.ApiConfig.Salt); err != nil {
-		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_CREATING_USR, err)
-	} else if existingUser, err := a.Store.FindUsers(req.Context(), newUser); err != nil {
-		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_CREATING_USR, err)
-
-	} else if len(existingUser) != 0 {
-		a.sendError(res, http.StatusConflict, STATUS_ERR_CREATING_USR, fmt.Sprintf("User '%s' already exists", *newUserDetails.Username))
-
-	} else if err := a.Store.UpsertUser(req.Context(), newUser); err != nil {
-		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_CREATING_USR, err)
+	newUserDetails, err := ParseNewUserDetails(req.Body)
+	if err != nil {
+		a.sendError(res, req, errInvalidUserDetails, err)
+		return
+	}
 
-	} else {
-		tokenData := token.TokenData{DurationSecs: extractTokenDuration(req), UserId: newUser.Id, IsServer: false, Role: "unverified"}
-		tokenConfig := token.TokenConfig{DurationSecs: a.ApiConfig.TokenDurationSecs, Secret: a.ApiConfig.Secret}
-		if sessionToken, err := CreateSessionTokenAndSave(req.Context(), &tokenData, tokenConfig, a.Store); err != nil {
-			a.sendError(res, http.StatusInternalServerError, STATUS_ERR_GENERATING_TOKEN, err)
-		} else {
-			a.logAudit(req, &tokenData, "CreateUser isClinic{%t}", newUser.IsClinic())
-			res.Header().Set(TP_SESSION_TOKEN, sessionToken.ID)
-			a.sendUserWithStatus(res, newUser, http.StatusCreated, false)
-		}
+	newUser, sessionToken, opErr := a.createUser(requestContext(req), newUserDetails, extractTokenDuration(req))
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
 	}
+	res.Header().Set(TP_SESSION_TOKEN, sessionToken.ID)
+	a.sendUserWithStatus(res, newUser, http.StatusCreated, false)
 }
 
 // @Summary Update user
@@ -414,116 +334,21 @@ func (a *Api) CreateUser(res http.ResponseWriter, req *http.Request) {
 // @Failure 400 {object} status.Status "message returned:\"Invalid user details were given\" "
 // @Router /user/{userid} [put]
 func (a *Api) UpdateUser(res http.ResponseWriter, req *http.Request, vars map[string]string) {
-	a.logger.Printf("UpdateUser %v", req)
-	sessionToken := req.Header.Get(TP_SESSION_TOKEN)
-	if tokenData, err := a.authenticateSessionToken(req.Context(), sessionToken); err != nil {
-		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED, err)
+	ctx := requestContext(req)
+	if tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN)); err != nil {
+		a.sendError(res, req, errUnauthorized, err)
 
 	} else if updateUserDetails, err := ParseUpdateUserDetails(req.Body); err != nil {
-		a.sendError(res, http.StatusBadRequest, STATUS_INVALID_USER_DETAILS, err)
+		a.sendError(res, req, errInvalidUserDetails, err)
 
 	} else if err := updateUserDetails.Validate(); err != nil {
-		a.sendError(res, http.StatusBadRequest, STATUS_INVALID_USER_DETAILS, err)
-
-	} else if updateUserDetails.nFields < 1 {
-		a.sendError(res, http.StatusNotModified, STATUS_INVALID_USER_DETAILS, "Empty payload")
-
-	} else if originalUser, err := a.Store.FindUser(req.Context(), &User{Id: firstStringNotEmpty(vars["userid"], tokenData.UserId)}); err != nil {
-		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USR, err)
+		a.sendError(res, req, errInvalidUserDetails, err)
 
-	} else if originalUser == nil {
-		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED, "User not found")
-
-	} else if !a.isAuthorized(tokenData, originalUser.Id) {
-		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED, "User does not have permissions")
-
-	} else if updateUserDetails.EmailVerified != nil && !tokenData.IsServer {
-		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED, "User does not have permissions")
+	} else if updatedUser, opErr := a.updateUser(ctx, tokenData, vars["userid"], updateUserDetails); opErr != nil {
+		a.sendOperationError(res, req, opErr)
 
 	} else {
-
-		// TODO: This all needs to be refactored so it can be more thoroughly tested
-
-		if updateUserDetails.Password != nil && !tokenData.IsServer && (originalUser.HasRole("hcp") || originalUser.HasRole("caregiver")) {
-			// Caregiver & hcp: Must provide their current password to change it
-			// Patient password change is done differently
-			// Server token: Can perform the change
-			if updateUserDetails.CurrentPassword == nil {
-				a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED, "Missing current password")
-				return
-			}
-			if !originalUser.PasswordsMatch(*updateUserDetails.CurrentPassword, a.ApiConfig.Salt) {
-				a.sendError(res, http.StatusUnauthorized, STATUS_PW_WRONG, "User does not have permissions", fmt.Errorf("User '%s' passwords do not match", originalUser.Username))
-				return
-			}
-		}
-
-		// Check role
-		if updateUserDetails.Roles != nil {
-			if len(updateUserDetails.Roles) != 1 {
-				a.sendError(res, http.StatusBadRequest, STATUS_INVALID_USER_DETAILS, errors.New("multiple roles were provided"))
-				return
-			}
-			if updateUserDetails.Roles[0] != originalUser.Roles[0] && (originalUser.Roles[0] == "patient" || originalUser.Roles[0] == "hcp") {
-				a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED, errors.New("patients or HCPs cannot change role"))
-				return
-			}
-			if updateUserDetails.Roles[0] != originalUser.Roles[0] && updateUserDetails.Roles[0] != "hcp" {
-				a.sendError(res, http.StatusForbidden, STATUS_UNAUTHORIZED, errors.New("caregivers cannot change role for something else than hcp"))
-				return
-			}
-		}
-
-		updatedUser := originalUser.DeepClone()
-		if updateUserDetails.Username != nil || updateUserDetails.Emails != nil {
-			dupCheck := &User{}
-			if updateUserDetails.Username != nil {
-				updatedUser.Username = *updateUserDetails.Username
-				dupCheck.Username = updatedUser.Username
-			}
-			if updateUserDetails.Emails != nil {
-				updatedUser.Emails = updateUserDetails.Emails
-				dupCheck.Emails = updatedUser.Emails
-			}
-
-			if results, err := a.Store.FindUsers(req.Context(), dupCheck); err != nil {
-				a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USR, err)
-				return
-			} else if len(results) == 1 && results[0].Id != firstStringNotEmpty(vars["userid"], tokenData.UserId) {
-				//only throw an error if there is a user with a different id but with the same username/email
-				a.sendError(res, http.StatusConflict, STATUS_USR_ALREADY_EXISTS)
-				return
-			} else if len(results) > 1 {
-				a.sendError(res, http.StatusConflict, STATUS_USR_ALREADY_EXISTS)
-				return
-			}
-		}
-
-		if updateUserDetails.Password != nil {
-			if err := updatedUser.HashPassword(*updateUserDetails.Password, a.ApiConfig.Salt); err != nil {
-				a.sendError(res, http.StatusInternalServerError, STATUS_ERR_UPDATING_USR, err)
-				return
-			}
-		}
-
-		if updateUserDetails.Roles != nil {
-			updatedUser.Roles = updateUserDetails.Roles
-		}
-
-		if updateUserDetails.TermsAccepted != nil {
-			updatedUser.TermsAccepted = *updateUserDetails.TermsAccepted
-		}
-
-		if updateUserDetails.EmailVerified != nil {
-			updatedUser.EmailVerified = *updateUserDetails.EmailVerified
-		}
-
-		if err := a.Store.UpsertUser(req.Context(), updatedUser); err != nil {
-			a.sendError(res, http.StatusInternalServerError, STATUS_ERR_UPDATING_USR, err)
-		} else {
-			a.logAudit(req, tokenData, "UpdateUser isClinic{%t}", updatedUser.IsClinic())
-			a.sendUser(res, updatedUser, tokenData.IsServer)
-		}
+		a.sendUser(res, updatedUser, tokenData.IsServer)
 	}
 }
 
@@ -540,41 +365,20 @@ func (a *Api) UpdateUser(res http.ResponseWriter, req *http.Request, vars map[st
 // @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
 // @Router /user/{userid} [get]
 func (a *Api) GetUserInfo(res http.ResponseWriter, req *http.Request, vars map[string]string) {
-	sessionToken := req.Header.Get(TP_SESSION_TOKEN)
-	if tokenData, err := a.authenticateSessionToken(req.Context(), sessionToken); err != nil {
-		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED, err)
-	} else {
-		var user *User
-		if userID := vars["userid"]; userID != "" {
-			user = &User{Id: userID, Username: userID, Emails: []string{userID}}
-		} else {
-			user = &User{Id: tokenData.UserId}
-		}
-
-		if results, err := a.Store.FindUsers(req.Context(), user); err != nil {
-			a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USR, err)
+	ctx := requestContext(req)
+	if tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN)); err != nil {
+		a.sendError(res, req, errUnauthorized, err)
 
-		} else if len(results) == 0 {
-			a.sendError(res, http.StatusNotFound, STATUS_USER_NOT_FOUND)
+	} else if user, opErr := a.findUser(ctx, tokenData, vars["userid"]); opErr != nil {
+		a.sendOperationError(res, req, opErr)
 
-		} else if len(results) != 1 {
-			a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USR, fmt.Sprintf("Found %d users matching %#v", len(results), user))
-
-		} else if result := results[0]; result == nil {
-			a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USR, "Found user is nil")
-
-		} else if !a.isAuthorized(tokenData, result.Id) {
-			a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED)
-
-		} else {
-			a.logAudit(req, tokenData, "GetUserInfo isClinic{%t}", result.IsClinic())
-			a.sendUser(res, result, tokenData.IsServer)
-		}
+	} else {
+		a.sendUser(res, user, tokenData.IsServer)
 	}
 }
 
 // @Summary Delete user
-// @Description Delete user
+// @Description Delete the user for the right to erasure: its personal data is anonymized, its session tokens are revoked and an AnonymizeUser audit event is emitted, so that the other services can erase their copies. The userid and the deletion metadata are kept.
 // @ID shoreline-user-api-deleteuser
 // @Accept  json
 // @Produce  json
@@ -582,55 +386,26 @@ func (a *Api) GetUserInfo(res http.ResponseWriter, req *http.Request, vars map[s
 // @Param password body string true "password"
 // @Security TidepoolAuth
 // @Success 202 "User deleted"
-// @Failure 500 {string} string ""
+// @Failure 500 {object} status.Status "message returned:\"Error finding user\" or \"Error updating user\" or \"Error updating token\" "
+// @Failure 404 {object} status.Status "message returned:\"User not found\" "
 // @Failure 403 {object} status.Status "message returned:\"Missing id and/or password\" "
 // @Failure 401 {string} string ""
 // @Router /user/{userid} [delete]
 func (a *Api) DeleteUser(res http.ResponseWriter, req *http.Request, vars map[string]string) {
-
-	td, err := a.authenticateSessionToken(req.Context(), req.Header.Get(TP_SESSION_TOKEN))
+	ctx := requestContext(req)
+	td, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
 
 	if err != nil {
-		a.logger.Println(http.StatusUnauthorized, err.Error())
+		a.log(req).WithError(err).Warn(STATUS_UNAUTHORIZED)
 		res.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	var id string
-	if td.IsServer == true {
-		id = vars["userid"]
-		a.logger.Println("operating as server")
-	} else {
-		id = td.UserId
-	}
-
-	pw := getGivenDetail(req)["password"]
-
-	if id != "" && pw != "" {
-
-		var err error
-		toDelete := &User{Id: id}
-
-		if err = toDelete.HashPassword(pw, a.ApiConfig.Salt); err == nil {
-			if err = a.Store.RemoveUser(req.Context(), toDelete); err == nil {
-
-				a.logAudit(req, td, "DeleteUser")
-				//cleanup if any
-				if td.IsServer == false {
-					a.Store.RemoveTokenByID(req.Context(), req.Header.Get(TP_SESSION_TOKEN))
-				}
-				//all good
-				res.WriteHeader(http.StatusAccepted)
-				return
-			}
-		}
-		a.logger.Println(http.StatusInternalServerError, err.Error())
-		res.WriteHeader(http.StatusInternalServerError)
+	if opErr := a.deleteUser(ctx, td, vars["userid"], getGivenDetail(req)["password"]); opErr != nil {
+		a.sendOperationError(res, req, opErr)
 		return
 	}
-	a.logger.Println(http.StatusForbidden, STATUS_MISSING_ID_PW)
-	sendModelAsResWithStatus(res, status.NewStatus(http.StatusForbidden, STATUS_MISSING_ID_PW), http.StatusForbidden)
-	return
+	res.WriteHeader(http.StatusAccepted)
 }
 
 // @Summary Login user
@@ -648,66 +423,24 @@ func (a *Api) DeleteUser(res http.ResponseWriter, req *http.Request, vars map[st
 // @Failure 400 {object} status.Status "message returned: \"Missing id and/or password\""
 // @Router /login [post]
 func (a *Api) Login(res http.ResponseWriter, req *http.Request) {
+	a.sendLogin(res, req, extractTokenDuration(req))
+}
+
+// sendLogin logs in the user of the basic authorization, for the given token duration
+func (a *Api) sendLogin(res http.ResponseWriter, req *http.Request, durationSecs int64) {
 	user, password := unpackAuth(req.Header.Get("Authorization"))
-	if user == nil {
-		a.sendError(res, http.StatusBadRequest, STATUS_MISSING_ID_PW)
+	result, tokenData, sessionToken, opErr := a.login(requestContext(req), user, password, durationSecs)
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
 		return
 	}
 
-	// Random sleep to avoid guessing accounts user.
-	time.Sleep(time.Millisecond * time.Duration(rand.Int63n(100)))
-
-	code, elem := a.appendUserLoginInProgress(user)
-	defer a.removeUserLoginInProgress(elem)
-	if code != http.StatusOK {
-		a.sendError(res, http.StatusUnauthorized, STATUS_NO_MATCH, fmt.Sprintf("User '%s' has too many ongoing login: %d", user.Username, a.loginLimiter.totalInProgress))
-
-	} else if results, err := a.Store.FindUsers(req.Context(), user); err != nil {
-		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USR, STATUS_USER_NOT_FOUND, err)
-
-	} else if len(results) != 1 {
-		a.sendError(res, http.StatusUnauthorized, STATUS_NO_MATCH, fmt.Sprintf("User '%s' have %d matching results", user.Username, len(results)))
-
-	} else if result := results[0]; result == nil {
-		a.sendError(res, http.StatusUnauthorized, STATUS_NO_MATCH, fmt.Sprintf("User '%s' is nil", user.Username))
-
-	} else if result.IsDeleted() {
-		a.sendError(res, http.StatusUnauthorized, STATUS_NO_MATCH, fmt.Sprintf("User '%s' is marked deleted", user.Username))
-
-	} else if !result.CanPerformALogin(a.ApiConfig.MaxFailedLogin) {
-		a.sendError(res, http.StatusUnauthorized, STATUS_NO_MATCH, fmt.Sprintf("User '%s' can't perform a login yet", user.Username))
-
-	} else if !result.PasswordsMatch(password, a.ApiConfig.Salt) {
-		// Limit login failed
-		if err := a.UpdateUserAfterFailedLogin(req.Context(), result); err != nil {
-			a.logger.Printf("User '%s' failed to save failed login status [%s]", user.Username, err.Error())
-		}
-		a.sendError(res, http.StatusUnauthorized, STATUS_NO_MATCH, fmt.Sprintf("User '%s' passwords do not match", user.Username))
-
-	} else if !result.IsEmailVerified(a.ApiConfig.VerificationSecret) {
-		a.sendError(res, http.StatusForbidden, STATUS_NOT_VERIFIED)
-
-	} else {
-		// TODO: replace this workaround, there should be only one role when the data is cleaned up
-		role := "patient"
-		if result.Roles != nil && len(result.Roles) > 0 {
-			role = result.Roles[0]
-		}
-		tokenData := &token.TokenData{DurationSecs: extractTokenDuration(req), UserId: result.Id, Email: result.Username, Name: result.Username, Role: role}
-		tokenConfig := token.TokenConfig{DurationSecs: a.ApiConfig.TokenDurationSecs, Secret: a.ApiConfig.Secret}
-		if sessionToken, err := CreateSessionTokenAndSave(req.Context(), tokenData, tokenConfig, a.Store); err != nil {
-			a.sendError(res, http.StatusInternalServerError, STATUS_ERR_UPDATING_TOKEN, err)
-
-		} else {
-			a.logAudit(req, tokenData, "Login")
-			res.Header().Set(TP_SESSION_TOKEN, sessionToken.ID)
-			a.sendUser(res, result, false)
-		}
-
-		if err := a.UpdateUserAfterSuccessfulLogin(req.Context(), result); err != nil {
-			a.logger.Printf("Failed to save success login status [%s] for user %#v", err.Error(), result)
-		}
+	res.Header().Set(TP_SESSION_TOKEN, sessionToken.ID)
+	loggedUser := a.asSerializableUser(result, false).(map[string]interface{})
+	if tokenData.ConsentRequired {
+		loggedUser["consentRequired"] = true
 	}
+	sendModelAsRes(res, loggedUser)
 }
 
 // @Summary Login server
@@ -724,62 +457,15 @@ func (a *Api) Login(res http.ResponseWriter, req *http.Request) {
 // @Failure 400 {object} status.Status "message returned:\"Missing id and/or password\" "
 // @Router /serverlogin [post]
 func (a *Api) ServerLogin(res http.ResponseWriter, req *http.Request) {
-
 	// which server is knocking at the door and what password is it using to enter?
 	server, pw := req.Header.Get(TP_SERVER_NAME), req.Header.Get(TP_SERVER_SECRET)
-	// the expected secret is the secret that the requesting server is supposed to give to be delivered the token
-	expectedSecret := ""
-
-	// if server or password is not given we obviously have a problem
-	if server == "" || pw == "" {
-		a.logger.Println(http.StatusBadRequest, STATUS_MISSING_ID_PW)
-		sendModelAsResWithStatus(res, status.NewStatus(http.StatusBadRequest, STATUS_MISSING_ID_PW), http.StatusBadRequest)
-		return
-	}
-
-	// At this stage both given password and server are passed and known
-
-	// What is the expected password for this specific requesting server?
-	expectedSecret = a.ApiConfig.ServerSecrets[server]
 
-	// Case specific to all Tidepool microservices that share the same secret
-	// This is done in order to maintain the current behaviour where Tidepool servers use the default password
-	// TODO: maintain a list of possible requesting micro-services?
-	if expectedSecret == "" {
-		expectedSecret = a.ApiConfig.ServerSecrets["default"]
-	}
-
-	// If no expected secret can be compared to, we have a problem and cannot continue
-	if expectedSecret == "" {
-		a.logger.Println(http.StatusInternalServerError, STATUS_NO_EXPECTED_PWD)
-		sendModelAsResWithStatus(res, status.NewStatus(http.StatusInternalServerError, STATUS_NO_EXPECTED_PWD), http.StatusInternalServerError)
-		return
-	}
-
-	// If the expected secret is the one given at the door then we can generate a token
-	if pw == expectedSecret {
-		//generate new token
-		if sessionToken, err := CreateSessionTokenAndSave(
-			req.Context(),
-			&token.TokenData{DurationSecs: extractTokenDuration(req), UserId: server, IsServer: true},
-			token.TokenConfig{DurationSecs: a.ApiConfig.TokenDurationSecs, Secret: a.ApiConfig.Secret},
-			a.Store,
-		); err != nil {
-			// Error generating the token
-			a.logger.Println(http.StatusInternalServerError, STATUS_ERR_GENERATING_TOKEN, err.Error())
-			sendModelAsResWithStatus(res, status.NewStatus(http.StatusInternalServerError, STATUS_ERR_GENERATING_TOKEN), http.StatusInternalServerError)
-			return
-		} else {
-			// Server is provided with the generated token
-			a.logAudit(req, nil, "ServerLogin")
-			res.Header().Set(TP_SESSION_TOKEN, sessionToken.ID)
-			return
-		}
+	if sessionToken, opErr := a.serverLogin(requestContext(req), server, pw, extractTokenDuration(req)); opErr != nil {
+		a.sendOperationError(res, req, opErr)
+	} else {
+		// Server is provided with the generated token
+		res.Header().Set(TP_SESSION_TOKEN, sessionToken.ID)
 	}
-	// If the password given at the door is wrong, we cannot generate the token
-	a.logger.Println(http.StatusUnauthorized, STATUS_PW_WRONG)
-	sendModelAsResWithStatus(res, status.NewStatus(http.StatusUnauthorized, STATUS_PW_WRONG), http.StatusUnauthorized)
-	return
 }
 
 // @Summary Refresh session
@@ -796,47 +482,21 @@ func (a *Api) ServerLogin(res http.ResponseWriter, req *http.Request) {
 // @Failure 401 {string} string ""
 // @Router /login [get]
 func (a *Api) RefreshSession(res http.ResponseWriter, req *http.Request) {
-	a.logger.Printf("refresh session with trace token %v", req.Header.Get(TP_TRACE_SESSION))
-	td, err := a.authenticateSessionToken(req.Context(), req.Header.Get(TP_SESSION_TOKEN))
+	a.log(req).Debug("refresh session")
+	ctx := requestContext(req)
+	td, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
 
 	if err != nil {
-		a.logger.Println(http.StatusUnauthorized, err.Error())
+		a.log(req).WithError(err).Warn(STATUS_UNAUTHORIZED)
 		res.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	// retrieve User in Db for having last information (role)
-	user, errUser := a.Store.FindUser(req.Context(), &User{Id: td.UserId})
-	if errUser != nil {
-		a.sendError(res, http.StatusInternalServerError, STATUS_ERR_FINDING_USR, err)
-
-	} else if user == nil {
-		a.sendError(res, http.StatusUnauthorized, STATUS_UNAUTHORIZED, "User not found")
-	}
-
-	// Set Role
-	var role string
-	if user.Roles != nil && len(user.Roles) > 0 {
-		role = user.Roles[0]
-	}
-
-	//refresh token with update user information
-	newTokenData := token.TokenData{DurationSecs: extractTokenDuration(req), UserId: user.Id, IsServer: false, Role: role}
-	tokenConfig := token.TokenConfig{DurationSecs: a.ApiConfig.TokenDurationSecs, Secret: a.ApiConfig.Secret}
-	if sessionToken, err := CreateSessionTokenAndSave(
-		req.Context(),
-		&newTokenData,
-		tokenConfig,
-		a.Store,
-	); err != nil {
-		a.logger.Println(http.StatusInternalServerError, STATUS_ERR_GENERATING_TOKEN, err.Error())
-		sendModelAsResWithStatus(res, status.NewStatus(http.StatusInternalServerError, STATUS_ERR_GENERATING_TOKEN), http.StatusInternalServerError)
-		return
+	if sessionToken, opErr := a.refreshSession(ctx, td, extractTokenDuration(req)); opErr != nil {
+		a.sendOperationError(res, req, opErr)
 	} else {
-		a.logAudit(req, td, "Refresh session token with last user information")
 		res.Header().Set(TP_SESSION_TOKEN, sessionToken.ID)
 		sendModelAsRes(res, td)
-		return
 	}
 }
 
@@ -857,21 +517,11 @@ func (a *Api) RefreshSession(res http.ResponseWriter, req *http.Request) {
 // Set the longeterm duration and then process as per Login
 // note: see Login for return codes
 func (a *Api) LongtermLogin(res http.ResponseWriter, req *http.Request, vars map[string]string) {
-
-	const day_as_secs = 1 * 24 * 60 * 60
-
-	duration := a.ApiConfig.LongTermDaysDuration * day_as_secs
-	longtermkey := vars["longtermkey"]
-
-	if longtermkey == a.ApiConfig.LongTermKey {
-		a.logger.Println("token duration is ", fmt.Sprint(time.Duration(duration)*time.Second))
-		req.Header.Add(token.TOKEN_DURATION_KEY, strconv.FormatFloat(float64(duration), 'f', -1, 64))
-	} else {
-		//tell us there was no match
-		a.logger.Println("tried to login using the longtermkey but it didn't match the stored key")
+	duration := a.longtermDuration(requestContext(req), vars["longtermkey"])
+	if duration == 0 {
+		duration = extractTokenDuration(req)
 	}
-
-	a.Login(res, req)
+	a.sendLogin(res, req, duration)
 
 	// TODO: Does not actually add the TOKEN_DURATION_KEY to the response on success (as the old unittests would imply)
 }
@@ -887,22 +537,11 @@ func (a *Api) LongtermLogin(res http.ResponseWriter, req *http.Request, vars map
 // @Failure 401 {object} status.Status "message returned:\"No x-tidepool-session-token was found\" "
 // @Router /token/{token} [get]
 func (a *Api) ServerCheckToken(res http.ResponseWriter, req *http.Request, vars map[string]string) {
-
-	if hasServerToken(req.Header.Get(TP_SESSION_TOKEN), a.ApiConfig.Secret) {
-		td, err := a.authenticateSessionToken(req.Context(), vars["token"])
-		if err != nil {
-			a.logger.Printf("failed request: %v", req)
-			a.logger.Println(http.StatusUnauthorized, STATUS_NO_TOKEN, err.Error())
-			sendModelAsResWithStatus(res, status.NewStatus(http.StatusUnauthorized, STATUS_NO_TOKEN), http.StatusUnauthorized)
-			return
-		}
+	if td, opErr := a.checkToken(requestContext(req), req.Header.Get(TP_SESSION_TOKEN), vars["token"]); opErr != nil {
+		a.sendOperationError(res, req, opErr)
+	} else {
 		sendModelAsRes(res, td)
-		return
 	}
-	a.logger.Println(http.StatusUnauthorized, STATUS_NO_TOKEN)
-	a.logger.Printf("header session token: %v", req.Header.Get(TP_SESSION_TOKEN))
-	sendModelAsResWithStatus(res, status.NewStatus(http.StatusUnauthorized, STATUS_NO_TOKEN), http.StatusUnauthorized)
-	return
 }
 
 // @Summary Logout
@@ -914,16 +553,8 @@ func (a *Api) ServerCheckToken(res http.ResponseWriter, req *http.Request, vars
 // @Success 200 {string} string ""
 // @Router /logout [post]
 func (a *Api) Logout(res http.ResponseWriter, req *http.Request) {
-	if id := req.Header.Get(TP_SESSION_TOKEN); id != "" {
-		if err := a.Store.RemoveTokenByID(req.Context(), id); err != nil {
-			//silently fail but still log it
-			a.logger.Println("Logout was unable to delete token", err.Error())
-		}
-	}
-	// otherwise all good
-	a.logAudit(req, nil, "Logout")
+	a.logout(requestContext(req), req.Header.Get(TP_SESSION_TOKEN))
 	res.WriteHeader(http.StatusOK)
-	return
 }
 
 // @Summary AnonymousIdHashPair ?
@@ -951,157 +582,93 @@ func (a *Api) AnonymousIdHashPair(res http.ResponseWriter, req *http.Request) {
 // @Failure 400 {object} status.Status "message returned:\"Unknown query parameter\" or \"Error generating the token\" "
 // @Router /ext-token/{service} [post]
 func (a *Api) Get3rdPartyToken(res http.ResponseWriter, req *http.Request, vars map[string]string) {
-
-	secret := ""
 	service := vars["service"]
-	if service == "" {
-		sendModelAsResWithStatus(res, status.NewStatus(http.StatusBadRequest, STATUS_PARAMETER_UNKNOWN), http.StatusBadRequest)
-		return
-	} else {
-		secret = a.ApiConfig.TokenSecrets[service]
-	}
-
-	if secret == "" {
-		// the secret is not defined for this service
-		a.logger.Println(http.StatusBadRequest, "the service does not exist")
-		sendModelAsResWithStatus(res, status.NewStatus(http.StatusBadRequest, STATUS_ERR_GENERATING_TOKEN), http.StatusBadRequest)
+	if _, opErr := a.externalTokenSecret(service); opErr != nil {
+		a.sendOperationError(res, req, opErr)
 		return
 	}
 
-	td, err := a.authenticateSessionToken(req.Context(), req.Header.Get(TP_SESSION_TOKEN))
+	ctx := requestContext(req)
+	td, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
 
 	if err != nil {
-		a.logger.Println(http.StatusUnauthorized, err.Error())
+		a.log(req).WithError(err).Warn(STATUS_UNAUTHORIZED)
 		res.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	td.Audience = service
-	//refresh
-	if sessionToken, err := token.CreateSessionToken(
-		td,
-		token.TokenConfig{DurationSecs: a.ApiConfig.TokenDurationSecs, Secret: secret},
-	); err != nil {
-		a.logger.Println(http.StatusInternalServerError, STATUS_ERR_GENERATING_TOKEN, err.Error())
-		sendModelAsResWithStatus(res, status.NewStatus(http.StatusInternalServerError, STATUS_ERR_GENERATING_TOKEN), http.StatusInternalServerError)
-		return
+
+	if sessionToken, opErr := a.externalToken(ctx, td, service); opErr != nil {
+		a.sendOperationError(res, req, opErr)
 	} else {
-		a.logAudit(req, td, "GenerateExternalToken")
 		res.Header().Set(EXT_SESSION_TOKEN, sessionToken.ID)
 		sendModelAsRes(res, td)
-		return
 	}
 }
 
-func (a *Api) sendError(res http.ResponseWriter, statusCode int, reason string, extras ...interface{}) {
-	_, file, line, ok := runtime.Caller(1)
-	if ok {
-		segments := strings.Split(file, "/")
-		file = segments[len(segments)-1]
-	} else {
-		file = "???"
-		line = 0
+func (a *Api) sendError(res http.ResponseWriter, req *http.Request, apiErr *apiError, extras ...interface{}) {
+	a.sendErrorFrom(res, req, callerOf(1), apiErr, extras...)
+}
+
+// sendOperationError sends the failure of an operation, logged with the place where it occurred
+func (a *Api) sendOperationError(res http.ResponseWriter, req *http.Request, opErr *operationError) {
+	a.sendErrorFrom(res, req, opErr.caller, opErr.apiErr, opErr.extras...)
+}
+
+// callerOf returns the file:line of the caller skip frames above the function calling it
+func callerOf(skip int) string {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "???:0"
 	}
+	segments := strings.Split(file, "/")
+	return fmt.Sprintf("%s:%d", segments[len(segments)-1], line)
+}
+
+func (a *Api) sendErrorFrom(res http.ResponseWriter, req *http.Request, caller string, apiErr *apiError, extras ...interface{}) {
+	countError(req, apiErr)
+	a.logError(req.Context(), caller, apiErr, extras...)
+	writeError(res, req, apiErr)
+}
 
+// logError logs an error with its caller, as an error for the server errors and a warning otherwise
+func (a *Api) logError(ctx context.Context, caller string, apiErr *apiError, extras ...interface{}) {
 	messages := make([]string, len(extras))
 	for index, extra := range extras {
 		messages[index] = fmt.Sprintf("%v", extra)
 	}
 
-	switch reason {
-	case STATUS_NO_USR_DETAILS:
-		statusNoUsrDetailsCounter.Inc()
-
-	case STATUS_INVALID_USER_DETAILS:
-		statusInvalidUserDetailsCounter.Inc()
-
-	case STATUS_USER_NOT_FOUND:
-		statusUserNotFoundCounter.Inc()
-
-	case STATUS_ERR_FINDING_USR:
-		statusErrFindingUsrCounter.Inc()
-
-	case STATUS_ERR_CREATING_USR:
-		statusErrCreatingUsrCounter.Inc()
-
-	case STATUS_ERR_UPDATING_USR:
-		statusErrUpdatingUsrCounter.Inc()
-
-	case STATUS_USR_ALREADY_EXISTS:
-		statusUsrAlreadyExistsCounter.Inc()
-
-	case STATUS_ERR_GENERATING_TOKEN:
-		statusErrGeneratingTokenCounter.Inc()
-
-	case STATUS_ERR_UPDATING_TOKEN:
-		statusErrUpdatingTokenCounter.Inc()
-
-	case STATUS_MISSING_USR_DETAILS:
-		statusMissingUsrDetailsCounter.Inc()
-
-	case STATUS_ERROR_UPDATING_PW:
-		statusErrorUpdatingPwCounter.Inc()
-
-	case STATUS_MISSING_ID_PW:
-		statusMissingIdPwCounter.Inc()
-
-	case STATUS_NO_MATCH:
-		statusNoMatchCounter.Inc()
-
-	case STATUS_NOT_VERIFIED:
-		statusNotVerifiedCounter.Inc()
-
-	case STATUS_NO_TOKEN_MATCH:
-		statusNoTokenMatchCounter.Inc()
-
-	case STATUS_PW_WRONG:
-		statusPwWrongCounter.Inc()
-
-	case STATUS_ERR_SENDING_EMAIL:
-		statusErrSendingEmailCounter.Inc()
-
-	case STATUS_NO_TOKEN:
-		statusNoTokenCounter.Inc()
-
-	case STATUS_SERVER_TOKEN_REQUIRED:
-		statusServerTokenRequiredCounter.Inc()
-
-	case STATUS_AUTH_HEADER_REQUIRED:
-		statusAuthHeaderRequiredCounter.Inc()
-
-	case STATUS_AUTH_HEADER_INVLAID:
-		statusAuthHeaderInvlaidCounter.Inc()
-
-	case STATUS_GETSTATUS_ERR:
-		statusGetstatusErrCounter.Inc()
-
-	case STATUS_UNAUTHORIZED:
-		statusUnauthorizedCounter.Inc()
-
-	case STATUS_NO_QUERY:
-		statusNoQueryCounter.Inc()
-
-	case STATUS_PARAMETER_UNKNOWN:
-		statusParameterUnknownCounter.Inc()
-
-	case STATUS_ONE_QUERY_PARAM:
-		statusOneQueryParamCounter.Inc()
-
-	case STATUS_INVALID_ROLE:
-		statusInvalidRoleCounter.Inc()
+	entry := a.logContext(ctx).WithFields(logrus.Fields{
+		"caller": caller,
+		"status": apiErr.status,
+		"code":   apiErr.code,
+	})
+	if len(messages) > 0 {
+		entry = entry.WithField("details", strings.Join(messages, "; "))
+	}
+	if apiErr.status >= http.StatusInternalServerError {
+		entry.Error(apiErr.reason)
+	} else {
+		entry.Warn(apiErr.reason)
 	}
-
-	a.logger.Printf("%s:%d RESPONSE ERROR: [%d %s] %s", file, line, statusCode, reason, strings.Join(messages, "; "))
-	sendModelAsResWithStatus(res, status.NewStatus(statusCode, reason), statusCode)
 }
 
 func (a *Api) authenticateSessionToken(ctx context.Context, sessionToken string) (*token.TokenData, error) {
 	if sessionToken == "" {
+		countTokenValidation(TOKEN_INVALID)
 		return nil, errors.New("Session token is empty")
 	} else if tokenData, err := token.UnpackSessionTokenAndVerify(sessionToken, a.ApiConfig.Secret); err != nil {
+		countTokenValidation(TOKEN_INVALID)
 		return nil, err
+	} else if tokenData.TenantID != a.tenantID {
+		// the tenants may share a secret, their tokens must not be reused across them
+		countTokenValidation(TOKEN_INVALID)
+		return nil, fmt.Errorf("Session token of tenant %q", tokenData.TenantID)
 	} else if _, err := a.Store.FindTokenByID(ctx, sessionToken); err != nil {
+		countTokenValidation(TOKEN_REVOKED)
 		return nil, err
 	} else {
+		countTokenValidation(TOKEN_VALID)
+		setLogUserID(ctx, tokenData.UserId)
 		return tokenData, nil
 	}
 }
@@ -1125,7 +692,7 @@ func (a *Api) UpdateUserAfterFailedLogin(ctx context.Context, u *User) error {
 	u.FailedLogin.Total++
 	if u.FailedLogin.Count >= a.ApiConfig.MaxFailedLogin {
 		nextAttemptTime := time.Now().Add(time.Minute * time.Duration(a.ApiConfig.DelayBeforeNextLoginAttempt))
-		u.FailedLogin.NextLoginAttemptTime = nextAttemptTime.Format(time.RFC3339)
+		u.FailedLogin.NextLoginAttemptTime = nextAttemptTime.UTC().Format(time.RFC3339)
 	}
 	return a.Store.UpsertUser(ctx, u)
 }
@@ -3,140 +3,608 @@
 package user
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"net/http/httptest"
-
-	commonUserApi "github.com/tidepool-org/go-common/clients/shoreline"
+	"context"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	shorelineClient "github.com/mdblp/shoreline/clients/shoreline"
+	"github.com/mdblp/shoreline/schema"
+	"github.com/mdblp/shoreline/token"
 	"github.com/tidepool-org/go-common/clients/status"
 )
 
-func extractUserData(data string) (*commonUserApi.UserData, error) {
-	var ud commonUserApi.UserData
+const (
+	// userClientServerName is the server name of the tokens provided by the UserClient
+	userClientServerName = "shoreline"
+	// serverTokenRenewal is how long before its expiration the server token is renewed
+	serverTokenRenewal = time.Minute
+)
 
-	if err := json.Unmarshal([]byte(data), &ud); err != nil {
-		return nil, err
-	}
-	return &ud, nil
-}
+// UserClient exposes the functionality to the internal services: it implements clients/shoreline.ClientInterface
+// on top of the operations of the Api, so that embedding shoreline behaves as calling it remotely.
+type UserClient struct {
+	userapi *Api
 
-//Expose functionality to internal services
-type UserClient struct{ userapi *Api }
+	mut         sync.Mutex
+	serverToken string
+}
 
 func NewUserClient(api *Api) *UserClient {
 	return &UserClient{userapi: api}
 }
 
-//added for completeness
+// added for completeness
 func (client *UserClient) Close()       {}
 func (client *UserClient) Start() error { return nil }
 
-func (client *UserClient) Signup(username, password, email string) (*commonUserApi.UserData, error) {
+// clientError returns the error of a failed operation as the remote client does, after logging it
+func (client *UserClient) clientError(ctx context.Context, opErr *operationError) error {
+	client.userapi.logError(ctx, opErr.caller, opErr.apiErr, opErr.extras...)
+	return &shorelineClient.Error{
+		Status:    status.NewStatus(opErr.apiErr.status, opErr.apiErr.reason),
+		ErrorCode: opErr.apiErr.code,
+		Details:   opErr.apiErr.details,
+	}
+}
 
-	data := []byte(fmt.Sprintf(`{"username": "%s", "password": "%s","emails":["%s"]}`, username, password, email))
-	request, _ := http.NewRequest("POST", "/user", bytes.NewBuffer(data))
-	request.Header.Add("content-type", "application/json")
+// authenticate returns the data of the session token, or the error of the routes requiring it
+func (client *UserClient) authenticate(ctx context.Context, tkn string) (*token.TokenData, error) {
+	tokenData, err := client.userapi.authenticateSessionToken(ctx, tkn)
+	if err != nil {
+		return nil, client.clientError(ctx, fail(errUnauthorized, err))
+	}
+	return tokenData, nil
+}
 
-	response := httptest.NewRecorder()
+// asUserData returns the user as serialized by the routes
+func asUserData(user *User, isServerRequest bool) *schema.UserData {
+	userData := &schema.UserData{
+		UserID:        user.Id,
+		Username:      user.Username,
+		Emails:        user.Emails,
+		Roles:         user.Roles,
+		TermsAccepted: user.TermsAccepted,
+	}
+	if len(user.Username) > 0 || len(user.Emails) > 0 {
+		userData.EmailVerified = user.EmailVerified
+	}
+	if !user.Profile.IsEmpty() {
+		profile := schema.UserProfile(*user.Profile)
+		userData.Profile = &profile
+	}
+	if isServerRequest {
+		userData.PasswordExists = user.PwHash != ""
+	}
+	return userData
+}
 
-	client.userapi.CreateUser(response, request)
+// newUpdateUserDetails returns the details of the update, as parsed by the UpdateUser route
+func newUpdateUserDetails(update schema.UserUpdate) *UpdateUserDetails {
+	details := &UpdateUserDetails{
+		Username:        update.Username,
+		Password:        update.Password,
+		CurrentPassword: update.CurrentPassword,
+		TermsAccepted:   update.TermsAccepted,
+		EmailVerified:   update.EmailVerified,
+	}
+	if update.Emails != nil {
+		details.Emails = *update.Emails
+	}
+	if update.Roles != nil {
+		details.Roles = *update.Roles
+	}
+	if update.Profile != nil {
+		details.Profile = &ProfileDetails{
+			FirstName: update.Profile.FirstName,
+			LastName:  update.Profile.LastName,
+			Language:  update.Profile.Language,
+			Timezone:  update.Profile.Timezone,
+			Country:   update.Profile.Country,
+		}
+	}
+	details.countFields()
+	return details
+}
 
-	body, _ := ioutil.ReadAll(response.Body)
+func (client *UserClient) Signup(username, password, email string) (*schema.UserData, error) {
+	return client.SignupContext(context.Background(), username, password, email)
+}
 
-	switch response.Code {
-	case http.StatusCreated:
-		ud, err := extractUserData(string(body))
-		if err != nil {
-			return nil, err
-		}
+func (client *UserClient) SignupContext(ctx context.Context, username, password, email string) (*schema.UserData, error) {
+	details := &NewUserDetails{Username: &username, Emails: []string{email}, Password: &password}
+	newUser, _, opErr := client.userapi.createUser(ctx, details, 0)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return asUserData(newUser, false), nil
+}
 
-		return ud, nil
-	default:
-		return nil, &status.StatusError{
-			Status: status.NewStatus(response.Code, "There was an issue trying to signup a new user"),
-		}
+func (client *UserClient) Login(username, password string) (*schema.UserData, string, error) {
+	return client.LoginContext(context.Background(), username, password)
+}
+
+func (client *UserClient) LoginContext(ctx context.Context, username, password string) (*schema.UserData, string, error) {
+	return client.login(ctx, username, password, 0)
+}
+
+// LongtermLogin logs in a user as Login does, for the long-term duration given by the long-term key
+func (client *UserClient) LongtermLogin(username, password, longtermKey string) (*schema.UserData, string, error) {
+	return client.LongtermLoginContext(context.Background(), username, password, longtermKey)
+}
+
+func (client *UserClient) LongtermLoginContext(ctx context.Context, username, password, longtermKey string) (*schema.UserData, string, error) {
+	return client.login(ctx, username, password, client.userapi.longtermDuration(ctx, longtermKey))
+}
+
+func (client *UserClient) login(ctx context.Context, username, password string, durationSecs int64) (*schema.UserData, string, error) {
+	var user *User
+	if username != "" || password != "" {
+		//Note the name could infact be id, email or the username
+		user = &User{Id: username, Username: username, Emails: []string{username}}
+	}
+	result, _, sessionToken, opErr := client.userapi.login(ctx, user, password, durationSecs)
+	if opErr != nil {
+		return nil, "", client.clientError(ctx, opErr)
 	}
+	return asUserData(result, false), sessionToken.ID, nil
 }
 
-func (client *UserClient) Login(username, password string) (*commonUserApi.UserData, string, error) {
+func (client *UserClient) CheckToken(tkn string) *token.TokenData {
+	return client.CheckTokenContext(context.Background(), tkn)
+}
+
+// CheckTokenContext returns the data of the token, nil when it is invalid
+func (client *UserClient) CheckTokenContext(ctx context.Context, tkn string) *token.TokenData {
+	tokenData, opErr := client.userapi.checkToken(ctx, client.TokenProvide(), tkn)
+	if opErr != nil {
+		client.userapi.logError(ctx, opErr.caller, opErr.apiErr, opErr.extras...)
+		return nil
+	}
+	return tokenData
+}
 
-	request, _ := http.NewRequest("POST", "", nil)
-	request.SetBasicAuth(username, password)
-	response := httptest.NewRecorder()
+// TokenProvide returns a server token, renewed shortly before it expires
+func (client *UserClient) TokenProvide() string {
+	client.mut.Lock()
+	defer client.mut.Unlock()
 
-	client.userapi.Login(response, request)
+	if expiresAt, ok := token.UnverifiedExpiresAt(client.serverToken); ok && time.Until(expiresAt) > serverTokenRenewal {
+		return client.serverToken
+	}
 
-	body, _ := ioutil.ReadAll(response.Body)
+	ctx := context.Background()
+	// Shoreline, as a Tidepool microservice, is using the default password
+	sessionToken, opErr := client.userapi.serverLogin(ctx, userClientServerName, client.userapi.ApiConfig.ServerSecrets["default"], 0)
+	if opErr != nil {
+		client.userapi.logError(ctx, opErr.caller, opErr.apiErr, opErr.extras...)
+		return ""
+	}
+	client.serverToken = sessionToken.ID
+	return client.serverToken
+}
 
-	switch response.Code {
-	case 200:
-		ud, err := extractUserData(string(body))
-		if err != nil {
-			return nil, "", err
-		}
+// GetUser returns the user matching the id, username or email
+func (client *UserClient) GetUser(userID, tkn string) (*schema.UserData, error) {
+	return client.GetUserContext(context.Background(), userID, tkn)
+}
 
-		return ud, response.Header().Get("x-tidepool-session-token"), nil
-	case 404:
-		return nil, "", nil
-	default:
-		return nil, "", &status.StatusError{
-			Status: status.NewStatus(response.Code, "Unknown response code from user api"),
-		}
+func (client *UserClient) GetUserContext(ctx context.Context, userID, tkn string) (*schema.UserData, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	user, opErr := client.userapi.findUser(ctx, tokenData, userID)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
 	}
+	return asUserData(user, tokenData.IsServer), nil
 }
 
-func (client *UserClient) CheckToken(token string) *commonUserApi.TokenData {
+// UpdateUser applies the updates to the user, they are validated as those of the UpdateUser route
+func (client *UserClient) UpdateUser(userID string, userUpdate schema.UserUpdate, tkn string) error {
+	return client.UpdateUserContext(context.Background(), userID, userUpdate, tkn)
+}
 
-	serverToken := client.TokenProvide()
+func (client *UserClient) UpdateUserContext(ctx context.Context, userID string, userUpdate schema.UserUpdate, tkn string) error {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return err
+	}
+	details := newUpdateUserDetails(userUpdate)
+	if err := details.Validate(); err != nil {
+		return client.clientError(ctx, fail(errInvalidUserDetails, err))
+	}
+	if _, opErr := client.userapi.updateUser(ctx, tokenData, userID, details); opErr != nil {
+		return client.clientError(ctx, opErr)
+	}
+	return nil
+}
 
-	request, _ := http.NewRequest("GET", "", nil)
-	request.Header.Add("x-tidepool-session-token", serverToken)
-	res := httptest.NewRecorder()
-	client.userapi.ServerCheckToken(res, request, map[string]string{"token": token})
+// GetUsers searches the users with a server token, see UsersPage.NextCursor for the following pages
+func (client *UserClient) GetUsers(query schema.UsersQuery, tkn string) (*schema.UsersPage, error) {
+	return client.GetUsersContext(context.Background(), query, tkn)
+}
 
-	body, _ := ioutil.ReadAll(res.Body)
+func (client *UserClient) GetUsersContext(ctx context.Context, query schema.UsersQuery, tkn string) (*schema.UsersPage, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	users, next, opErr := client.userapi.searchUsers(ctx, tokenData, query.Values())
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	page := &schema.UsersPage{Users: make([]*schema.UserData, len(users)), NextCursor: next}
+	for index, user := range users {
+		page.Users[index] = asUserData(user, tokenData.IsServer)
+	}
+	return page, nil
+}
 
-	switch res.Code {
-	case 200:
-		var td commonUserApi.TokenData
+// DeleteUser deletes (anonymizes) a user, with its password or a server token
+func (client *UserClient) DeleteUser(userID, password, tkn string) error {
+	return client.DeleteUserContext(context.Background(), userID, password, tkn)
+}
 
-		if err := json.Unmarshal([]byte(string(body)), &td); err != nil {
-			log.Printf("Error parsing JSON results [%s]", err.Error())
-			return nil
-		}
+func (client *UserClient) DeleteUserContext(ctx context.Context, userID, password, tkn string) error {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return err
+	}
+	if opErr := client.userapi.deleteUser(ctx, tokenData, userID, password); opErr != nil {
+		return client.clientError(ctx, opErr)
+	}
+	return nil
+}
 
-		return &td
-	case 404:
-		return nil
-	default:
-		log.Printf("Unknown response code[%d] from user api", res.Code)
-		return nil
+// RefreshSession returns a new session token with the up to date user information,
+// and the data of the token which was refreshed
+func (client *UserClient) RefreshSession(tkn string) (string, *token.TokenData, error) {
+	return client.RefreshSessionContext(context.Background(), tkn)
+}
+
+func (client *UserClient) RefreshSessionContext(ctx context.Context, tkn string) (string, *token.TokenData, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return "", nil, err
 	}
+	sessionToken, opErr := client.userapi.refreshSession(ctx, tokenData, 0)
+	if opErr != nil {
+		return "", nil, client.clientError(ctx, opErr)
+	}
+	return sessionToken.ID, tokenData, nil
 }
 
-func (client *UserClient) TokenProvide() string {
+// Logout revokes a session token
+func (client *UserClient) Logout(tkn string) error {
+	return client.LogoutContext(context.Background(), tkn)
+}
 
-	request, _ := http.NewRequest("GET", "", nil)
-	request.Header.Set(TP_SERVER_NAME, "shoreline")
-	// Shoreline, as a Tidepool microservice, is using the default password
-	request.Header.Set(TP_SERVER_SECRET, client.userapi.ApiConfig.ServerSecrets["default"])
-	response := httptest.NewRecorder()
+func (client *UserClient) LogoutContext(ctx context.Context, tkn string) error {
+	client.userapi.logout(ctx, tkn)
+	return nil
+}
+
+// ExternalToken returns a token authenticating the user of the session token to a 3rd party service
+func (client *UserClient) ExternalToken(service, tkn string) (string, error) {
+	return client.ExternalTokenContext(context.Background(), service, tkn)
+}
+
+func (client *UserClient) ExternalTokenContext(ctx context.Context, service, tkn string) (string, error) {
+	if _, opErr := client.userapi.externalTokenSecret(service); opErr != nil {
+		return "", client.clientError(ctx, opErr)
+	}
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return "", err
+	}
+	sessionToken, opErr := client.userapi.externalToken(ctx, tokenData, service)
+	if opErr != nil {
+		return "", client.clientError(ctx, opErr)
+	}
+	return sessionToken.ID, nil
+}
+
+// AnonymousIdHashPair returns an anonymous id and hash, derived from the given parameters
+func (client *UserClient) AnonymousIdHashPair(params url.Values) (*schema.IdHashPair, error) {
+	return client.AnonymousIdHashPairContext(context.Background(), params)
+}
+
+func (client *UserClient) AnonymousIdHashPairContext(ctx context.Context, params url.Values) (*schema.IdHashPair, error) {
+	pair := NewAnonIdHashPair([]string{client.userapi.ApiConfig.Salt}, params)
+	return &schema.IdHashPair{Name: pair.Name, ID: pair.Id, Hash: pair.Hash}, nil
+}
+
+// CreateCustodialUser creates a patient account without password on behalf of the hcp creatorID
+func (client *UserClient) CreateCustodialUser(creatorID string, details *NewCustodialUserDetails, tkn string) (*schema.UserData, error) {
+	return client.CreateCustodialUserContext(context.Background(), creatorID, details, tkn)
+}
+
+func (client *UserClient) CreateCustodialUserContext(ctx context.Context, creatorID string, details *NewCustodialUserDetails, tkn string) (*schema.UserData, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	if err := details.Validate(); err != nil {
+		return nil, client.clientError(ctx, fail(errInvalidUserDetails, err))
+	}
+	newUser, opErr := client.userapi.createCustodialUser(ctx, tokenData, creatorID, details)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return asUserData(newUser, tokenData.IsServer), nil
+}
+
+// CreateClaimToken returns the invitation letting the patient take over the custodial account
+func (client *UserClient) CreateClaimToken(userID, tkn string) (string, error) {
+	return client.CreateClaimTokenContext(context.Background(), userID, tkn)
+}
 
-	client.userapi.ServerLogin(response, request)
+func (client *UserClient) CreateClaimTokenContext(ctx context.Context, userID, tkn string) (string, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return "", err
+	}
+	claimToken, _, opErr := client.userapi.createClaimToken(ctx, tokenData, userID)
+	if opErr != nil {
+		return "", client.clientError(ctx, opErr)
+	}
+	return claimToken, nil
+}
+
+// ClaimUser gives the custodial account of the claim token to the patient, with its email and password
+func (client *UserClient) ClaimUser(details *ClaimUserDetails) (*schema.UserData, error) {
+	return client.ClaimUserContext(context.Background(), details)
+}
+
+func (client *UserClient) ClaimUserContext(ctx context.Context, details *ClaimUserDetails) (*schema.UserData, error) {
+	if err := details.Validate(); err != nil {
+		return nil, client.clientError(ctx, fail(errInvalidUserDetails, err))
+	}
+	claimedUser, opErr := client.userapi.claimUser(ctx, details)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return asUserData(claimedUser, false), nil
+}
+
+// GetOrganizations returns all the organizations with a server token, sorted by name
+func (client *UserClient) GetOrganizations(tkn string) ([]*Organization, error) {
+	return client.GetOrganizationsContext(context.Background(), tkn)
+}
+
+func (client *UserClient) GetOrganizationsContext(ctx context.Context, tkn string) ([]*Organization, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	organizations, opErr := client.userapi.getOrganizations(ctx, tokenData)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return organizations, nil
+}
+
+// GetOrganization returns an organization with a server token
+func (client *UserClient) GetOrganization(organizationID, tkn string) (*Organization, error) {
+	return client.GetOrganizationContext(context.Background(), organizationID, tkn)
+}
+
+func (client *UserClient) GetOrganizationContext(ctx context.Context, organizationID, tkn string) (*Organization, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	organization, opErr := client.userapi.getOrganization(ctx, tokenData, organizationID)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return organization, nil
+}
+
+// CreateOrganization creates an organization with a server token
+func (client *UserClient) CreateOrganization(details *OrganizationDetails, tkn string) (*Organization, error) {
+	return client.CreateOrganizationContext(context.Background(), details, tkn)
+}
+
+func (client *UserClient) CreateOrganizationContext(ctx context.Context, details *OrganizationDetails, tkn string) (*Organization, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	if err := details.Validate(); err != nil {
+		return nil, client.clientError(ctx, fail(errInvalidOrganizationDetails, err))
+	}
+	organization, opErr := client.userapi.createOrganization(ctx, tokenData, details)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return organization, nil
+}
+
+// UpdateOrganization renames an organization with a server token
+func (client *UserClient) UpdateOrganization(organizationID string, details *OrganizationDetails, tkn string) (*Organization, error) {
+	return client.UpdateOrganizationContext(context.Background(), organizationID, details, tkn)
+}
+
+func (client *UserClient) UpdateOrganizationContext(ctx context.Context, organizationID string, details *OrganizationDetails, tkn string) (*Organization, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	if err := details.Validate(); err != nil {
+		return nil, client.clientError(ctx, fail(errInvalidOrganizationDetails, err))
+	}
+	organization, opErr := client.userapi.updateOrganization(ctx, tokenData, organizationID, details)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return organization, nil
+}
+
+// DeleteOrganization deletes an organization and the memberships of its members, with a server token
+func (client *UserClient) DeleteOrganization(organizationID, tkn string) error {
+	return client.DeleteOrganizationContext(context.Background(), organizationID, tkn)
+}
+
+func (client *UserClient) DeleteOrganizationContext(ctx context.Context, organizationID, tkn string) error {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return err
+	}
+	if opErr := client.userapi.deleteOrganization(ctx, tokenData, organizationID); opErr != nil {
+		return client.clientError(ctx, opErr)
+	}
+	return nil
+}
+
+// GetOrganizationMembers returns the members of an organization with their role, with a server token
+func (client *UserClient) GetOrganizationMembers(organizationID, tkn string) ([]OrganizationMemberInfo, error) {
+	return client.GetOrganizationMembersContext(context.Background(), organizationID, tkn)
+}
+
+func (client *UserClient) GetOrganizationMembersContext(ctx context.Context, organizationID, tkn string) ([]OrganizationMemberInfo, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	members, opErr := client.userapi.getOrganizationMembers(ctx, tokenData, organizationID)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return members, nil
+}
 
-	log.Print(USER_API_PREFIX, "UserClient.TokenProvide")
+// UpsertOrganizationMember adds a hcp user to an organization, or changes its role, with a server token
+func (client *UserClient) UpsertOrganizationMember(organizationID, userID, role, tkn string) (*OrganizationMemberInfo, error) {
+	return client.UpsertOrganizationMemberContext(context.Background(), organizationID, userID, role, tkn)
+}
 
-	return response.Header().Get(TP_SESSION_TOKEN)
+func (client *UserClient) UpsertOrganizationMemberContext(ctx context.Context, organizationID, userID, role, tkn string) (*OrganizationMemberInfo, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	if !IsValidOrganizationRole(role) {
+		return nil, client.clientError(ctx, fail(errInvalidOrganizationRole, Organization_error_role_invalid))
+	}
+	member, opErr := client.userapi.upsertOrganizationMember(ctx, tokenData, organizationID, userID, role)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return member, nil
 }
 
-func (client *UserClient) GetUser(userID, token string) (*commonUserApi.UserData, error) {
-	return nil, nil
+// RemoveOrganizationMember removes a user from an organization, with a server token
+func (client *UserClient) RemoveOrganizationMember(organizationID, userID, tkn string) error {
+	return client.RemoveOrganizationMemberContext(context.Background(), organizationID, userID, tkn)
 }
 
-func (client *UserClient) UpdateUser(userID string, userUpdate commonUserApi.UserUpdate, token string) error {
+func (client *UserClient) RemoveOrganizationMemberContext(ctx context.Context, organizationID, userID, tkn string) error {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return err
+	}
+	if opErr := client.userapi.removeOrganizationMember(ctx, tokenData, organizationID, userID); opErr != nil {
+		return client.clientError(ctx, opErr)
+	}
 	return nil
 }
+
+// GetConsents returns the consents of the user, only those of the given type when not empty
+func (client *UserClient) GetConsents(userID, consentType, tkn string) (*ConsentsStatus, error) {
+	return client.GetConsentsContext(context.Background(), userID, consentType, tkn)
+}
+
+func (client *UserClient) GetConsentsContext(ctx context.Context, userID, consentType, tkn string) (*ConsentsStatus, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	status, opErr := client.userapi.getConsents(ctx, tokenData, userID, consentType)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return status, nil
+}
+
+// AddConsent records the acceptance of a document version by the user
+func (client *UserClient) AddConsent(userID string, version ConsentVersion, tkn string) (*ConsentsStatus, error) {
+	return client.AddConsentContext(context.Background(), userID, version, tkn)
+}
+
+func (client *UserClient) AddConsentContext(ctx context.Context, userID string, version ConsentVersion, tkn string) (*ConsentsStatus, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	status, opErr := client.userapi.addConsent(ctx, tokenData, userID, &ConsentDetails{Type: &version.Type, Version: &version.Version})
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return status, nil
+}
+
+// ImportUsers creates users in bulk from a csv or ndjson file with a server token, see the ImportUsers route
+func (client *UserClient) ImportUsers(reader io.Reader, format string, dryRun, invite bool, tkn string) (*ImportReport, error) {
+	return client.ImportUsersContext(context.Background(), reader, format, dryRun, invite, tkn)
+}
+
+func (client *UserClient) ImportUsersContext(ctx context.Context, reader io.Reader, format string, dryRun, invite bool, tkn string) (*ImportReport, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	if opErr := requireServer(tokenData); opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	rows, opErr := parseImport(reader, format)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	report, opErr := client.userapi.importUsers(ctx, tokenData, rows, dryRun, invite)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return report, nil
+}
+
+// ExportUsers gives the users matching the query of the ExportUsers route one after the other to fn,
+// with a server token. The export stops on the first error of fn.
+func (client *UserClient) ExportUsers(query url.Values, fn func(exported map[string]interface{}) error, tkn string) error {
+	return client.ExportUsersContext(context.Background(), query, fn, tkn)
+}
+
+func (client *UserClient) ExportUsersContext(ctx context.Context, query url.Values, fn func(exported map[string]interface{}) error, tkn string) error {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return err
+	}
+	search, fields, opErr := client.userapi.usersExport(tokenData, query)
+	if opErr != nil {
+		return client.clientError(ctx, opErr)
+	}
+	if opErr := client.userapi.exportUsers(ctx, tokenData, search, fields, fn); opErr != nil {
+		return client.clientError(ctx, opErr)
+	}
+	return nil
+}
+
+// ExportUserData returns everything shoreline holds on the user, for the subject-access requests
+func (client *UserClient) ExportUserData(userID, tkn string) (*PersonalDataExport, error) {
+	return client.ExportUserDataContext(context.Background(), userID, tkn)
+}
+
+func (client *UserClient) ExportUserDataContext(ctx context.Context, userID, tkn string) (*PersonalDataExport, error) {
+	tokenData, err := client.authenticate(ctx, tkn)
+	if err != nil {
+		return nil, err
+	}
+	export, opErr := client.userapi.exportUserData(ctx, tokenData, userID)
+	if opErr != nil {
+		return nil, client.clientError(ctx, opErr)
+	}
+	return export, nil
+}
@@ -0,0 +1,174 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	shorelineClient "github.com/mdblp/shoreline/clients/shoreline"
+	"github.com/mdblp/shoreline/schema"
+)
+
+var _ shorelineClient.ClientInterface = &UserClient{}
+
+func Test_UserClient_Signup_Login(t *testing.T) {
+	client := NewUserClient(responsableShoreline)
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{}, nil}}
+	responsableStore.UpsertUserResponses = []error{nil}
+	responsableStore.AddTokenResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	userData, err := client.Signup("a@z.co", "12345678", "a@z.co")
+	if err != nil || userData.UserID == "" || userData.Username != "a@z.co" || !userData.HasRole("patient") {
+		t.Fatalf("Unexpected signup %+v %v", userData, err)
+	}
+
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{}, nil}}
+	_, _, err = client.Login("a@z.co", "12345678")
+	if !errors.Is(err, shorelineClient.ErrPasswordMismatch) {
+		t.Fatalf("Unexpected login error %#v", err)
+	}
+}
+
+func Test_UserClient_GetUser_UpdateUser(t *testing.T) {
+	client := NewUserClient(responsableShoreline)
+	sessionToken := T_CreateSessionToken(t, "1111111111", false, TOKEN_DURATION)
+	user := &User{Id: "1111111111", Username: "a@z.co", Emails: []string{"a@z.co"}, Roles: []string{"patient"}, Profile: &Profile{FirstName: "Ann"}}
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}, {sessionToken, nil}}
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{user}, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{user, nil}}
+	responsableStore.UpsertUserResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	userData, err := client.GetUser("", sessionToken.ID)
+	if err != nil || userData.UserID != "1111111111" || userData.Profile == nil || userData.Profile.FirstName != "Ann" || userData.PasswordExists {
+		t.Fatalf("Unexpected user %+v %v", userData, err)
+	}
+
+	termsAccepted := "2016-01-01T01:23:45-08:00"
+	if err := client.UpdateUser("1111111111", schema.UserUpdate{TermsAccepted: &termsAccepted}, sessionToken.ID); err != nil {
+		t.Fatalf("Unexpected update error %v", err)
+	}
+
+	invalid := "not a time"
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	err = client.UpdateUser("1111111111", schema.UserUpdate{TermsAccepted: &invalid}, sessionToken.ID)
+	if !errors.Is(err, shorelineClient.ErrInvalidUserDetails) {
+		t.Fatalf("Unexpected update error %#v", err)
+	}
+}
+
+func Test_UserClient_CheckToken(t *testing.T) {
+	client := NewUserClient(responsableShoreline)
+	sessionToken := T_CreateSessionToken(t, "1111111111", false, TOKEN_DURATION)
+	// the server token of the client, then the checked token
+	responsableStore.AddTokenResponses = []error{nil}
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}, {nil, errors.New("not found")}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	if tokenData := client.CheckToken(sessionToken.ID); tokenData == nil || tokenData.UserId != "1111111111" {
+		t.Fatalf("Unexpected token data %+v", tokenData)
+	}
+	// the server token is reused
+	if tokenData := client.CheckToken(sessionToken.ID); tokenData != nil {
+		t.Fatalf("Unexpected token data of a revoked token %+v", tokenData)
+	}
+}
+
+func Test_UserClient_GetUsers(t *testing.T) {
+	client := NewUserClient(responsableShoreline)
+	sessionToken := T_CreateSessionToken(t, "shoreline", true, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	responsableStore.SearchUsersResponses = []SearchUsersResponse{{[]*User{{Id: "1111111111", Username: "a@z.co", Roles: []string{"hcp"}}}, "next", nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	page, err := client.GetUsers(schema.UsersQuery{Role: "hcp", Limit: 1}, sessionToken.ID)
+	if err != nil || page.NextCursor != "next" || len(page.Users) != 1 || page.Users[0].UserID != "1111111111" || !page.Users[0].HasRole("hcp") {
+		t.Fatalf("Unexpected page %+v %v", page, err)
+	}
+}
+
+func Test_UserClient_DeleteUser_Error(t *testing.T) {
+	client := NewUserClient(responsableShoreline)
+	sessionToken := T_CreateSessionToken(t, "shoreline", true, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	err := client.DeleteUser("1111111111", "", sessionToken.ID)
+	var shorelineErr *shorelineClient.Error
+	if !errors.As(err, &shorelineErr) || shorelineErr.Code != 403 || shorelineErr.Reason != STATUS_MISSING_ID_PW {
+		t.Fatalf("Unexpected error %#v", err)
+	}
+}
+
+func Test_UserClient_RefreshSession_Logout(t *testing.T) {
+	client := NewUserClient(responsableShoreline)
+	sessionToken := T_CreateSessionToken(t, "1111111111", false, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}, {sessionToken, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", Roles: []string{"patient"}}, nil}}
+	responsableStore.AddTokenResponses = []error{nil}
+	responsableStore.RemoveTokenByIDResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	refreshed, tokenData, err := client.RefreshSession(sessionToken.ID)
+	if err != nil || refreshed == "" || tokenData.UserId != "1111111111" {
+		t.Fatalf("Unexpected refresh %s %v %v", refreshed, tokenData, err)
+	}
+	externalToken, err := client.ExternalToken("zendesk", sessionToken.ID)
+	if err != nil || externalToken == "" {
+		t.Fatalf("Unexpected external token %s %v", externalToken, err)
+	}
+	if err := client.Logout(sessionToken.ID); err != nil {
+		t.Fatalf("Unexpected logout error %v", err)
+	}
+}
+
+func Test_UserClient_AnonymousIdHashPair(t *testing.T) {
+	client := NewUserClient(responsableShoreline)
+
+	pair, err := client.AnonymousIdHashPair(url.Values{"one": {"two"}})
+	if err != nil || len(pair.ID) != 10 || len(pair.Hash) != 24 {
+		t.Fatalf("Unexpected id hash pair %+v %v", pair, err)
+	}
+}
+
+func Test_UserClient_Organizations_Consents(t *testing.T) {
+	store := NewMemoryStoreClient()
+	client := NewUserClient(InitAPITest(FAKE_CONFIG, logger, store))
+	serverToken := client.TokenProvide()
+	ctx := context.Background()
+	if err := store.UpsertUser(ctx, &User{Id: "1111111111", Username: "a@z.co", Emails: []string{"a@z.co"}, Roles: []string{"hcp"}}); err != nil {
+		t.Fatalf("Failed to store the user: %v", err)
+	}
+
+	name := "Clinic"
+	organization, err := client.CreateOrganization(&OrganizationDetails{Name: &name}, serverToken)
+	if err != nil || organization.Name != name {
+		t.Fatalf("Unexpected organization %+v %v", organization, err)
+	}
+	if _, err := client.UpsertOrganizationMember(organization.Id, "1111111111", ORGANIZATION_ROLE_ADMIN, serverToken); err != nil {
+		t.Fatalf("Unexpected member error %v", err)
+	}
+	members, err := client.GetOrganizationMembers(organization.Id, serverToken)
+	if err != nil || len(members) != 1 || members[0].Role != ORGANIZATION_ROLE_ADMIN {
+		t.Fatalf("Unexpected members %+v %v", members, err)
+	}
+
+	status, err := client.AddConsent("1111111111", ConsentVersion{Type: "terms", Version: "1.0"}, serverToken)
+	if err != nil || len(status.Consents) != 1 || status.Consents[0].IP != "" {
+		t.Fatalf("Unexpected consents %+v %v", status, err)
+	}
+	var clientErr *shorelineClient.Error
+	if _, err := client.AddConsent("1111111111", ConsentVersion{Type: "cookies", Version: "1.0"}, serverToken); !errors.As(err, &clientErr) || clientErr.ErrorCode != schema.ErrorInvalidConsent {
+		t.Fatalf("Unexpected consent error %#v", err)
+	}
+
+	sessionToken := T_CreateSessionToken(t, "1111111111", false, TOKEN_DURATION)
+	if err := store.AddToken(ctx, sessionToken); err != nil {
+		t.Fatalf("Failed to store the token: %v", err)
+	}
+	if _, err := client.GetOrganizations(sessionToken.ID); !errors.Is(err, shorelineClient.ErrUnauthorized) {
+		t.Fatalf("Only the servers should get the organizations, got %#v", err)
+	}
+}
@@ -9,10 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"reflect"
 	"regexp"
 	"strings"
@@ -21,7 +19,9 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mdblp/shoreline/audit"
 	"github.com/mdblp/shoreline/token"
+	"github.com/sirupsen/logrus"
 	"github.com/tidepool-org/go-common/clients/version"
 )
 
@@ -30,7 +30,7 @@ const (
 	MAKE_IT_FAIL = true
 )
 
-func InitAPITest(cfg ApiConfig, logger *log.Logger, store Storage) *Api {
+func InitAPITest(cfg ApiConfig, logger *logrus.Logger, store Storage) *Api {
 	cfg.ServerSecrets = make(map[string]string)
 	for _, sec := range cfg.Secrets {
 		cfg.ServerSecrets[sec.Secret] = sec.Pass
@@ -39,7 +39,7 @@ func InitAPITest(cfg ApiConfig, logger *log.Logger, store Storage) *Api {
 		Store:          store,
 		ApiConfig:      cfg,
 		logger:         logger,
-		auditLogger:    logger,
+		auditLogger:    audit.NewLogger(false, audit.NewStdoutSink()),
 	}
 	api.loginLimiter.usersInProgress = list.New()
 	return &api
@@ -76,7 +76,7 @@ var (
 	/*
 	 * expected path
 	 */
-	logger             = log.New(os.Stdout, USER_API_PREFIX, log.LstdFlags|log.Lshortfile)
+	logger             = logrus.New()
 	mockStore          = NewMockStoreClient(FAKE_CONFIG.Salt, false, false)
 	shoreline          = InitAPITest(FAKE_CONFIG, logger, mockStore)
 	/*
@@ -173,6 +173,25 @@ func T_ExpectErrorResponse(t *testing.T, response *httptest.ResponseRecorder, ex
 	}
 }
 
+func T_ExpectErrorResponseWithCode(t *testing.T, response *httptest.ResponseRecorder, expectedCode int, expectedReason string, expectedErrorCode string) map[string]interface{} {
+	if response.Code != expectedCode {
+		t.Fatalf("Unexpected response status code: %d", response.Code)
+	}
+
+	var errorResponse map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&errorResponse); err != nil {
+		t.Fatalf("Error parsing response body: %#v", err)
+	}
+
+	if reason := errorResponse["reason"]; reason != expectedReason {
+		t.Fatalf("Unexpected response error reason: %#v", reason)
+	}
+	if errorCode := errorResponse["errorCode"]; errorCode != expectedErrorCode {
+		t.Fatalf("Unexpected response error code: %#v", errorCode)
+	}
+	return errorResponse
+}
+
 func T_ExpectSuccessResponse(t *testing.T, response *httptest.ResponseRecorder, expectedCode int) string {
 	if response.Code != expectedCode {
 		t.Fatalf("Unexpected response status code: %d", response.Code)
@@ -259,6 +278,15 @@ func T_ExpectResponsablesEmpty(t *testing.T) {
 		if len(responsableStore.FindUsersResponses) > 0 {
 			t.Logf("FindUsersResponses still available")
 		}
+		if len(responsableStore.InsertUsersResponses) > 0 {
+			t.Logf("InsertUsersResponses still available")
+		}
+		if len(responsableStore.SearchUsersResponses) > 0 {
+			t.Logf("SearchUsersResponses still available")
+		}
+		if len(responsableStore.ExportUsersResponses) > 0 {
+			t.Logf("ExportUsersResponses still available")
+		}
 		if len(responsableStore.FindUserResponses) > 0 {
 			t.Logf("FindUserResponses still available")
 		}
@@ -274,6 +302,39 @@ func T_ExpectResponsablesEmpty(t *testing.T) {
 		if len(responsableStore.RemoveTokenByIDResponses) > 0 {
 			t.Logf("RemoveTokenByIDResponses still available")
 		}
+		if len(responsableStore.FindTokensByUserIDResponses) > 0 {
+			t.Logf("FindTokensByUserIDResponses still available")
+		}
+		if len(responsableStore.RemoveTokensByUserIDResponses) > 0 {
+			t.Logf("RemoveTokensByUserIDResponses still available")
+		}
+		if len(responsableStore.ReplaceUserResponses) > 0 {
+			t.Logf("ReplaceUserResponses still available")
+		}
+		if len(responsableStore.UpsertOrganizationResponses) > 0 {
+			t.Logf("UpsertOrganizationResponses still available")
+		}
+		if len(responsableStore.FindOrganizationResponses) > 0 {
+			t.Logf("FindOrganizationResponses still available")
+		}
+		if len(responsableStore.FindOrganizationsResponses) > 0 {
+			t.Logf("FindOrganizationsResponses still available")
+		}
+		if len(responsableStore.RemoveOrganizationResponses) > 0 {
+			t.Logf("RemoveOrganizationResponses still available")
+		}
+		if len(responsableStore.FindUsersByOrganizationResponses) > 0 {
+			t.Logf("FindUsersByOrganizationResponses still available")
+		}
+		if len(responsableStore.UpsertOrganizationMemberResponses) > 0 {
+			t.Logf("UpsertOrganizationMemberResponses still available")
+		}
+		if len(responsableStore.RemoveOrganizationMemberResponses) > 0 {
+			t.Logf("RemoveOrganizationMemberResponses still available")
+		}
+		if len(responsableStore.AddUserConsentResponses) > 0 {
+			t.Logf("AddUserConsentResponses still available")
+		}
 		responsableStore.Reset()
 		t.Fail()
 	}
@@ -393,7 +454,7 @@ func Test_GetUsers_Error_InvalidQuery(t *testing.T) {
 func Test_GetUsers_Error_FindUsersWithIdsError(t *testing.T) {
 	sessionToken := T_CreateSessionToken(t, "abcdef1234", true, TOKEN_DURATION)
 	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
-	responsableStore.FindUsersWithIdsResponses = []FindUsersWithIdsResponse{{[]*User{}, errors.New("ERROR")}}
+	responsableStore.SearchUsersResponses = []SearchUsersResponse{{[]*User{}, "", errors.New("ERROR")}}
 	defer T_ExpectResponsablesEmpty(t)
 
 	headers := http.Header{}
@@ -447,7 +508,7 @@ func Test_GetUsers_Error_FindUsersWithIdsSuccess(t *testing.T) {
 func Test_GetUsers_Error_FindUsersByRoleError(t *testing.T) {
 	sessionToken := T_CreateSessionToken(t, "abcdef1234", true, TOKEN_DURATION)
 	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
-	responsableStore.FindUsersByRoleResponses = []FindUsersByRoleResponse{{[]*User{}, errors.New("ERROR")}}
+	responsableStore.SearchUsersResponses = []SearchUsersResponse{{[]*User{}, "", errors.New("ERROR")}}
 	defer T_ExpectResponsablesEmpty(t)
 
 	headers := http.Header{}
@@ -459,7 +520,7 @@ func Test_GetUsers_Error_FindUsersByRoleError(t *testing.T) {
 func Test_GetUsers_Error_FindUsersByRoleSuccess(t *testing.T) {
 	sessionToken := T_CreateSessionToken(t, "abcdef1234", true, TOKEN_DURATION)
 	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
-	responsableStore.FindUsersByRoleResponses = []FindUsersByRoleResponse{{[]*User{{Id: "0000000000"}, {Id: "1111111111"}}, nil}}
+	responsableStore.SearchUsersResponses = []SearchUsersResponse{{[]*User{{Id: "0000000000"}, {Id: "1111111111"}}, "", nil}}
 	defer T_ExpectResponsablesEmpty(t)
 
 	headers := http.Header{}
@@ -469,6 +530,45 @@ func Test_GetUsers_Error_FindUsersByRoleSuccess(t *testing.T) {
 	T_ExpectEqualsArray(t, successResponse, []interface{}{map[string]interface{}{"userid": "0000000000", "passwordExists": false}, map[string]interface{}{"userid": "1111111111", "passwordExists": false}})
 }
 
+func Test_GetUsers_Error_InvalidParameter(t *testing.T) {
+	sessionToken := T_CreateSessionToken(t, "abcdef1234", true, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	response := T_PerformRequestHeaders(t, "GET", "/users?role=hcp&createdFrom=yesterday", headers)
+	T_ExpectErrorResponse(t, response, 400, "Invalid query parameter")
+}
+
+func Test_GetUsers_Error_InvalidCursor(t *testing.T) {
+	sessionToken := T_CreateSessionToken(t, "abcdef1234", true, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	responsableStore.SearchUsersResponses = []SearchUsersResponse{{nil, "", ErrInvalidSearchCursor}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	response := T_PerformRequestHeaders(t, "GET", "/users?role=hcp", headers)
+	T_ExpectErrorResponse(t, response, 400, "Invalid query parameter")
+}
+
+func Test_GetUsers_Search(t *testing.T) {
+	sessionToken := T_CreateSessionToken(t, "abcdef1234", true, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	responsableStore.SearchUsersResponses = []SearchUsersResponse{{[]*User{{Id: "0000000000"}}, "next", nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	response := T_PerformRequestHeaders(t, "GET", "/users?role=hcp&id=0000000000,1111111111&email=a@&emailVerified=true&deleted=false&sort=-createdTime&limit=1", headers)
+	successResponse := T_ExpectSuccessResponseWithJSONArray(t, response, 200)
+	T_ExpectEqualsArray(t, successResponse, []interface{}{map[string]interface{}{"userid": "0000000000", "passwordExists": false}})
+	if next := response.Header().Get(USERS_NEXT_CURSOR); next != "next" {
+		t.Fatalf("Unexpected next cursor %q", next)
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 func Test_CreateUser_Error_MissingBody(t *testing.T) {
@@ -827,6 +927,39 @@ func Test_UpdateUser_Success_UserFromToken(t *testing.T) {
 	T_ExpectEqualsMap(t, successResponse, map[string]interface{}{"emailVerified": false, "emails": []interface{}{"a@z.co"}, "username": "a@z.co", "termsAccepted": "2016-01-01T01:23:45-08:00"})
 }
 
+func Test_UpdateUser_Success_Profile(t *testing.T) {
+	sessionToken := T_CreateSessionToken(t, "1111111111", false, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}, {sessionToken, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", Profile: &Profile{FirstName: "John", Country: "FR"}}, nil}, {&User{Id: "1111111111", Profile: &Profile{Country: "FR"}}, nil}}
+	responsableStore.UpsertUserResponses = []error{nil, nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	body := "{\"updates\": {\"profile\": {\"firstName\": \"Jane\", \"lastName\": \"Doe\", \"language\": \"fr\", \"timezone\": \"Europe/Paris\"}}}"
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	response := T_PerformRequestBodyHeaders(t, "PUT", "/user", body, headers)
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	T_ExpectEqualsMap(t, successResponse, map[string]interface{}{"userid": "1111111111", "profile": map[string]interface{}{"firstName": "Jane", "lastName": "Doe", "language": "fr", "timezone": "Europe/Paris", "country": "FR"}})
+
+	// clearing all its attributes removes the profile
+	body = "{\"updates\": {\"profile\": {\"country\": \"\"}}}"
+	response = T_PerformRequestBodyHeaders(t, "PUT", "/user", body, headers)
+	successResponse = T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	T_ExpectEqualsMap(t, successResponse, map[string]interface{}{"userid": "1111111111"})
+}
+
+func Test_UpdateUser_Error_InvalidProfile(t *testing.T) {
+	sessionToken := T_CreateSessionToken(t, "1111111111", false, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	body := "{\"updates\": {\"profile\": {\"timezone\": \"Mars/Olympus\"}}}"
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	response := T_PerformRequestBodyHeaders(t, "PUT", "/user", body, headers)
+	T_ExpectErrorResponse(t, response, 400, "Invalid user details were given")
+}
+
 func Test_UpdateUser_Success_AuthorizedRoles_Caregiver(t *testing.T) {
 	sessionToken := T_CreateSessionToken(t, "1111111111", false, TOKEN_DURATION)
 	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
@@ -980,7 +1113,7 @@ func TestDeleteUser_StatusForbidden_WhenNoPw(t *testing.T) {
 
 	body, _ := ioutil.ReadAll(response.Body)
 
-	if string(body) != `{"code":403,"reason":"Missing id and/or password"}` {
+	if string(body) != `{"code":403,"reason":"Missing id and/or password","errorCode":"missing_credentials"}` {
 		t.Fatalf("Message given [%s] expected [%s] ", string(body), STATUS_MISSING_ID_PW)
 	}
 }
@@ -1002,7 +1135,7 @@ func TestDeleteUser_StatusForbidden_WhenEmptyPw(t *testing.T) {
 
 	body, _ := ioutil.ReadAll(response.Body)
 
-	if string(body) != `{"code":403,"reason":"Missing id and/or password"}` {
+	if string(body) != `{"code":403,"reason":"Missing id and/or password","errorCode":"missing_credentials"}` {
 		t.Fatalf("Message given [%s] expected [%s] ", string(body), STATUS_MISSING_ID_PW)
 	}
 }
@@ -1093,7 +1226,7 @@ func Test_Login_Error_FindUsersMissing(t *testing.T) {
 	headers := http.Header{}
 	headers.Add("Authorization", authorization)
 	response := T_PerformRequestHeaders(t, "POST", "/login", headers)
-	T_ExpectErrorResponse(t, response, 401, "No user matched the given details")
+	T_ExpectErrorResponseWithCode(t, response, 401, "No user matched the given details", "password_mismatch")
 }
 
 func Test_Login_Error_FindUsersNil(t *testing.T) {
@@ -1128,7 +1261,7 @@ func Test_Login_Error_PasswordMismatch(t *testing.T) {
 	headers := http.Header{}
 	headers.Add("Authorization", authorization)
 	response := T_PerformRequestHeaders(t, "POST", "/login", headers)
-	T_ExpectErrorResponse(t, response, 401, "No user matched the given details")
+	T_ExpectErrorResponseWithCode(t, response, 401, "No user matched the given details", "password_mismatch")
 }
 
 func Test_Login_Error_AccountLock(t *testing.T) {
@@ -1149,7 +1282,11 @@ func Test_Login_Error_AccountLock(t *testing.T) {
 	headers := http.Header{}
 	headers.Add("Authorization", authorization)
 	response := T_PerformRequestHeaders(t, "POST", "/login", headers)
-	T_ExpectErrorResponse(t, response, 401, "No user matched the given details")
+	errorResponse := T_ExpectErrorResponseWithCode(t, response, 401, "No user matched the given details", "account_locked")
+	details, _ := errorResponse["details"].(map[string]interface{})
+	if details["nextLoginAttemptTime"] != user.FailedLogin.NextLoginAttemptTime {
+		t.Fatalf("Unexpected error details: %#v", errorResponse["details"])
+	}
 }
 
 func Test_Login_Error_EmailNotVerified(t *testing.T) {
@@ -1160,7 +1297,7 @@ func Test_Login_Error_EmailNotVerified(t *testing.T) {
 	headers := http.Header{}
 	headers.Add("Authorization", authorization)
 	response := T_PerformRequestHeaders(t, "POST", "/login", headers)
-	T_ExpectErrorResponse(t, response, 403, "The user hasn't verified this account yet")
+	T_ExpectErrorResponseWithCode(t, response, 403, "The user hasn't verified this account yet", "email_not_verified")
 }
 
 func Test_Login_Error_ErrorCreatingToken(t *testing.T) {
@@ -1192,6 +1329,25 @@ func Test_Login_Success(t *testing.T) {
 	}
 }
 
+func Test_Login_Success_Profile(t *testing.T) {
+	authorization := T_CreateAuthorization(t, "a@b.co", "password")
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{&User{Id: "1111111111", Username: "a@z.co", Emails: []string{"a@z.co"}, PwHash: "d1fef52139b0d120100726bcb43d5cc13d41e4b5", EmailVerified: true, Profile: &Profile{FirstName: "Jane", LastName: "Doe", Language: "fr", Country: "FR"}}}, nil}}
+	responsableStore.AddTokenResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add("Authorization", authorization)
+	response := T_PerformRequestHeaders(t, "POST", "/login", headers)
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	if successResponse["profile"] == nil {
+		t.Fatalf("The login response should have the profile: %v", successResponse)
+	}
+	tokenData, err := token.UnpackSessionTokenAndVerify(response.Header().Get(TP_SESSION_TOKEN), TOKEN_CONFIG.Secret)
+	if err != nil || tokenData.Name != "Jane Doe" || tokenData.Email != "a@z.co" || tokenData.Locale != "fr-FR" {
+		t.Fatalf("The token should have the name and locale of the profile: %v %v", tokenData, err)
+	}
+}
+
 func Test_Login_Success_Password_Complex(t *testing.T) {
 	authorization := T_CreateAuthorization(t, "a@b.co", "`-=[]\\;',./~!@#$%^&*)(_+}{|\":<>?`¡™£¢∞§¶•ª–≠‘“æ…÷≥”’")
 	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{&User{Id: "1111111111", Username: "a@z.co", Emails: []string{"a@z.co"}, TermsAccepted: "2016-01-01T01:23:45-08:00", PwHash: "80464ae775ca97187d29bc4b3e391e959947138a", EmailVerified: true}}, nil}}
@@ -1225,7 +1381,7 @@ func TestServerLogin_StatusBadRequest_WhenNoNameOrSecret(t *testing.T) {
 
 	body, _ := ioutil.ReadAll(response.Body)
 
-	if string(body) != `{"code":400,"reason":"Missing id and/or password"}` {
+	if string(body) != `{"code":400,"reason":"Missing id and/or password","errorCode":"missing_credentials"}` {
 		t.Fatalf("Message given [%s] expected [%s] ", string(body), STATUS_MISSING_ID_PW)
 	}
 }
@@ -1245,7 +1401,7 @@ func TestServerLogin_StatusBadRequest_WhenNoName(t *testing.T) {
 
 	body, _ := ioutil.ReadAll(response.Body)
 
-	if string(body) != `{"code":400,"reason":"Missing id and/or password"}` {
+	if string(body) != `{"code":400,"reason":"Missing id and/or password","errorCode":"missing_credentials"}` {
 		t.Fatalf("Message given [%s] expected [%s] ", string(body), STATUS_MISSING_ID_PW)
 	}
 }
@@ -1265,7 +1421,7 @@ func TestServerLogin_StatusBadRequest_WhenNoSecret(t *testing.T) {
 
 	body, _ := ioutil.ReadAll(response.Body)
 
-	if string(body) != `{"code":400,"reason":"Missing id and/or password"}` {
+	if string(body) != `{"code":400,"reason":"Missing id and/or password","errorCode":"missing_credentials"}` {
 		t.Fatalf("Message given [%s] expected [%s] ", string(body), STATUS_MISSING_ID_PW)
 	}
 }
@@ -1321,7 +1477,7 @@ func TestServerLogin_StatusUnauthorized_WhenSecretWrong(t *testing.T) {
 
 	body, _ := ioutil.ReadAll(response.Body)
 
-	if string(body) != `{"code":401,"reason":"Wrong password"}` {
+	if string(body) != `{"code":401,"reason":"Wrong password","errorCode":"server_secret_mismatch"}` {
 		t.Fatalf("Message given [%s] expected [%s] ", string(body), STATUS_PW_WRONG)
 	}
 }
@@ -1545,7 +1701,7 @@ func TestHasServerToken_True(t *testing.T) {
 		t.Fatal("The session token should have been set")
 	}
 
-	if hasServerToken(response.Header().Get(TP_SESSION_TOKEN), shoreline.ApiConfig.Secret) == false {
+	if hasServerToken(response.Header().Get(TP_SESSION_TOKEN), shoreline.ApiConfig.Secret, "") == false {
 		t.Fatal("The token should have been a valid server token")
 	}
 }
@@ -0,0 +1,152 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdblp/shoreline/audit"
+)
+
+const (
+	// AUDIT_NEXT_CURSOR response header holding the cursor of the next page of audit events
+	AUDIT_NEXT_CURSOR = "x-audit-next-cursor"
+)
+
+var errUnknownFormat = errors.New("unknown format")
+
+type auditEventsPage struct {
+	Events []*audit.Event `json:"events"`
+	Next   string         `json:"next,omitempty"`
+}
+
+// @Summary Get audit events
+// @Description Query the audit trail, for compliance exports. Results are returned in chronological order, use the "next" cursor to get the following page.
+// @ID shoreline-user-api-getauditevents
+// @Produce json
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Param targetUser query string false "Id of the user the actions were performed on"
+// @Param actor query string false "Id of the user or server which performed the actions"
+// @Param action query string false "Action name, e.g. UpdateUser"
+// @Param organization query string false "Id of the organization the actions were performed on"
+// @Param from query string false "Start time (RFC3339), inclusive"
+// @Param to query string false "End time (RFC3339), exclusive"
+// @Param cursor query string false "Cursor returned by the previous page"
+// @Param limit query int false "Page size, up to 1000"
+// @Param format query string false "Output format" Enums(json, ndjson, csv)
+// @Security TidepoolAuth
+// @Success 200 {object} user.auditEventsPage
+// @Header 200 {string} x-audit-next-cursor "cursor of the next page, absent on the last page"
+// @Failure 500 {object} status.Status "message returned:\"Error finding audit events\" "
+// @Failure 501 {object} status.Status "message returned:\"Audit events are not queryable\" "
+// @Failure 400 {object} status.Status "message returned:\"Invalid query parameter\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /audit [get]
+func (a *Api) GetAuditEvents(res http.ResponseWriter, req *http.Request) {
+	ctx := requestContext(req)
+	tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
+	if err != nil {
+		a.sendError(res, req, errUnauthorized, err)
+		return
+	}
+	if !tokenData.IsServer {
+		a.sendError(res, req, errUnauthorized)
+		return
+	}
+
+	reader := a.auditLogger.Reader()
+	if reader == nil {
+		a.sendError(res, req, errAuditUnavailable)
+		return
+	}
+
+	query, format, err := parseAuditQuery(req)
+	if err != nil {
+		a.sendError(res, req, errInvalidQuery, err)
+		return
+	}
+	// the server tokens of a tenant only read its events
+	query.TenantID = a.tenantID
+
+	events, next, err := reader.Find(ctx, query)
+	if err == audit.ErrInvalidCursor {
+		a.sendError(res, req, errInvalidQuery, err)
+		return
+	} else if err != nil {
+		a.sendError(res, req, errFindingAudit, err)
+		return
+	}
+
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "GetAuditEvents", TargetUserID: query.TargetUserID})
+
+	if next != "" {
+		res.Header().Set(AUDIT_NEXT_CURSOR, next)
+	}
+	switch format {
+	case "ndjson":
+		res.Header().Set("content-type", "application/x-ndjson")
+		res.WriteHeader(http.StatusOK)
+		err = audit.WriteNDJSON(res, events)
+	case "csv":
+		res.Header().Set("content-type", "text/csv")
+		res.Header().Set("content-disposition", `attachment; filename="audit.csv"`)
+		res.WriteHeader(http.StatusOK)
+		err = audit.WriteCSV(res, events)
+	default:
+		sendModelAsRes(res, auditEventsPage{Events: events, Next: next})
+	}
+	if err != nil {
+		a.log(req).WithError(err).Error("Error writing audit events export")
+	}
+}
+
+// parseAuditQuery extracts the audit query and the output format from the request
+func parseAuditQuery(req *http.Request) (*audit.Query, string, error) {
+	values := req.URL.Query()
+	query := &audit.Query{
+		TargetUserID:   values.Get("targetUser"),
+		ActorID:        values.Get("actor"),
+		Action:         values.Get("action"),
+		OrganizationID: values.Get("organization"),
+		Cursor:         values.Get("cursor"),
+	}
+
+	var err error
+	if from := values.Get("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return nil, "", err
+		}
+	}
+	if to := values.Get("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return nil, "", err
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, "", err
+		}
+	}
+
+	format := values.Get("format")
+	if format == "" {
+		accept := req.Header.Get("Accept")
+		switch {
+		case strings.Contains(accept, "application/x-ndjson"):
+			format = "ndjson"
+		case strings.Contains(accept, "text/csv"):
+			format = "csv"
+		default:
+			format = "json"
+		}
+	}
+	switch format {
+	case "json", "ndjson", "csv":
+		return query, format, nil
+	default:
+		return nil, "", errUnknownFormat
+	}
+}
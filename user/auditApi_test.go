@@ -0,0 +1,151 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mdblp/shoreline/audit"
+)
+
+func T_PerformAuditRequest(t *testing.T, api *Api, url string, isServer bool) *httptest.ResponseRecorder {
+	sessionToken := T_CreateSessionToken(t, "abcdef1234", isServer, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+
+	request, _ := http.NewRequest("GET", url, nil)
+	request.Header.Set(TP_SESSION_TOKEN, sessionToken.ID)
+	response := httptest.NewRecorder()
+	router := mux.NewRouter()
+	api.SetHandlers("", router)
+	router.ServeHTTP(response, request)
+	return response
+}
+
+func initAuditAPITest() (*Api, *audit.MemorySink) {
+	sink := audit.NewMemorySink()
+	api := InitAPITest(FAKE_CONFIG, logger, responsableStore)
+	api.auditLogger = audit.NewLogger(false, sink)
+	ctx := context.Background()
+	api.auditLogger.Log(ctx, &audit.Event{Action: "Login", ActorType: audit.ActorUser, ActorID: "1234", TargetUserID: "1234"})
+	api.auditLogger.Log(ctx, &audit.Event{Action: "UpdateUser", ActorType: audit.ActorServer, ActorID: "portal", TargetUserID: "1234", Fields: []string{"emails"}})
+	api.auditLogger.Log(ctx, &audit.Event{Action: "Login", ActorType: audit.ActorUser, ActorID: "5678", TargetUserID: "5678"})
+	return api, sink
+}
+
+func Test_GetAuditEvents_Error_NotServerToken(t *testing.T) {
+	api, _ := initAuditAPITest()
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/audit?targetUser=1234", false)
+	T_ExpectErrorResponse(t, response, 401, "Not authorized for requested operation")
+}
+
+func Test_GetAuditEvents_Error_NotQueryable(t *testing.T) {
+	api := InitAPITest(FAKE_CONFIG, logger, responsableStore)
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/audit?targetUser=1234", true)
+	T_ExpectErrorResponse(t, response, 501, "Audit events are not queryable")
+}
+
+func Test_GetAuditEvents_Error_InvalidQuery(t *testing.T) {
+	api, _ := initAuditAPITest()
+	defer T_ExpectResponsablesEmpty(t)
+
+	for _, url := range []string{"/audit?from=yesterday", "/audit?limit=ten", "/audit?format=xml", "/audit?cursor=abc"} {
+		response := T_PerformAuditRequest(t, api, url, true)
+		T_ExpectErrorResponse(t, response, 400, "Invalid query parameter")
+	}
+}
+
+func Test_GetAuditEvents_Success_JSON(t *testing.T) {
+	api, sink := initAuditAPITest()
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/audit?targetUser=1234&limit=1", true)
+	page := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	events := page["events"].([]interface{})
+	if len(events) != 1 || events[0].(map[string]interface{})["action"] != "Login" {
+		t.Fatalf("Unexpected events %#v", events)
+	}
+	next := response.Header().Get(AUDIT_NEXT_CURSOR)
+	if next == "" || page["next"] != next {
+		t.Fatalf("Expected a next cursor, got header %q and body %#v", next, page["next"])
+	}
+
+	response = T_PerformAuditRequest(t, api, "/audit?targetUser=1234&limit=1&cursor="+next, true)
+	page = T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	events = page["events"].([]interface{})
+	if len(events) != 1 || events[0].(map[string]interface{})["action"] != "UpdateUser" {
+		t.Fatalf("Unexpected events %#v", events)
+	}
+
+	// The query itself is audited
+	stored := sink.Events()
+	if last := stored[len(stored)-1]; last.Action != "GetAuditEvents" || last.ActorType != audit.ActorServer {
+		t.Fatalf("Expected the audit query to be audited, got %#v", last)
+	}
+}
+
+func Test_GetAuditEvents_Success_NDJSON(t *testing.T) {
+	api, _ := initAuditAPITest()
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/audit?action=Login&format=ndjson", true)
+	if response.Code != 200 || response.Header().Get("content-type") != "application/x-ndjson" {
+		t.Fatalf("Unexpected response %d %s", response.Code, response.Header().Get("content-type"))
+	}
+	decoder := json.NewDecoder(response.Body)
+	count := 0
+	for decoder.More() {
+		var event audit.Event
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatalf("Unable to decode event: %v", err)
+		}
+		if event.Action != "Login" {
+			t.Fatalf("Unexpected event %#v", event)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("Expected 2 events, got %d", count)
+	}
+}
+
+func Test_GetAuditEvents_Success_CSV(t *testing.T) {
+	api, _ := initAuditAPITest()
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/audit?actor=portal&format=csv", true)
+	if response.Code != 200 || response.Header().Get("content-type") != "text/csv" {
+		t.Fatalf("Unexpected response %d %s", response.Code, response.Header().Get("content-type"))
+	}
+	lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], ",portal,server,1234,UpdateUser,emails,success,") {
+		t.Fatalf("Unexpected CSV export %q", response.Body.String())
+	}
+}
+
+func Test_GetAuditEvents_Success_Organization(t *testing.T) {
+	api, _ := initAuditAPITest()
+	defer T_ExpectResponsablesEmpty(t)
+	api.logAuditContext(context.Background(), nil, &audit.Event{Action: "CreateOrganization", OrganizationID: "0123456789"})
+	api.logAuditContext(context.Background(), nil, &audit.Event{Action: "UpsertOrganizationMember", TargetUserID: "1234", OrganizationID: "0123456789", OrganizationRole: "admin"})
+	api.logAuditContext(context.Background(), nil, &audit.Event{Action: "CreateOrganization", OrganizationID: "9876543210"})
+
+	response := T_PerformAuditRequest(t, api, "/audit?organization=0123456789", true)
+	if response.Code != 200 {
+		t.Fatalf("Unexpected response code %d", response.Code)
+	}
+	var page auditEventsPage
+	if err := json.Unmarshal(response.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Unable to decode response: %v", err)
+	}
+	if len(page.Events) != 2 || page.Events[1].OrganizationRole != "admin" || len(page.Events[1].Fields) != 0 {
+		t.Fatalf("Expected the events of the organization, got %v", page.Events)
+	}
+}
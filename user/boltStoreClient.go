@@ -0,0 +1,149 @@
+package user
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// BoltConfig is the configuration of the embedded store
+type BoltConfig struct {
+	// Path of the database file, created when it does not exist
+	Path string `json:"path"`
+	// Timeout to wait for the lock of the file, held by the process which has it open
+	Timeout time.Duration `json:"timeout"`
+}
+
+// FromEnv takes the path from the BOLT_PATH environment variable, when set
+func (config *BoltConfig) FromEnv() {
+	if path, found := os.LookupEnv("BOLT_PATH"); found {
+		config.Path = path
+	}
+}
+
+// BoltStoreClient is a Storage in a bbolt database file, for a single node or the local development:
+// the data is kept across the restarts, but the file can only be opened by one process at a time.
+//
+// The collections are buckets of BSON documents, keyed as in the MemoryStoreClient,
+// so it has the same semantics as the Mongo Client. The users are indexed by username, email and role
+// in buckets updated in the same transactions, which are used by the lookups and the unique checks.
+type BoltStoreClient struct {
+	documentStore
+	db     *bolt.DB
+	logger *logrus.Logger
+}
+
+// boltCollections are documentCollections in the buckets named by a tenant prefix and the collection name
+type boltCollections struct {
+	db     *bolt.DB
+	prefix string
+}
+
+// boltTx is a documentTx on a bbolt transaction, whose buckets are created by the first write
+type boltTx struct {
+	tx     *bolt.Tx
+	prefix string
+}
+
+// NewBoltStore opens (or creates) the database file, it fails when the file is opened by another process
+func NewBoltStore(config *BoltConfig, logger *logrus.Logger) (*BoltStoreClient, error) {
+	if config.Path == "" {
+		return nil, errors.New("path is missing")
+	}
+	db, err := bolt.Open(config.Path, 0600, &bolt.Options{Timeout: config.Timeout})
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("Store opened at %s", config.Path)
+	return &BoltStoreClient{
+		documentStore: newDocumentStore(&boltCollections{db: db}),
+		db:            db,
+		logger:        logger,
+	}, nil
+}
+
+// ForTenant returns a client sharing the database file, whose buckets are named by the database and collection prefix of a tenant
+func (b *BoltStoreClient) ForTenant(database string, collectionPrefix string) *BoltStoreClient {
+	prefix := collectionPrefix
+	if database != "" {
+		prefix = database + "." + collectionPrefix
+	}
+	tenant := *b
+	tenant.documentStore = newDocumentStore(&boltCollections{db: b.db, prefix: prefix})
+	return &tenant
+}
+
+// Close closes the database file, shared by the tenants
+func (b *BoltStoreClient) Close() error {
+	return b.db.Close()
+}
+
+// Ping checks that the database file is still open
+func (b *BoltStoreClient) Ping() error {
+	return b.db.View(func(*bolt.Tx) error { return nil })
+}
+
+func (b *BoltStoreClient) PingOK() bool {
+	return b.Ping() == nil
+}
+
+func (b *boltCollections) view(fn func(documentTx) error) error {
+	return b.db.View(func(tx *bolt.Tx) error { return fn(&boltTx{tx: tx, prefix: b.prefix}) })
+}
+
+func (b *boltCollections) update(fn func(documentTx) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error { return fn(&boltTx{tx: tx, prefix: b.prefix}) })
+}
+
+func (b *boltTx) bucket(collection string) *bolt.Bucket {
+	return b.tx.Bucket([]byte(b.prefix + collection))
+}
+
+// get copies the document, the values of bbolt being only valid during the transaction
+func (b *boltTx) get(collection string, key string) bson.Raw {
+	bucket := b.bucket(collection)
+	if bucket == nil {
+		return nil
+	}
+	value := bucket.Get([]byte(key))
+	if value == nil {
+		return nil
+	}
+	return append(bson.Raw(nil), value...)
+}
+
+func (b *boltTx) put(collection string, key string, document bson.Raw) error {
+	bucket, err := b.tx.CreateBucketIfNotExists([]byte(b.prefix + collection))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), document)
+}
+
+func (b *boltTx) delete(collection string, key string) error {
+	bucket := b.bucket(collection)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Delete([]byte(key))
+}
+
+// forEach seeks the prefix in the keys, which are sorted
+func (b *boltTx) forEach(collection string, prefix string, fn func(key string, document bson.Raw) error) error {
+	bucket := b.bucket(collection)
+	if bucket == nil {
+		return nil
+	}
+	cursor := bucket.Cursor()
+	for key, value := cursor.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, value = cursor.Next() {
+		if err := fn(string(key), append(bson.Raw(nil), value...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
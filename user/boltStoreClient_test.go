@@ -0,0 +1,104 @@
+package user
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ Storage = &BoltStoreClient{}
+
+func TestBoltStore_Persistence(t *testing.T) {
+	ctx := context.Background()
+	config := &BoltConfig{Path: filepath.Join(t.TempDir(), "shoreline.db"), Timeout: 100 * time.Millisecond}
+	store, err := NewBoltStore(config, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to open the store: %v", err)
+	}
+	if err := store.UpsertUser(ctx, &User{Id: "1234", Username: "test@foo.bar"}); err != nil {
+		t.Fatalf("Failed to upsert the user: %v", err)
+	}
+	tenant := store.ForTenant("tenant", "prefix_")
+	if err := tenant.UpsertUser(ctx, &User{Id: "5678", Username: "other@foo.bar"}); err != nil {
+		t.Fatalf("Failed to upsert the tenant user: %v", err)
+	}
+
+	// the file is locked by the store
+	if _, err := NewBoltStore(config, logrus.New()); err == nil {
+		t.Fatalf("The file should not be opened twice")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to close the store: %v", err)
+	}
+	if store.PingOK() {
+		t.Errorf("The closed store should not be pinged")
+	}
+
+	store, err = NewBoltStore(config, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to reopen the store: %v", err)
+	}
+	defer store.Close()
+	if found, err := store.FindUser(ctx, &User{Id: "1234"}); err != nil || found.Username != "test@foo.bar" {
+		t.Errorf("Expected the user to be kept, got %v, %v", found, err)
+	}
+	if users, _ := store.FindUsersWithIds(ctx, []string{"5678"}); len(users) != 0 {
+		t.Errorf("The tenant users should not be found, got %v", users)
+	}
+	if users, _ := store.ForTenant("tenant", "prefix_").FindUsersWithIds(ctx, []string{"5678"}); len(users) != 1 {
+		t.Errorf("Expected the tenant user, got %v", users)
+	}
+}
+
+func TestBoltStore_Indexes(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewBoltStore(&BoltConfig{Path: filepath.Join(t.TempDir(), "shoreline.db"), Timeout: 100 * time.Millisecond}, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to open the store: %v", err)
+	}
+	defer store.Close()
+	store.UpsertUser(ctx, &User{Id: "1234", Username: "Test@Foo.bar", Emails: []string{"test@foo.bar"}, Roles: []string{"hcp"}})
+	store.UpsertUser(ctx, &User{Id: "1234", Username: "renamed@foo.bar", Roles: []string{"patient"}})
+
+	indexed := map[string][]string{}
+	store.db.View(func(tx *bolt.Tx) error {
+		for index := range userIndexes {
+			if bucket := tx.Bucket([]byte(index)); bucket != nil {
+				bucket.ForEach(func(key []byte, value []byte) error {
+					indexed[index] = append(indexed[index], string(key))
+					return nil
+				})
+			}
+		}
+		return nil
+	})
+	expected := map[string][]string{
+		USERS_COLLECTION + ".username": {"renamed@foo.bar" + indexSeparator + "1234"},
+		USERS_COLLECTION + ".emails":   {"test@foo.bar" + indexSeparator + "1234"},
+		USERS_COLLECTION + ".roles":    {"patient" + indexSeparator + "1234"},
+	}
+	if !reflect.DeepEqual(indexed, expected) {
+		t.Errorf("Expected the indexes of the updated user %q, got %q", expected, indexed)
+	}
+	if users, err := store.FindUsers(ctx, &User{Username: "test@foo.bar"}); err != nil || len(users) != 0 {
+		t.Errorf("The previous username should not be found, got %v, %v", users, err)
+	}
+	if users, err := store.FindUsersByRole(ctx, "hcp"); err != nil || len(users) != 0 {
+		t.Errorf("The previous role should not be found, got %v, %v", users, err)
+	}
+
+	if err := store.RemoveUser(ctx, &User{Id: "1234"}); err != nil {
+		t.Fatalf("Failed to remove the user: %v", err)
+	}
+	if users, err := store.FindUsers(ctx, &User{Username: "renamed@foo.bar", Emails: []string{"test@foo.bar"}}); err != nil || len(users) != 0 {
+		t.Errorf("The removed user should not be found, got %v, %v", users, err)
+	}
+	if err := store.UpsertUser(ctx, &User{Id: "5678", Username: "RENAMED@foo.bar", Emails: []string{"test@foo.bar"}}); err != nil {
+		t.Errorf("The username and email of the removed user should be free, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Consent is the acceptance by the user of a version of a document, stored with the user
+type Consent struct {
+	Type         string `json:"type" bson:"type"`
+	Version      string `json:"version" bson:"version"`
+	AcceptedTime string `json:"acceptedTime" bson:"acceptedTime"`
+	// IP address the consent was given from, cleared when the user is anonymized
+	IP string `json:"ip,omitempty" bson:"ip,omitempty"`
+}
+
+// ConsentVersion is a version of a document, the configured ones must be accepted by all the users
+type ConsentVersion struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+}
+
+/*
+ * Incoming consent details used to record a `Consent`
+ */
+type ConsentDetails struct {
+	Type    *string
+	Version *string
+}
+
+const (
+	// Types of the documents the users consent to
+	CONSENT_TERMS        = "terms"
+	CONSENT_PRIVACY      = "privacy"
+	CONSENT_DATA_SHARING = "dataSharing"
+
+	consentVersionMaxLength = 64
+)
+
+var (
+	Consent_error_details_missing = errors.New("Consent details are missing")
+	Consent_error_type_invalid    = errors.New("Consent type is invalid")
+	Consent_error_version_invalid = errors.New("Consent version is invalid")
+)
+
+func IsValidConsentType(consentType string) bool {
+	switch consentType {
+	case CONSENT_TERMS:
+		return true
+	case CONSENT_PRIVACY:
+		return true
+	case CONSENT_DATA_SHARING:
+		return true
+	default:
+		return false
+	}
+}
+
+func (details *ConsentDetails) ExtractFromJSON(reader io.Reader) error {
+	if reader == nil {
+		return Consent_error_details_missing
+	}
+
+	var decoded map[string]interface{}
+	if err := json.NewDecoder(reader).Decode(&decoded); err != nil {
+		return err
+	}
+
+	consentType, ok := ExtractString(decoded, "type")
+	if !ok {
+		return Consent_error_type_invalid
+	}
+	version, ok := ExtractString(decoded, "version")
+	if !ok {
+		return Consent_error_version_invalid
+	}
+
+	details.Type = consentType
+	details.Version = version
+	return nil
+}
+
+func (details *ConsentDetails) Validate() error {
+	if details.Type == nil || !IsValidConsentType(*details.Type) {
+		return Consent_error_type_invalid
+	}
+	if details.Version == nil || *details.Version == "" || len(*details.Version) > consentVersionMaxLength {
+		return Consent_error_version_invalid
+	}
+	return nil
+}
+
+func ParseConsentDetails(reader io.Reader) (*ConsentDetails, error) {
+	details := &ConsentDetails{}
+	if err := details.ExtractFromJSON(reader); err != nil {
+		return nil, err
+	} else if err := details.Validate(); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// HasConsent returns true if the user accepted this version of the document
+func (u *User) HasConsent(version ConsentVersion) bool {
+	for _, consent := range u.Consents {
+		if consent.Type == version.Type && consent.Version == version.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingConsents returns the required versions the user has not accepted yet
+func (u *User) MissingConsents(required []ConsentVersion) []ConsentVersion {
+	missing := []ConsentVersion{}
+	for _, version := range required {
+		if !u.HasConsent(version) {
+			missing = append(missing, version)
+		}
+	}
+	return missing
+}
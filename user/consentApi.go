@@ -0,0 +1,94 @@
+package user
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// ConsentsStatus are the consents given by a user, and the required versions it has not accepted yet
+type ConsentsStatus struct {
+	Consents []Consent `json:"consents"`
+	// Missing are the required versions the user has not accepted yet
+	Missing         []ConsentVersion `json:"missing"`
+	ConsentRequired bool             `json:"consentRequired"`
+}
+
+// @Summary Get the consents of a user
+// @Description Get the consents given by the user, and the current versions of the required documents it has not accepted yet
+// @ID shoreline-user-api-getconsents
+// @Produce json
+// @Param userid path string true "user id"
+// @Param type query string false "Type of the document" Enums(terms, privacy, dataSharing)
+// @Security TidepoolAuth
+// @Success 200 {object} user.ConsentsStatus
+// @Failure 500 {object} status.Status "message returned:\"Error finding user\" "
+// @Failure 404 {object} status.Status "message returned:\"User not found\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /user/{userid}/consents [get]
+func (a *Api) GetConsents(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	ctx := requestContext(req)
+	tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
+	if err != nil {
+		a.sendError(res, req, errUnauthorized, err)
+		return
+	}
+	status, opErr := a.getConsents(ctx, tokenData, vars["userid"], req.URL.Query().Get("type"))
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsRes(res, status)
+}
+
+// @Summary Record a consent
+// @Description Record the acceptance by the user of a version of a document, with the time and the IP address of the request
+// @ID shoreline-user-api-addconsent
+// @Accept json
+// @Produce json
+// @Param userid path string true "user id"
+// @Param consent body user.ConsentVersion true "type (terms, privacy or dataSharing) and version of the document"
+// @Security TidepoolAuth
+// @Success 201 {object} user.ConsentsStatus
+// @Failure 500 {object} status.Status "message returned:\"Error finding user\" or \"Error updating user\" "
+// @Failure 404 {object} status.Status "message returned:\"User not found\" "
+// @Failure 400 {object} status.Status "message returned:\"Invalid consent details were given\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /user/{userid}/consents [post]
+func (a *Api) AddConsent(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	ctx := requestContext(req)
+	tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
+	if err != nil {
+		a.sendError(res, req, errUnauthorized, err)
+		return
+	}
+	details := &ConsentDetails{}
+	if err := details.ExtractFromJSON(req.Body); err != nil {
+		a.sendError(res, req, errInvalidConsent, err)
+		return
+	}
+	status, opErr := a.addConsent(ctx, tokenData, vars["userid"], details)
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsResWithStatus(res, status, http.StatusCreated)
+}
+
+func (a *Api) consentsStatus(user *User) *ConsentsStatus {
+	status := &ConsentsStatus{Consents: user.Consents, Missing: user.MissingConsents(a.ApiConfig.RequiredConsents)}
+	if status.Consents == nil {
+		status.Consents = []Consent{}
+	}
+	status.ConsentRequired = len(status.Missing) > 0
+	return status
+}
+
+// remoteIP is the address of the client of the request (see requestContext) without its port, empty out of a request
+func remoteIP(ctx context.Context) string {
+	source, _ := ctx.Value(auditSourceKey{}).(auditSource)
+	if host, _, err := net.SplitHostPort(source.remoteAddr); err == nil {
+		return host
+	}
+	return source.remoteAddr
+}
@@ -0,0 +1,112 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mdblp/shoreline/schema"
+	"github.com/mdblp/shoreline/token"
+)
+
+func T_RequireConsents(required ...ConsentVersion) func() {
+	responsableShoreline.ApiConfig.RequiredConsents = required
+	return func() { responsableShoreline.ApiConfig.RequiredConsents = nil }
+}
+
+func T_UserTokenHeaders(t *testing.T, userID string) http.Header {
+	sessionToken := T_CreateSessionToken(t, userID, false, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	return headers
+}
+
+func Test_GetConsents_Error_Unauthorized(t *testing.T) {
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestHeaders(t, "GET", "/user/1111111111/consents", T_UserTokenHeaders(t, "2222222222"))
+	T_ExpectErrorResponse(t, response, 401, STATUS_UNAUTHORIZED)
+}
+
+func Test_GetConsents_Error_NotFound(t *testing.T) {
+	responsableStore.FindUserResponses = []FindUserResponse{{nil, nil}, {&User{Id: "1111111111", DeletedTime: "2021-01-01T00:00:00Z"}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestHeaders(t, "GET", "/user/1111111111/consents", T_ServerTokenHeaders(t))
+	T_ExpectErrorResponse(t, response, 404, STATUS_USER_NOT_FOUND)
+	response = T_PerformRequestHeaders(t, "GET", "/user/1111111111/consents", T_ServerTokenHeaders(t))
+	T_ExpectErrorResponse(t, response, 404, STATUS_USER_NOT_FOUND)
+}
+
+func Test_GetConsents(t *testing.T) {
+	defer T_RequireConsents(ConsentVersion{CONSENT_TERMS, "2.0"}, ConsentVersion{CONSENT_PRIVACY, "1.0"})()
+	consents := []Consent{{Type: CONSENT_TERMS, Version: "1.0"}, {Type: CONSENT_TERMS, Version: "2.0"}, {Type: CONSENT_DATA_SHARING, Version: "1.0"}}
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", Consents: consents}, nil}, {&User{Id: "1111111111", Consents: consents}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestHeaders(t, "GET", "/user/1111111111/consents", T_UserTokenHeaders(t, "1111111111"))
+	body := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	if len(body["consents"].([]interface{})) != 3 || body["consentRequired"] != true {
+		t.Fatalf("Unexpected consents %v", body)
+	}
+	T_ExpectEqualsArray(t, body["missing"].([]interface{}), []interface{}{map[string]interface{}{"type": "privacy", "version": "1.0"}})
+
+	response = T_PerformRequestHeaders(t, "GET", "/user/1111111111/consents?type=terms", T_ServerTokenHeaders(t))
+	body = T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	if len(body["consents"].([]interface{})) != 2 {
+		t.Fatalf("Only the terms consents should be returned: %v", body)
+	}
+}
+
+func Test_AddConsent_Error_Invalid(t *testing.T) {
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111"}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "POST", "/user/1111111111/consents", `{"type": "cookies", "version": "1.0"}`, T_UserTokenHeaders(t, "1111111111"))
+	T_ExpectErrorResponseWithCode(t, response, 400, STATUS_INVALID_CONSENT, schema.ErrorInvalidConsent)
+}
+
+func Test_AddConsent_Error_Store(t *testing.T) {
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111"}, nil}}
+	responsableStore.AddUserConsentResponses = []error{errors.New("ERROR")}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "POST", "/user/1111111111/consents", `{"type": "terms", "version": "1.0"}`, T_UserTokenHeaders(t, "1111111111"))
+	T_ExpectErrorResponse(t, response, 500, STATUS_ERR_UPDATING_USR)
+}
+
+func Test_AddConsent(t *testing.T) {
+	defer T_RequireConsents(ConsentVersion{CONSENT_TERMS, "2.0"})()
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", Consents: []Consent{{Type: CONSENT_TERMS, Version: "1.0"}}}, nil}}
+	responsableStore.AddUserConsentResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "POST", "/user/1111111111/consents", `{"type": "terms", "version": "2.0"}`, T_UserTokenHeaders(t, "1111111111"))
+	body := T_ExpectSuccessResponseWithJSONMap(t, response, 201)
+	consents := body["consents"].([]interface{})
+	added := consents[len(consents)-1].(map[string]interface{})
+	if len(consents) != 2 || added["version"] != "2.0" || added["acceptedTime"] == "" || body["consentRequired"] != false {
+		t.Fatalf("Unexpected consents %v", body)
+	}
+}
+
+func Test_Login_ConsentRequired(t *testing.T) {
+	defer T_RequireConsents(ConsentVersion{CONSENT_TERMS, "2.0"})()
+	authorization := T_CreateAuthorization(t, "a@b.co", "password")
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{{Id: "1111111111", Username: "a@z.co", Emails: []string{"a@z.co"}, PwHash: "d1fef52139b0d120100726bcb43d5cc13d41e4b5", EmailVerified: true, Consents: []Consent{{Type: CONSENT_TERMS, Version: "1.0"}}}}, nil}}
+	responsableStore.AddTokenResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add("Authorization", authorization)
+	response := T_PerformRequestHeaders(t, "POST", "/login", headers)
+	body := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	if body["consentRequired"] != true {
+		t.Fatalf("The login response should tell the consent is required: %v", body)
+	}
+	tokenData, err := token.UnpackSessionTokenAndVerify(response.Header().Get(TP_SESSION_TOKEN), TOKEN_CONFIG.Secret)
+	if err != nil || !tokenData.ConsentRequired {
+		t.Fatalf("The token should have the consent_required claim: %v %v", tokenData, err)
+	}
+}
@@ -0,0 +1,42 @@
+package user
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ParseConsentDetails(t *testing.T) {
+	details, err := ParseConsentDetails(strings.NewReader(`{"type": "privacy", "version": "2.1"}`))
+	if err != nil || *details.Type != CONSENT_PRIVACY || *details.Version != "2.1" {
+		t.Fatalf("Unexpected details %v %v", details, err)
+	}
+
+	for body, expectedErr := range map[string]error{
+		`{"type": "cookies", "version": "1"}`:                             Consent_error_type_invalid,
+		`{"version": "1"}`:                                                Consent_error_type_invalid,
+		`{"type": 1, "version": "1"}`:                                     Consent_error_type_invalid,
+		`{"type": "terms"}`:                                               Consent_error_version_invalid,
+		`{"type": "terms", "version": ""}`:                                Consent_error_version_invalid,
+		`{"type": "terms", "version": "` + strings.Repeat("1", 65) + `"}`: Consent_error_version_invalid,
+	} {
+		if _, err := ParseConsentDetails(strings.NewReader(body)); err != expectedErr {
+			t.Fatalf("%s: expected %v, got %v", body, expectedErr, err)
+		}
+	}
+	if _, err := ParseConsentDetails(nil); err != Consent_error_details_missing {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func Test_User_MissingConsents(t *testing.T) {
+	user := &User{Consents: []Consent{{Type: CONSENT_TERMS, Version: "1.0"}, {Type: CONSENT_TERMS, Version: "2.0"}, {Type: CONSENT_PRIVACY, Version: "1.0"}}}
+	required := []ConsentVersion{{Type: CONSENT_TERMS, Version: "2.0"}, {Type: CONSENT_PRIVACY, Version: "1.1"}}
+
+	missing := user.MissingConsents(required)
+	if len(missing) != 1 || missing[0].Type != CONSENT_PRIVACY || missing[0].Version != "1.1" {
+		t.Fatalf("Unexpected missing consents %v", missing)
+	}
+	if missing := user.MissingConsents(nil); len(missing) != 0 {
+		t.Fatalf("No consent should be missing without requirement: %v", missing)
+	}
+}
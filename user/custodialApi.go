@@ -0,0 +1,103 @@
+package user
+
+import (
+	"net/http"
+)
+
+type claimTokenResponse struct {
+	ClaimToken string `json:"claimToken"`
+	// ExpiresAt in seconds since epoch
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// @Summary Create custodial user
+// @Description Create a patient account without password on behalf of a clinic. The account can later be claimed by the patient with a claim token.
+// @ID shoreline-user-api-createcustodialuser
+// @Accept  json
+// @Produce  json
+// @Param userid path string true "id of the creator (hcp)"
+// @Param user body user.NewCustodialUserDetails true "custodial user details, username and emails are optional"
+// @Security TidepoolAuth
+// @Success 201 {object} user.User
+// @Failure 500 {object} status.Status "message returned:\"Error creating the user\" "
+// @Failure 409 {object} status.Status "message returned:\"Error creating the user\" "
+// @Failure 404 {object} status.Status "message returned:\"User not found\" "
+// @Failure 400 {object} status.Status "message returned:\"Invalid user details were given\" or \"Only hcp users can create custodial users\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /user/{userid}/user [post]
+func (a *Api) CreateCustodialUser(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	ctx := requestContext(req)
+	tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
+	if err != nil {
+		a.sendError(res, req, errUnauthorized, err)
+		return
+	}
+	details, err := ParseNewCustodialUserDetails(req.Body)
+	if err != nil {
+		a.sendError(res, req, errInvalidUserDetails, err)
+		return
+	}
+
+	newUser, opErr := a.createCustodialUser(ctx, tokenData, vars["userid"], details)
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	a.sendUserWithStatus(res, newUser, http.StatusCreated, tokenData.IsServer)
+}
+
+// @Summary Create claim token
+// @Description Create the signed invitation letting the patient take over a custodial account. Only its creator, or a server, can get one.
+// @ID shoreline-user-api-createclaimtoken
+// @Produce  json
+// @Param userid path string true "id of the custodial user"
+// @Security TidepoolAuth
+// @Success 200 {object} user.claimTokenResponse
+// @Failure 500 {object} status.Status "message returned:\"Error finding user\" or \"Error generating the token\" "
+// @Failure 409 {object} status.Status "message returned:\"The account has already been claimed\" "
+// @Failure 404 {object} status.Status "message returned:\"User not found\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /user/{userid}/claim [post]
+func (a *Api) CreateClaimToken(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	ctx := requestContext(req)
+	tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
+	if err != nil {
+		a.sendError(res, req, errUnauthorized, err)
+		return
+	}
+
+	claimToken, claimData, opErr := a.createClaimToken(ctx, tokenData, vars["userid"])
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsRes(res, claimTokenResponse{ClaimToken: claimToken, ExpiresAt: claimData.ExpiresAt})
+}
+
+// @Summary Claim custodial user
+// @Description Take over a custodial account with the claim token given by its creator: the patient sets the email and password of the account, which then behaves as a regular one. The email must be verified before the first login.
+// @ID shoreline-user-api-claimuser
+// @Accept  json
+// @Produce  json
+// @Param claim body user.ClaimUserDetails true "claim token, email and password"
+// @Success 200 {object} user.User
+// @Failure 500 {object} status.Status "message returned:\"Error finding user\" or \"Error updating user\" "
+// @Failure 409 {object} status.Status "message returned:\"The account has already been claimed\" or \"User already exists\" "
+// @Failure 404 {object} status.Status "message returned:\"User not found\" "
+// @Failure 401 {object} status.Status "message returned:\"Invalid claim token\" "
+// @Failure 400 {object} status.Status "message returned:\"Invalid user details were given\" or \"Only hcp users can create custodial users\" "
+// @Router /claim [post]
+func (a *Api) ClaimUser(res http.ResponseWriter, req *http.Request) {
+	details, err := ParseClaimUserDetails(req.Body)
+	if err != nil {
+		a.sendError(res, req, errInvalidUserDetails, err)
+		return
+	}
+
+	claimedUser, opErr := a.claimUser(requestContext(req), details)
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	a.sendUser(res, claimedUser, false)
+}
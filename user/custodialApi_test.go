@@ -0,0 +1,239 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/mdblp/shoreline/schema"
+	"github.com/mdblp/shoreline/token"
+)
+
+const CUSTODIAL_CREATOR_ID = "0000000001"
+
+func T_CreateHcpSessionToken(t *testing.T, userID string) *token.SessionToken {
+	sessionToken, err := token.CreateSessionToken(&token.TokenData{UserId: userID, Role: "hcp", DurationSecs: TOKEN_DURATION}, TOKEN_CONFIG)
+	if err != nil {
+		t.Fatalf("Error creating session token: %#v", err)
+	}
+	return sessionToken
+}
+
+func T_CreateClaimToken(t *testing.T, userID string, creatorID string) string {
+	claimToken, err := token.CreateClaimToken(&token.ClaimData{UserID: userID, CreatorID: creatorID}, TOKEN_CONFIG)
+	if err != nil {
+		t.Fatalf("Error creating claim token: %#v", err)
+	}
+	return claimToken
+}
+
+func Test_CreateCustodialUser_Success(t *testing.T) {
+	sessionToken := T_CreateHcpSessionToken(t, CUSTODIAL_CREATOR_ID)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: CUSTODIAL_CREATOR_ID, Roles: []string{"hcp"}}, nil}}
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{}, nil}}
+	responsableStore.UpsertUserResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	body := "{\"username\": \"patient@example.com\", \"emails\": [\"patient@example.com\"]}"
+	response := T_PerformRequestBodyHeaders(t, "POST", "/user/"+CUSTODIAL_CREATOR_ID+"/user", body, headers)
+
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 201)
+	T_ExpectElementMatch(t, successResponse, "userid", `\A[0-9a-f]{10}\z`, true)
+	T_ExpectEqualsMap(t, successResponse, map[string]interface{}{
+		"username":      "patient@example.com",
+		"emails":        []interface{}{"patient@example.com"},
+		"roles":         []interface{}{"patient"},
+		"emailVerified": false,
+	})
+}
+
+func Test_CreateCustodialUser_Success_NoDetails(t *testing.T) {
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{&token.SessionToken{ID: SRVR_TOKEN.ID}, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: CUSTODIAL_CREATOR_ID, Roles: []string{"hcp"}}, nil}}
+	responsableStore.UpsertUserResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, SRVR_TOKEN.ID)
+	response := T_PerformRequestBodyHeaders(t, "POST", "/user/"+CUSTODIAL_CREATOR_ID+"/user", "{}", headers)
+
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 201)
+	T_ExpectElementMatch(t, successResponse, "userid", `\A[0-9a-f]{10}\z`, true)
+	T_ExpectEqualsMap(t, successResponse, map[string]interface{}{
+		"roles":          []interface{}{"patient"},
+		"passwordExists": false,
+	})
+}
+
+func Test_CreateCustodialUser_Error_NotHcp(t *testing.T) {
+	for _, userID := range []string{"abcdef1234", CUSTODIAL_CREATOR_ID} {
+		sessionToken := T_CreateSessionToken(t, "abcdef1234", false, TOKEN_DURATION)
+		if userID == CUSTODIAL_CREATOR_ID {
+			// An hcp cannot create custodial users on behalf of another one
+			sessionToken = T_CreateHcpSessionToken(t, "abcdef1234")
+		}
+		responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+
+		headers := http.Header{}
+		headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+		response := T_PerformRequestBodyHeaders(t, "POST", "/user/"+userID+"/user", "{}", headers)
+		T_ExpectErrorResponse(t, response, 401, "Not authorized for requested operation")
+	}
+	T_ExpectResponsablesEmpty(t)
+}
+
+func Test_CreateCustodialUser_Error_Creator(t *testing.T) {
+	responsableStore.FindUserResponses = []FindUserResponse{
+		{nil, nil},
+		{&User{Id: CUSTODIAL_CREATOR_ID, Roles: []string{"hcp"}, DeletedTime: "2021-01-01T10:00:00Z"}, nil},
+		{&User{Id: CUSTODIAL_CREATOR_ID, Roles: []string{"patient"}}, nil},
+	}
+	defer T_ExpectResponsablesEmpty(t)
+
+	// the servers can only create custodial users on behalf of an existing hcp
+	response := T_PerformRequestBodyHeaders(t, "POST", "/user/"+CUSTODIAL_CREATOR_ID+"/user", "{}", T_ServerTokenHeaders(t))
+	T_ExpectErrorResponse(t, response, 404, STATUS_USER_NOT_FOUND)
+	response = T_PerformRequestBodyHeaders(t, "POST", "/user/"+CUSTODIAL_CREATOR_ID+"/user", "{}", T_ServerTokenHeaders(t))
+	T_ExpectErrorResponse(t, response, 404, STATUS_USER_NOT_FOUND)
+	response = T_PerformRequestBodyHeaders(t, "POST", "/user/"+CUSTODIAL_CREATOR_ID+"/user", "{}", T_ServerTokenHeaders(t))
+	T_ExpectErrorResponseWithCode(t, response, 400, STATUS_CREATOR_NOT_HCP, schema.ErrorInvalidRole)
+}
+
+func Test_CreateCustodialUser_Error_Duplicate(t *testing.T) {
+	sessionToken := T_CreateHcpSessionToken(t, CUSTODIAL_CREATOR_ID)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: CUSTODIAL_CREATOR_ID, Roles: []string{"hcp"}}, nil}}
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{&User{Id: "1111111111"}}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	response := T_PerformRequestBodyHeaders(t, "POST", "/user/"+CUSTODIAL_CREATOR_ID+"/user", "{\"username\": \"patient@example.com\"}", headers)
+	T_ExpectErrorResponseWithCode(t, response, 409, STATUS_ERR_CREATING_USR, schema.ErrorUserAlreadyExists)
+}
+
+func Test_CreateClaimToken_Success(t *testing.T) {
+	sessionToken := T_CreateHcpSessionToken(t, CUSTODIAL_CREATOR_ID)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", Roles: []string{"patient"}, CreatedUserID: CUSTODIAL_CREATOR_ID}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	response := T_PerformRequestHeaders(t, "POST", "/user/1111111111/claim", headers)
+
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	claimData, err := token.UnpackClaimTokenAndVerify(successResponse["claimToken"].(string), FAKE_CONFIG.Secret)
+	if err != nil {
+		t.Fatalf("Unexpected invalid claim token: %v", err)
+	}
+	if claimData.UserID != "1111111111" || claimData.CreatorID != CUSTODIAL_CREATOR_ID {
+		t.Fatalf("Unexpected claim data %#v", claimData)
+	}
+	if successResponse["expiresAt"] != float64(claimData.ExpiresAt) {
+		t.Fatalf("Unexpected expiration %v", successResponse["expiresAt"])
+	}
+}
+
+func Test_CreateClaimToken_Error(t *testing.T) {
+	var tests = []struct {
+		user           *User
+		expectedCode   int
+		expectedReason string
+		errorCode      string
+	}{
+		{&User{Id: "1111111111", CreatedUserID: "abcdef1234"}, 401, STATUS_UNAUTHORIZED, schema.ErrorUnauthorized},
+		{&User{Id: "1111111111"}, 401, STATUS_UNAUTHORIZED, schema.ErrorUnauthorized},
+		{&User{Id: "1111111111", CreatedUserID: CUSTODIAL_CREATOR_ID, PwHash: "d1fef52139b0d120100726bcb43d5cc13d41e4b5"}, 409, STATUS_ALREADY_CLAIMED, schema.ErrorAccountAlreadyClaimed},
+		{nil, 404, STATUS_USER_NOT_FOUND, schema.ErrorUserNotFound},
+	}
+	for _, test := range tests {
+		sessionToken := T_CreateHcpSessionToken(t, CUSTODIAL_CREATOR_ID)
+		responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+		responsableStore.FindUserResponses = []FindUserResponse{{test.user, nil}}
+
+		headers := http.Header{}
+		headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+		response := T_PerformRequestHeaders(t, "POST", "/user/1111111111/claim", headers)
+		T_ExpectErrorResponseWithCode(t, response, test.expectedCode, test.expectedReason, test.errorCode)
+	}
+	T_ExpectResponsablesEmpty(t)
+}
+
+func Test_ClaimUser_Success(t *testing.T) {
+	claimToken := T_CreateClaimToken(t, "1111111111", CUSTODIAL_CREATOR_ID)
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", Roles: []string{"patient"}, CreatedUserID: CUSTODIAL_CREATOR_ID}, nil}}
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{}, nil}}
+	responsableStore.UpsertUserResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	body := fmt.Sprintf("{\"claimToken\": \"%s\", \"email\": \"patient@example.com\", \"password\": \"a-secure-password\"}", claimToken)
+	response := T_PerformRequestBody(t, "POST", "/claim", body)
+
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	T_ExpectEqualsMap(t, successResponse, map[string]interface{}{
+		"userid":        "1111111111",
+		"username":      "patient@example.com",
+		"emails":        []interface{}{"patient@example.com"},
+		"roles":         []interface{}{"patient"},
+		"emailVerified": false,
+	})
+}
+
+func Test_ClaimUser_Error_InvalidDetails(t *testing.T) {
+	claimToken := T_CreateClaimToken(t, "1111111111", CUSTODIAL_CREATOR_ID)
+	defer T_ExpectResponsablesEmpty(t)
+
+	for _, body := range []string{
+		"{\"email\": \"patient@example.com\", \"password\": \"a-secure-password\"}",
+		fmt.Sprintf("{\"claimToken\": \"%s\", \"email\": \"patient\", \"password\": \"a-secure-password\"}", claimToken),
+		fmt.Sprintf("{\"claimToken\": \"%s\", \"email\": \"patient@example.com\", \"password\": \"short\"}", claimToken),
+	} {
+		response := T_PerformRequestBody(t, "POST", "/claim", body)
+		T_ExpectErrorResponseWithCode(t, response, 400, STATUS_INVALID_USER_DETAILS, schema.ErrorInvalidUserDetails)
+	}
+}
+
+func Test_ClaimUser_Error_InvalidToken(t *testing.T) {
+	defer T_ExpectResponsablesEmpty(t)
+
+	for _, claimToken := range []string{"abcdef", USR_TOKEN.ID} {
+		body := fmt.Sprintf("{\"claimToken\": \"%s\", \"email\": \"patient@example.com\", \"password\": \"a-secure-password\"}", claimToken)
+		response := T_PerformRequestBody(t, "POST", "/claim", body)
+		T_ExpectErrorResponseWithCode(t, response, 401, STATUS_INVALID_CLAIM, schema.ErrorInvalidClaimToken)
+	}
+}
+
+func Test_ClaimUser_Error_CreatorMismatch(t *testing.T) {
+	claimToken := T_CreateClaimToken(t, "1111111111", "abcdef1234")
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", CreatedUserID: CUSTODIAL_CREATOR_ID}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	body := fmt.Sprintf("{\"claimToken\": \"%s\", \"email\": \"patient@example.com\", \"password\": \"a-secure-password\"}", claimToken)
+	response := T_PerformRequestBody(t, "POST", "/claim", body)
+	T_ExpectErrorResponseWithCode(t, response, 401, STATUS_INVALID_CLAIM, schema.ErrorInvalidClaimToken)
+}
+
+func Test_ClaimUser_Error_AlreadyClaimed(t *testing.T) {
+	claimToken := T_CreateClaimToken(t, "1111111111", CUSTODIAL_CREATOR_ID)
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", CreatedUserID: CUSTODIAL_CREATOR_ID, PwHash: "d1fef52139b0d120100726bcb43d5cc13d41e4b5"}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	body := fmt.Sprintf("{\"claimToken\": \"%s\", \"email\": \"patient@example.com\", \"password\": \"a-secure-password\"}", claimToken)
+	response := T_PerformRequestBody(t, "POST", "/claim", body)
+	T_ExpectErrorResponseWithCode(t, response, 409, STATUS_ALREADY_CLAIMED, schema.ErrorAccountAlreadyClaimed)
+}
+
+func Test_ClaimUser_Error_EmailTaken(t *testing.T) {
+	claimToken := T_CreateClaimToken(t, "1111111111", CUSTODIAL_CREATOR_ID)
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", CreatedUserID: CUSTODIAL_CREATOR_ID}, nil}}
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{&User{Id: "2222222222"}}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	body := fmt.Sprintf("{\"claimToken\": \"%s\", \"email\": \"patient@example.com\", \"password\": \"a-secure-password\"}", claimToken)
+	response := T_PerformRequestBody(t, "POST", "/claim", body)
+	T_ExpectErrorResponseWithCode(t, response, 409, STATUS_USR_ALREADY_EXISTS, schema.ErrorUserAlreadyExists)
+}
@@ -0,0 +1,662 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdblp/shoreline/token"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// documentCollections keep the BSON documents of the collections (USERS_COLLECTION...), by key
+type documentCollections interface {
+	// view runs fn in a read-only transaction
+	view(fn func(documentTx) error) error
+	// update runs fn in a read-write transaction, whose changes are kept unless fn fails
+	update(fn func(documentTx) error) error
+}
+
+// documentTx reads and writes the documents, which are only valid during the transaction
+type documentTx interface {
+	// get returns nil when the document does not exist
+	get(collection string, key string) bson.Raw
+	put(collection string, key string, document bson.Raw) error
+	delete(collection string, key string) error
+	// forEach calls fn for the documents whose key starts with prefix
+	forEach(collection string, prefix string, fn func(key string, document bson.Raw) error) error
+}
+
+// indexSeparator separates the indexed value and the user id in the keys of the userIndexes
+const indexSeparator = "\x00"
+
+// userIndexes are the collections indexing the users by value, updated with the users in the same transaction:
+// their keys are the value and the user id, with an empty document
+var userIndexes = map[string]func(*User) []string{
+	USERS_COLLECTION + ".username": func(user *User) []string {
+		if user.Username == "" {
+			return nil
+		}
+		return []string{strings.ToLower(user.Username)}
+	},
+	USERS_COLLECTION + ".emails": func(user *User) []string {
+		emails := make([]string, len(user.Emails))
+		for index, email := range user.Emails {
+			emails[index] = strings.ToLower(email)
+		}
+		return emails
+	},
+	USERS_COLLECTION + ".roles": func(user *User) []string { return user.Roles },
+}
+
+// documentStore is a Storage on documentCollections, with the semantics of the Mongo Client:
+// the upserts only set the fields which are not empty, the usernames are matched case-insensitively,
+// FindUser returns nil when the user does not exist, FindTokenByID returns mongo.ErrNoDocuments when nothing is found,
+// and the expired tokens are not listed and removed when adding tokens.
+// The users are keyed by id, and the writes fail on the ids, usernames or emails already taken (regardless of their case)
+// as the unique indexes would.
+type documentStore struct {
+	collections documentCollections
+	sweep       *tokensSweep
+}
+
+// expiredTokensSweepInterval is the minimum delay between the removals of the expired tokens, done when adding a token
+const expiredTokensSweepInterval = time.Minute
+
+// tokensSweep is the time of the last removal of the expired tokens
+type tokensSweep struct {
+	mut  sync.Mutex
+	last time.Time
+}
+
+func newDocumentStore(collections documentCollections) documentStore {
+	return documentStore{collections: collections, sweep: &tokensSweep{}}
+}
+
+// due tells whether the expired tokens should be removed, and then counts it as done
+func (s *tokensSweep) due(now time.Time) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if now.Sub(s.last) < expiredTokensSweepInterval {
+		return false
+	}
+	s.last = now
+	return true
+}
+
+func (d *documentStore) Close() error {
+	return nil
+}
+func (d *documentStore) Ping() error {
+	return nil
+}
+func (d *documentStore) PingOK() bool {
+	return true
+}
+
+// Collection returns nil: there is no Mongo collection behind the store
+func (d *documentStore) Collection(collectionName string, databaseName ...string) *mongo.Collection {
+	return nil
+}
+func (d *documentStore) WaitUntilStarted() {}
+func (d *documentStore) Start()            {}
+
+// setDocument returns the stored document (nil when new) with the fields of update, as a Mongo $set
+func setDocument(stored bson.Raw, update interface{}) (bson.Raw, error) {
+	fields := bson.M{}
+	if stored != nil {
+		if err := bson.Unmarshal(stored, &fields); err != nil {
+			return nil, err
+		}
+	}
+	updateFields := bson.M{}
+	data, err := bson.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	if err := bson.Unmarshal(data, &updateFields); err != nil {
+		return nil, err
+	}
+	for name, value := range updateFields {
+		fields[name] = value
+	}
+	return bson.Marshal(fields)
+}
+
+// findUsers returns the stored users matching fn, ordered by id
+func (d *documentStore) findUsers(fn func(*User) bool) ([]*User, error) {
+	results := []*User{}
+	err := d.collections.view(func(tx documentTx) error {
+		return tx.forEach(USERS_COLLECTION, "", func(key string, document bson.Raw) error {
+			user := &User{}
+			if err := bson.Unmarshal(document, user); err != nil {
+				return err
+			}
+			if fn(user) {
+				results = append(results, user)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Id < results[j].Id })
+	return results, nil
+}
+
+// getUser returns the stored user, nil when it does not exist
+func getUser(tx documentTx, userID string) (*User, error) {
+	document := tx.get(USERS_COLLECTION, userID)
+	if document == nil {
+		return nil, nil
+	}
+	user := &User{}
+	if err := bson.Unmarshal(document, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// getUsers returns the stored users with the ids, ordered by id
+func getUsers(tx documentTx, ids map[string]bool) ([]*User, error) {
+	users := []*User{}
+	for id := range ids {
+		user, err := getUser(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Id < users[j].Id })
+	return users, nil
+}
+
+// indexedUserIDs adds the ids of the users having the value in the index
+func indexedUserIDs(tx documentTx, index string, value string, ids map[string]bool) error {
+	prefix := value + indexSeparator
+	return tx.forEach(index, prefix, func(key string, document bson.Raw) error {
+		ids[strings.TrimPrefix(key, prefix)] = true
+		return nil
+	})
+}
+
+// putUserDocument stores the document of the user in the transaction, and updates the userIndexes
+func putUserDocument(tx documentTx, userID string, document bson.Raw) error {
+	user := &User{}
+	if err := bson.Unmarshal(document, user); err != nil {
+		return err
+	}
+	if err := unindexUser(tx, userID); err != nil {
+		return err
+	}
+	for index, values := range userIndexes {
+		for _, value := range values(user) {
+			if err := tx.put(index, value+indexSeparator+userID, bson.Raw{}); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.put(USERS_COLLECTION, userID, document)
+}
+
+// unindexUser removes the stored user, if any, from the userIndexes
+func unindexUser(tx documentTx, userID string) error {
+	stored, err := getUser(tx, userID)
+	if err != nil || stored == nil {
+		return err
+	}
+	for index, values := range userIndexes {
+		for _, value := range values(stored) {
+			if err := tx.delete(index, value+indexSeparator+userID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// putUser stores the user in the transaction
+func putUser(tx documentTx, user *User) error {
+	document, err := bson.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return putUserDocument(tx, user.Id, document)
+}
+
+// checkUniqueUser fails when another user has the username or one of the emails of the user, regardless of their case
+func checkUniqueUser(tx documentTx, user *User) error {
+	if user.Username != "" {
+		ids := map[string]bool{}
+		if err := indexedUserIDs(tx, USERS_COLLECTION+".username", strings.ToLower(user.Username), ids); err != nil {
+			return err
+		}
+		delete(ids, user.Id)
+		if len(ids) > 0 {
+			return fmt.Errorf("duplicate key: username %q", user.Username)
+		}
+	}
+	for _, email := range user.Emails {
+		ids := map[string]bool{}
+		if err := indexedUserIDs(tx, USERS_COLLECTION+".emails", strings.ToLower(email), ids); err != nil {
+			return err
+		}
+		delete(ids, user.Id)
+		if len(ids) > 0 {
+			return fmt.Errorf("duplicate key: email %q", email)
+		}
+	}
+	return nil
+}
+
+// updateUser applies fn to the stored user, if any
+func (d *documentStore) updateUser(userID string, fn func(*User)) error {
+	return d.collections.update(func(tx documentTx) error {
+		document := tx.get(USERS_COLLECTION, userID)
+		if document == nil {
+			return nil
+		}
+		user := &User{}
+		if err := bson.Unmarshal(document, user); err != nil {
+			return err
+		}
+		fn(user)
+		return putUser(tx, user)
+	})
+}
+
+func (d *documentStore) UpsertUser(ctx context.Context, user *User) error {
+	if user.Roles != nil {
+		sort.Strings(user.Roles)
+	}
+	return d.collections.update(func(tx documentTx) error {
+		document, err := setDocument(tx.get(USERS_COLLECTION, user.Id), user)
+		if err != nil {
+			return err
+		}
+		merged := &User{}
+		if err := bson.Unmarshal(document, merged); err != nil {
+			return err
+		}
+		if err := checkUniqueUser(tx, merged); err != nil {
+			return err
+		}
+		return putUserDocument(tx, user.Id, document)
+	})
+}
+
+// InsertUsers inserts the users whose id, username and emails are not taken, the others are listed by an *InsertUsersError
+func (d *documentStore) InsertUsers(ctx context.Context, users []*User) error {
+	insertErr := &InsertUsersError{Errors: map[int]error{}}
+	err := d.collections.update(func(tx documentTx) error {
+		for index, user := range users {
+			if user.Roles != nil {
+				sort.Strings(user.Roles)
+			}
+			if tx.get(USERS_COLLECTION, user.Id) != nil {
+				insertErr.Errors[index] = fmt.Errorf("duplicate key: userid %q", user.Id)
+				continue
+			}
+			if err := checkUniqueUser(tx, user); err != nil {
+				insertErr.Errors[index] = err
+				continue
+			}
+			if err := putUser(tx, user); err != nil {
+				insertErr.Errors[index] = err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(insertErr.Errors) > 0 {
+		return insertErr
+	}
+	return nil
+}
+
+func (d *documentStore) FindUser(ctx context.Context, user *User) (*User, error) {
+	if user.Id == "" {
+		return nil, nil
+	}
+	var result *User
+	err := d.collections.view(func(tx documentTx) error {
+		document := tx.get(USERS_COLLECTION, user.Id)
+		if document == nil {
+			return nil
+		}
+		result = &User{}
+		return bson.Unmarshal(document, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// FindUsers returns the users matching the id, the username (case insensitive) or any of the emails,
+// found with the userIndexes
+func (d *documentStore) FindUsers(ctx context.Context, user *User) ([]*User, error) {
+	var results []*User
+	err := d.collections.view(func(tx documentTx) error {
+		ids := map[string]bool{}
+		if user.Id != "" {
+			ids[user.Id] = true
+		}
+		if user.Username != "" {
+			if err := indexedUserIDs(tx, USERS_COLLECTION+".username", strings.ToLower(user.Username), ids); err != nil {
+				return err
+			}
+		}
+		emailIDs := map[string]bool{}
+		for _, email := range user.Emails {
+			if err := indexedUserIDs(tx, USERS_COLLECTION+".emails", strings.ToLower(email), emailIDs); err != nil {
+				return err
+			}
+		}
+		found, err := getUsers(tx, emailIDs)
+		if err != nil {
+			return err
+		}
+		// the emails are matched exactly, as by the Mongo Client
+		for _, stored := range found {
+			for _, storedEmail := range stored.Emails {
+				for _, email := range user.Emails {
+					if email == storedEmail {
+						ids[stored.Id] = true
+					}
+				}
+			}
+		}
+		results, err = getUsers(tx, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (d *documentStore) FindUsersByRole(ctx context.Context, role string) ([]*User, error) {
+	var results []*User
+	err := d.collections.view(func(tx documentTx) error {
+		ids := map[string]bool{}
+		if err := indexedUserIDs(tx, USERS_COLLECTION+".roles", role, ids); err != nil {
+			return err
+		}
+		var err error
+		results, err = getUsers(tx, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (d *documentStore) FindUsersWithIds(ctx context.Context, ids []string) ([]*User, error) {
+	return d.findUsers(func(stored *User) bool {
+		for _, id := range ids {
+			if stored.Id == id {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (d *documentStore) SearchUsers(ctx context.Context, search *UserSearch) ([]*User, string, error) {
+	users, err := d.findUsers(func(*User) bool { return true })
+	if err != nil {
+		return nil, "", err
+	}
+	return search.Apply(users)
+}
+
+func (d *documentStore) ExportUsers(ctx context.Context, search *UserSearch, fn func(*User) error) error {
+	users, err := d.findUsers(search.Match)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceUser replaces the stored user, nothing is done when it does not exist
+func (d *documentStore) ReplaceUser(ctx context.Context, user *User) error {
+	if user.Roles != nil {
+		sort.Strings(user.Roles)
+	}
+	return d.collections.update(func(tx documentTx) error {
+		if tx.get(USERS_COLLECTION, user.Id) == nil {
+			return nil
+		}
+		if err := checkUniqueUser(tx, user); err != nil {
+			return err
+		}
+		return putUser(tx, user)
+	})
+}
+
+func (d *documentStore) RemoveUser(ctx context.Context, user *User) error {
+	return d.collections.update(func(tx documentTx) error {
+		if err := unindexUser(tx, user.Id); err != nil {
+			return err
+		}
+		return tx.delete(USERS_COLLECTION, user.Id)
+	})
+}
+
+// AddToken stores the token, and removes the expired tokens at most once per expiredTokensSweepInterval
+// as the Mongo TTL index does
+func (d *documentStore) AddToken(ctx context.Context, sessionToken *token.SessionToken) error {
+	now := time.Now()
+	return d.collections.update(func(tx documentTx) error {
+		if d.sweep.due(now) {
+			expired, err := findTokens(tx, func(stored *token.SessionToken) bool { return stored.ExpiresAt <= now.Unix() })
+			if err != nil {
+				return err
+			}
+			for key := range expired {
+				if err := tx.delete(TOKENS_COLLECTION, key); err != nil {
+					return err
+				}
+			}
+		}
+		document, err := setDocument(tx.get(TOKENS_COLLECTION, sessionToken.ID), sessionToken)
+		if err != nil {
+			return err
+		}
+		return tx.put(TOKENS_COLLECTION, sessionToken.ID, document)
+	})
+}
+
+func (d *documentStore) FindTokenByID(ctx context.Context, id string) (*token.SessionToken, error) {
+	var sessionToken *token.SessionToken
+	err := d.collections.view(func(tx documentTx) error {
+		document := tx.get(TOKENS_COLLECTION, id)
+		if document == nil {
+			return mongo.ErrNoDocuments
+		}
+		sessionToken = &token.SessionToken{}
+		return bson.Unmarshal(document, sessionToken)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessionToken, nil
+}
+
+// findTokens returns the stored tokens matching fn, with their keys
+func findTokens(tx documentTx, fn func(*token.SessionToken) bool) (map[string]*token.SessionToken, error) {
+	results := map[string]*token.SessionToken{}
+	err := tx.forEach(TOKENS_COLLECTION, "", func(key string, document bson.Raw) error {
+		sessionToken := &token.SessionToken{}
+		if err := bson.Unmarshal(document, sessionToken); err != nil {
+			return err
+		}
+		if fn(sessionToken) {
+			results[key] = sessionToken
+		}
+		return nil
+	})
+	return results, err
+}
+
+// FindTokensByUserID returns the tokens of the user which have not expired, by creation time
+func (d *documentStore) FindTokensByUserID(ctx context.Context, userID string) ([]*token.SessionToken, error) {
+	now := time.Now().Unix()
+	sessionTokens := []*token.SessionToken{}
+	err := d.collections.view(func(tx documentTx) error {
+		found, err := findTokens(tx, func(sessionToken *token.SessionToken) bool {
+			return sessionToken.UserID == userID && sessionToken.ExpiresAt > now
+		})
+		for _, sessionToken := range found {
+			sessionTokens = append(sessionTokens, sessionToken)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessionTokens, func(i, j int) bool {
+		if sessionTokens[i].CreatedAt != sessionTokens[j].CreatedAt {
+			return sessionTokens[i].CreatedAt < sessionTokens[j].CreatedAt
+		}
+		return sessionTokens[i].ID < sessionTokens[j].ID
+	})
+	return sessionTokens, nil
+}
+
+// removeTokens removes the stored tokens matching fn
+func (d *documentStore) removeTokens(fn func(*token.SessionToken) bool) error {
+	return d.collections.update(func(tx documentTx) error {
+		found, err := findTokens(tx, fn)
+		if err != nil {
+			return err
+		}
+		for key := range found {
+			if err := tx.delete(TOKENS_COLLECTION, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *documentStore) RemoveTokensByUserID(ctx context.Context, userID string) error {
+	return d.removeTokens(func(sessionToken *token.SessionToken) bool { return sessionToken.UserID == userID })
+}
+
+func (d *documentStore) RemoveTokenByID(ctx context.Context, id string) error {
+	return d.collections.update(func(tx documentTx) error {
+		return tx.delete(TOKENS_COLLECTION, id)
+	})
+}
+
+func (d *documentStore) UpsertOrganization(ctx context.Context, organization *Organization) error {
+	return d.collections.update(func(tx documentTx) error {
+		document, err := setDocument(tx.get(ORGANIZATIONS_COLLECTION, organization.Id), organization)
+		if err != nil {
+			return err
+		}
+		return tx.put(ORGANIZATIONS_COLLECTION, organization.Id, document)
+	})
+}
+
+func (d *documentStore) FindOrganization(ctx context.Context, id string) (*Organization, error) {
+	var organization *Organization
+	err := d.collections.view(func(tx documentTx) error {
+		document := tx.get(ORGANIZATIONS_COLLECTION, id)
+		if document == nil {
+			return nil
+		}
+		organization = &Organization{}
+		return bson.Unmarshal(document, organization)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+func (d *documentStore) FindOrganizations(ctx context.Context) ([]*Organization, error) {
+	organizations := []*Organization{}
+	err := d.collections.view(func(tx documentTx) error {
+		return tx.forEach(ORGANIZATIONS_COLLECTION, "", func(key string, document bson.Raw) error {
+			organization := &Organization{}
+			if err := bson.Unmarshal(document, organization); err != nil {
+				return err
+			}
+			organizations = append(organizations, organization)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(organizations, func(i, j int) bool {
+		if organizations[i].Name != organizations[j].Name {
+			return organizations[i].Name < organizations[j].Name
+		}
+		return organizations[i].Id < organizations[j].Id
+	})
+	return organizations, nil
+}
+
+// RemoveOrganization removes the organization and its memberships
+func (d *documentStore) RemoveOrganization(ctx context.Context, id string) error {
+	return d.collections.update(func(tx documentTx) error {
+		members := []*User{}
+		err := tx.forEach(USERS_COLLECTION, "", func(key string, document bson.Raw) error {
+			user := &User{}
+			if err := bson.Unmarshal(document, user); err != nil {
+				return err
+			}
+			if user.OrganizationRole(id) != "" {
+				members = append(members, user)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			member.removeOrganization(id)
+			if err := putUser(tx, member); err != nil {
+				return err
+			}
+		}
+		return tx.delete(ORGANIZATIONS_COLLECTION, id)
+	})
+}
+
+func (d *documentStore) FindUsersByOrganization(ctx context.Context, organizationID string) ([]*User, error) {
+	return d.findUsers(func(stored *User) bool { return stored.OrganizationRole(organizationID) != "" })
+}
+
+func (d *documentStore) UpsertOrganizationMember(ctx context.Context, userID string, member *OrganizationMember) error {
+	return d.updateUser(userID, func(user *User) { user.setOrganizationMember(member) })
+}
+
+func (d *documentStore) RemoveOrganizationMember(ctx context.Context, userID string, organizationID string) error {
+	return d.updateUser(userID, func(user *User) { user.removeOrganization(organizationID) })
+}
+
+func (d *documentStore) AddUserConsent(ctx context.Context, userID string, consent *Consent) error {
+	return d.updateUser(userID, func(user *User) {
+		user.Consents = append(user.Consents, *consent)
+	})
+}
@@ -0,0 +1,106 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mdblp/shoreline/schema"
+)
+
+const (
+	// PROBLEM_JSON content type of the RFC 7807 error responses, sent when accepted by the caller
+	PROBLEM_JSON = "application/problem+json"
+)
+
+// apiError is an entry of the error catalogue: the HTTP status, the stable
+// code (see schema.Error*) and the legacy human readable reason
+type apiError struct {
+	status  int
+	code    string
+	reason  string
+	details map[string]interface{}
+}
+
+// withDetails returns a copy of the error carrying details for the caller
+func (e *apiError) withDetails(details map[string]interface{}) *apiError {
+	detailed := *e
+	detailed.details = details
+	return &detailed
+}
+
+// The error catalogue. Several entries may share a reason for compatibility
+// with the clients which still read it, the code is what tells them apart.
+var (
+	errUnauthorized         = &apiError{http.StatusUnauthorized, schema.ErrorUnauthorized, STATUS_UNAUTHORIZED, nil}
+	errForbidden            = &apiError{http.StatusForbidden, schema.ErrorForbidden, STATUS_UNAUTHORIZED, nil}
+	errInvalidToken         = &apiError{http.StatusUnauthorized, schema.ErrorInvalidToken, STATUS_NO_TOKEN, nil}
+	errServerTokenRequired  = &apiError{http.StatusUnauthorized, schema.ErrorServerTokenRequired, STATUS_NO_TOKEN, nil}
+	errMissingCredentials   = &apiError{http.StatusBadRequest, schema.ErrorMissingCredentials, STATUS_MISSING_ID_PW, nil}
+	errMissingPassword      = &apiError{http.StatusForbidden, schema.ErrorMissingCredentials, STATUS_MISSING_ID_PW, nil}
+	errPasswordMismatch     = &apiError{http.StatusUnauthorized, schema.ErrorPasswordMismatch, STATUS_PW_WRONG, nil}
+	errServerSecretMismatch = &apiError{http.StatusUnauthorized, schema.ErrorServerSecretMismatch, STATUS_PW_WRONG, nil}
+	// Unknown users and wrong passwords share the same code so the accounts cannot be enumerated
+	errInvalidCredentials = &apiError{http.StatusUnauthorized, schema.ErrorPasswordMismatch, STATUS_NO_MATCH, nil}
+	errAccountLocked      = &apiError{http.StatusUnauthorized, schema.ErrorAccountLocked, STATUS_NO_MATCH, nil}
+	errTooManyLogins      = &apiError{http.StatusUnauthorized, schema.ErrorTooManyLogins, STATUS_NO_MATCH, nil}
+	errEmailNotVerified   = &apiError{http.StatusForbidden, schema.ErrorEmailNotVerified, STATUS_NOT_VERIFIED, nil}
+	errUserNotFound       = &apiError{http.StatusNotFound, schema.ErrorUserNotFound, STATUS_USER_NOT_FOUND, nil}
+	errUserConflict       = &apiError{http.StatusConflict, schema.ErrorUserAlreadyExists, STATUS_ERR_CREATING_USR, nil}
+	errUserAlreadyExists  = &apiError{http.StatusConflict, schema.ErrorUserAlreadyExists, STATUS_USR_ALREADY_EXISTS, nil}
+	errInvalidUserDetails = &apiError{http.StatusBadRequest, schema.ErrorInvalidUserDetails, STATUS_INVALID_USER_DETAILS, nil}
+	errInvalidClaimToken  = &apiError{http.StatusUnauthorized, schema.ErrorInvalidClaimToken, STATUS_INVALID_CLAIM, nil}
+	errAlreadyClaimed     = &apiError{http.StatusConflict, schema.ErrorAccountAlreadyClaimed, STATUS_ALREADY_CLAIMED, nil}
+	errCreatorNotHcp      = &apiError{http.StatusBadRequest, schema.ErrorInvalidRole, STATUS_CREATOR_NOT_HCP, nil}
+	errEmptyUpdate        = &apiError{http.StatusNotModified, schema.ErrorInvalidUserDetails, STATUS_INVALID_USER_DETAILS, nil}
+	errInvalidRole        = &apiError{http.StatusBadRequest, schema.ErrorInvalidRole, STATUS_INVALID_ROLE, nil}
+	errNoQuery            = &apiError{http.StatusBadRequest, schema.ErrorInvalidQuery, STATUS_NO_QUERY, nil}
+	errUnknownParameter   = &apiError{http.StatusBadRequest, schema.ErrorInvalidQuery, STATUS_PARAMETER_UNKNOWN, nil}
+	errInvalidQuery       = &apiError{http.StatusBadRequest, schema.ErrorInvalidQuery, STATUS_INVALID_QUERY, nil}
+	errUnknownService     = &apiError{http.StatusBadRequest, schema.ErrorUnknownService, STATUS_ERR_GENERATING_TOKEN, nil}
+	errAuditUnavailable   = &apiError{http.StatusNotImplemented, schema.ErrorNotImplemented, STATUS_AUDIT_UNAVAILABLE, nil}
+	errFindingUser        = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_ERR_FINDING_USR, nil}
+	errCreatingUser       = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_ERR_CREATING_USR, nil}
+	errUpdatingUser       = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_ERR_UPDATING_USR, nil}
+	errGeneratingToken    = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_ERR_GENERATING_TOKEN, nil}
+	errUpdatingToken      = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_ERR_UPDATING_TOKEN, nil}
+	errNoExpectedPassword = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_NO_EXPECTED_PWD, nil}
+	errFindingAudit       = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_ERR_FINDING_AUDIT, nil}
+	// Organizations
+	errOrganizationNotFound       = &apiError{http.StatusNotFound, schema.ErrorOrganizationNotFound, STATUS_ORGANIZATION_NOT_FOUND, nil}
+	errInvalidOrganizationDetails = &apiError{http.StatusBadRequest, schema.ErrorInvalidOrganizationDetails, STATUS_INVALID_ORGANIZATION_DETAILS, nil}
+	errInvalidOrganizationRole    = &apiError{http.StatusBadRequest, schema.ErrorInvalidRole, STATUS_INVALID_ROLE, nil}
+	errMemberNotHcp               = &apiError{http.StatusBadRequest, schema.ErrorInvalidRole, STATUS_MEMBER_NOT_HCP, nil}
+	errFindingOrganization        = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_ERR_FINDING_ORGANIZATION, nil}
+	errUpdatingOrganization       = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_ERR_UPDATING_ORGANIZATION, nil}
+	errUpdatingMember             = &apiError{http.StatusInternalServerError, schema.ErrorInternal, STATUS_ERR_UPDATING_MEMBER, nil}
+	// Consents
+	errInvalidConsent = &apiError{http.StatusBadRequest, schema.ErrorInvalidConsent, STATUS_INVALID_CONSENT, nil}
+)
+
+// acceptsProblem returns true if the caller asked for RFC 7807 error responses
+func acceptsProblem(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), PROBLEM_JSON)
+}
+
+// writeError writes the error response, as "application/problem+json" when accepted
+// by the caller, or else as the legacy status body completed with the error code
+func writeError(res http.ResponseWriter, req *http.Request, apiErr *apiError) {
+	problem := schema.Problem{ErrorCode: apiErr.code, Details: apiErr.details}
+	contentType := "application/json"
+	if acceptsProblem(req) {
+		contentType = PROBLEM_JSON
+		problem.Type = schema.ErrorTypePrefix + apiErr.code
+		problem.Title = apiErr.reason
+		problem.Status = apiErr.status
+	} else {
+		problem.Code = apiErr.status
+		problem.Reason = apiErr.reason
+	}
+
+	res.Header().Set("content-type", contentType)
+	res.WriteHeader(apiErr.status)
+	if jsonDetails, err := json.Marshal(problem); err == nil {
+		res.Write(jsonDetails)
+	}
+}
@@ -0,0 +1,55 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mdblp/shoreline/schema"
+)
+
+func Test_Login_Error_ProblemJSON(t *testing.T) {
+	authorization := T_CreateAuthorization(t, "a@b.co", "password")
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{&User{Id: "1111111111", PwHash: "d1fef52139b0d120100726bcb43d5cc13d41e4b5"}}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add("Authorization", authorization)
+	headers.Add("Accept", "application/json, application/problem+json")
+	response := T_PerformRequestHeaders(t, "POST", "/login", headers)
+
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("Unexpected response status code: %d", response.Code)
+	}
+	if contentType := response.Header().Get("content-type"); contentType != PROBLEM_JSON {
+		t.Fatalf("Unexpected response content type: %s", contentType)
+	}
+	var problem map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&problem); err != nil {
+		t.Fatalf("Error parsing response body: %#v", err)
+	}
+	expected := map[string]interface{}{
+		"type":      schema.ErrorTypePrefix + schema.ErrorEmailNotVerified,
+		"title":     STATUS_NOT_VERIFIED,
+		"status":    float64(http.StatusForbidden),
+		"errorCode": schema.ErrorEmailNotVerified,
+	}
+	if len(problem) != len(expected) {
+		t.Fatalf("Unexpected problem document: %#v", problem)
+	}
+	for key, value := range expected {
+		if problem[key] != value {
+			t.Fatalf("Unexpected problem member %s: %#v", key, problem[key])
+		}
+	}
+}
+
+func Test_apiError_withDetails(t *testing.T) {
+	detailed := errAccountLocked.withDetails(map[string]interface{}{"nextLoginAttemptTime": "2021-06-01T10:00:00Z"})
+	if errAccountLocked.details != nil {
+		t.Fatalf("The catalogue entry should not be modified")
+	}
+	if detailed.code != schema.ErrorAccountLocked || detailed.status != http.StatusUnauthorized || len(detailed.details) != 1 {
+		t.Fatalf("Unexpected detailed error %#v", detailed)
+	}
+}
@@ -0,0 +1,114 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// USERS_EXPORT_ERROR response trailer set when the export stopped before its end
+	USERS_EXPORT_ERROR = "x-users-export-error"
+	// exportFlushInterval is the number of users written between two flushes of the response
+	exportFlushInterval = 500
+)
+
+var errUnknownExportField = errors.New("unknown field")
+
+// exportFields are the fields of the exported users which can be selected
+var exportFields = map[string]bool{
+	"userid": true, "username": true, "emails": true, "roles": true, "termsAccepted": true, "emailVerified": true,
+	"organizations": true, "profile": true, "passwordExists": true, "createdTime": true, "modifiedTime": true, "deletedTime": true,
+}
+
+// @Summary Export users
+// @Description Stream the users as NDJSON, one user per line, for the data warehouse. The search filters of GET /users can be used, without pagination.
+// @ID shoreline-user-api-exportusers
+// @Produce application/x-ndjson
+// @Param modifiedSince query string false "Only the users created, modified or deleted since then (RFC3339), for the incremental exports"
+// @Param fields query string false "Fields of the exported users separated by , (all by default), the userid is always exported"
+// @Param role query string false "Role" Enums(patient, caregiver, hcp)
+// @Param deleted query bool false "Only the deleted users when true, only the others when false"
+// @Security TidepoolAuth
+// @Success 200 {string} string "one user per line"
+// @Header 200 {string} x-users-export-error "trailer set when the export is incomplete"
+// @Failure 400 {object} status.Status "message returned:\"The role specified is invalid\" or \"Invalid query parameter\" or \"Unknown query parameter\""
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /users/export [get]
+func (a *Api) ExportUsers(res http.ResponseWriter, req *http.Request) {
+	ctx := requestContext(req)
+	tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
+	if err != nil {
+		a.sendError(res, req, errUnauthorized, err)
+		return
+	}
+	search, fields, opErr := a.usersExport(tokenData, req.URL.Query())
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+
+	// the status is sent before the first user, a failure can only be reported afterwards
+	res.Header().Set("Trailer", USERS_EXPORT_ERROR)
+	res.Header().Set("content-type", "application/x-ndjson")
+	res.WriteHeader(http.StatusOK)
+
+	flusher, _ := res.(http.Flusher)
+	encoder := json.NewEncoder(res)
+	count := 0
+	opErr = a.exportUsers(ctx, tokenData, search, fields, func(exported map[string]interface{}) error {
+		if err := encoder.Encode(exported); err != nil {
+			return err
+		}
+		count++
+		if flusher != nil && count%exportFlushInterval == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if opErr != nil {
+		a.logError(ctx, opErr.caller, opErr.apiErr, append(opErr.extras, fmt.Sprintf("%d users exported", count))...)
+		res.Header().Set(USERS_EXPORT_ERROR, opErr.apiErr.reason)
+	}
+}
+
+// parseExportFields returns the selected fields, nil for all of them
+func parseExportFields(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	fields := []string{}
+	for _, field := range strings.Split(value, ",") {
+		if !exportFields[field] {
+			return nil, errUnknownExportField
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// asExportedUser is the serialized user, with its history times, restricted to the given fields
+func (a *Api) asExportedUser(user *User, fields []string) map[string]interface{} {
+	exported := a.asSerializableUser(user, true).(map[string]interface{})
+	if user.CreatedTime != "" {
+		exported["createdTime"] = user.CreatedTime
+	}
+	if user.ModifiedTime != "" {
+		exported["modifiedTime"] = user.ModifiedTime
+	}
+	if user.DeletedTime != "" {
+		exported["deletedTime"] = user.DeletedTime
+	}
+	if fields == nil {
+		return exported
+	}
+	selected := map[string]interface{}{"userid": exported["userid"]}
+	for _, field := range fields {
+		if value, found := exported[field]; found {
+			selected[field] = value
+		}
+	}
+	return selected
+}
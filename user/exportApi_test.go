@@ -0,0 +1,100 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func T_ExpectNDJSONLines(t *testing.T, body string, expected []map[string]interface{}) {
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if body == "" {
+		lines = []string{}
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %q", len(expected), body)
+	}
+	for index, line := range lines {
+		decoded := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("Invalid line %q: %v", line, err)
+		}
+		if !reflect.DeepEqual(decoded, expected[index]) {
+			t.Fatalf("Expected line %v, got %v", expected[index], decoded)
+		}
+	}
+}
+
+func Test_ExportUsers_Error_NotServerToken(t *testing.T) {
+	sessionToken := T_CreateSessionToken(t, "abcdef1234", false, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	response := T_PerformRequestHeaders(t, "GET", "/users/export", headers)
+	T_ExpectErrorResponse(t, response, 401, STATUS_UNAUTHORIZED)
+}
+
+func Test_ExportUsers_Error_InvalidQuery(t *testing.T) {
+	defer T_ExpectResponsablesEmpty(t)
+
+	for _, test := range []struct {
+		query          string
+		expectedReason string
+	}{
+		{"fields=userid,pwhash", STATUS_INVALID_QUERY},
+		{"modifiedSince=yesterday", STATUS_INVALID_QUERY},
+		{"role=clinic", STATUS_INVALID_ROLE},
+		{"limit=10", STATUS_PARAMETER_UNKNOWN},
+		{"yolo=swag", STATUS_PARAMETER_UNKNOWN},
+	} {
+		response := T_PerformRequestHeaders(t, "GET", "/users/export?"+test.query, T_ServerTokenHeaders(t))
+		T_ExpectErrorResponse(t, response, 400, test.expectedReason)
+	}
+}
+
+func Test_ExportUsers(t *testing.T) {
+	users := []*User{
+		{Id: "0000000001", Username: "a@z.co", Emails: []string{"a@z.co"}, Roles: []string{"patient"}, PwHash: "hash", EmailVerified: true, CreatedTime: "2021-01-01T10:00:00Z"},
+		{Id: "0000000002", Roles: []string{"patient"}, ModifiedTime: "2021-02-01T10:00:00Z"},
+	}
+	responsableStore.ExportUsersResponses = []ExportUsersResponse{{users, nil}, {users, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestHeaders(t, "GET", "/users/export?role=patient&modifiedSince=2021-01-01T00:00:00Z", T_ServerTokenHeaders(t))
+	if response.Code != http.StatusOK || response.Header().Get("content-type") != "application/x-ndjson" {
+		t.Fatalf("Unexpected response %d %v", response.Code, response.Header())
+	}
+	T_ExpectNDJSONLines(t, response.Body.String(), []map[string]interface{}{
+		{"userid": "0000000001", "username": "a@z.co", "emails": []interface{}{"a@z.co"}, "roles": []interface{}{"patient"}, "emailVerified": true, "passwordExists": true, "createdTime": "2021-01-01T10:00:00Z"},
+		{"userid": "0000000002", "roles": []interface{}{"patient"}, "passwordExists": false, "modifiedTime": "2021-02-01T10:00:00Z"},
+	})
+	if trailer := response.Result().Trailer.Get(USERS_EXPORT_ERROR); trailer != "" {
+		t.Fatalf("Unexpected export error %q", trailer)
+	}
+
+	response = T_PerformRequestHeaders(t, "GET", "/users/export?fields=emails,modifiedTime", T_ServerTokenHeaders(t))
+	T_ExpectNDJSONLines(t, response.Body.String(), []map[string]interface{}{
+		{"userid": "0000000001", "emails": []interface{}{"a@z.co"}},
+		{"userid": "0000000002", "modifiedTime": "2021-02-01T10:00:00Z"},
+	})
+}
+
+func Test_ExportUsers_Error_Store(t *testing.T) {
+	users := []*User{{Id: "0000000001", Roles: []string{"patient"}}}
+	responsableStore.ExportUsersResponses = []ExportUsersResponse{{users, errors.New("ERROR")}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestHeaders(t, "GET", "/users/export?fields=userid", T_ServerTokenHeaders(t))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", response.Code)
+	}
+	T_ExpectNDJSONLines(t, response.Body.String(), []map[string]interface{}{{"userid": "0000000001"}})
+	if trailer := response.Result().Trailer.Get(USERS_EXPORT_ERROR); trailer != STATUS_ERR_FINDING_USR {
+		t.Fatalf("The export error should be in the trailer, got %q", trailer)
+	}
+}
@@ -5,13 +5,13 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/mdblp/shoreline/audit"
 	"github.com/mdblp/shoreline/token"
+	"github.com/sirupsen/logrus"
 )
 
 func firstStringNotEmpty(strs ...string) string {
@@ -27,7 +27,7 @@ func firstStringNotEmpty(strs ...string) string {
 func getGivenDetail(req *http.Request) (d map[string]string) {
 	if req.ContentLength > 0 {
 		if err := json.NewDecoder(req.Body).Decode(&d); err != nil {
-			log.Print(USER_API_PREFIX, "error trying to decode user detail ", err)
+			logrus.WithError(err).Warn("error trying to decode user detail")
 			return nil
 		}
 	}
@@ -42,7 +42,7 @@ func unpackAuth(authLine string) (usr *User, pw string) {
 		parts := strings.SplitN(authLine, " ", 2)
 		payload := parts[1]
 		if decodedPayload, err := base64.StdEncoding.DecodeString(payload); err != nil {
-			log.Print(USER_API_PREFIX, "Error unpacking authorization header [%s]", err.Error())
+			logrus.WithError(err).Warn("Error unpacking authorization header")
 		} else {
 			details := strings.SplitN(string(decodedPayload), ":", 2)
 			if details[0] != "" || details[1] != "" {
@@ -63,31 +63,54 @@ func sendModelAsResWithStatus(res http.ResponseWriter, model interface{}, status
 	res.WriteHeader(statusCode)
 
 	if jsonDetails, err := json.Marshal(model); err != nil {
-		log.Println(USER_API_PREFIX, err.Error())
+		logrus.WithError(err).Error("Error marshaling response")
 	} else {
 		res.Write(jsonDetails)
 	}
 }
 
-// logAudit Variatic log for audit trails
-func (a *Api) logAudit(req *http.Request, tokenData *token.TokenData, format string, args ...interface{}) {
-	var prefix string
+// auditSourceKey holds the auditSource of a request in the context of the operations
+type auditSourceKey struct{}
 
-	if req.RemoteAddr != "" {
-		prefix = fmt.Sprintf("remoteAddr{%s}, ", req.RemoteAddr)
-	}
+// auditSource is the origin of the request recorded by the audit events
+type auditSource struct {
+	traceID    string
+	remoteAddr string
+}
 
-	traceSession := req.Header.Get(TP_TRACE_SESSION)
-	if traceSession != "" {
-		prefix += fmt.Sprintf("trace{%s}, ", traceSession)
-	}
+// requestContext returns the context of the request, carrying what the operations need to audit it
+func requestContext(req *http.Request) context.Context {
+	return context.WithValue(req.Context(), auditSourceKey{}, auditSource{traceID: req.Header.Get(TP_TRACE_SESSION), remoteAddr: req.RemoteAddr})
+}
+
+// logAudit records a structured audit event for the request.
+// The actor is taken from the token data unless already set on the event.
+func (a *Api) logAudit(req *http.Request, tokenData *token.TokenData, event *audit.Event) {
+	a.logAuditContext(requestContext(req), tokenData, event)
+}
 
-	if tokenData != nil {
-		prefix += fmt.Sprintf("isServer{%t}, ", tokenData.IsServer)
+// logAuditContext records a structured audit event for an operation,
+// the trace id and the remote address are those of the request if any (see requestContext)
+func (a *Api) logAuditContext(ctx context.Context, tokenData *token.TokenData, event *audit.Event) {
+	if event.ActorType == "" {
+		switch {
+		case tokenData == nil:
+			event.ActorType = audit.ActorAnonymous
+		case tokenData.IsServer:
+			event.ActorType = audit.ActorServer
+			event.ActorID = tokenData.UserId
+		default:
+			event.ActorType = audit.ActorUser
+			event.ActorID = tokenData.UserId
+		}
+	}
+	event.TenantID = a.tenantID
+	if source, ok := ctx.Value(auditSourceKey{}).(auditSource); ok {
+		event.TraceID = source.traceID
+		event.RemoteAddr = source.remoteAddr
 	}
 
-	s := fmt.Sprintf(format, args...)
-	a.auditLogger.Printf("%s%s", prefix, s)
+	a.auditLogger.Log(ctx, event)
 }
 
 func (a *Api) sendUser(res http.ResponseWriter, user *User, isServerRequest bool) {
@@ -128,6 +151,12 @@ func (a *Api) asSerializableUser(user *User, isServerRequest bool) interface{} {
 	if len(user.Username) > 0 || len(user.Emails) > 0 {
 		serializable["emailVerified"] = user.EmailVerified
 	}
+	if len(user.Organizations) > 0 {
+		serializable["organizations"] = user.Organizations
+	}
+	if !user.Profile.IsEmpty() {
+		serializable["profile"] = user.Profile
+	}
 	if isServerRequest {
 		serializable["passwordExists"] = (user.PwHash != "")
 	}
@@ -140,6 +169,7 @@ func (a *Api) appendUserLoginInProgress(user *User) (code int, elem *list.Elemen
 
 	// Simple rate limiter
 	a.loginLimiter.totalInProgress++
+	loginsInFlight.Inc()
 	if a.loginLimiter.totalInProgress > a.ApiConfig.MaxConcurrentLogin {
 		return http.StatusTooManyRequests, nil
 	}
@@ -159,6 +189,7 @@ func (a *Api) removeUserLoginInProgress(elem *list.Element) {
 	a.loginLimiter.mutex.Lock()
 
 	a.loginLimiter.totalInProgress--
+	loginsInFlight.Dec()
 
 	if elem != nil {
 		a.loginLimiter.usersInProgress.Remove(elem)
@@ -176,6 +207,11 @@ func CreateSessionTokenAndSave(ctx context.Context, data *token.TokenData, confi
 	if err != nil {
 		return nil, err
 	}
+	if data.IsServer {
+		countTokenIssued(TOKEN_SERVER)
+	} else {
+		countTokenIssued(TOKEN_USER)
+	}
 
 	return sessionToken, nil
 }
@@ -194,10 +230,11 @@ func extractTokenDuration(r *http.Request) int64 {
 	return 0
 }
 
-func hasServerToken(tokenString, secret string) bool {
+// hasServerToken tells whether the token is a server token of the tenant (empty for the default tenant)
+func hasServerToken(tokenString, secret, tenantID string) bool {
 	td, err := token.UnpackSessionTokenAndVerify(tokenString, secret)
 	if err != nil {
 		return false
 	}
-	return td.IsServer
+	return td.IsServer && td.TenantID == tenantID
 }
@@ -1,11 +1,14 @@
 package user
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"reflect"
 	"strconv"
 	"testing"
 
+	"github.com/mdblp/shoreline/audit"
 	"github.com/mdblp/shoreline/token"
 )
 
@@ -271,7 +274,7 @@ func Test_hasServerToken(t *testing.T) {
 
 	token, _ := token.CreateSessionToken(tokenTestData, tokenTestConfig)
 
-	if hasServerToken(token.ID, tokenTestConfig.Secret) == false {
+	if hasServerToken(token.ID, tokenTestConfig.Secret, "") == false {
 		t.Fatal("We should have got a server Token")
 	}
 }
@@ -282,7 +285,53 @@ func Test_hasServerToken_false(t *testing.T) {
 
 	token, _ := token.CreateSessionToken(tokenTestData, tokenTestConfig)
 
-	if hasServerToken(token.ID, tokenTestConfig.Secret) != false {
+	if hasServerToken(token.ID, tokenTestConfig.Secret, "") != false {
 		t.Fatal("We should have not got a server Token")
 	}
 }
+
+func Test_logAudit(t *testing.T) {
+	var buffer bytes.Buffer
+	api := InitAPITest(FAKE_CONFIG, logger, mockStore)
+	api.auditLogger = audit.NewLogger(false, audit.NewWriterSink(&buffer))
+
+	request, _ := http.NewRequest("PUT", "/user/1234", nil)
+	request.RemoteAddr = "127.0.0.1:1234"
+	request.Header.Set(TP_TRACE_SESSION, "trace-id")
+	api.logAudit(request, &token.TokenData{UserId: "5678", IsServer: false}, &audit.Event{Action: "UpdateUser", TargetUserID: "1234", Fields: []string{"emails"}})
+	api.logAudit(request, &token.TokenData{UserId: "shoreline", IsServer: true}, &audit.Event{Action: "GetUsers"})
+	api.logAudit(request, nil, &audit.Event{Action: "Logout"})
+
+	expected := []audit.Event{
+		{ActorID: "5678", ActorType: audit.ActorUser, Action: "UpdateUser", TargetUserID: "1234"},
+		{ActorID: "shoreline", ActorType: audit.ActorServer, Action: "GetUsers"},
+		{ActorID: "", ActorType: audit.ActorAnonymous, Action: "Logout"},
+	}
+	decoder := json.NewDecoder(&buffer)
+	for _, exp := range expected {
+		var event audit.Event
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatalf("Unable to decode audit event: %v", err)
+		}
+		if event.ActorID != exp.ActorID || event.ActorType != exp.ActorType || event.Action != exp.Action || event.TargetUserID != exp.TargetUserID {
+			t.Fatalf("Unexpected audit event %#v, expected %#v", event, exp)
+		}
+		if event.TraceID != "trace-id" || event.RemoteAddr != "127.0.0.1:1234" || event.Outcome != audit.OutcomeSuccess {
+			t.Fatalf("Unexpected audit event request details %#v", event)
+		}
+	}
+}
+
+func Test_hasServerToken_otherTenant(t *testing.T) {
+	tokenTestData := &token.TokenData{UserId: "2341", IsServer: true, DurationSecs: 1, TenantID: "fr"}
+	tokenTestConfig := token.TokenConfig{DurationSecs: 3600, Secret: "my secret"}
+
+	token, _ := token.CreateSessionToken(tokenTestData, tokenTestConfig)
+
+	if hasServerToken(token.ID, tokenTestConfig.Secret, "de") || hasServerToken(token.ID, tokenTestConfig.Secret, "") {
+		t.Fatal("The server token of another tenant should be rejected")
+	}
+	if !hasServerToken(token.ID, tokenTestConfig.Secret, "fr") {
+		t.Fatal("We should have got a server Token of the tenant")
+	}
+}
@@ -0,0 +1,243 @@
+package user
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxImportRows is the maximum number of users of an import, bigger files must be split
+	MaxImportRows = 1000
+	// MaxImportLineSize is the maximum size in bytes of an NDJSON import line
+	MaxImportLineSize = 64 * 1024
+	// importListSeparator separates the emails and the roles in the CSV columns
+	importListSeparator = ";"
+)
+
+var (
+	errImportTooManyRows   = fmt.Errorf("more than %d rows", MaxImportRows)
+	errImportPasswordSet   = errors.New("Password is set while inviting the user")
+	errImportDuplicateUser = errors.New("User is already in the import")
+	errImportLineTooLong   = fmt.Errorf("line longer than %d bytes", MaxImportLineSize)
+)
+
+// importColumns are the columns of the CSV imports, the username is required
+var importColumns = map[string]bool{"username": true, "emails": true, "password": true, "roles": true}
+
+// importRow is a user of a bulk import, with the line it comes from.
+// Its error is set when the line cannot be parsed.
+type importRow struct {
+	line    int
+	details *NewUserDetails
+	err     error
+}
+
+// ImportRowResult is the outcome of a row of a bulk import
+type ImportRowResult struct {
+	Line       int    `json:"line"`
+	UserID     string `json:"userid,omitempty"`
+	Username   string `json:"username,omitempty"`
+	ClaimToken string `json:"claimToken,omitempty"`
+	ErrorCode  string `json:"errorCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ImportReport is the outcome of a bulk import, row by row
+type ImportReport struct {
+	DryRun  bool              `json:"dryRun"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// @Summary Import users
+// @Description Create users in bulk from a CSV (columns username, emails, password and roles, lists separated by ;) or NDJSON (one POST /user body per line) file. The emails default to the username. Every row is validated and reported, the valid ones are created unless dryRun is set. With invite, the users are created without password and a claim token is returned for each of them, to be sent in the invitations.
+// @ID shoreline-user-api-importusers
+// @Accept text/csv
+// @Accept application/x-ndjson
+// @Produce json
+// @Param format query string false "Format of the file, taken from the content type by default" Enums(csv, ndjson)
+// @Param dryRun query bool false "Only validate the rows"
+// @Param invite query bool false "Create the users without password and return their claim tokens"
+// @Security TidepoolAuth
+// @Success 200 {object} user.ImportReport
+// @Failure 500 {object} status.Status "message returned:\"Error finding user\" or \"Error creating the user\" "
+// @Failure 400 {object} status.Status "message returned:\"Invalid query parameter\" or \"Invalid user details were given\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /users/import [post]
+func (a *Api) ImportUsers(res http.ResponseWriter, req *http.Request) {
+	tokenData := a.authenticateServerToken(res, req)
+	if tokenData == nil {
+		return
+	}
+
+	query := req.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		switch contentType := req.Header.Get("content-type"); {
+		case strings.Contains(contentType, "csv"):
+			format = "csv"
+		case strings.Contains(contentType, "ndjson"), strings.Contains(contentType, "json"):
+			format = "ndjson"
+		}
+	}
+	rows, opErr := parseImport(req.Body, format)
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+
+	report, opErr := a.importUsers(requestContext(req), tokenData, rows, query.Get("dryRun") == "true", query.Get("invite") == "true")
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsRes(res, report)
+}
+
+// parseImport reads the rows of an import in the given format, csv or ndjson
+func parseImport(reader io.Reader, format string) ([]*importRow, *operationError) {
+	var rows []*importRow
+	var err error
+	switch format {
+	case "csv":
+		rows, err = parseImportCSV(reader)
+	case "ndjson":
+		rows, err = parseImportNDJSON(reader)
+	default:
+		return nil, fail(errInvalidQuery, errUnknownFormat)
+	}
+	if err != nil {
+		return nil, fail(errInvalidUserDetails, err)
+	}
+	return rows, nil
+}
+
+// parseImportCSV reads the rows of a CSV import, its first line holds the column names
+func parseImportCSV(reader io.Reader) ([]*importRow, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	columns := map[string]int{}
+	for index, name := range header {
+		name = strings.TrimSpace(name)
+		if !importColumns[name] {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = index
+	}
+	if _, found := columns["username"]; !found {
+		return nil, errors.New("missing username column")
+	}
+
+	rows := []*importRow{}
+	for line := 2; ; line++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if len(rows) == MaxImportRows {
+			return nil, errImportTooManyRows
+		}
+		row := &importRow{line: line, details: &NewUserDetails{}}
+		rows = append(rows, row)
+		if err != nil {
+			row.err = err
+			continue
+		}
+		value := func(column string) string {
+			if index, found := columns[column]; found && index < len(record) {
+				return strings.TrimSpace(record[index])
+			}
+			return ""
+		}
+		if username := value("username"); username != "" {
+			row.details.Username = &username
+		}
+		if password := value("password"); password != "" {
+			row.details.Password = &password
+		}
+		row.details.Emails = splitImportList(value("emails"))
+		row.details.Roles = splitImportList(value("roles"))
+	}
+}
+
+// parseImportNDJSON reads the rows of an NDJSON import, each line is a user creation body.
+// The lines longer than MaxImportLineSize are reported as invalid rows.
+func parseImportNDJSON(reader io.Reader) ([]*importRow, error) {
+	rows := []*importRow{}
+	buffered := bufio.NewReaderSize(reader, MaxImportLineSize)
+	for line := 1; ; line++ {
+		data, isPrefix, err := buffered.ReadLine()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		text := strings.TrimSpace(string(data))
+		tooLong := isPrefix
+		// the rest of a line too long is skipped
+		for isPrefix {
+			if _, isPrefix, err = buffered.ReadLine(); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+		if text == "" {
+			continue
+		}
+		if len(rows) == MaxImportRows {
+			return nil, errImportTooManyRows
+		}
+		row := &importRow{line: line, details: &NewUserDetails{}}
+		if tooLong {
+			row.err = errImportLineTooLong
+		} else {
+			row.err = row.details.ExtractFromJSON(strings.NewReader(text))
+		}
+		rows = append(rows, row)
+	}
+}
+
+func splitImportList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	values := []string{}
+	for _, item := range strings.Split(value, importListSeparator) {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// InsertUsersError lists the users which could not be inserted, by their index in the inserted list
+type InsertUsersError struct {
+	Errors map[int]error
+}
+
+func (e *InsertUsersError) Error() string {
+	indexes := []int{}
+	for index := range e.Errors {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	names := []string{}
+	for _, index := range indexes {
+		names = append(names, strconv.Itoa(index))
+	}
+	return fmt.Sprintf("%d users not inserted (%s)", len(e.Errors), strings.Join(names, ", "))
+}
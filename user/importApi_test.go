@@ -0,0 +1,144 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mdblp/shoreline/schema"
+	"github.com/mdblp/shoreline/token"
+)
+
+func T_ExpectImportReport(t *testing.T, response *http.Response, expected *ImportReport) *ImportReport {
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %d", response.StatusCode)
+	}
+	report := &ImportReport{}
+	if err := json.NewDecoder(response.Body).Decode(report); err != nil {
+		t.Fatalf("Invalid report: %v", err)
+	}
+	if report.DryRun != expected.DryRun || report.Total != expected.Total || report.Created != expected.Created || report.Failed != expected.Failed || len(report.Rows) != len(expected.Rows) {
+		t.Fatalf("Expected the report %+v, got %+v", expected, report)
+	}
+	for index, row := range report.Rows {
+		expectedRow := expected.Rows[index]
+		if row.Line != expectedRow.Line || row.Username != expectedRow.Username || row.ErrorCode != expectedRow.ErrorCode || row.Error != expectedRow.Error || (row.UserID != "") != (expectedRow.UserID != "") {
+			t.Fatalf("Expected the row %+v, got %+v", expectedRow, row)
+		}
+	}
+	return report
+}
+
+func T_ImportHeaders(t *testing.T, contentType string) http.Header {
+	headers := T_ServerTokenHeaders(t)
+	headers.Set("content-type", contentType)
+	return headers
+}
+
+func Test_ImportUsers_Error_NotServerToken(t *testing.T) {
+	sessionToken := T_CreateSessionToken(t, "abcdef1234", false, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	response := T_PerformRequestBodyHeaders(t, "POST", "/users/import?format=csv", "username\na@z.co\n", headers)
+	T_ExpectErrorResponse(t, response, 401, STATUS_UNAUTHORIZED)
+}
+
+func Test_ImportUsers_Error_InvalidFile(t *testing.T) {
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "POST", "/users/import", "username\na@z.co\n", T_ImportHeaders(t, "text/plain"))
+	T_ExpectErrorResponse(t, response, 400, STATUS_INVALID_QUERY)
+
+	response = T_PerformRequestBodyHeaders(t, "POST", "/users/import", "username,pwhash\na@z.co,hash\n", T_ImportHeaders(t, "text/csv"))
+	T_ExpectErrorResponse(t, response, 400, STATUS_INVALID_USER_DETAILS)
+
+	response = T_PerformRequestBodyHeaders(t, "POST", "/users/import", "emails\na@z.co\n", T_ImportHeaders(t, "text/csv"))
+	T_ExpectErrorResponse(t, response, 400, STATUS_INVALID_USER_DETAILS)
+}
+
+func Test_ImportUsers_DryRun(t *testing.T) {
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{}, nil}, {[]*User{{Id: "1111111111"}}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	body := "username,emails,password,roles\n" +
+		"a@z.co,,password1,hcp\n" +
+		"invalid,,password1,hcp\n" +
+		"b@z.co,A@z.co,password1,hcp\n" +
+		"c@z.co,c@z.co;c2@z.co,password1,patient\n"
+	response := T_PerformRequestBodyHeaders(t, "POST", "/users/import?dryRun=true", body, T_ImportHeaders(t, "text/csv"))
+	T_ExpectImportReport(t, response.Result(), &ImportReport{DryRun: true, Total: 4, Failed: 3, Rows: []ImportRowResult{
+		{Line: 2, Username: "a@z.co"},
+		{Line: 3, ErrorCode: schema.ErrorInvalidUserDetails, Error: User_error_username_invalid.Error()},
+		{Line: 4, ErrorCode: schema.ErrorUserAlreadyExists, Error: errImportDuplicateUser.Error()},
+		{Line: 5, ErrorCode: schema.ErrorUserAlreadyExists, Error: STATUS_USR_ALREADY_EXISTS},
+	}})
+}
+
+func Test_ImportUsers_CSV(t *testing.T) {
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{}, nil}, {[]*User{}, nil}}
+	responsableStore.InsertUsersResponses = []error{&InsertUsersError{Errors: map[int]error{1: errors.New("duplicate key")}}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	body := "username, password\na@z.co, password1\nb@z.co, password2\n"
+	response := T_PerformRequestBodyHeaders(t, "POST", "/users/import", body, T_ImportHeaders(t, "text/csv"))
+	T_ExpectImportReport(t, response.Result(), &ImportReport{Total: 2, Created: 1, Failed: 1, Rows: []ImportRowResult{
+		{Line: 2, Username: "a@z.co", UserID: "set"},
+		{Line: 3, Username: "b@z.co", ErrorCode: schema.ErrorInternal, Error: STATUS_ERR_CREATING_USR},
+	}})
+}
+
+func Test_ImportUsers_Error_InsertUsers(t *testing.T) {
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{}, nil}}
+	responsableStore.InsertUsersResponses = []error{errors.New("ERROR")}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "POST", "/users/import?format=csv", "username,password\na@z.co,password1\n", T_ServerTokenHeaders(t))
+	T_ExpectErrorResponse(t, response, 500, STATUS_ERR_CREATING_USR)
+}
+
+func Test_ImportUsers_NDJSON_Invite(t *testing.T) {
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{}, nil}}
+	responsableStore.InsertUsersResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	body := `{"username": "a@z.co", "emails": ["a@z.co"], "roles": ["patient"]}` + "\n" +
+		`{"username": "b@z.co", "password": "password1"}` + "\n\n" +
+		`{"username": ` + "\n"
+	response := T_PerformRequestBodyHeaders(t, "POST", "/users/import?invite=true", body, T_ImportHeaders(t, "application/x-ndjson"))
+	report := T_ExpectImportReport(t, response.Result(), &ImportReport{Total: 3, Created: 1, Failed: 2, Rows: []ImportRowResult{
+		{Line: 1, Username: "a@z.co", UserID: "set"},
+		{Line: 2, ErrorCode: schema.ErrorInvalidUserDetails, Error: errImportPasswordSet.Error()},
+		{Line: 4, ErrorCode: schema.ErrorInvalidUserDetails, Error: "unexpected EOF"},
+	}})
+
+	claimData, err := token.UnpackClaimTokenAndVerify(report.Rows[0].ClaimToken, TOKEN_CONFIG.Secret)
+	if err != nil || claimData.UserID != report.Rows[0].UserID || claimData.CreatorID != "shoreline" {
+		t.Fatalf("The invited user should have a claim token: %+v %v", claimData, err)
+	}
+}
+
+func Test_parseImportNDJSON_Lines(t *testing.T) {
+	body := "\n" + `{"username": "a@z.co"}` + "\n\n" +
+		`{"username": "` + strings.Repeat("b", MaxImportLineSize) + `"}` + "\n" +
+		`{"username": "c@z.co"}`
+	rows, err := parseImportNDJSON(strings.NewReader(body))
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("Unexpected rows %v %v", rows, err)
+	}
+	for index, expected := range []struct {
+		line int
+		err  error
+	}{{2, nil}, {4, errImportLineTooLong}, {5, nil}} {
+		if rows[index].line != expected.line || rows[index].err != expected.err {
+			t.Fatalf("Expected the row %d at line %d with error %v, got line %d and %v", index, expected.line, expected.err, rows[index].line, rows[index].err)
+		}
+	}
+	if *rows[2].details.Username != "c@z.co" {
+		t.Fatalf("The rows after a line too long should be read, got %+v", rows[2].details)
+	}
+}
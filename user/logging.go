@@ -0,0 +1,108 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// REQUEST_ID_HEADER request id, generated when not given by the caller and returned in the response
+	REQUEST_ID_HEADER = "x-request-id"
+)
+
+type requestLogKey struct{}
+
+// requestLog holds the log entry of a request, completed with the user id once authenticated
+type requestLog struct {
+	mutex sync.Mutex
+	entry *logrus.Entry
+}
+
+func (r *requestLog) get() *logrus.Entry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.entry
+}
+
+func (r *requestLog) withField(key string, value interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entry = r.entry.WithField(key, value)
+}
+
+// statusRecorder keeps the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(statusCode int) {
+	s.status = statusCode
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush lets the streaming handlers flush through the middlewares
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// requestLogging is a middleware adding a request-scoped log entry
+// (request id, trace id, method, path) to the request context
+func (a *Api) requestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		requestID := req.Header.Get(REQUEST_ID_HEADER)
+		if requestID == "" {
+			requestID = uuid.New().String()
+		}
+		res.Header().Set(REQUEST_ID_HEADER, requestID)
+
+		fields := logrus.Fields{
+			"requestId": requestID,
+			"method":    req.Method,
+			"path":      req.URL.Path,
+		}
+		if traceID := req.Header.Get(TP_TRACE_SESSION); traceID != "" {
+			fields["traceId"] = traceID
+		}
+		if tenantID := a.resolveTenant(req).tenantID; tenantID != "" {
+			fields["tenantId"] = tenantID
+		}
+		reqLog := &requestLog{entry: a.logger.WithFields(fields)}
+		recorder := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), requestLogKey{}, reqLog)))
+
+		reqLog.get().WithFields(logrus.Fields{
+			"status":   recorder.status,
+			"duration": time.Since(start).Seconds(),
+		}).Debug("request completed")
+	})
+}
+
+// log returns the log entry of the request, or the api logger
+// when the request did not go through the middleware
+func (a *Api) log(req *http.Request) *logrus.Entry {
+	return a.logContext(req.Context())
+}
+
+func (a *Api) logContext(ctx context.Context) *logrus.Entry {
+	if reqLog, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		return reqLog.get()
+	}
+	return logrus.NewEntry(a.logger)
+}
+
+// setLogUserID adds the authenticated user id to the request log entry
+func setLogUserID(ctx context.Context, userID string) {
+	if reqLog, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		reqLog.withField("userId", userID)
+	}
+}
@@ -0,0 +1,68 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+func Test_RequestLogging(t *testing.T) {
+	var buffer bytes.Buffer
+	testLogger := logrus.New()
+	testLogger.SetOutput(&buffer)
+	testLogger.SetFormatter(&logrus.JSONFormatter{})
+	api := InitAPITest(FAKE_CONFIG, testLogger, responsableStore)
+	defer T_ExpectResponsablesEmpty(t)
+
+	sessionToken := T_CreateSessionToken(t, "abcdef1234", false, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{sessionToken, nil}}
+
+	request, _ := http.NewRequest("GET", "/audit", nil)
+	request.Header.Set(TP_SESSION_TOKEN, sessionToken.ID)
+	request.Header.Set(TP_TRACE_SESSION, "trace-1")
+	request.Header.Set(REQUEST_ID_HEADER, "request-1")
+	response := httptest.NewRecorder()
+	router := mux.NewRouter()
+	api.SetHandlers("", router)
+	router.ServeHTTP(response, request)
+
+	if response.Code != http.StatusUnauthorized {
+		t.Fatalf("Unexpected status %d", response.Code)
+	}
+	if response.Header().Get(REQUEST_ID_HEADER) != "request-1" {
+		t.Fatalf("Request id should be returned, got %q", response.Header().Get(REQUEST_ID_HEADER))
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		t.Fatalf("Unable to decode log entry %q: %v", buffer.String(), err)
+	}
+	if entry["requestId"] != "request-1" || entry["traceId"] != "trace-1" || entry["userId"] != "abcdef1234" {
+		t.Fatalf("Missing request fields in log entry %#v", entry)
+	}
+	if entry["level"] != "warning" || entry["msg"] != STATUS_UNAUTHORIZED {
+		t.Fatalf("Unexpected log entry %#v", entry)
+	}
+	if strings.Contains(buffer.String(), sessionToken.ID) {
+		t.Fatalf("Session token should not be logged")
+	}
+}
+
+func Test_RequestLogging_GeneratedRequestID(t *testing.T) {
+	api := InitAPITest(FAKE_CONFIG, logger, responsableStore)
+	request, _ := http.NewRequest("GET", "/private", nil)
+	response := httptest.NewRecorder()
+	router := mux.NewRouter()
+	api.SetHandlers("", router)
+	router.ServeHTTP(response, request)
+
+	if response.Header().Get(REQUEST_ID_HEADER) == "" {
+		t.Fatalf("A request id should be generated")
+	}
+}
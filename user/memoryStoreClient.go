@@ -0,0 +1,116 @@
+package user
+
+import (
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MemoryStoreClient is a Storage keeping the users, tokens and organizations in memory,
+// for the local development without Mongo and the tests running a shoreline service (see the shorelinetest package).
+//
+// It has the semantics of the Mongo Client: the documents are stored in BSON, the upserts only set the fields
+// which are not empty, the usernames are matched case-insensitively, FindUser returns nil when the user does not exist,
+// FindTokenByID returns mongo.ErrNoDocuments when nothing is found, and the expired tokens are not listed and removed
+// when adding tokens.
+// The users are keyed by id, and the writes fail on the ids, usernames or emails already taken (regardless of their case)
+// as the unique indexes would.
+type MemoryStoreClient struct {
+	documentStore
+}
+
+// NewMemoryStoreClient creates an empty MemoryStoreClient
+func NewMemoryStoreClient() *MemoryStoreClient {
+	return &MemoryStoreClient{newDocumentStore(&memoryCollections{
+		documents: map[string]map[string]bson.Raw{
+			USERS_COLLECTION:         {}, // by userid
+			TOKENS_COLLECTION:        {}, // by _id
+			ORGANIZATIONS_COLLECTION: {}, // by id
+		},
+	})}
+}
+
+// memoryCollections are documentCollections in maps, the update transactions are serialized
+type memoryCollections struct {
+	mut       sync.RWMutex
+	documents map[string]map[string]bson.Raw
+}
+
+func (m *memoryCollections) view(fn func(documentTx) error) error {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return fn(&memoryTx{documents: m.documents})
+}
+
+func (m *memoryCollections) update(fn func(documentTx) error) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	tx := &memoryTx{documents: m.documents, writes: map[string]map[string]bson.Raw{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for collection, writes := range tx.writes {
+		if m.documents[collection] == nil {
+			m.documents[collection] = map[string]bson.Raw{}
+		}
+		for key, document := range writes {
+			if document == nil {
+				delete(m.documents[collection], key)
+			} else {
+				m.documents[collection][key] = document
+			}
+		}
+	}
+	return nil
+}
+
+// memoryTx keeps the writes of the transaction (nil for a deletion) until it succeeds
+type memoryTx struct {
+	documents map[string]map[string]bson.Raw
+	writes    map[string]map[string]bson.Raw
+}
+
+func (m *memoryTx) get(collection string, key string) bson.Raw {
+	if document, ok := m.writes[collection][key]; ok {
+		return document
+	}
+	return m.documents[collection][key]
+}
+
+func (m *memoryTx) write(collection string, key string, document bson.Raw) {
+	if m.writes[collection] == nil {
+		m.writes[collection] = map[string]bson.Raw{}
+	}
+	m.writes[collection][key] = document
+}
+
+func (m *memoryTx) put(collection string, key string, document bson.Raw) error {
+	m.write(collection, key, document)
+	return nil
+}
+
+func (m *memoryTx) delete(collection string, key string) error {
+	m.write(collection, key, nil)
+	return nil
+}
+
+func (m *memoryTx) forEach(collection string, prefix string, fn func(key string, document bson.Raw) error) error {
+	for key, document := range m.documents[collection] {
+		if _, written := m.writes[collection][key]; written || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if err := fn(key, document); err != nil {
+			return err
+		}
+	}
+	for key, document := range m.writes[collection] {
+		if document == nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if err := fn(key, document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package user
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mdblp/shoreline/token"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var _ Storage = &MemoryStoreClient{}
+
+func TestMemoryStore_UpsertUser(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStoreClient()
+
+	original := &User{Id: "1234", Username: "Test@Foo.bar", Emails: []string{"test@foo.bar"}, Roles: []string{"patient", "hcp"}, PwHash: "hash"}
+	if err := store.UpsertUser(ctx, original); err != nil {
+		t.Fatalf("Failed to upsert the user: %v", err)
+	}
+	// the empty fields are kept, as with a $set
+	if err := store.UpsertUser(ctx, &User{Id: "1234", TermsAccepted: "2016-01-01T01:23:45-08:00"}); err != nil {
+		t.Fatalf("Failed to update the user: %v", err)
+	}
+	original.TermsAccepted = "changed by the caller"
+
+	found, err := store.FindUser(ctx, &User{Id: "1234"})
+	if err != nil {
+		t.Fatalf("Failed to find the user: %v", err)
+	}
+	if found.Username != "Test@Foo.bar" || found.PwHash != "hash" || found.TermsAccepted != "2016-01-01T01:23:45-08:00" {
+		t.Errorf("Unexpected stored user %v", found)
+	}
+	if len(found.Roles) != 2 || found.Roles[0] != "hcp" {
+		t.Errorf("The roles should be sorted, got %v", found.Roles)
+	}
+
+	if found, err := store.FindUser(ctx, &User{Id: "unknown"}); found != nil || err != nil {
+		t.Errorf("Expected no user and no error, got %v, %v", found, err)
+	}
+	if found, err := store.FindUser(ctx, &User{Username: "Test@Foo.bar"}); found != nil || err != nil {
+		t.Errorf("A user should only be found by id, got %v, %v", found, err)
+	}
+}
+
+func TestMemoryStore_FindUsers(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStoreClient()
+	store.UpsertUser(ctx, &User{Id: "1", Username: "a.b@foo.bar", Emails: []string{"a.b@foo.bar"}})
+	store.UpsertUser(ctx, &User{Id: "2", Username: "axb@foo.bar", Emails: []string{"other@foo.bar"}})
+
+	tests := []struct {
+		name     string
+		search   *User
+		expected []string
+	}{
+		{"case insensitive username", &User{Username: "A.B@FOO.BAR"}, []string{"1"}},
+		{"username without regex", &User{Username: "a.b@foo.bar"}, []string{"1"}},
+		{"any email", &User{Emails: []string{"unknown@foo.bar", "other@foo.bar"}}, []string{"2"}},
+		{"case sensitive email", &User{Emails: []string{"OTHER@foo.bar"}}, []string{}},
+		{"id or username", &User{Id: "2", Username: "a.b@foo.bar"}, []string{"1", "2"}},
+		{"no criteria", &User{}, []string{}},
+	}
+	for _, test := range tests {
+		found, err := store.FindUsers(ctx, test.search)
+		if err != nil {
+			t.Fatalf("%s: failed to find the users: %v", test.name, err)
+		}
+		ids := []string{}
+		for _, user := range found {
+			ids = append(ids, user.Id)
+		}
+		if len(ids) != len(test.expected) || (len(ids) > 0 && ids[0] != test.expected[0]) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, ids)
+		}
+	}
+}
+
+func TestMemoryStore_InsertUsers(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStoreClient()
+	store.UpsertUser(ctx, &User{Id: "1", Username: "existing@foo.bar"})
+
+	err := store.InsertUsers(ctx, []*User{{Id: "1", Username: "duplicate@foo.bar"}, {Id: "2", Username: "new@foo.bar"}})
+	insertErr, ok := err.(*InsertUsersError)
+	if !ok || len(insertErr.Errors) != 1 || insertErr.Errors[0] == nil {
+		t.Fatalf("Expected the first user to fail, got %v", err)
+	}
+	if found, _ := store.FindUser(ctx, &User{Id: "1"}); found.Username != "existing@foo.bar" {
+		t.Errorf("The existing user should be kept, got %v", found)
+	}
+	if found, _ := store.FindUser(ctx, &User{Id: "2"}); found == nil {
+		t.Errorf("The second user should be inserted")
+	}
+}
+
+func TestMemoryStore_Tokens(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStoreClient()
+	now := time.Now().Unix()
+	store.AddToken(ctx, &token.SessionToken{ID: "later", UserID: "1234", CreatedAt: now, ExpiresAt: now + 3600})
+	store.AddToken(ctx, &token.SessionToken{ID: "first", UserID: "1234", CreatedAt: now - 60, ExpiresAt: now + 3600})
+	store.AddToken(ctx, &token.SessionToken{ID: "expired", UserID: "1234", CreatedAt: now - 7200, ExpiresAt: now - 3600})
+
+	sessionTokens, err := store.FindTokensByUserID(ctx, "1234")
+	if err != nil {
+		t.Fatalf("Failed to find the tokens: %v", err)
+	}
+	if len(sessionTokens) != 2 || sessionTokens[0].ID != "first" || sessionTokens[1].ID != "later" {
+		t.Errorf("Expected the valid tokens by creation time, got %v", sessionTokens)
+	}
+
+	if err := store.RemoveTokensByUserID(ctx, "1234"); err != nil {
+		t.Fatalf("Failed to remove the tokens: %v", err)
+	}
+	if _, err := store.FindTokenByID(ctx, "expired"); err != mongo.ErrNoDocuments {
+		t.Errorf("Expected no documents, got %v", err)
+	}
+}
+
+func TestMemoryStore_ExpiredTokensRemoved(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStoreClient()
+	now := time.Now().Unix()
+	store.AddToken(ctx, &token.SessionToken{ID: "expired", UserID: "1234", CreatedAt: now - 7200, ExpiresAt: now - 3600})
+	// the sweep is done once per interval, the first add has already counted it
+	store.sweep.last = time.Time{}
+	store.AddToken(ctx, &token.SessionToken{ID: "valid", UserID: "1234", CreatedAt: now, ExpiresAt: now + 3600})
+
+	found := false
+	store.collections.view(func(tx documentTx) error {
+		found = tx.get(TOKENS_COLLECTION, "expired") != nil
+		return nil
+	})
+	if found {
+		t.Errorf("Expected the expired token to be removed")
+	}
+	if sessionToken, err := store.FindTokenByID(ctx, "valid"); err != nil || sessionToken == nil {
+		t.Errorf("Expected the valid token to be kept, got %v, %v", sessionToken, err)
+	}
+}
+
+func TestMemoryStore_Organizations(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStoreClient()
+	store.UpsertOrganization(ctx, &Organization{Id: "org2", Name: "B"})
+	store.UpsertOrganization(ctx, &Organization{Id: "org1", Name: "A"})
+	store.UpsertUser(ctx, &User{Id: "1234", Username: "test@foo.bar"})
+	store.UpsertOrganizationMember(ctx, "1234", &OrganizationMember{OrganizationID: "org1", Role: "member"})
+	store.UpsertOrganizationMember(ctx, "1234", &OrganizationMember{OrganizationID: "org1", Role: "admin"})
+	store.UpsertOrganizationMember(ctx, "1234", &OrganizationMember{OrganizationID: "org2", Role: "member"})
+
+	if organizations, _ := store.FindOrganizations(ctx); len(organizations) != 2 || organizations[0].Id != "org1" {
+		t.Errorf("Expected the organizations by name, got %v", organizations)
+	}
+	if members, _ := store.FindUsersByOrganization(ctx, "org1"); len(members) != 1 || members[0].OrganizationRole("org1") != "admin" {
+		t.Errorf("Expected an admin member, got %v", members)
+	}
+
+	if err := store.RemoveOrganization(ctx, "org1"); err != nil {
+		t.Fatalf("Failed to remove the organization: %v", err)
+	}
+	if organization, err := store.FindOrganization(ctx, "org1"); organization != nil || err != nil {
+		t.Errorf("The organization should be removed, got %v, %v", organization, err)
+	}
+	if found, _ := store.FindUser(ctx, &User{Id: "1234"}); len(found.Organizations) != 1 || found.Organizations[0].OrganizationID != "org2" {
+		t.Errorf("The membership should be removed, got %v", found.Organizations)
+	}
+}
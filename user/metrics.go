@@ -0,0 +1,127 @@
+package user
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metricsNamespace = "shoreline"
+
+	// Login types of the login metrics
+	LOGIN_USER   = "user"
+	LOGIN_SERVER = "server"
+	// Outcome of the successful logins, the failures are labelled with their error code
+	LOGIN_SUCCESS = "success"
+
+	// Token types of the token metrics
+	TOKEN_USER     = "user"
+	TOKEN_SERVER   = "server"
+	TOKEN_EXTERNAL = "external"
+
+	// Results of the token validations
+	TOKEN_VALID   = "valid"
+	TOKEN_INVALID = "invalid"
+	TOKEN_REVOKED = "revoked"
+
+	// unmatchedRoute labels the requests which did not match any route
+	unmatchedRoute = "unmatched"
+)
+
+var (
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "The total number of error responses, by route, method, status and error code",
+	}, []string{"route", "method", "status", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "The duration of the HTTP requests, by route, method and status",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	loginsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "logins_total",
+		Help:      "The total number of logins, by type (user or server) and outcome (success or error code)",
+	}, []string{"type", "outcome"})
+
+	loginsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "logins_in_flight",
+		Help:      "The number of logins in progress",
+	})
+
+	tokensIssuedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tokens_issued_total",
+		Help:      "The total number of tokens issued, by type (user, server or external)",
+	}, []string{"type"})
+
+	tokenValidationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "token_validations_total",
+		Help:      "The total number of session token validations, by result (valid, invalid or revoked)",
+	}, []string{"result"})
+
+	mongoOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "The duration of the Mongo store operations, by operation",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+)
+
+// routeName returns the path template of the route matched by the request,
+// so the metrics cardinality does not depend on the ids in the paths
+func routeName(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return unmatchedRoute
+}
+
+// requestMetrics is a middleware measuring the duration of the requests
+func requestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+		next.ServeHTTP(recorder, req)
+		requestDuration.WithLabelValues(routeName(req), req.Method, strconv.Itoa(recorder.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+func countError(req *http.Request, apiErr *apiError) {
+	errorsTotal.WithLabelValues(routeName(req), req.Method, strconv.Itoa(apiErr.status), apiErr.code).Inc()
+}
+
+// countLogin counts a login, successful when apiErr is nil
+func countLogin(loginType string, apiErr *apiError) {
+	outcome := LOGIN_SUCCESS
+	if apiErr != nil {
+		outcome = apiErr.code
+	}
+	loginsTotal.WithLabelValues(loginType, outcome).Inc()
+}
+
+func countTokenIssued(tokenType string) {
+	tokensIssuedTotal.WithLabelValues(tokenType).Inc()
+}
+
+func countTokenValidation(result string) {
+	tokenValidationsTotal.WithLabelValues(result).Inc()
+}
+
+// observeMongoOperation is meant to be deferred at the start of the store operations
+func observeMongoOperation(operation string, start time.Time) {
+	mongoOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,81 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mdblp/shoreline/schema"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_Metrics_LoginError(t *testing.T) {
+	authorization := T_CreateAuthorization(t, "a@b.co", "password")
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{&User{Id: "1111111111", PwHash: "d1fef52139b0d120100726bcb43d5cc13d41e4b5"}}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	errorCount := errorsTotal.WithLabelValues("/login", "POST", "403", schema.ErrorEmailNotVerified)
+	logins := loginsTotal.WithLabelValues(LOGIN_USER, schema.ErrorEmailNotVerified)
+	errorsBefore, loginsBefore := testutil.ToFloat64(errorCount), testutil.ToFloat64(logins)
+	// the gauge is shared by the apis of the tests
+	inFlightBefore := testutil.ToFloat64(loginsInFlight)
+
+	headers := http.Header{}
+	headers.Add("Authorization", authorization)
+	T_PerformRequestHeaders(t, "POST", "/login", headers)
+
+	if value := testutil.ToFloat64(errorCount); value != errorsBefore+1 {
+		t.Fatalf("Expected the error to be counted once, got %v", value-errorsBefore)
+	}
+	if value := testutil.ToFloat64(logins); value != loginsBefore+1 {
+		t.Fatalf("Expected the login failure to be counted once, got %v", value-loginsBefore)
+	}
+	if testutil.ToFloat64(loginsInFlight) != inFlightBefore {
+		t.Fatalf("No login should be in flight anymore")
+	}
+}
+
+func Test_Metrics_TokenValidation(t *testing.T) {
+	revoked := tokenValidationsTotal.WithLabelValues(TOKEN_REVOKED)
+	invalid := tokenValidationsTotal.WithLabelValues(TOKEN_INVALID)
+	revokedBefore, invalidBefore := testutil.ToFloat64(revoked), testutil.ToFloat64(invalid)
+
+	sessionToken := T_CreateSessionToken(t, "abcdef1234", false, TOKEN_DURATION)
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{nil, errors.New("ERROR")}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, sessionToken.ID)
+	T_PerformRequestHeaders(t, "GET", "/user", headers)
+	T_PerformRequest(t, "GET", "/user")
+
+	if testutil.ToFloat64(revoked) != revokedBefore+1 || testutil.ToFloat64(invalid) != invalidBefore+1 {
+		t.Fatalf("Expected one revoked and one invalid token validation")
+	}
+}
+
+func Test_Metrics_RequestDuration(t *testing.T) {
+	T_PerformRequest(t, "GET", "/private")
+	if testutil.CollectAndCount(requestDuration) == 0 {
+		t.Fatalf("Expected the request duration to be observed")
+	}
+}
+
+func Test_Metrics_LoginsInFlight_Tenants(t *testing.T) {
+	api, _, _ := initTenantAPITest(t)
+	before := testutil.ToFloat64(loginsInFlight)
+
+	_, first := api.appendUserLoginInProgress(&User{Username: "a@b.co"})
+	_, second := api.tenants[0].appendUserLoginInProgress(&User{Username: "c@d.co"})
+	if value := testutil.ToFloat64(loginsInFlight); value != before+2 {
+		t.Fatalf("Expected the logins of all the tenants to be in flight, got %v", value-before)
+	}
+	api.tenants[0].removeUserLoginInProgress(second)
+	if value := testutil.ToFloat64(loginsInFlight); value != before+1 {
+		t.Fatalf("Expected the login of the default tenant to be in flight, got %v", value-before)
+	}
+	api.removeUserLoginInProgress(first)
+	if value := testutil.ToFloat64(loginsInFlight); value != before {
+		t.Fatalf("No login should be in flight anymore, got %v", value-before)
+	}
+}
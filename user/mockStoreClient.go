@@ -100,6 +100,36 @@ func (d MockStoreClient) FindUsersWithIds(ctx context.Context, ids []string) (fo
 	return users, nil
 }
 
+func (d MockStoreClient) SearchUsers(ctx context.Context, search *UserSearch) ([]*User, string, error) {
+	if d.doBad {
+		return nil, "", errors.New("SearchUsers failure")
+	}
+	users, _ := d.FindUsersWithIds(ctx, search.IDs)
+	return search.Apply(users)
+}
+
+func (d MockStoreClient) ExportUsers(ctx context.Context, search *UserSearch, fn func(*User) error) error {
+	if d.doBad {
+		return errors.New("ExportUsers failure")
+	}
+	users, _ := d.FindUsersWithIds(ctx, search.IDs)
+	for _, user := range users {
+		if search.Match(user) {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d MockStoreClient) InsertUsers(ctx context.Context, users []*User) error {
+	if d.doBad {
+		return errors.New("InsertUsers failure")
+	}
+	return nil
+}
+
 func (d MockStoreClient) FindUser(ctx context.Context, user *User) (found *User, err error) {
 
 	if d.doBad {
@@ -128,9 +158,19 @@ func (d MockStoreClient) FindUser(ctx context.Context, user *User) (found *User,
 		return found, nil
 	}
 	user.EmailVerified = true
+	if err := user.HashPassword(password, d.salt); err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
+func (d MockStoreClient) ReplaceUser(ctx context.Context, user *User) error {
+	if d.doBad {
+		return errors.New("ReplaceUser failure")
+	}
+	return nil
+}
+
 func (d MockStoreClient) RemoveUser(ctx context.Context, user *User) error {
 	if d.doBad {
 		return errors.New("RemoveUser failure")
@@ -153,9 +193,80 @@ func (d MockStoreClient) FindTokenByID(ctx context.Context, id string) (*token.S
 	return nil, nil
 }
 
+func (d MockStoreClient) FindTokensByUserID(ctx context.Context, userID string) ([]*token.SessionToken, error) {
+	if d.doBad {
+		return nil, errors.New("FindTokensByUserID failure")
+	}
+	return []*token.SessionToken{}, nil
+}
+
+func (d MockStoreClient) RemoveTokensByUserID(ctx context.Context, userID string) error {
+	if d.doBad {
+		return errors.New("RemoveTokensByUserID failure")
+	}
+	return nil
+}
+
 func (d MockStoreClient) RemoveTokenByID(ctx context.Context, id string) error {
 	if d.doBad {
 		return errors.New("RemoveTokenByID failure")
 	}
 	return nil
 }
+
+func (d MockStoreClient) UpsertOrganization(ctx context.Context, organization *Organization) error {
+	if d.doBad {
+		return errors.New("UpsertOrganization failure")
+	}
+	return nil
+}
+
+func (d MockStoreClient) FindOrganization(ctx context.Context, id string) (*Organization, error) {
+	if d.doBad {
+		return nil, errors.New("FindOrganization failure")
+	}
+	//`find` a pretend one we just made
+	return &Organization{Id: id, Name: "Clinic"}, nil
+}
+
+func (d MockStoreClient) FindOrganizations(ctx context.Context) ([]*Organization, error) {
+	if d.doBad {
+		return nil, errors.New("FindOrganizations failure")
+	}
+	return []*Organization{}, nil
+}
+
+func (d MockStoreClient) RemoveOrganization(ctx context.Context, id string) error {
+	if d.doBad {
+		return errors.New("RemoveOrganization failure")
+	}
+	return nil
+}
+
+func (d MockStoreClient) FindUsersByOrganization(ctx context.Context, organizationID string) ([]*User, error) {
+	if d.doBad {
+		return nil, errors.New("FindUsersByOrganization failure")
+	}
+	return []*User{}, nil
+}
+
+func (d MockStoreClient) UpsertOrganizationMember(ctx context.Context, userID string, member *OrganizationMember) error {
+	if d.doBad {
+		return errors.New("UpsertOrganizationMember failure")
+	}
+	return nil
+}
+
+func (d MockStoreClient) RemoveOrganizationMember(ctx context.Context, userID string, organizationID string) error {
+	if d.doBad {
+		return errors.New("RemoveOrganizationMember failure")
+	}
+	return nil
+}
+
+func (d MockStoreClient) AddUserConsent(ctx context.Context, userID string, consent *Consent) error {
+	if d.doBad {
+		return errors.New("AddUserConsent failure")
+	}
+	return nil
+}
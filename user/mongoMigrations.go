@@ -0,0 +1,287 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mdblp/shoreline/audit"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MIGRATIONS_COLLECTION keeps the versions of the migrations applied to the collections of a tenant
+const MIGRATIONS_COLLECTION = "schema_migrations"
+
+// mongoMigration is a change of the collections, applied once in order of version:
+// once deployed, a migration must not be changed. As the instances starting together may apply
+// the same migrations, they must be idempotent.
+type mongoMigration struct {
+	version     int
+	description string
+	apply       func(ctx context.Context, c *Client) error
+	// check returns the problems the migration would meet, for the dry runs
+	check func(ctx context.Context, c *Client) ([]string, error)
+}
+
+// PendingMigration is a migration which has not been applied yet, with the problems it would meet
+type PendingMigration struct {
+	Version     int
+	Description string
+	Warnings    []string
+}
+
+// migrationRecord is the document of an applied migration
+type migrationRecord struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedTime time.Time `bson:"appliedTime"`
+}
+
+// usernameCollation compares the usernames regardless of their case, as FindUsers does
+var usernameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+var mongoMigrations = []mongoMigration{
+	{
+		version:     1,
+		description: "create the indexes of the users",
+		apply: func(ctx context.Context, c *Client) error {
+			_, err := mgoUsersCollection(c).Indexes().CreateMany(ctx, usersIndexes)
+			return err
+		},
+		check: checkDuplicateUserIDs,
+	},
+	{
+		version:     2,
+		description: "make the usernames unique regardless of their case",
+		apply: func(ctx context.Context, c *Client) error {
+			_, err := mgoUsersCollection(c).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "username", Value: 1}},
+				Options: options.Index().
+					SetName("username_unique").
+					SetUnique(true).
+					SetCollation(usernameCollation).
+					SetPartialFilterExpression(bson.M{"username": bson.M{"$exists": true}}),
+			})
+			return err
+		},
+		check: checkDuplicateUsernames,
+	},
+	{
+		version:     3,
+		description: "expire the tokens with a TTL index on their expireTime",
+		apply: func(ctx context.Context, c *Client) error {
+			// the tokens added before have no expireTime, which is their expiresAt as a date
+			filter := bson.M{"expireTime": bson.M{"$exists": false}, "expiresAt": bson.M{"$gt": 0}}
+			update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+				"expireTime": bson.M{"$toDate": bson.M{"$multiply": bson.A{"$expiresAt", 1000}}},
+			}}}}
+			if _, err := mgoTokensCollection(c).UpdateMany(ctx, filter, update); err != nil {
+				return err
+			}
+			_, err := mgoTokensCollection(c).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "expireTime", Value: 1}},
+				Options: options.Index().SetName("expireTime_ttl").SetExpireAfterSeconds(0),
+			})
+			return err
+		},
+	},
+	{
+		version:     4,
+		description: "create the indexes of the audit events",
+		apply: func(ctx context.Context, c *Client) error {
+			// the mongo audit sink writes the events of all the tenants to the default database
+			if c.database != "" || c.collectionPrefix != "" {
+				return nil
+			}
+			_, err := c.Collection(audit.AUDIT_COLLECTION).Indexes().CreateMany(ctx, auditIndexes)
+			return err
+		},
+	},
+	{
+		version:     5,
+		description: "make the emails unique regardless of their case",
+		apply: func(ctx context.Context, c *Client) error {
+			_, err := mgoUsersCollection(c).Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{{Key: "emails", Value: 1}},
+				Options: options.Index().
+					SetName("emails_unique").
+					SetUnique(true).
+					SetCollation(usernameCollation).
+					// the users without emails (e.g. custodial) have an empty list or none
+					SetPartialFilterExpression(bson.M{"emails": bson.M{"$type": "string"}}),
+			})
+			return err
+		},
+		check: checkDuplicateEmails,
+	},
+}
+
+// auditIndexes support the audit queries (see audit.MongoSink.Find), always filtered by tenant and sorted by time
+var auditIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "targetUserId", Value: 1}, {Key: "time", Value: 1}, {Key: "_id", Value: 1}}},
+	{Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "actorId", Value: 1}, {Key: "time", Value: 1}, {Key: "_id", Value: 1}}},
+	{Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "organizationId", Value: 1}, {Key: "time", Value: 1}, {Key: "_id", Value: 1}}},
+	{Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "action", Value: 1}, {Key: "time", Value: 1}, {Key: "_id", Value: 1}}},
+	{Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "time", Value: 1}, {Key: "_id", Value: 1}}},
+}
+
+// checkDuplicateUserIDs lists the user ids of several users, which prevent the unique index
+func checkDuplicateUserIDs(ctx context.Context, c *Client) ([]string, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userid": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$userid", "usernames": bson.M{"$push": "$username"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := mgoUsersCollection(c).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	warnings := []string{}
+	for cursor.Next(ctx) {
+		var duplicate struct {
+			UserID    string   `bson:"_id"`
+			Usernames []string `bson:"usernames"`
+		}
+		if err := cursor.Decode(&duplicate); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, fmt.Sprintf("userid %q is taken by the users %v", duplicate.UserID, duplicate.Usernames))
+	}
+	return warnings, cursor.Err()
+}
+
+// checkDuplicateUsernames lists the usernames taken by several users, which prevent the unique index
+func checkDuplicateUsernames(ctx context.Context, c *Client) ([]string, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"username": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$username", "userids": bson.M{"$push": "$userid"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := mgoUsersCollection(c).Aggregate(ctx, pipeline, options.Aggregate().SetCollation(usernameCollation))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	warnings := []string{}
+	for cursor.Next(ctx) {
+		var duplicate struct {
+			Username string   `bson:"_id"`
+			UserIDs  []string `bson:"userids"`
+		}
+		if err := cursor.Decode(&duplicate); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, fmt.Sprintf("username %q is taken by the users %v", duplicate.Username, duplicate.UserIDs))
+	}
+	return warnings, cursor.Err()
+}
+
+// checkDuplicateEmails lists the emails of several users, which prevent the unique index
+func checkDuplicateEmails(ctx context.Context, c *Client) ([]string, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$emails"}},
+		{{Key: "$group", Value: bson.M{"_id": "$emails", "userids": bson.M{"$addToSet": "$userid"}}}},
+		{{Key: "$match", Value: bson.M{"userids.1": bson.M{"$exists": true}}}},
+	}
+	cursor, err := mgoUsersCollection(c).Aggregate(ctx, pipeline, options.Aggregate().SetCollation(usernameCollation))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	warnings := []string{}
+	for cursor.Next(ctx) {
+		var duplicate struct {
+			Email   string   `bson:"_id"`
+			UserIDs []string `bson:"userids"`
+		}
+		if err := cursor.Decode(&duplicate); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, fmt.Sprintf("email %q is taken by the users %v", duplicate.Email, duplicate.UserIDs))
+	}
+	return warnings, cursor.Err()
+}
+
+func mgoMigrationsCollection(c *Client) *mongo.Collection {
+	return c.collection(MIGRATIONS_COLLECTION)
+}
+
+// appliedMigrations returns the versions of the migrations already applied
+func (c *Client) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	cursor, err := mgoMigrationsCollection(c).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	applied := map[int]bool{}
+	for cursor.Next(ctx) {
+		var record migrationRecord
+		if err := cursor.Decode(&record); err != nil {
+			return nil, err
+		}
+		applied[record.Version] = true
+	}
+	return applied, cursor.Err()
+}
+
+// PendingMigrations returns the migrations which have not been applied yet, by version, without applying them
+func (c *Client) PendingMigrations(ctx context.Context) ([]PendingMigration, error) {
+	applied, err := c.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := []PendingMigration{}
+	for _, migration := range mongoMigrations {
+		if applied[migration.version] {
+			continue
+		}
+		pendingMigration := PendingMigration{Version: migration.version, Description: migration.description}
+		if migration.check != nil {
+			if pendingMigration.Warnings, err = migration.check(ctx, c); err != nil {
+				return nil, err
+			}
+		}
+		pending = append(pending, pendingMigration)
+	}
+	return pending, nil
+}
+
+// Migrate applies the migrations which have not been yet, once the store is started:
+// it creates the indexes of the collections and records the versions in MIGRATIONS_COLLECTION.
+// It stops at the first failed migration, which is applied again on the next start.
+func (c *Client) Migrate(ctx context.Context) error {
+	applied, err := c.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range mongoMigrations {
+		if applied[migration.version] {
+			continue
+		}
+		c.logger.WithField("version", migration.version).Infof("Applying the migration: %s", migration.description)
+		if err := migration.apply(ctx, c); err != nil {
+			return fmt.Errorf("migration %d failed: %v", migration.version, err)
+		}
+		record := migrationRecord{Version: migration.version, Description: migration.description, AppliedTime: time.Now().UTC()}
+		// another instance may have recorded it meanwhile
+		if _, err := mgoMigrationsCollection(c).InsertOne(ctx, record); err != nil && !isDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDuplicateKeyError tells whether the write failed on a unique index
+func isDuplicateKeyError(err error) bool {
+	if writeErr, ok := err.(mongo.WriteException); ok {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdblp/shoreline/token"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMongoMigrations_Versions(t *testing.T) {
+	for index, migration := range mongoMigrations {
+		if migration.version != index+1 {
+			t.Errorf("The migration %q should have the version %d, got %d", migration.description, index+1, migration.version)
+		}
+		if migration.apply == nil {
+			t.Errorf("The migration %d has nothing to apply", migration.version)
+		}
+	}
+}
+
+func TestMongoSessionToken_ExpireTime(t *testing.T) {
+	expireTime := time.Unix(1600000000, 0).UTC()
+	data, err := bson.Marshal(mongoSessionToken{
+		SessionToken: token.SessionToken{ID: "token", UserID: "1234", ExpiresAt: 1600000000},
+		ExpireTime:   &expireTime,
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal the token: %v", err)
+	}
+	document := bson.Raw(data)
+	if id, ok := document.Lookup("_id").StringValueOK(); !ok || id != "token" {
+		t.Errorf("The token fields should be inlined, got %v", document)
+	}
+	if date, ok := document.Lookup("expireTime").DateTimeOK(); !ok || date != 1600000000000 {
+		t.Errorf("Expected the expireTime date, got %v", document)
+	}
+
+	var sessionToken token.SessionToken
+	if err := bson.Unmarshal(data, &sessionToken); err != nil || sessionToken.ExpiresAt != 1600000000 {
+		t.Errorf("The document should be read as a session token, got %v, %v", sessionToken, err)
+	}
+}
@@ -4,44 +4,85 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"regexp"
 	"sort"
+	"time"
 
 	"github.com/mdblp/shoreline/token"
+	"github.com/sirupsen/logrus"
 	goComMgo "github.com/tidepool-org/go-common/clients/mongo"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
-	USERS_COLLECTION  = "users"
-	TOKENS_COLLECTION = "tokens"
+	USERS_COLLECTION         = "users"
+	TOKENS_COLLECTION        = "tokens"
+	ORGANIZATIONS_COLLECTION = "organizations"
+	// EXPORT_BATCH_SIZE is the number of users fetched at once by the exports
+	EXPORT_BATCH_SIZE = 1000
 )
 
 // Client struct
 type Client struct {
 	*goComMgo.StoreClient
+	logger *logrus.Logger
+	// database and collectionPrefix of a tenant, see ForTenant
+	database         string
+	collectionPrefix string
 }
 
 // NewStore creates a new Client
-func NewStore(config *goComMgo.Config, logger *log.Logger) (*Client, error) {
-	client := Client{}
-	store, err := goComMgo.NewStoreClient(config, logger)
+func NewStore(config *goComMgo.Config, logger *logrus.Logger) (*Client, error) {
+	client := Client{logger: logger}
+	// go-common still expects a standard logger, its output goes through ours
+	storeLogger := log.New(logger.WriterLevel(logrus.InfoLevel), "", 0)
+	store, err := goComMgo.NewStoreClient(config, storeLogger)
 	client.StoreClient = store
 	return &client, err
 }
 
+// ForTenant returns a client sharing the connection, which uses the database
+// (the default one when empty) and the collection prefix of a tenant
+func (c *Client) ForTenant(database string, collectionPrefix string) *Client {
+	tenant := *c
+	tenant.database = database
+	tenant.collectionPrefix = collectionPrefix
+	return &tenant
+}
+
+func (c *Client) collection(name string) *mongo.Collection {
+	if c.database != "" {
+		return c.Collection(c.collectionPrefix+name, c.database)
+	}
+	return c.Collection(c.collectionPrefix + name)
+}
+
+// usersIndexes make the user ids unique (see InsertUsers) and support the user searches (see SearchUsers),
+// they are created by the first migration
+var usersIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "userid", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "roles", Value: 1}, {Key: "userid", Value: 1}}},
+	{Keys: bson.D{{Key: "emails", Value: 1}}},
+	{Keys: bson.D{{Key: "username", Value: 1}, {Key: "userid", Value: 1}}},
+	{Keys: bson.D{{Key: "createdTime", Value: 1}, {Key: "userid", Value: 1}}},
+	{Keys: bson.D{{Key: "modifiedTime", Value: 1}, {Key: "userid", Value: 1}}},
+}
+
 func mgoUsersCollection(c *Client) *mongo.Collection {
-	return c.Collection(USERS_COLLECTION)
+	return c.collection(USERS_COLLECTION)
 }
 
 func mgoTokensCollection(c *Client) *mongo.Collection {
-	return c.Collection(TOKENS_COLLECTION)
+	return c.collection(TOKENS_COLLECTION)
+}
+
+func mgoOrganizationsCollection(c *Client) *mongo.Collection {
+	return c.collection(ORGANIZATIONS_COLLECTION)
 }
 
 func (c *Client) UpsertUser(ctx context.Context, user *User) error {
+	defer observeMongoOperation("UpsertUser", time.Now())
 	if user.Roles != nil {
 		sort.Strings(user.Roles)
 	}
@@ -52,11 +93,35 @@ func (c *Client) UpsertUser(ctx context.Context, user *User) error {
 	return err
 }
 
+func (c *Client) InsertUsers(ctx context.Context, users []*User) error {
+	defer observeMongoOperation("InsertUsers", time.Now())
+	documents := make([]interface{}, len(users))
+	for index, user := range users {
+		if user.Roles != nil {
+			sort.Strings(user.Roles)
+		}
+		documents[index] = user
+	}
+	// unordered, so that a failed user does not prevent the next ones from being inserted
+	_, err := mgoUsersCollection(c).InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	if bulkErr, ok := err.(mongo.BulkWriteException); ok && len(bulkErr.WriteErrors) > 0 {
+		insertErr := &InsertUsersError{Errors: map[int]error{}}
+		for _, writeErr := range bulkErr.WriteErrors {
+			insertErr.Errors[writeErr.Index] = writeErr.WriteError
+		}
+		return insertErr
+	}
+	return err
+}
+
 func (c *Client) FindUser(ctx context.Context, user *User) (result *User, err error) {
+	defer observeMongoOperation("FindUser", time.Now())
 
 	if user.Id != "" {
 		opts := options.FindOne()
-		if err = mgoUsersCollection(c).FindOne(ctx, bson.M{"userid": user.Id}, opts).Decode(&result); err != nil {
+		if err = mgoUsersCollection(c).FindOne(ctx, bson.M{"userid": user.Id}, opts).Decode(&result); err == mongo.ErrNoDocuments {
+			return nil, nil
+		} else if err != nil {
 			return result, err
 		}
 	}
@@ -64,72 +129,273 @@ func (c *Client) FindUser(ctx context.Context, user *User) (result *User, err er
 	return result, nil
 }
 
-func (c *Client) findUsers(ctx context.Context, filter interface{}, noResultMessage string) (results []*User, err error) {
-	cursor, err := mgoUsersCollection(c).Find(ctx, filter)
-	defer cursor.Close(ctx)
+func (c *Client) findUsers(ctx context.Context, filter interface{}, noResultMessage string, opts ...*options.FindOptions) (results []*User, err error) {
+	cursor, err := mgoUsersCollection(c).Find(ctx, filter, opts...)
 	if err != nil {
 		return results, err
 	}
+	defer cursor.Close(ctx)
 	err = cursor.All(ctx, &results)
 	if err != nil {
 		return results, err
 	}
 	if results == nil {
-		log.Print(noResultMessage)
+		c.logger.Debug(noResultMessage)
 		results = []*User{}
 	}
 
 	return results, nil
 }
 
+// FindUsers returns the users matching the id, the username or any of the emails.
+// The username is matched with the case-insensitive collation of the username_unique index, in its own query
+// as the other indexes have the default collation.
 func (c *Client) FindUsers(ctx context.Context, user *User) (results []*User, err error) {
+	defer observeMongoOperation("FindUsers", time.Now())
 
 	fieldsToMatch := []bson.M{}
 
 	if user.Id != "" {
 		fieldsToMatch = append(fieldsToMatch, bson.M{"userid": user.Id})
 	}
-	if user.Username != "" {
-		regexFilter := primitive.Regex{Pattern: fmt.Sprintf(`^%s$`, regexp.QuoteMeta(user.Username)), Options: "i"}
-		fieldsToMatch = append(fieldsToMatch, bson.M{"username": bson.M{"$regex": regexFilter}})
-	}
 	if len(user.Emails) > 0 {
 		fieldsToMatch = append(fieldsToMatch, bson.M{"emails": bson.M{"$in": user.Emails}})
 	}
 
-	if len(fieldsToMatch) == 0 {
-		return []*User{}, nil
+	results = []*User{}
+	if len(fieldsToMatch) > 0 {
+		noUserMessage := fmt.Sprintf("no users found: query: (Id = %v) OR (Emails IN %v)", user.Id, user.Emails)
+		if results, err = c.findUsers(ctx, bson.M{"$or": fieldsToMatch}, noUserMessage); err != nil {
+			return results, err
+		}
+	}
+	if user.Username != "" {
+		noUserMessage := fmt.Sprintf("no users found: query: Name ~= %v", user.Username)
+		byUsername, err := c.findUsers(ctx, bson.M{"username": user.Username}, noUserMessage, options.Find().SetCollation(usernameCollation))
+		if err != nil {
+			return results, err
+		}
+		for _, found := range byUsername {
+			duplicate := false
+			for _, result := range results {
+				duplicate = duplicate || result.Id == found.Id
+			}
+			if !duplicate {
+				results = append(results, found)
+			}
+		}
 	}
-	noUserMessage := fmt.Sprintf("no users found: query: (Id = %v) OR (Name ~= %v) OR (Emails IN %v)", user.Id, user.Username, user.Emails)
-	return c.findUsers(ctx, bson.M{"$or": fieldsToMatch}, noUserMessage)
+	return results, nil
 }
 
 func (c *Client) FindUsersByRole(ctx context.Context, role string) (results []*User, err error) {
+	defer observeMongoOperation("FindUsersByRole", time.Now())
 	noUserMessage := fmt.Sprintf("no users found: query: role: %v", role)
 	return c.findUsers(ctx, bson.M{"roles": role}, noUserMessage)
 }
 
 func (c *Client) FindUsersWithIds(ctx context.Context, ids []string) (results []*User, err error) {
+	defer observeMongoOperation("FindUsersWithIds", time.Now())
 	noUserMessage := fmt.Sprintf("no users found: query: id: %v", ids)
 	return c.findUsers(ctx, bson.M{"userid": bson.M{"$in": ids}}, noUserMessage)
 }
 
+// SearchUsers returns a page of the users matching the search, and the cursor of the next one
+func (c *Client) SearchUsers(ctx context.Context, search *UserSearch) ([]*User, string, error) {
+	defer observeMongoOperation("SearchUsers", time.Now())
+	after, err := search.after()
+	if err != nil {
+		return nil, "", err
+	}
+
+	filter := searchFilter(search)
+	if after != nil {
+		filter = bson.M{"$and": bson.A{filter, searchCursorFilter(search, after)}}
+	}
+
+	order := 1
+	if search.Descending {
+		order = -1
+	}
+	sortFields := bson.D{{Key: "userid", Value: order}}
+	if field := search.sortField(); field != "userid" {
+		sortFields = append(bson.D{{Key: field, Value: order}}, sortFields...)
+	}
+	limit := search.limit()
+	opts := searchFindOptions(search).SetSort(sortFields).SetLimit(int64(limit + 1))
+
+	cursor, err := mgoUsersCollection(c).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+	results := []*User{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if len(results) > limit {
+		results = results[:limit]
+		next = search.encodeCursor(results[limit-1])
+	}
+	return results, next, nil
+}
+
+// searchFindOptions are the options of the searches, with the collation of the emails_unique index for the email prefix
+func searchFindOptions(search *UserSearch) *options.FindOptions {
+	opts := options.Find()
+	if search.EmailPrefix != "" {
+		opts.SetCollation(usernameCollation)
+	}
+	return opts
+}
+
+// searchFilter is the Mongo filter of the search criteria, regardless of the cursor
+func searchFilter(search *UserSearch) bson.M {
+	filter := bson.M{}
+	if search.Role != "" {
+		filter["roles"] = search.Role
+	}
+	if len(search.IDs) > 0 {
+		filter["userid"] = bson.M{"$in": search.IDs}
+	}
+	if search.EmailPrefix != "" {
+		// a range on the emails_unique index, compared with its collation (see searchFindOptions) regardless of
+		// the case: U+FFFF sorts after any character, and the $type matches the partial filter of the index
+		filter["emails"] = bson.M{"$type": "string", "$gte": search.EmailPrefix, "$lt": search.EmailPrefix + "\uffff"}
+	}
+	if search.EmailVerified != nil {
+		if *search.EmailVerified {
+			filter["authenticated"] = true
+		} else {
+			filter["authenticated"] = bson.M{"$ne": true}
+		}
+	}
+	if search.Deleted != nil {
+		if *search.Deleted {
+			filter["deletedTime"] = bson.M{"$nin": bson.A{nil, ""}}
+		} else {
+			filter["deletedTime"] = bson.M{"$in": bson.A{nil, ""}}
+		}
+	}
+	if timeFilter := searchTimeFilter(search.CreatedFrom, search.CreatedTo); len(timeFilter) > 0 {
+		filter["createdTime"] = timeFilter
+	}
+	if timeFilter := searchTimeFilter(search.ModifiedFrom, search.ModifiedTo); len(timeFilter) > 0 {
+		filter["modifiedTime"] = timeFilter
+	}
+	if !search.ModifiedSince.IsZero() {
+		since := bson.M{"$gte": searchTime(search.ModifiedSince)}
+		filter["$or"] = bson.A{bson.M{"createdTime": since}, bson.M{"modifiedTime": since}, bson.M{"deletedTime": since}}
+	}
+	return filter
+}
+
+// ExportUsers calls fn for each user matching the search criteria, in no particular order, without loading them all in memory.
+// The cursor, limit and sort of the search are ignored.
+func (c *Client) ExportUsers(ctx context.Context, search *UserSearch, fn func(*User) error) error {
+	defer observeMongoOperation("ExportUsers", time.Now())
+	cursor, err := mgoUsersCollection(c).Find(ctx, searchFilter(search), searchFindOptions(search).SetBatchSize(EXPORT_BATCH_SIZE))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		user := &User{}
+		if err := cursor.Decode(user); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func searchTimeFilter(from time.Time, to time.Time) bson.M {
+	timeFilter := bson.M{}
+	if !from.IsZero() {
+		timeFilter["$gte"] = searchTime(from)
+	}
+	if !to.IsZero() {
+		timeFilter["$lt"] = searchTime(to)
+	}
+	return timeFilter
+}
+
+// searchCursorFilter selects the users after the cursor in the search order.
+// The users without a value for the sort field come first in ascending order, last in descending order.
+func searchCursorFilter(search *UserSearch, after *searchCursor) bson.M {
+	field := search.sortField()
+	next := "$gt"
+	if search.Descending {
+		next = "$lt"
+	}
+	if field == "userid" {
+		return bson.M{"userid": bson.M{next: after.ID}}
+	}
+	noValue := bson.M{"$in": bson.A{nil, ""}}
+	switch {
+	case after.Value == "" && search.Descending:
+		return bson.M{field: noValue, "userid": bson.M{next: after.ID}}
+	case after.Value == "":
+		return bson.M{"$or": bson.A{
+			bson.M{field: bson.M{"$gt": ""}},
+			bson.M{field: noValue, "userid": bson.M{next: after.ID}},
+		}}
+	case search.Descending:
+		return bson.M{"$or": bson.A{
+			bson.M{field: bson.M{"$lt": after.Value}},
+			bson.M{field: noValue},
+			bson.M{field: after.Value, "userid": bson.M{next: after.ID}},
+		}}
+	default:
+		return bson.M{"$or": bson.A{
+			bson.M{field: bson.M{"$gt": after.Value}},
+			bson.M{field: after.Value, "userid": bson.M{next: after.ID}},
+		}}
+	}
+}
+
+func (c *Client) ReplaceUser(ctx context.Context, user *User) error {
+	defer observeMongoOperation("ReplaceUser", time.Now())
+	if user.Roles != nil {
+		sort.Strings(user.Roles)
+	}
+	_, err := mgoUsersCollection(c).ReplaceOne(ctx, bson.M{"userid": user.Id}, user)
+	return err
+}
+
 func (c *Client) RemoveUser(ctx context.Context, user *User) (err error) {
+	defer observeMongoOperation("RemoveUser", time.Now())
 	if _, err := mgoUsersCollection(c).DeleteOne(ctx, bson.M{"userid": user.Id}); err != nil {
 		return err
 	}
 	return nil
 }
 
+// mongoSessionToken is the document of a token, with the expiration date of the TTL index (see mongoMigrations)
+type mongoSessionToken struct {
+	token.SessionToken `bson:",inline"`
+	ExpireTime         *time.Time `bson:"expireTime,omitempty"`
+}
+
 func (c *Client) AddToken(ctx context.Context, st *token.SessionToken) error {
+	defer observeMongoOperation("AddToken", time.Now())
 	options := options.Update().SetUpsert(true)
-	update := bson.M{"$set": st}
+	document := mongoSessionToken{SessionToken: *st}
+	if st.ExpiresAt > 0 {
+		expireTime := time.Unix(st.ExpiresAt, 0).UTC()
+		document.ExpireTime = &expireTime
+	}
+	update := bson.M{"$set": document}
 	// if the user already exists we update otherwise we add
 	_, err := mgoTokensCollection(c).UpdateOne(ctx, bson.M{"_id": st.ID}, update, options)
 	return err
 }
 
 func (c *Client) FindTokenByID(ctx context.Context, id string) (*token.SessionToken, error) {
+	defer observeMongoOperation("FindTokenByID", time.Now())
 	opts := options.FindOne()
 	sessionToken := &token.SessionToken{}
 	if err := mgoTokensCollection(c).FindOne(ctx, bson.M{"_id": id}, opts).Decode(sessionToken); err != nil {
@@ -138,9 +404,115 @@ func (c *Client) FindTokenByID(ctx context.Context, id string) (*token.SessionTo
 	return sessionToken, nil
 }
 
+func (c *Client) FindTokensByUserID(ctx context.Context, userID string) ([]*token.SessionToken, error) {
+	defer observeMongoOperation("FindTokensByUserID", time.Now())
+	filter := bson.M{"userId": userID, "expiresAt": bson.M{"$gt": time.Now().Unix()}}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := mgoTokensCollection(c).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	sessionTokens := []*token.SessionToken{}
+	if err := cursor.All(ctx, &sessionTokens); err != nil {
+		return nil, err
+	}
+	return sessionTokens, nil
+}
+
+func (c *Client) RemoveTokensByUserID(ctx context.Context, userID string) error {
+	defer observeMongoOperation("RemoveTokensByUserID", time.Now())
+	_, err := mgoTokensCollection(c).DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
+
 func (c *Client) RemoveTokenByID(ctx context.Context, id string) (err error) {
+	defer observeMongoOperation("RemoveTokenByID", time.Now())
 	if _, err := mgoTokensCollection(c).DeleteOne(ctx, bson.M{"_id": id}); err != nil {
 		return err
 	}
 	return nil
 }
+
+func (c *Client) UpsertOrganization(ctx context.Context, organization *Organization) error {
+	defer observeMongoOperation("UpsertOrganization", time.Now())
+	options := options.Update().SetUpsert(true)
+	update := bson.M{"$set": organization}
+	_, err := mgoOrganizationsCollection(c).UpdateOne(ctx, bson.M{"id": organization.Id}, update, options)
+	return err
+}
+
+func (c *Client) FindOrganization(ctx context.Context, id string) (*Organization, error) {
+	defer observeMongoOperation("FindOrganization", time.Now())
+	organization := &Organization{}
+	if err := mgoOrganizationsCollection(c).FindOne(ctx, bson.M{"id": id}).Decode(organization); err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+func (c *Client) FindOrganizations(ctx context.Context) (results []*Organization, err error) {
+	defer observeMongoOperation("FindOrganizations", time.Now())
+	cursor, err := mgoOrganizationsCollection(c).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	if results == nil {
+		results = []*Organization{}
+	}
+	return results, nil
+}
+
+func (c *Client) RemoveOrganization(ctx context.Context, id string) error {
+	defer observeMongoOperation("RemoveOrganization", time.Now())
+	// the memberships are removed first, so that a failure leaves the organization to retry with
+	filter := bson.M{"organizations.organizationId": id}
+	update := bson.M{"$pull": bson.M{"organizations": bson.M{"organizationId": id}}}
+	if _, err := mgoUsersCollection(c).UpdateMany(ctx, filter, update); err != nil {
+		return err
+	}
+	_, err := mgoOrganizationsCollection(c).DeleteOne(ctx, bson.M{"id": id})
+	return err
+}
+
+func (c *Client) FindUsersByOrganization(ctx context.Context, organizationID string) (results []*User, err error) {
+	defer observeMongoOperation("FindUsersByOrganization", time.Now())
+	noUserMessage := fmt.Sprintf("no users found: query: organization: %v", organizationID)
+	return c.findUsers(ctx, bson.M{"organizations.organizationId": organizationID}, noUserMessage)
+}
+
+func (c *Client) UpsertOrganizationMember(ctx context.Context, userID string, member *OrganizationMember) error {
+	defer observeMongoOperation("UpsertOrganizationMember", time.Now())
+	// update the role of an existing membership
+	filter := bson.M{"userid": userID, "organizations.organizationId": member.OrganizationID}
+	update := bson.M{"$set": bson.M{"organizations.$.role": member.Role}}
+	result, err := mgoUsersCollection(c).UpdateOne(ctx, filter, update)
+	if err != nil || result.MatchedCount > 0 {
+		return err
+	}
+	// or add a new one
+	filter = bson.M{"userid": userID, "organizations.organizationId": bson.M{"$ne": member.OrganizationID}}
+	update = bson.M{"$push": bson.M{"organizations": member}}
+	_, err = mgoUsersCollection(c).UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (c *Client) RemoveOrganizationMember(ctx context.Context, userID string, organizationID string) error {
+	defer observeMongoOperation("RemoveOrganizationMember", time.Now())
+	update := bson.M{"$pull": bson.M{"organizations": bson.M{"organizationId": organizationID}}}
+	_, err := mgoUsersCollection(c).UpdateOne(ctx, bson.M{"userid": userID}, update)
+	return err
+}
+
+func (c *Client) AddUserConsent(ctx context.Context, userID string, consent *Consent) error {
+	defer observeMongoOperation("AddUserConsent", time.Now())
+	update := bson.M{"$push": bson.M{"consents": consent}}
+	_, err := mgoUsersCollection(c).UpdateOne(ctx, bson.M{"userid": userID}, update)
+	return err
+}
@@ -2,13 +2,13 @@ package user
 
 import (
 	"context"
-	"log"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/mdblp/shoreline/token"
+	"github.com/sirupsen/logrus"
 	"github.com/tidepool-org/go-common/clients/mongo"
 )
 
@@ -24,7 +24,7 @@ func mgoTestSetup() (*Client, error) {
 		// if mongo connexion information is provided via env var
 		testingConfig.FromEnv()
 	}
-	var logger = log.New(os.Stdout, "mongo-test ", log.LstdFlags|log.LUTC|log.Lshortfile)
+	var logger = logrus.New()
 
 	mc, _ := NewStore(testingConfig, logger)
 	mc.Start()
@@ -32,6 +32,7 @@ func mgoTestSetup() (*Client, error) {
 
 	//just drop and don't worry about any errors
 	mgoUsersCollection(mc).Drop(context.TODO())
+	mgoMigrationsCollection(mc).Drop(context.TODO())
 
 	return mc, nil
 }
@@ -264,6 +265,65 @@ func TestMongoStore_FindUsersById(t *testing.T) {
 	}
 }
 
+func TestMongoStore_SearchUsers(t *testing.T) {
+	ctx := context.Background()
+	mc, _ := mgoTestSetup()
+	for _, user := range searchTestUsers() {
+		if err := mc.UpsertUser(ctx, user); err != nil {
+			t.Fatalf("we could not upsert the user %v", err)
+		}
+	}
+	if err := mc.Migrate(ctx); err != nil {
+		t.Fatalf("we could not migrate the collections %v", err)
+	}
+
+	yes, no := true, false
+	searches := []UserSearch{
+		{Role: "hcp"},
+		{EmailPrefix: "a.HCP@"},
+		{EmailVerified: &no},
+		{Deleted: &yes, EmailVerified: &yes},
+		{CreatedFrom: time.Date(2021, 2, 1, 10, 0, 0, 0, time.UTC), CreatedTo: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, sort := range []string{"userid", "username", "createdTime", "modifiedTime"} {
+		for _, descending := range []bool{false, true} {
+			searches = append(searches, UserSearch{Sort: sort, Descending: descending, Limit: 2})
+		}
+	}
+
+	// the results must be the same as the ones of the in memory search
+	for _, search := range searches {
+		for page := 0; page < 3; page++ {
+			expected, expectedNext, _ := search.Apply(searchTestUsers())
+			found, next, err := mc.SearchUsers(ctx, &search)
+			if err != nil {
+				t.Fatalf("we could not search the users %#v: %v", search, err)
+			}
+			expectUserIDs(t, found, userIDs(expected)...)
+			if next != expectedNext {
+				t.Fatalf("unexpected cursor %q for %#v", next, search)
+			}
+			if next == "" {
+				break
+			}
+			search.Cursor = next
+		}
+	}
+
+	exported := []*User{}
+	export := &UserSearch{ModifiedSince: time.Date(2021, 2, 15, 0, 0, 0, 0, time.UTC)}
+	if err := mc.ExportUsers(ctx, export, func(user *User) error {
+		exported = append(exported, user)
+		return nil
+	}); err != nil {
+		t.Fatalf("we could not export the users %v", err)
+	}
+	expected, _, _ := export.Apply(searchTestUsers())
+	if len(exported) != len(expected) {
+		t.Fatalf("unexpected exported users %v", userIDs(exported))
+	}
+}
+
 func TestMongoStoreTokenOperations(t *testing.T) {
 
 	testing_token_data := &token.TokenData{UserId: "2341", IsServer: true, DurationSecs: 3600}
@@ -298,6 +358,22 @@ func TestMongoStoreTokenOperations(t *testing.T) {
 		t.Fatalf("no token was returned when it should have been - err[%v]", err)
 	}
 
+	userToken, _ := token.CreateSessionToken(&token.TokenData{UserId: "1234", DurationSecs: 3600}, testing_token_config)
+	if err := mc.AddToken(ctx, userToken); err != nil {
+		t.Fatalf("we could not save the token %v", err)
+	}
+	if found, err := mc.FindTokensByUserID(ctx, "1234"); err != nil {
+		t.Fatalf("error finding the tokens of the user %v", err)
+	} else if len(found) != 1 || found[0].ID != userToken.ID {
+		t.Fatalf("should find the token of the user but found %v", found)
+	}
+	if err := mc.RemoveTokensByUserID(ctx, "1234"); err != nil {
+		t.Fatalf("we could not remove the tokens of the user %v", err)
+	}
+	if found, err := mc.FindTokensByUserID(ctx, "1234"); err != nil || len(found) != 0 {
+		t.Fatalf("the tokens of the user have been removed but found %v %v", found, err)
+	}
+
 	if err := mc.RemoveTokenByID(ctx, sessionToken.ID); err != nil {
 		t.Fatalf("we could not remove the token %v", err)
 	}
@@ -309,3 +385,85 @@ func TestMongoStoreTokenOperations(t *testing.T) {
 	}
 
 }
+
+func TestMongoStoreOrganizationOperations(t *testing.T) {
+	ctx := context.Background()
+	mc, _ := mgoTestSetup()
+	mgoOrganizationsCollection(mc).Drop(ctx)
+
+	name := "Clinic"
+	organization, err := NewOrganization(&OrganizationDetails{Name: &name})
+	if err != nil {
+		t.Fatalf("we could not create the organization %v", err)
+	}
+	if err := mc.UpsertOrganization(ctx, organization); err != nil {
+		t.Fatalf("we could not upsert the organization %v", err)
+	}
+	if found, err := mc.FindOrganization(ctx, organization.Id); err != nil || found == nil || found.Name != name {
+		t.Fatalf("we could not find the organization %v %v", found, err)
+	}
+	if found, err := mc.FindOrganization(ctx, "unknown"); err != nil || found != nil {
+		t.Fatalf("no organization should be found %v %v", found, err)
+	}
+	if found, err := mc.FindOrganizations(ctx); err != nil || len(found) != 1 {
+		t.Fatalf("we could not find the organizations %v %v", found, err)
+	}
+
+	user := &User{Id: "0000000001", Username: "hcp@foo.bar", Roles: []string{"hcp"}}
+	if err := mc.UpsertUser(ctx, user); err != nil {
+		t.Fatalf("we could not upsert the user %v", err)
+	}
+	if err := mc.UpsertOrganizationMember(ctx, user.Id, &OrganizationMember{OrganizationID: organization.Id, Role: ORGANIZATION_ROLE_MEMBER}); err != nil {
+		t.Fatalf("we could not add the member %v", err)
+	}
+	if err := mc.UpsertOrganizationMember(ctx, user.Id, &OrganizationMember{OrganizationID: organization.Id, Role: ORGANIZATION_ROLE_ADMIN}); err != nil {
+		t.Fatalf("we could not update the member %v", err)
+	}
+	if found, err := mc.FindUsersByOrganization(ctx, organization.Id); err != nil || len(found) != 1 || len(found[0].Organizations) != 1 || found[0].OrganizationRole(organization.Id) != ORGANIZATION_ROLE_ADMIN {
+		t.Fatalf("we could not find the member %v %v", found, err)
+	}
+
+	if err := mc.RemoveOrganization(ctx, organization.Id); err != nil {
+		t.Fatalf("we could not remove the organization %v", err)
+	}
+	if found, err := mc.FindUser(ctx, user); err != nil || len(found.Organizations) != 0 {
+		t.Fatalf("the membership should have been removed %v %v", found, err)
+	}
+	if found, err := mc.FindOrganization(ctx, organization.Id); err != nil || found != nil {
+		t.Fatalf("the organization should have been removed %v %v", found, err)
+	}
+}
+
+func TestMongoStore_InsertUsers(t *testing.T) {
+	ctx := context.Background()
+	mc, err := mgoTestSetup()
+	if err != nil {
+		t.Fatalf("we initialise the test store %s", err.Error())
+	}
+
+	users := searchTestUsers()
+	if err := mc.InsertUsers(ctx, users); err != nil {
+		t.Fatalf("we could not insert the users %v", err)
+	}
+	found, err := mc.FindUsersWithIds(ctx, userIDs(users))
+	if err != nil {
+		t.Fatalf("error finding the inserted users %v", err)
+	} else if len(found) != len(users) {
+		t.Fatalf("should find the %d inserted users but found %v", len(users), found)
+	}
+
+	users[0].Anonymize("salt")
+	if err := mc.ReplaceUser(ctx, users[0]); err != nil {
+		t.Fatalf("we could not replace the user %v", err)
+	}
+	if found, err := mc.FindUser(ctx, &User{Id: users[0].Id}); err != nil || found.Username != users[0].Username || found.EmailVerified || found.CreatedTime != users[0].CreatedTime {
+		t.Fatalf("the user should have been replaced by its anonymized version but found %v %v", found, err)
+	}
+	consent := &Consent{Type: CONSENT_TERMS, Version: "2.0", AcceptedTime: "2021-01-01T10:00:00Z", IP: "127.0.0.1"}
+	if err := mc.AddUserConsent(ctx, users[1].Id, consent); err != nil {
+		t.Fatalf("we could not add the consent %v", err)
+	}
+	if found, err := mc.FindUser(ctx, &User{Id: users[1].Id}); err != nil || len(found.Consents) != 1 || found.Consents[0] != *consent {
+		t.Fatalf("the user should have the consent but found %v %v", found, err)
+	}
+}
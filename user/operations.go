@@ -0,0 +1,1051 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mdblp/shoreline/audit"
+	"github.com/mdblp/shoreline/schema"
+	"github.com/mdblp/shoreline/token"
+)
+
+// The operations hold the domain logic of the routes, shared by their handlers and the in-process UserClient.
+// They are given the request context (see requestContext) and the authenticated token data,
+// the handlers parse the requests and write the responses.
+
+// operationError is the failure of an operation, sent by the handlers with sendOperationError
+type operationError struct {
+	apiErr *apiError
+	extras []interface{}
+	caller string // where the operation failed, for the logs
+}
+
+func (e *operationError) Error() string {
+	messages := []string{e.apiErr.reason}
+	for _, extra := range e.extras {
+		messages = append(messages, fmt.Sprintf("%v", extra))
+	}
+	return strings.Join(messages, "; ")
+}
+
+func fail(apiErr *apiError, extras ...interface{}) *operationError {
+	return &operationError{apiErr: apiErr, extras: extras, caller: callerOf(1)}
+}
+
+func (a *Api) sessionTokenConfig() token.TokenConfig {
+	return token.TokenConfig{DurationSecs: a.ApiConfig.TokenDurationSecs, Secret: a.ApiConfig.Secret}
+}
+
+// createUser creates the user and its first session token, for the given duration (0 for the default one)
+func (a *Api) createUser(ctx context.Context, details *NewUserDetails, durationSecs int64) (*User, *token.SessionToken, *operationError) {
+	// Random sleep to avoid guessing accounts user.
+	time.Sleep(time.Millisecond * time.Duration(rand.Int63n(300)))
+
+	if err := details.Validate(); err != nil {
+		return nil, nil, fail(errInvalidUserDetails, err)
+	}
+	newUser, err := NewUser(details, a.ApiConfig.Salt)
+	if err != nil {
+		return nil, nil, fail(errCreatingUser, err)
+	}
+	if existingUser, err := a.Store.FindUsers(ctx, newUser); err != nil {
+		return nil, nil, fail(errCreatingUser, err)
+	} else if len(existingUser) != 0 {
+		return nil, nil, fail(errUserConflict, fmt.Sprintf("User '%s' already exists", *details.Username))
+	}
+	if err := a.Store.UpsertUser(ctx, newUser); err != nil {
+		return nil, nil, fail(errCreatingUser, err)
+	}
+
+	tokenData := token.TokenData{DurationSecs: durationSecs, UserId: newUser.Id, IsServer: false, Role: "unverified", TenantID: a.tenantID}
+	sessionToken, err := CreateSessionTokenAndSave(ctx, &tokenData, a.sessionTokenConfig(), a.Store)
+	if err != nil {
+		return nil, nil, fail(errGeneratingToken, err)
+	}
+	a.logAuditContext(ctx, nil, &audit.Event{Action: "CreateUser", TargetUserID: newUser.Id, Fields: details.fields(), Roles: newUser.Roles})
+	return newUser, sessionToken, nil
+}
+
+// login checks the credentials of the user (matched by id, username or email) and creates its session token.
+// The failed logins are counted against the user, which is locked after too many of them.
+func (a *Api) login(ctx context.Context, user *User, password string, durationSecs int64) (*User, *token.TokenData, *token.SessionToken, *operationError) {
+	if user == nil {
+		countLogin(LOGIN_USER, errMissingCredentials)
+		return nil, nil, nil, fail(errMissingCredentials)
+	}
+
+	// Random sleep to avoid guessing accounts user.
+	time.Sleep(time.Millisecond * time.Duration(rand.Int63n(100)))
+
+	code, elem := a.appendUserLoginInProgress(user)
+	defer a.removeUserLoginInProgress(elem)
+	if code != http.StatusOK {
+		countLogin(LOGIN_USER, errTooManyLogins)
+		return nil, nil, nil, fail(errTooManyLogins, fmt.Sprintf("User '%s' has too many ongoing login: %d", user.Username, a.loginLimiter.totalInProgress))
+	}
+
+	results, err := a.Store.FindUsers(ctx, user)
+	if err != nil {
+		countLogin(LOGIN_USER, errFindingUser)
+		return nil, nil, nil, fail(errFindingUser, STATUS_USER_NOT_FOUND, err)
+	} else if len(results) != 1 {
+		countLogin(LOGIN_USER, errInvalidCredentials)
+		return nil, nil, nil, fail(errInvalidCredentials, fmt.Sprintf("User '%s' have %d matching results", user.Username, len(results)))
+	}
+
+	result := results[0]
+	if result == nil {
+		countLogin(LOGIN_USER, errInvalidCredentials)
+		return nil, nil, nil, fail(errInvalidCredentials, fmt.Sprintf("User '%s' is nil", user.Username))
+
+	} else if result.IsDeleted() {
+		countLogin(LOGIN_USER, errInvalidCredentials)
+		return nil, nil, nil, fail(errInvalidCredentials, fmt.Sprintf("User '%s' is marked deleted", user.Username))
+
+	} else if !result.CanPerformALogin(a.ApiConfig.MaxFailedLogin) {
+		a.logAuditContext(ctx, nil, &audit.Event{Action: "Login", TargetUserID: result.Id, Outcome: audit.OutcomeFailure, Reason: "account locked"})
+		countLogin(LOGIN_USER, errAccountLocked)
+		return nil, nil, nil, fail(errAccountLocked.withDetails(map[string]interface{}{"nextLoginAttemptTime": result.FailedLogin.NextLoginAttemptTime}), fmt.Sprintf("User '%s' can't perform a login yet", user.Username))
+
+	} else if !result.PasswordsMatch(password, a.ApiConfig.Salt) {
+		// Limit login failed
+		if err := a.UpdateUserAfterFailedLogin(ctx, result); err != nil {
+			a.logContext(ctx).WithError(err).WithField("targetUserId", user.Id).Error("Failed to save failed login status")
+		}
+		a.logAuditContext(ctx, nil, &audit.Event{Action: "Login", TargetUserID: result.Id, Outcome: audit.OutcomeFailure, Reason: "wrong password"})
+		countLogin(LOGIN_USER, errInvalidCredentials)
+		return nil, nil, nil, fail(errInvalidCredentials, fmt.Sprintf("User '%s' passwords do not match", user.Username))
+
+	} else if !result.IsEmailVerified(a.ApiConfig.VerificationSecret) {
+		a.logAuditContext(ctx, nil, &audit.Event{Action: "Login", TargetUserID: result.Id, Outcome: audit.OutcomeFailure, Reason: "email not verified"})
+		countLogin(LOGIN_USER, errEmailNotVerified)
+		return nil, nil, nil, fail(errEmailNotVerified)
+	}
+
+	defer func() {
+		if err := a.UpdateUserAfterSuccessfulLogin(ctx, result); err != nil {
+			a.logContext(ctx).WithError(err).WithField("targetUserId", result.Id).Error("Failed to save success login status")
+		}
+	}()
+
+	// TODO: replace this workaround, there should be only one role when the data is cleaned up
+	role := "patient"
+	if result.Roles != nil && len(result.Roles) > 0 {
+		role = result.Roles[0]
+	}
+	tokenData := &token.TokenData{DurationSecs: durationSecs, UserId: result.Id, Email: result.Username, Name: result.DisplayName(), Locale: result.Profile.Locale(), Role: role, Organizations: result.OrganizationIDs(), TenantID: a.tenantID}
+	tokenData.ConsentRequired = len(result.MissingConsents(a.ApiConfig.RequiredConsents)) > 0
+	sessionToken, err := CreateSessionTokenAndSave(ctx, tokenData, a.sessionTokenConfig(), a.Store)
+	if err != nil {
+		countLogin(LOGIN_USER, errUpdatingToken)
+		return nil, nil, nil, fail(errUpdatingToken, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "Login", TargetUserID: result.Id})
+	countLogin(LOGIN_USER, nil)
+	return result, tokenData, sessionToken, nil
+}
+
+// longtermDuration returns the duration of the long-term session tokens, 0 when the key does not match
+func (a *Api) longtermDuration(ctx context.Context, longtermKey string) int64 {
+	const day_as_secs = 1 * 24 * 60 * 60
+
+	if longtermKey != a.ApiConfig.LongTermKey {
+		//tell us there was no match
+		a.logContext(ctx).Warn("tried to login using the longtermkey but it didn't match the stored key")
+		return 0
+	}
+	duration := a.ApiConfig.LongTermDaysDuration * day_as_secs
+	a.logContext(ctx).WithField("duration", fmt.Sprint(time.Duration(duration)*time.Second)).Debug("long term token requested")
+	return int64(duration)
+}
+
+// serverLogin creates a server token when the secret is the one of the server, or the default one
+func (a *Api) serverLogin(ctx context.Context, server, secret string, durationSecs int64) (*token.SessionToken, *operationError) {
+	// if server or password is not given we obviously have a problem
+	if server == "" || secret == "" {
+		countLogin(LOGIN_SERVER, errMissingCredentials)
+		return nil, fail(errMissingCredentials)
+	}
+
+	// What is the expected password for this specific requesting server?
+	expectedSecret := a.ApiConfig.ServerSecrets[server]
+
+	// Case specific to all Tidepool microservices that share the same secret
+	// This is done in order to maintain the current behaviour where Tidepool servers use the default password
+	// TODO: maintain a list of possible requesting micro-services?
+	if expectedSecret == "" {
+		expectedSecret = a.ApiConfig.ServerSecrets["default"]
+	}
+
+	// If no expected secret can be compared to, we have a problem and cannot continue
+	if expectedSecret == "" {
+		countLogin(LOGIN_SERVER, errNoExpectedPassword)
+		return nil, fail(errNoExpectedPassword)
+	}
+
+	// If the password given at the door is wrong, we cannot generate the token
+	if secret != expectedSecret {
+		a.logAuditContext(ctx, nil, &audit.Event{Action: "ServerLogin", ActorID: server, ActorType: audit.ActorServer, Outcome: audit.OutcomeFailure, Reason: "wrong secret"})
+		countLogin(LOGIN_SERVER, errServerSecretMismatch)
+		return nil, fail(errServerSecretMismatch)
+	}
+
+	sessionToken, err := CreateSessionTokenAndSave(ctx, &token.TokenData{DurationSecs: durationSecs, UserId: server, IsServer: true, TenantID: a.tenantID}, a.sessionTokenConfig(), a.Store)
+	if err != nil {
+		countLogin(LOGIN_SERVER, errGeneratingToken)
+		return nil, fail(errGeneratingToken, err)
+	}
+	countLogin(LOGIN_SERVER, nil)
+	a.logAuditContext(ctx, nil, &audit.Event{Action: "ServerLogin", ActorID: server, ActorType: audit.ActorServer})
+	return sessionToken, nil
+}
+
+// checkToken returns the data of a session token, for the holders of a server token
+func (a *Api) checkToken(ctx context.Context, serverToken, sessionToken string) (*token.TokenData, *operationError) {
+	if !hasServerToken(serverToken, a.ApiConfig.Secret, a.tenantID) {
+		return nil, fail(errServerTokenRequired)
+	}
+	tokenData, err := a.authenticateSessionToken(ctx, sessionToken)
+	if err != nil {
+		return nil, fail(errInvalidToken, err)
+	}
+	return tokenData, nil
+}
+
+// findUser returns the user matching the id, username or email, the user of the token when empty
+func (a *Api) findUser(ctx context.Context, tokenData *token.TokenData, userID string) (*User, *operationError) {
+	var user *User
+	if userID != "" {
+		user = &User{Id: userID, Username: userID, Emails: []string{userID}}
+	} else {
+		user = &User{Id: tokenData.UserId}
+	}
+
+	results, err := a.Store.FindUsers(ctx, user)
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	} else if len(results) == 0 {
+		return nil, fail(errUserNotFound)
+	} else if len(results) != 1 {
+		return nil, fail(errFindingUser, fmt.Sprintf("Found %d users matching %#v", len(results), user))
+	}
+
+	result := results[0]
+	if result == nil {
+		return nil, fail(errFindingUser, "Found user is nil")
+	} else if !a.isAuthorized(tokenData, result.Id) {
+		return nil, fail(errUnauthorized)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "GetUserInfo", TargetUserID: result.Id})
+	return result, nil
+}
+
+// searchUsers returns a page of the users matching the query, and the cursor of the next page
+func (a *Api) searchUsers(ctx context.Context, tokenData *token.TokenData, query url.Values) ([]*User, string, *operationError) {
+	if !tokenData.IsServer {
+		return nil, "", fail(errUnauthorized)
+	}
+	if len(query) == 0 {
+		return nil, "", fail(errNoQuery)
+	}
+
+	search, err := parseUserSearch(query)
+	if err == errUnknownSearchParameter {
+		return nil, "", fail(errUnknownParameter, err)
+	} else if err != nil {
+		return nil, "", fail(errInvalidQuery, err)
+	}
+	if search.Role != "" && !IsValidRole(search.Role) {
+		return nil, "", fail(errInvalidRole)
+	}
+
+	users, next, err := a.Store.SearchUsers(ctx, search)
+	if err == ErrInvalidSearchCursor {
+		return nil, "", fail(errInvalidQuery, err)
+	} else if err != nil {
+		return nil, "", fail(errFindingUser, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "GetUsers"})
+	return users, next, nil
+}
+
+// updateUser applies the validated details to the user, the user of the token when the id is empty
+func (a *Api) updateUser(ctx context.Context, tokenData *token.TokenData, userID string, details *UpdateUserDetails) (*User, *operationError) {
+	userID = firstStringNotEmpty(userID, tokenData.UserId)
+	if details.nFields < 1 {
+		return nil, fail(errEmptyUpdate, "Empty payload")
+	}
+
+	originalUser, err := a.Store.FindUser(ctx, &User{Id: userID})
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	} else if originalUser == nil {
+		return nil, fail(errUnauthorized, "User not found")
+	} else if !a.isAuthorized(tokenData, originalUser.Id) {
+		return nil, fail(errUnauthorized, "User does not have permissions")
+	} else if details.EmailVerified != nil && !tokenData.IsServer {
+		return nil, fail(errUnauthorized, "User does not have permissions")
+	}
+
+	if details.Password != nil && !tokenData.IsServer && (originalUser.HasRole("hcp") || originalUser.HasRole("caregiver")) {
+		// Caregiver & hcp: Must provide their current password to change it
+		// Patient password change is done differently
+		// Server token: Can perform the change
+		if details.CurrentPassword == nil {
+			return nil, fail(errUnauthorized, "Missing current password")
+		}
+		if !originalUser.PasswordsMatch(*details.CurrentPassword, a.ApiConfig.Salt) {
+			return nil, fail(errPasswordMismatch, "User does not have permissions", fmt.Errorf("User '%s' passwords do not match", originalUser.Username))
+		}
+	}
+
+	// Check role
+	if details.Roles != nil {
+		if len(details.Roles) != 1 {
+			return nil, fail(errInvalidUserDetails, errors.New("multiple roles were provided"))
+		}
+		if details.Roles[0] != originalUser.Roles[0] && (originalUser.Roles[0] == "patient" || originalUser.Roles[0] == "hcp") {
+			return nil, fail(errUnauthorized, errors.New("patients or HCPs cannot change role"))
+		}
+		if details.Roles[0] != originalUser.Roles[0] && details.Roles[0] != "hcp" {
+			return nil, fail(errForbidden, errors.New("caregivers cannot change role for something else than hcp"))
+		}
+	}
+
+	updatedUser := originalUser.DeepClone()
+	if details.Username != nil || details.Emails != nil {
+		dupCheck := &User{}
+		if details.Username != nil {
+			updatedUser.Username = *details.Username
+			dupCheck.Username = updatedUser.Username
+		}
+		if details.Emails != nil {
+			updatedUser.Emails = details.Emails
+			dupCheck.Emails = updatedUser.Emails
+		}
+
+		if results, err := a.Store.FindUsers(ctx, dupCheck); err != nil {
+			return nil, fail(errFindingUser, err)
+		} else if len(results) == 1 && results[0].Id != userID {
+			//only throw an error if there is a user with a different id but with the same username/email
+			return nil, fail(errUserAlreadyExists)
+		} else if len(results) > 1 {
+			return nil, fail(errUserAlreadyExists)
+		}
+	}
+
+	if details.Password != nil {
+		if err := updatedUser.HashPassword(*details.Password, a.ApiConfig.Salt); err != nil {
+			return nil, fail(errUpdatingUser, err)
+		}
+	}
+	if details.Roles != nil {
+		updatedUser.Roles = details.Roles
+	}
+	if details.TermsAccepted != nil {
+		updatedUser.TermsAccepted = *details.TermsAccepted
+	}
+	if details.EmailVerified != nil {
+		updatedUser.EmailVerified = *details.EmailVerified
+	}
+	if details.Profile != nil {
+		updatedUser.Profile = details.Profile.Apply(updatedUser.Profile)
+	}
+
+	updatedUser.ModifiedTime = time.Now().UTC().Format(time.RFC3339)
+	updatedUser.ModifiedUserID = tokenData.UserId
+	if err := a.Store.UpsertUser(ctx, updatedUser); err != nil {
+		return nil, fail(errUpdatingUser, err)
+	}
+	event := &audit.Event{Action: "UpdateUser", TargetUserID: updatedUser.Id, Fields: details.fields()}
+	if details.Roles != nil {
+		event.Roles, event.PreviousRoles = updatedUser.Roles, originalUser.Roles
+	}
+	a.logAuditContext(ctx, tokenData, event)
+	return updatedUser, nil
+}
+
+// deleteUser anonymizes the user and revokes its session tokens.
+// The servers give the id of the user, the users delete themselves with their password.
+// Deleting a deleted user does nothing.
+func (a *Api) deleteUser(ctx context.Context, tokenData *token.TokenData, userID, password string) *operationError {
+	id := tokenData.UserId
+	if tokenData.IsServer {
+		id = userID
+		a.logContext(ctx).Debug("operating as server")
+	}
+
+	if id == "" || password == "" {
+		return fail(errMissingPassword)
+	}
+
+	toDelete, err := a.Store.FindUser(ctx, &User{Id: id})
+	if err != nil {
+		return fail(errFindingUser, err)
+	} else if toDelete == nil {
+		return fail(errUserNotFound)
+	} else if toDelete.IsDeleted() {
+		// already anonymized, there is nothing left to delete
+		return nil
+	} else if !tokenData.IsServer && !toDelete.PasswordsMatch(password, a.ApiConfig.Salt) {
+		return fail(errPasswordMismatch, fmt.Errorf("User '%s' passwords do not match", toDelete.Id))
+	}
+
+	// the user is anonymized rather than removed, so that the other services and the audit trail still reference it
+	toDelete.Anonymize(a.ApiConfig.Salt)
+	toDelete.DeletedTime = time.Now().UTC().Format(time.RFC3339)
+	toDelete.DeletedUserID = tokenData.UserId
+	if err := a.Store.ReplaceUser(ctx, toDelete); err != nil {
+		return fail(errUpdatingUser, err)
+	}
+	if err := a.Store.RemoveTokensByUserID(ctx, toDelete.Id); err != nil {
+		return fail(errUpdatingToken, err)
+	}
+
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "AnonymizeUser", TargetUserID: toDelete.Id})
+	return nil
+}
+
+// refreshSession creates a new session token with the up to date user information
+func (a *Api) refreshSession(ctx context.Context, tokenData *token.TokenData, durationSecs int64) (*token.SessionToken, *operationError) {
+	// retrieve User in Db for having last information (role)
+	user, err := a.Store.FindUser(ctx, &User{Id: tokenData.UserId})
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	} else if user == nil {
+		return nil, fail(errUnauthorized, "User not found")
+	}
+
+	// Set Role
+	var role string
+	if user.Roles != nil && len(user.Roles) > 0 {
+		role = user.Roles[0]
+	}
+
+	//refresh token with update user information
+	newTokenData := token.TokenData{DurationSecs: durationSecs, UserId: user.Id, IsServer: false, Name: user.DisplayName(), Locale: user.Profile.Locale(), Role: role, Organizations: user.OrganizationIDs(), TenantID: a.tenantID}
+	newTokenData.ConsentRequired = len(user.MissingConsents(a.ApiConfig.RequiredConsents)) > 0
+	sessionToken, err := CreateSessionTokenAndSave(ctx, &newTokenData, a.sessionTokenConfig(), a.Store)
+	if err != nil {
+		return nil, fail(errGeneratingToken, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "RefreshSession", TargetUserID: user.Id})
+	return sessionToken, nil
+}
+
+// logout revokes the session token, it never fails
+func (a *Api) logout(ctx context.Context, sessionToken string) {
+	if sessionToken != "" {
+		if err := a.Store.RemoveTokenByID(ctx, sessionToken); err != nil {
+			//silently fail but still log it
+			a.logContext(ctx).WithError(err).Warn("Logout was unable to delete token")
+		}
+	}
+	a.logAuditContext(ctx, nil, &audit.Event{Action: "Logout"})
+}
+
+// externalTokenSecret returns the secret signing the tokens of a 3rd party service
+func (a *Api) externalTokenSecret(service string) (string, *operationError) {
+	if service == "" {
+		return "", fail(errUnknownParameter)
+	}
+	secret := a.ApiConfig.TokenSecrets[service]
+	if secret == "" {
+		// the secret is not defined for this service
+		return "", fail(errUnknownService, fmt.Sprintf("the service %q does not exist", service))
+	}
+	return secret, nil
+}
+
+// externalToken creates a token authenticating the user of the token data to a 3rd party service.
+// The token data gets the service as audience.
+func (a *Api) externalToken(ctx context.Context, tokenData *token.TokenData, service string) (*token.SessionToken, *operationError) {
+	secret, opErr := a.externalTokenSecret(service)
+	if opErr != nil {
+		return nil, opErr
+	}
+	tokenData.Audience = service
+	sessionToken, err := token.CreateSessionToken(tokenData, token.TokenConfig{DurationSecs: a.ApiConfig.TokenDurationSecs, Secret: secret})
+	if err != nil {
+		return nil, fail(errGeneratingToken, err)
+	}
+	countTokenIssued(TOKEN_EXTERNAL)
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "GenerateExternalToken", TargetUserID: tokenData.UserId})
+	return sessionToken, nil
+}
+
+// requireServer fails the operations reserved to the servers
+func requireServer(tokenData *token.TokenData) *operationError {
+	if !tokenData.IsServer {
+		return fail(errUnauthorized, "server token required")
+	}
+	return nil
+}
+
+// createCustodialUser creates a patient account without password on behalf of the hcp creatorID.
+// The hcp users create custodial accounts for themselves, the servers on their behalf.
+func (a *Api) createCustodialUser(ctx context.Context, tokenData *token.TokenData, creatorID string, details *NewCustodialUserDetails) (*User, *operationError) {
+	if !tokenData.IsServer && (tokenData.UserId != creatorID || tokenData.Role != "hcp") {
+		return nil, fail(errUnauthorized, "custodial users can only be created by hcp users")
+	}
+	// the servers give the creator, which must be an existing hcp
+	creator, err := a.Store.FindUser(ctx, &User{Id: creatorID})
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	} else if creator == nil || creator.IsDeleted() {
+		return nil, fail(errUserNotFound, "custodial user creator not found")
+	} else if !creator.HasRole("hcp") {
+		return nil, fail(errCreatorNotHcp)
+	}
+
+	newUser, err := NewCustodialUser(details, a.ApiConfig.Salt)
+	if err != nil {
+		return nil, fail(errInvalidUserDetails, err)
+	}
+	if newUser.Username != "" || len(newUser.Emails) > 0 {
+		if existingUsers, err := a.Store.FindUsers(ctx, newUser); err != nil {
+			return nil, fail(errCreatingUser, err)
+		} else if len(existingUsers) != 0 {
+			return nil, fail(errUserConflict, "custodial user already exists")
+		}
+	}
+
+	newUser.Roles = []string{"patient"}
+	newUser.CreatedTime = time.Now().UTC().Format(time.RFC3339)
+	newUser.CreatedUserID = creatorID
+	if err := a.Store.UpsertUser(ctx, newUser); err != nil {
+		return nil, fail(errCreatingUser, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "CreateCustodialUser", TargetUserID: newUser.Id, Fields: details.fields(), Roles: newUser.Roles})
+	return newUser, nil
+}
+
+// createClaimToken creates the invitation letting the patient take over the custodial account.
+// Only its creator, or a server, can get one.
+func (a *Api) createClaimToken(ctx context.Context, tokenData *token.TokenData, userID string) (string, *token.ClaimData, *operationError) {
+	custodialUser, err := a.Store.FindUser(ctx, &User{Id: userID})
+	if err != nil {
+		return "", nil, fail(errFindingUser, err)
+	} else if custodialUser == nil || custodialUser.IsDeleted() {
+		return "", nil, fail(errUserNotFound)
+	}
+	if !tokenData.IsServer && (custodialUser.CreatedUserID == "" || tokenData.UserId != custodialUser.CreatedUserID) {
+		return "", nil, fail(errUnauthorized, "only the creator of the custodial user can invite the patient")
+	}
+	if !custodialUser.IsCustodial() {
+		return "", nil, fail(errAlreadyClaimed)
+	}
+
+	claimData := &token.ClaimData{UserID: custodialUser.Id, CreatorID: custodialUser.CreatedUserID, TenantID: a.tenantID}
+	claimToken, err := a.claimToken(claimData)
+	if err != nil {
+		return "", nil, fail(errGeneratingToken, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "CreateClaimToken", TargetUserID: custodialUser.Id})
+	return claimToken, claimData, nil
+}
+
+// claimToken signs the claim data, its expiration time is set
+func (a *Api) claimToken(claimData *token.ClaimData) (string, error) {
+	tokenConfig := token.TokenConfig{DurationSecs: a.ApiConfig.ClaimTokenDurationSecs, Secret: a.ApiConfig.Secret}
+	return token.CreateClaimToken(claimData, tokenConfig)
+}
+
+// claimUser gives the custodial account of the claim token to the patient, with its email and password.
+// The email must be verified before the first login.
+func (a *Api) claimUser(ctx context.Context, details *ClaimUserDetails) (*User, *operationError) {
+	claimData, err := token.UnpackClaimTokenAndVerify(details.ClaimToken, a.ApiConfig.Secret)
+	if err != nil {
+		return nil, fail(errInvalidClaimToken, err)
+	} else if claimData.TenantID != a.tenantID {
+		return nil, fail(errInvalidClaimToken, "tenant mismatch")
+	}
+
+	custodialUser, err := a.Store.FindUser(ctx, &User{Id: claimData.UserID})
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	} else if custodialUser == nil || custodialUser.IsDeleted() {
+		return nil, fail(errUserNotFound)
+	}
+	// The link to the creator must not have changed since the invitation
+	if custodialUser.CreatedUserID != claimData.CreatorID {
+		return nil, fail(errInvalidClaimToken, "creator mismatch")
+	}
+	if !custodialUser.IsCustodial() {
+		return nil, fail(errAlreadyClaimed)
+	}
+
+	if existingUsers, err := a.Store.FindUsers(ctx, &User{Username: details.Email, Emails: []string{details.Email}}); err != nil {
+		return nil, fail(errFindingUser, err)
+	} else {
+		for _, existingUser := range existingUsers {
+			if existingUser.Id != custodialUser.Id {
+				return nil, fail(errUserAlreadyExists)
+			}
+		}
+	}
+
+	claimedUser := custodialUser.DeepClone()
+	claimedUser.Username = details.Email
+	claimedUser.Emails = []string{details.Email}
+	claimedUser.EmailVerified = false
+	if err := claimedUser.HashPassword(details.Password, a.ApiConfig.Salt); err != nil {
+		return nil, fail(errUpdatingUser, err)
+	}
+	claimedUser.ModifiedTime = time.Now().UTC().Format(time.RFC3339)
+	claimedUser.ModifiedUserID = claimedUser.Id
+	if err := a.Store.UpsertUser(ctx, claimedUser); err != nil {
+		return nil, fail(errUpdatingUser, err)
+	}
+	a.logAuditContext(ctx, nil, &audit.Event{Action: "ClaimUser", ActorType: audit.ActorUser, ActorID: claimedUser.Id, TargetUserID: claimedUser.Id, Fields: []string{"username", "emails", "password"}})
+	return claimedUser, nil
+}
+
+// findOrganization returns the organization, which must exist
+func (a *Api) findOrganization(ctx context.Context, organizationID string) (*Organization, *operationError) {
+	organization, err := a.Store.FindOrganization(ctx, organizationID)
+	if err != nil {
+		return nil, fail(errFindingOrganization, err)
+	} else if organization == nil {
+		return nil, fail(errOrganizationNotFound)
+	}
+	return organization, nil
+}
+
+// getOrganizations returns all the organizations, sorted by name
+func (a *Api) getOrganizations(ctx context.Context, tokenData *token.TokenData) ([]*Organization, *operationError) {
+	if opErr := requireServer(tokenData); opErr != nil {
+		return nil, opErr
+	}
+	organizations, err := a.Store.FindOrganizations(ctx)
+	if err != nil {
+		return nil, fail(errFindingOrganization, err)
+	}
+	return organizations, nil
+}
+
+// getOrganization returns an organization
+func (a *Api) getOrganization(ctx context.Context, tokenData *token.TokenData, organizationID string) (*Organization, *operationError) {
+	if opErr := requireServer(tokenData); opErr != nil {
+		return nil, opErr
+	}
+	return a.findOrganization(ctx, organizationID)
+}
+
+// createOrganization creates an organization from the validated details
+func (a *Api) createOrganization(ctx context.Context, tokenData *token.TokenData, details *OrganizationDetails) (*Organization, *operationError) {
+	if opErr := requireServer(tokenData); opErr != nil {
+		return nil, opErr
+	}
+	organization, err := NewOrganization(details)
+	if err != nil {
+		return nil, fail(errUpdatingOrganization, err)
+	}
+	organization.CreatedTime = time.Now().UTC().Format(time.RFC3339)
+	organization.CreatedUserID = tokenData.UserId
+	if err := a.Store.UpsertOrganization(ctx, organization); err != nil {
+		return nil, fail(errUpdatingOrganization, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "CreateOrganization", OrganizationID: organization.Id})
+	return organization, nil
+}
+
+// updateOrganization renames an organization with the validated details
+func (a *Api) updateOrganization(ctx context.Context, tokenData *token.TokenData, organizationID string, details *OrganizationDetails) (*Organization, *operationError) {
+	if opErr := requireServer(tokenData); opErr != nil {
+		return nil, opErr
+	}
+	organization, opErr := a.findOrganization(ctx, organizationID)
+	if opErr != nil {
+		return nil, opErr
+	}
+
+	organization.Name = strings.TrimSpace(*details.Name)
+	organization.ModifiedTime = time.Now().UTC().Format(time.RFC3339)
+	organization.ModifiedUserID = tokenData.UserId
+	if err := a.Store.UpsertOrganization(ctx, organization); err != nil {
+		return nil, fail(errUpdatingOrganization, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "UpdateOrganization", OrganizationID: organization.Id, Fields: []string{"name"}})
+	return organization, nil
+}
+
+// deleteOrganization deletes an organization and the memberships of its members
+func (a *Api) deleteOrganization(ctx context.Context, tokenData *token.TokenData, organizationID string) *operationError {
+	if opErr := requireServer(tokenData); opErr != nil {
+		return opErr
+	}
+	organization, opErr := a.findOrganization(ctx, organizationID)
+	if opErr != nil {
+		return opErr
+	}
+	if err := a.Store.RemoveOrganization(ctx, organization.Id); err != nil {
+		return fail(errUpdatingOrganization, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "DeleteOrganization", OrganizationID: organization.Id})
+	return nil
+}
+
+// getOrganizationMembers returns the members of an organization with their role, the deleted users excluded
+func (a *Api) getOrganizationMembers(ctx context.Context, tokenData *token.TokenData, organizationID string) ([]OrganizationMemberInfo, *operationError) {
+	if opErr := requireServer(tokenData); opErr != nil {
+		return nil, opErr
+	}
+	organization, opErr := a.findOrganization(ctx, organizationID)
+	if opErr != nil {
+		return nil, opErr
+	}
+	users, err := a.Store.FindUsersByOrganization(ctx, organization.Id)
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	}
+
+	members := make([]OrganizationMemberInfo, 0, len(users))
+	for _, user := range users {
+		if !user.IsDeleted() {
+			members = append(members, OrganizationMemberInfo{UserID: user.Id, Username: user.Username, Role: user.OrganizationRole(organization.Id)})
+		}
+	}
+	return members, nil
+}
+
+// upsertOrganizationMember adds a hcp user to an organization with the validated role, or changes its role
+func (a *Api) upsertOrganizationMember(ctx context.Context, tokenData *token.TokenData, organizationID, userID, role string) (*OrganizationMemberInfo, *operationError) {
+	if opErr := requireServer(tokenData); opErr != nil {
+		return nil, opErr
+	}
+	organization, opErr := a.findOrganization(ctx, organizationID)
+	if opErr != nil {
+		return nil, opErr
+	}
+	user, err := a.Store.FindUser(ctx, &User{Id: userID})
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	} else if user == nil || user.IsDeleted() {
+		return nil, fail(errUserNotFound)
+	} else if !user.HasRole("hcp") {
+		return nil, fail(errMemberNotHcp)
+	}
+
+	member := &OrganizationMember{OrganizationID: organization.Id, Role: role}
+	if err := a.Store.UpsertOrganizationMember(ctx, user.Id, member); err != nil {
+		return nil, fail(errUpdatingMember, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "UpsertOrganizationMember", TargetUserID: user.Id, OrganizationID: organization.Id, OrganizationRole: role})
+	return &OrganizationMemberInfo{UserID: user.Id, Username: user.Username, Role: role}, nil
+}
+
+// removeOrganizationMember removes a user from an organization
+func (a *Api) removeOrganizationMember(ctx context.Context, tokenData *token.TokenData, organizationID, userID string) *operationError {
+	if opErr := requireServer(tokenData); opErr != nil {
+		return opErr
+	}
+	user, err := a.Store.FindUser(ctx, &User{Id: userID})
+	if err != nil {
+		return fail(errFindingUser, err)
+	} else if user == nil || user.OrganizationRole(organizationID) == "" {
+		return fail(errUserNotFound, "not a member of the organization")
+	}
+
+	if err := a.Store.RemoveOrganizationMember(ctx, user.Id, organizationID); err != nil {
+		return fail(errUpdatingMember, err)
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "RemoveOrganizationMember", TargetUserID: user.Id, OrganizationID: organizationID})
+	return nil
+}
+
+// findConsentsUser returns the user whose consents are managed with the token data.
+// The consents are given by the user itself, the servers may also record them on its behalf.
+func (a *Api) findConsentsUser(ctx context.Context, tokenData *token.TokenData, userID string) (*User, *operationError) {
+	if !a.isAuthorized(tokenData, userID) {
+		return nil, fail(errUnauthorized)
+	}
+	user, err := a.Store.FindUser(ctx, &User{Id: userID})
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	} else if user == nil || user.IsDeleted() {
+		return nil, fail(errUserNotFound)
+	}
+	return user, nil
+}
+
+// getConsents returns the consents of the user, only those of the given type when not empty,
+// and the required versions it has not accepted yet
+func (a *Api) getConsents(ctx context.Context, tokenData *token.TokenData, userID, consentType string) (*ConsentsStatus, *operationError) {
+	user, opErr := a.findConsentsUser(ctx, tokenData, userID)
+	if opErr != nil {
+		return nil, opErr
+	}
+
+	status := a.consentsStatus(user)
+	if consentType != "" {
+		consents := []Consent{}
+		for _, consent := range status.Consents {
+			if consent.Type == consentType {
+				consents = append(consents, consent)
+			}
+		}
+		status.Consents = consents
+	}
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "GetConsents", TargetUserID: user.Id})
+	return status, nil
+}
+
+// addConsent records the acceptance of the document version by the user,
+// with the IP address of the request if any
+func (a *Api) addConsent(ctx context.Context, tokenData *token.TokenData, userID string, details *ConsentDetails) (*ConsentsStatus, *operationError) {
+	user, opErr := a.findConsentsUser(ctx, tokenData, userID)
+	if opErr != nil {
+		return nil, opErr
+	}
+	if err := details.Validate(); err != nil {
+		return nil, fail(errInvalidConsent, err)
+	}
+
+	consent := Consent{Type: *details.Type, Version: *details.Version, AcceptedTime: time.Now().UTC().Format(time.RFC3339), IP: remoteIP(ctx)}
+	if err := a.Store.AddUserConsent(ctx, user.Id, &consent); err != nil {
+		return nil, fail(errUpdatingUser, err)
+	}
+	user.Consents = append(user.Consents, consent)
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "AddConsent", TargetUserID: user.Id, Fields: []string{consent.Type}})
+	return a.consentsStatus(user), nil
+}
+
+// importUsers validates the parsed rows and creates the users of the valid ones, unless dryRun is set.
+// With invite, the users are created without password and their claim tokens are reported.
+func (a *Api) importUsers(ctx context.Context, tokenData *token.TokenData, rows []*importRow, dryRun, invite bool) (*ImportReport, *operationError) {
+	if opErr := requireServer(tokenData); opErr != nil {
+		return nil, opErr
+	}
+
+	report := &ImportReport{DryRun: dryRun, Total: len(rows), Rows: make([]ImportRowResult, len(rows))}
+	newUsers := []*User{}
+	rowIndexes := []int{}
+	seen := map[string]bool{}
+	for index, row := range rows {
+		result := &report.Rows[index]
+		result.Line = row.line
+		newUser, apiErr, err := a.newImportedUser(ctx, row, invite, seen)
+		if err != nil {
+			result.ErrorCode, result.Error = apiErr.code, err.Error()
+			continue
+		}
+		newUser.CreatedUserID = tokenData.UserId
+		result.Username = newUser.Username
+		if !dryRun {
+			result.UserID = newUser.Id
+		}
+		newUsers = append(newUsers, newUser)
+		rowIndexes = append(rowIndexes, index)
+	}
+
+	if !dryRun && len(newUsers) > 0 {
+		err := a.Store.InsertUsers(ctx, newUsers)
+		if insertErr, ok := err.(*InsertUsersError); ok {
+			for index, userErr := range insertErr.Errors {
+				result := &report.Rows[rowIndexes[index]]
+				a.logContext(ctx).WithError(userErr).WithField("line", result.Line).Error("Error importing the user")
+				result.UserID, result.ErrorCode, result.Error = "", schema.ErrorInternal, STATUS_ERR_CREATING_USR
+			}
+		} else if err != nil {
+			return nil, fail(errCreatingUser, err)
+		}
+
+		for index, newUser := range newUsers {
+			result := &report.Rows[rowIndexes[index]]
+			if result.Error != "" {
+				continue
+			}
+			a.logAuditContext(ctx, tokenData, &audit.Event{Action: "ImportUser", TargetUserID: newUser.Id, Fields: rows[rowIndexes[index]].details.fields(), Roles: newUser.Roles})
+			if invite {
+				claimData := &token.ClaimData{UserID: newUser.Id, CreatorID: newUser.CreatedUserID, TenantID: a.tenantID}
+				if result.ClaimToken, err = a.claimToken(claimData); err != nil {
+					result.ErrorCode, result.Error = schema.ErrorInternal, STATUS_ERR_GENERATING_TOKEN
+				}
+			}
+		}
+	}
+
+	for _, result := range report.Rows {
+		if result.Error != "" {
+			report.Failed++
+		} else if !dryRun {
+			report.Created++
+		}
+	}
+	return report, nil
+}
+
+// newImportedUser validates the row and builds its user. The catalogue error is returned with the row error.
+func (a *Api) newImportedUser(ctx context.Context, row *importRow, invite bool, seen map[string]bool) (*User, *apiError, error) {
+	if row.err != nil {
+		return nil, errInvalidUserDetails, row.err
+	}
+	details := row.details
+	if details.Username != nil && len(details.Emails) == 0 {
+		details.Emails = []string{*details.Username}
+	}
+
+	var newUser *User
+	var err error
+	if invite {
+		// the password is only checked by the validation, the user sets it when claiming the account
+		if details.Password != nil {
+			return nil, errInvalidUserDetails, errImportPasswordSet
+		}
+		placeholder := "invitation"
+		validated := *details
+		validated.Password = &placeholder
+		if err := validated.Validate(); err != nil {
+			return nil, errInvalidUserDetails, err
+		}
+		if newUser, err = NewCustodialUser(&NewCustodialUserDetails{Username: details.Username, Emails: details.Emails}, a.ApiConfig.Salt); err != nil {
+			return nil, errInvalidUserDetails, err
+		}
+		newUser.Roles = details.Roles
+		newUser.CreatedTime = time.Now().UTC().Format(time.RFC3339)
+	} else if newUser, err = NewUser(details, a.ApiConfig.Salt); err != nil {
+		return nil, errInvalidUserDetails, err
+	}
+
+	for _, name := range append([]string{newUser.Username}, newUser.Emails...) {
+		if seen[strings.ToLower(name)] {
+			return nil, errUserAlreadyExists, errImportDuplicateUser
+		}
+	}
+	if existingUsers, err := a.Store.FindUsers(ctx, newUser); err != nil {
+		a.logContext(ctx).WithError(err).WithField("line", row.line).Error("Error finding the imported user")
+		return nil, errFindingUser, errors.New(STATUS_ERR_FINDING_USR)
+	} else if len(existingUsers) > 0 {
+		return nil, errUserAlreadyExists, errors.New(STATUS_USR_ALREADY_EXISTS)
+	}
+	for _, name := range append([]string{newUser.Username}, newUser.Emails...) {
+		seen[strings.ToLower(name)] = true
+	}
+	return newUser, nil, nil
+}
+
+// usersExport returns the search and the selected fields (nil for all) of the export query.
+// It runs before the export starts, while its errors can still be sent.
+func (a *Api) usersExport(tokenData *token.TokenData, query url.Values) (*UserSearch, []string, *operationError) {
+	if !tokenData.IsServer {
+		return nil, nil, fail(errUnauthorized)
+	}
+
+	fields, err := parseExportFields(query.Get("fields"))
+	if err != nil {
+		return nil, nil, fail(errInvalidQuery, err)
+	}
+	searchQuery := url.Values{}
+	for key, values := range query {
+		switch key {
+		case "fields":
+		case "cursor", "limit", "sort":
+			return nil, nil, fail(errUnknownParameter, key)
+		default:
+			searchQuery[key] = values
+		}
+	}
+	search, err := parseUserSearch(searchQuery)
+	if err == errUnknownSearchParameter {
+		return nil, nil, fail(errUnknownParameter, err)
+	} else if err != nil {
+		return nil, nil, fail(errInvalidQuery, err)
+	}
+	if search.Role != "" && !IsValidRole(search.Role) {
+		return nil, nil, fail(errInvalidRole)
+	}
+	return search, fields, nil
+}
+
+// exportUsers gives the users of the search, restricted to the fields, one after the other to write.
+// The export is audited even when it could not be completed.
+func (a *Api) exportUsers(ctx context.Context, tokenData *token.TokenData, search *UserSearch, fields []string, write func(exported map[string]interface{}) error) *operationError {
+	err := a.Store.ExportUsers(ctx, search, func(user *User) error {
+		return write(a.asExportedUser(user, fields))
+	})
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "ExportUsers", Fields: fields})
+	if err != nil {
+		return fail(errFindingUser, err)
+	}
+	return nil
+}
+
+// exportUserData returns everything shoreline holds on the user, for the subject-access requests
+func (a *Api) exportUserData(ctx context.Context, tokenData *token.TokenData, userID string) (*PersonalDataExport, *operationError) {
+	if !a.isAuthorized(tokenData, userID) {
+		return nil, fail(errUnauthorized)
+	}
+	user, err := a.Store.FindUser(ctx, &User{Id: userID})
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	} else if user == nil {
+		return nil, fail(errUserNotFound)
+	}
+
+	export := &PersonalDataExport{
+		ExportedTime: time.Now().UTC().Format(time.RFC3339),
+		Profile:      a.asExportedUser(user, nil),
+		RolesHistory: []*audit.Event{},
+		LoginHistory: []*audit.Event{},
+		Sessions:     []ExportedSession{},
+		AuditEvents:  []*audit.Event{},
+		Consents:     user.Consents,
+	}
+	if export.Consents == nil {
+		export.Consents = []Consent{}
+	}
+	for field, value := range map[string]string{"createdUserId": user.CreatedUserID, "modifiedUserId": user.ModifiedUserID, "deletedUserId": user.DeletedUserID} {
+		if value != "" {
+			export.Profile[field] = value
+		}
+	}
+	if user.FailedLogin != nil {
+		export.FailedLogin = ExportedFailedLogin{Count: user.FailedLogin.Count, Total: user.FailedLogin.Total, NextLoginAttemptTime: user.FailedLogin.NextLoginAttemptTime}
+	}
+
+	sessionTokens, err := a.Store.FindTokensByUserID(ctx, user.Id)
+	if err != nil {
+		return nil, fail(errFindingUser, err)
+	}
+	for _, sessionToken := range sessionTokens {
+		export.Sessions = append(export.Sessions, ExportedSession{
+			CreatedTime: time.Unix(sessionToken.CreatedAt, 0).UTC().Format(time.RFC3339),
+			ExpiresTime: time.Unix(sessionToken.ExpiresAt, 0).UTC().Format(time.RFC3339),
+		})
+	}
+
+	if reader := a.auditLogger.Reader(); reader != nil {
+		export.AuditAvailable = true
+		query := &audit.Query{TenantID: a.tenantID, TargetUserID: user.Id, Limit: audit.MaxQueryLimit}
+		for {
+			events, next, err := reader.Find(ctx, query)
+			if err != nil {
+				return nil, fail(errFindingAudit, err)
+			}
+			export.AuditEvents = append(export.AuditEvents, events...)
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+		for _, event := range export.AuditEvents {
+			if event.Action == "Login" {
+				export.LoginHistory = append(export.LoginHistory, event)
+			}
+			// the events logged before the roles were recorded only list the field
+			if event.Outcome != audit.OutcomeFailure && (len(event.Roles) > 0 || hasAuditField(event, "roles")) {
+				export.RolesHistory = append(export.RolesHistory, event)
+			}
+		}
+	}
+
+	a.logAuditContext(ctx, tokenData, &audit.Event{Action: "ExportUserData", TargetUserID: user.Id})
+	return export, nil
+}
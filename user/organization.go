@@ -0,0 +1,169 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Organization is a clinic (or any group of health care professionals)
+type Organization struct {
+	Id             string `json:"id" bson:"id"`
+	Name           string `json:"name" bson:"name"`
+	CreatedTime    string `json:"createdTime,omitempty" bson:"createdTime,omitempty"`
+	CreatedUserID  string `json:"createdUserId,omitempty" bson:"createdUserId,omitempty"`
+	ModifiedTime   string `json:"modifiedTime,omitempty" bson:"modifiedTime,omitempty"`
+	ModifiedUserID string `json:"modifiedUserId,omitempty" bson:"modifiedUserId,omitempty"`
+}
+
+// OrganizationMember is the membership of a user to an organization, stored with the user
+type OrganizationMember struct {
+	OrganizationID string `json:"organizationId" bson:"organizationId"`
+	Role           string `json:"role" bson:"role"`
+}
+
+/*
+ * Incoming organization details used to create or update an `Organization`
+ */
+type OrganizationDetails struct {
+	Name *string
+}
+
+const (
+	// Roles of the members of an organization
+	ORGANIZATION_ROLE_ADMIN  = "admin"
+	ORGANIZATION_ROLE_MEMBER = "member"
+
+	organizationNameMaxLength = 256
+)
+
+var (
+	Organization_error_details_missing = errors.New("Organization details are missing")
+	Organization_error_name_missing    = errors.New("Organization name is missing")
+	Organization_error_name_invalid    = errors.New("Organization name is invalid")
+	Organization_error_role_invalid    = errors.New("Organization role is invalid")
+)
+
+func IsValidOrganizationRole(role string) bool {
+	switch role {
+	case ORGANIZATION_ROLE_ADMIN:
+		return true
+	case ORGANIZATION_ROLE_MEMBER:
+		return true
+	default:
+		return false
+	}
+}
+
+func (details *OrganizationDetails) ExtractFromJSON(reader io.Reader) error {
+	if reader == nil {
+		return Organization_error_details_missing
+	}
+
+	var decoded map[string]interface{}
+	if err := json.NewDecoder(reader).Decode(&decoded); err != nil {
+		return err
+	}
+
+	name, ok := ExtractString(decoded, "name")
+	if !ok {
+		return Organization_error_name_invalid
+	}
+
+	details.Name = name
+	return nil
+}
+
+func (details *OrganizationDetails) Validate() error {
+	if details.Name == nil {
+		return Organization_error_name_missing
+	}
+	if name := strings.TrimSpace(*details.Name); name == "" || len(name) > organizationNameMaxLength {
+		return Organization_error_name_invalid
+	}
+	return nil
+}
+
+func ParseOrganizationDetails(reader io.Reader) (*OrganizationDetails, error) {
+	details := &OrganizationDetails{}
+	if err := details.ExtractFromJSON(reader); err != nil {
+		return nil, err
+	} else if err := details.Validate(); err != nil {
+		return nil, err
+	} else {
+		return details, nil
+	}
+}
+
+func NewOrganization(details *OrganizationDetails) (organization *Organization, err error) {
+	if details == nil {
+		return nil, errors.New("New organization details is nil")
+	} else if err := details.Validate(); err != nil {
+		return nil, err
+	}
+
+	organization = &Organization{Name: strings.TrimSpace(*details.Name)}
+	if organization.Id, err = generateUniqueHash([]string{organization.Name}, 10); err != nil {
+		return nil, errors.New("Organization: error generating id")
+	}
+	return organization, nil
+}
+
+// ParseOrganizationRole reads the role of a new member, "member" when not given
+func ParseOrganizationRole(reader io.Reader) (string, error) {
+	var decoded map[string]interface{}
+	if err := json.NewDecoder(reader).Decode(&decoded); err != nil && err != io.EOF {
+		return "", err
+	}
+	role, ok := ExtractString(decoded, "role")
+	if !ok {
+		return "", Organization_error_role_invalid
+	} else if role == nil {
+		return ORGANIZATION_ROLE_MEMBER, nil
+	} else if !IsValidOrganizationRole(*role) {
+		return "", Organization_error_role_invalid
+	}
+	return *role, nil
+}
+
+// OrganizationIDs returns the ids of the organizations the user is a member of
+func (u *User) OrganizationIDs() []string {
+	ids := make([]string, len(u.Organizations))
+	for index, member := range u.Organizations {
+		ids[index] = member.OrganizationID
+	}
+	return ids
+}
+
+// OrganizationRole returns the role of the user in the organization, empty if not a member
+func (u *User) OrganizationRole(organizationID string) string {
+	for _, member := range u.Organizations {
+		if member.OrganizationID == organizationID {
+			return member.Role
+		}
+	}
+	return ""
+}
+
+// setOrganizationMember adds the membership, or updates the role of the existing one
+func (u *User) setOrganizationMember(member *OrganizationMember) {
+	for index := range u.Organizations {
+		if u.Organizations[index].OrganizationID == member.OrganizationID {
+			u.Organizations[index].Role = member.Role
+			return
+		}
+	}
+	u.Organizations = append(u.Organizations, *member)
+}
+
+// removeOrganization removes the membership of the organization, if any
+func (u *User) removeOrganization(organizationID string) {
+	var kept []OrganizationMember
+	for _, member := range u.Organizations {
+		if member.OrganizationID != organizationID {
+			kept = append(kept, member)
+		}
+	}
+	u.Organizations = kept
+}
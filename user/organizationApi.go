@@ -0,0 +1,239 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/mdblp/shoreline/token"
+)
+
+// OrganizationMemberInfo is a member of an organization with its role in it
+type OrganizationMemberInfo struct {
+	UserID   string `json:"userid"`
+	Username string `json:"username,omitempty"`
+	Role     string `json:"role"`
+}
+
+// authenticateServerToken returns the token data of the request, or sends the error
+// response and returns nil if it was not made with a server token
+func (a *Api) authenticateServerToken(res http.ResponseWriter, req *http.Request) *token.TokenData {
+	tokenData, err := a.authenticateSessionToken(requestContext(req), req.Header.Get(TP_SESSION_TOKEN))
+	if err != nil {
+		a.sendError(res, req, errUnauthorized, err)
+		return nil
+	}
+	if opErr := requireServer(tokenData); opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return nil
+	}
+	return tokenData
+}
+
+// @Summary Get organizations
+// @Description Get all the organizations, sorted by name
+// @ID shoreline-user-api-getorganizations
+// @Produce  json
+// @Security TidepoolAuth
+// @Success 200 {array} user.Organization
+// @Failure 500 {object} status.Status "message returned:\"Error finding organization\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /organizations [get]
+func (a *Api) GetOrganizations(res http.ResponseWriter, req *http.Request) {
+	tokenData := a.authenticateServerToken(res, req)
+	if tokenData == nil {
+		return
+	}
+	organizations, opErr := a.getOrganizations(requestContext(req), tokenData)
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsRes(res, organizations)
+}
+
+// @Summary Create organization
+// @Description Create an organization (clinic)
+// @ID shoreline-user-api-createorganization
+// @Accept  json
+// @Produce  json
+// @Param organization body user.OrganizationDetails true "organization details"
+// @Security TidepoolAuth
+// @Success 201 {object} user.Organization
+// @Failure 500 {object} status.Status "message returned:\"Error updating organization\" "
+// @Failure 400 {object} status.Status "message returned:\"Invalid organization details were given\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /organizations [post]
+func (a *Api) CreateOrganization(res http.ResponseWriter, req *http.Request) {
+	tokenData := a.authenticateServerToken(res, req)
+	if tokenData == nil {
+		return
+	}
+	details, err := ParseOrganizationDetails(req.Body)
+	if err != nil {
+		a.sendError(res, req, errInvalidOrganizationDetails, err)
+		return
+	}
+	organization, opErr := a.createOrganization(requestContext(req), tokenData, details)
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsResWithStatus(res, organization, http.StatusCreated)
+}
+
+// @Summary Get organization
+// @Description Get an organization
+// @ID shoreline-user-api-getorganization
+// @Produce  json
+// @Param organizationid path string true "organization id"
+// @Security TidepoolAuth
+// @Success 200 {object} user.Organization
+// @Failure 500 {object} status.Status "message returned:\"Error finding organization\" "
+// @Failure 404 {object} status.Status "message returned:\"Organization not found\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /organizations/{organizationid} [get]
+func (a *Api) GetOrganization(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	tokenData := a.authenticateServerToken(res, req)
+	if tokenData == nil {
+		return
+	}
+	organization, opErr := a.getOrganization(requestContext(req), tokenData, vars["organizationid"])
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsRes(res, organization)
+}
+
+// @Summary Update organization
+// @Description Rename an organization
+// @ID shoreline-user-api-updateorganization
+// @Accept  json
+// @Produce  json
+// @Param organizationid path string true "organization id"
+// @Param organization body user.OrganizationDetails true "organization details"
+// @Security TidepoolAuth
+// @Success 200 {object} user.Organization
+// @Failure 500 {object} status.Status "message returned:\"Error finding organization\" or \"Error updating organization\" "
+// @Failure 404 {object} status.Status "message returned:\"Organization not found\" "
+// @Failure 400 {object} status.Status "message returned:\"Invalid organization details were given\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /organizations/{organizationid} [put]
+func (a *Api) UpdateOrganization(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	tokenData := a.authenticateServerToken(res, req)
+	if tokenData == nil {
+		return
+	}
+	details, err := ParseOrganizationDetails(req.Body)
+	if err != nil {
+		a.sendError(res, req, errInvalidOrganizationDetails, err)
+		return
+	}
+	organization, opErr := a.updateOrganization(requestContext(req), tokenData, vars["organizationid"], details)
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsRes(res, organization)
+}
+
+// @Summary Delete organization
+// @Description Delete an organization and the memberships of its members
+// @ID shoreline-user-api-deleteorganization
+// @Param organizationid path string true "organization id"
+// @Security TidepoolAuth
+// @Success 204 "Organization deleted"
+// @Failure 500 {object} status.Status "message returned:\"Error finding organization\" or \"Error updating organization\" "
+// @Failure 404 {object} status.Status "message returned:\"Organization not found\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /organizations/{organizationid} [delete]
+func (a *Api) DeleteOrganization(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	tokenData := a.authenticateServerToken(res, req)
+	if tokenData == nil {
+		return
+	}
+	if opErr := a.deleteOrganization(requestContext(req), tokenData, vars["organizationid"]); opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Get organization members
+// @Description Get the members of an organization with their role (admin or member)
+// @ID shoreline-user-api-getorganizationmembers
+// @Produce  json
+// @Param organizationid path string true "organization id"
+// @Security TidepoolAuth
+// @Success 200 {array} user.OrganizationMemberInfo
+// @Failure 500 {object} status.Status "message returned:\"Error finding organization\" or \"Error finding user\" "
+// @Failure 404 {object} status.Status "message returned:\"Organization not found\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /organizations/{organizationid}/members [get]
+func (a *Api) GetOrganizationMembers(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	tokenData := a.authenticateServerToken(res, req)
+	if tokenData == nil {
+		return
+	}
+	members, opErr := a.getOrganizationMembers(requestContext(req), tokenData, vars["organizationid"])
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsRes(res, members)
+}
+
+// @Summary Add or update organization member
+// @Description Add a hcp user to an organization, or change its role in the organization. The organization ids are given in the session tokens of the members.
+// @ID shoreline-user-api-upsertorganizationmember
+// @Accept  json
+// @Produce  json
+// @Param organizationid path string true "organization id"
+// @Param userid path string true "user id"
+// @Param role body string false "{\"role\": \"admin\"}, member by default" Enums(admin, member)
+// @Security TidepoolAuth
+// @Success 200 {object} user.OrganizationMemberInfo
+// @Failure 500 {object} status.Status "message returned:\"Error finding organization\" or \"Error finding user\" or \"Error updating organization member\" "
+// @Failure 404 {object} status.Status "message returned:\"Organization not found\" or \"User not found\" "
+// @Failure 400 {object} status.Status "message returned:\"The role specified is invalid\" or \"Only hcp users can be members of an organization\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /organizations/{organizationid}/members/{userid} [put]
+func (a *Api) UpsertOrganizationMember(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	tokenData := a.authenticateServerToken(res, req)
+	if tokenData == nil {
+		return
+	}
+	role, err := ParseOrganizationRole(req.Body)
+	if err != nil {
+		a.sendError(res, req, errInvalidOrganizationRole, err)
+		return
+	}
+	member, opErr := a.upsertOrganizationMember(requestContext(req), tokenData, vars["organizationid"], vars["userid"], role)
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsRes(res, member)
+}
+
+// @Summary Remove organization member
+// @Description Remove a user from an organization
+// @ID shoreline-user-api-removeorganizationmember
+// @Param organizationid path string true "organization id"
+// @Param userid path string true "user id"
+// @Security TidepoolAuth
+// @Success 204 "Member removed"
+// @Failure 500 {object} status.Status "message returned:\"Error finding user\" or \"Error updating organization member\" "
+// @Failure 404 {object} status.Status "message returned:\"User not found\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /organizations/{organizationid}/members/{userid} [delete]
+func (a *Api) RemoveOrganizationMember(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	tokenData := a.authenticateServerToken(res, req)
+	if tokenData == nil {
+		return
+	}
+	if opErr := a.removeOrganizationMember(requestContext(req), tokenData, vars["organizationid"], vars["userid"]); opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,220 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mdblp/shoreline/audit"
+	"github.com/mdblp/shoreline/schema"
+	"github.com/mdblp/shoreline/token"
+)
+
+var ORGANIZATION = &Organization{Id: "0123456789", Name: "Clinic"}
+
+func T_ServerTokenHeaders(t *testing.T) http.Header {
+	responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{&token.SessionToken{ID: SRVR_TOKEN.ID}, nil}}
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, SRVR_TOKEN.ID)
+	return headers
+}
+
+func Test_Organizations_Error_NotServerToken(t *testing.T) {
+	defer T_ExpectResponsablesEmpty(t)
+
+	for _, route := range []struct{ method, url string }{
+		{"GET", "/organizations"},
+		{"POST", "/organizations"},
+		{"GET", "/organizations/0123456789"},
+		{"PUT", "/organizations/0123456789"},
+		{"DELETE", "/organizations/0123456789"},
+		{"GET", "/organizations/0123456789/members"},
+		{"PUT", "/organizations/0123456789/members/1111111111"},
+		{"DELETE", "/organizations/0123456789/members/1111111111"},
+	} {
+		responsableStore.FindTokenByIDResponses = []FindTokenByIDResponse{{&token.SessionToken{ID: USR_TOKEN.ID}, nil}}
+		headers := http.Header{}
+		headers.Add(TP_SESSION_TOKEN, USR_TOKEN.ID)
+		response := T_PerformRequestBodyHeaders(t, route.method, route.url, "{}", headers)
+		T_ExpectErrorResponse(t, response, 401, STATUS_UNAUTHORIZED)
+	}
+}
+
+func Test_CreateOrganization_Success(t *testing.T) {
+	headers := T_ServerTokenHeaders(t)
+	responsableStore.UpsertOrganizationResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "POST", "/organizations", "{\"name\": \"Clinic\"}", headers)
+
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 201)
+	T_ExpectElementMatch(t, successResponse, "id", `\A[0-9a-f]{10}\z`, true)
+	T_ExpectElementMatch(t, successResponse, "createdTime", `\A\d{4}-\d{2}-\d{2}T`, true)
+	T_ExpectEqualsMap(t, successResponse, map[string]interface{}{"name": "Clinic", "createdUserId": "shoreline"})
+}
+
+func Test_CreateOrganization_Error_InvalidDetails(t *testing.T) {
+	headers := T_ServerTokenHeaders(t)
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "POST", "/organizations", "{\"name\": \"\"}", headers)
+	T_ExpectErrorResponseWithCode(t, response, 400, STATUS_INVALID_ORGANIZATION_DETAILS, schema.ErrorInvalidOrganizationDetails)
+}
+
+func Test_GetOrganizations_Success(t *testing.T) {
+	headers := T_ServerTokenHeaders(t)
+	responsableStore.FindOrganizationsResponses = []FindOrganizationsResponse{{[]*Organization{ORGANIZATION}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestHeaders(t, "GET", "/organizations", headers)
+	successResponse := T_ExpectSuccessResponseWithJSONArray(t, response, 200)
+	T_ExpectEqualsArray(t, successResponse, []interface{}{map[string]interface{}{"id": "0123456789", "name": "Clinic"}})
+}
+
+func Test_GetOrganization_Error_NotFound(t *testing.T) {
+	headers := T_ServerTokenHeaders(t)
+	responsableStore.FindOrganizationResponses = []FindOrganizationResponse{{nil, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestHeaders(t, "GET", "/organizations/0123456789", headers)
+	T_ExpectErrorResponseWithCode(t, response, 404, STATUS_ORGANIZATION_NOT_FOUND, schema.ErrorOrganizationNotFound)
+}
+
+func Test_UpdateOrganization_Success(t *testing.T) {
+	headers := T_ServerTokenHeaders(t)
+	responsableStore.FindOrganizationResponses = []FindOrganizationResponse{{&Organization{Id: "0123456789", Name: "Clinic"}, nil}}
+	responsableStore.UpsertOrganizationResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "PUT", "/organizations/0123456789", "{\"name\": \"New clinic\"}", headers)
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	T_ExpectElementMatch(t, successResponse, "modifiedTime", `\A\d{4}-\d{2}-\d{2}T`, true)
+	T_ExpectEqualsMap(t, successResponse, map[string]interface{}{"id": "0123456789", "name": "New clinic", "modifiedUserId": "shoreline"})
+}
+
+func Test_DeleteOrganization(t *testing.T) {
+	headers := T_ServerTokenHeaders(t)
+	responsableStore.FindOrganizationResponses = []FindOrganizationResponse{{ORGANIZATION, nil}}
+	responsableStore.RemoveOrganizationResponses = []error{nil}
+	response := T_PerformRequestHeaders(t, "DELETE", "/organizations/0123456789", headers)
+	T_ExpectSuccessResponse(t, response, 204)
+
+	headers = T_ServerTokenHeaders(t)
+	responsableStore.FindOrganizationResponses = []FindOrganizationResponse{{ORGANIZATION, nil}}
+	responsableStore.RemoveOrganizationResponses = []error{errors.New("ERROR")}
+	response = T_PerformRequestHeaders(t, "DELETE", "/organizations/0123456789", headers)
+	T_ExpectErrorResponseWithCode(t, response, 500, STATUS_ERR_UPDATING_ORGANIZATION, schema.ErrorInternal)
+
+	T_ExpectResponsablesEmpty(t)
+}
+
+func Test_GetOrganizationMembers_Success(t *testing.T) {
+	headers := T_ServerTokenHeaders(t)
+	responsableStore.FindOrganizationResponses = []FindOrganizationResponse{{ORGANIZATION, nil}}
+	responsableStore.FindUsersByOrganizationResponses = []FindUsersByOrganizationResponse{{[]*User{
+		&User{Id: "1111111111", Username: "admin@example.com", Organizations: []OrganizationMember{{"0123456789", ORGANIZATION_ROLE_ADMIN}}},
+		&User{Id: "2222222222", Username: "deleted@example.com", DeletedTime: "2021-06-01T10:00:00Z", Organizations: []OrganizationMember{{"0123456789", ORGANIZATION_ROLE_MEMBER}}},
+	}, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestHeaders(t, "GET", "/organizations/0123456789/members", headers)
+	successResponse := T_ExpectSuccessResponseWithJSONArray(t, response, 200)
+	T_ExpectEqualsArray(t, successResponse, []interface{}{map[string]interface{}{"userid": "1111111111", "username": "admin@example.com", "role": "admin"}})
+}
+
+func Test_UpsertOrganizationMember_Success(t *testing.T) {
+	headers := T_ServerTokenHeaders(t)
+	responsableStore.FindOrganizationResponses = []FindOrganizationResponse{{ORGANIZATION, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", Username: "hcp@example.com", Roles: []string{"hcp"}}, nil}}
+	responsableStore.UpsertOrganizationMemberResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "PUT", "/organizations/0123456789/members/1111111111", "{\"role\": \"admin\"}", headers)
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	T_ExpectEqualsMap(t, successResponse, map[string]interface{}{"userid": "1111111111", "username": "hcp@example.com", "role": "admin"})
+}
+
+func Test_UpsertOrganizationMember_Error(t *testing.T) {
+	headers := T_ServerTokenHeaders(t)
+	response := T_PerformRequestBodyHeaders(t, "PUT", "/organizations/0123456789/members/1111111111", "{\"role\": \"owner\"}", headers)
+	T_ExpectErrorResponseWithCode(t, response, 400, STATUS_INVALID_ROLE, schema.ErrorInvalidRole)
+
+	headers = T_ServerTokenHeaders(t)
+	responsableStore.FindOrganizationResponses = []FindOrganizationResponse{{ORGANIZATION, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "1111111111", Roles: []string{"patient"}}, nil}}
+	response = T_PerformRequestBodyHeaders(t, "PUT", "/organizations/0123456789/members/1111111111", "", headers)
+	T_ExpectErrorResponseWithCode(t, response, 400, STATUS_MEMBER_NOT_HCP, schema.ErrorInvalidRole)
+
+	headers = T_ServerTokenHeaders(t)
+	responsableStore.FindOrganizationResponses = []FindOrganizationResponse{{ORGANIZATION, nil}}
+	responsableStore.FindUserResponses = []FindUserResponse{{nil, nil}}
+	response = T_PerformRequestBodyHeaders(t, "PUT", "/organizations/0123456789/members/1111111111", "", headers)
+	T_ExpectErrorResponseWithCode(t, response, 404, STATUS_USER_NOT_FOUND, schema.ErrorUserNotFound)
+
+	T_ExpectResponsablesEmpty(t)
+}
+
+func Test_RemoveOrganizationMember(t *testing.T) {
+	member := &User{Id: "1111111111", Roles: []string{"hcp"}, Organizations: []OrganizationMember{{"0123456789", ORGANIZATION_ROLE_MEMBER}}}
+
+	headers := T_ServerTokenHeaders(t)
+	responsableStore.FindUserResponses = []FindUserResponse{{member, nil}}
+	responsableStore.RemoveOrganizationMemberResponses = []error{nil}
+	response := T_PerformRequestHeaders(t, "DELETE", "/organizations/0123456789/members/1111111111", headers)
+	T_ExpectSuccessResponse(t, response, 204)
+
+	headers = T_ServerTokenHeaders(t)
+	responsableStore.FindUserResponses = []FindUserResponse{{member, nil}}
+	response = T_PerformRequestHeaders(t, "DELETE", "/organizations/abcdef0123/members/1111111111", headers)
+	T_ExpectErrorResponseWithCode(t, response, 404, STATUS_USER_NOT_FOUND, schema.ErrorUserNotFound)
+
+	T_ExpectResponsablesEmpty(t)
+}
+
+func Test_Login_Success_Organizations(t *testing.T) {
+	authorization := T_CreateAuthorization(t, "a@b.co", "password")
+	responsableStore.FindUsersResponses = []FindUsersResponse{{[]*User{&User{Id: "1111111111", Username: "a@z.co", Roles: []string{"hcp"}, PwHash: "d1fef52139b0d120100726bcb43d5cc13d41e4b5", EmailVerified: true, Organizations: []OrganizationMember{{"0123456789", ORGANIZATION_ROLE_ADMIN}}}}, nil}}
+	responsableStore.AddTokenResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add("Authorization", authorization)
+	response := T_PerformRequestHeaders(t, "POST", "/login", headers)
+	successResponse := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	if organizations, ok := successResponse["organizations"].([]interface{}); !ok || len(organizations) != 1 {
+		t.Fatalf("Unexpected organizations %#v", successResponse["organizations"])
+	}
+
+	tokenData, err := token.UnpackSessionTokenAndVerify(response.Header().Get(TP_SESSION_TOKEN), FAKE_CONFIG.Secret)
+	if err != nil {
+		t.Fatalf("Unexpected invalid session token: %v", err)
+	}
+	if len(tokenData.Organizations) != 1 || tokenData.Organizations[0] != "0123456789" {
+		t.Fatalf("Unexpected organizations in the session token %v", tokenData.Organizations)
+	}
+}
+
+func Test_CreateOrganization_AuditSource(t *testing.T) {
+	sink := audit.NewMemorySink()
+	api := InitAPITest(FAKE_CONFIG, logger, NewMemoryStoreClient())
+	api.auditLogger = audit.NewLogger(false, sink)
+	serverToken := NewUserClient(api).TokenProvide()
+
+	request, _ := http.NewRequest("POST", "/organizations", strings.NewReader(`{"name": "Clinic"}`))
+	request.Header.Set(TP_SESSION_TOKEN, serverToken)
+	request.Header.Set(TP_TRACE_SESSION, "trace1234")
+	request.RemoteAddr = "192.0.2.1:1234"
+	response := httptest.NewRecorder()
+	api.CreateOrganization(response, request)
+
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status %d", response.Code)
+	}
+	events := sink.Events()
+	last := events[len(events)-1]
+	if last.Action != "CreateOrganization" || last.TraceID != "trace1234" || last.RemoteAddr != "192.0.2.1:1234" {
+		t.Fatalf("The audit event should carry the trace id and the remote address, got %#v", last)
+	}
+}
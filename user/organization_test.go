@@ -0,0 +1,57 @@
+package user
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ParseOrganizationDetails(t *testing.T) {
+	details, err := ParseOrganizationDetails(strings.NewReader(`{"name": " Clinic "}`))
+	if err != nil || *details.Name != " Clinic " {
+		t.Fatalf("Unexpected details %v %v", details, err)
+	}
+	organization, err := NewOrganization(details)
+	if err != nil || organization.Name != "Clinic" || len(organization.Id) != 10 {
+		t.Fatalf("Unexpected organization %v %v", organization, err)
+	}
+
+	for body, expected := range map[string]error{
+		`{}`:             Organization_error_name_missing,
+		`{"name": 1}`:    Organization_error_name_invalid,
+		`{"name": "  "}`: Organization_error_name_invalid,
+	} {
+		if _, err := ParseOrganizationDetails(strings.NewReader(body)); err != expected {
+			t.Fatalf("Unexpected error for %s: %v", body, err)
+		}
+	}
+}
+
+func Test_ParseOrganizationRole(t *testing.T) {
+	for body, expected := range map[string]string{
+		``:                  ORGANIZATION_ROLE_MEMBER,
+		`{}`:                ORGANIZATION_ROLE_MEMBER,
+		`{"role": "admin"}`: ORGANIZATION_ROLE_ADMIN,
+	} {
+		if role, err := ParseOrganizationRole(strings.NewReader(body)); err != nil || role != expected {
+			t.Fatalf("Unexpected role for %s: %s %v", body, role, err)
+		}
+	}
+	for _, body := range []string{`{"role": "owner"}`, `{"role": 1}`} {
+		if _, err := ParseOrganizationRole(strings.NewReader(body)); err != Organization_error_role_invalid {
+			t.Fatalf("Unexpected error for %s: %v", body, err)
+		}
+	}
+}
+
+func Test_User_Organizations(t *testing.T) {
+	user := &User{Organizations: []OrganizationMember{{"0123456789", ORGANIZATION_ROLE_ADMIN}, {"abcdef0123", ORGANIZATION_ROLE_MEMBER}}}
+	if ids := user.OrganizationIDs(); len(ids) != 2 || ids[0] != "0123456789" || ids[1] != "abcdef0123" {
+		t.Fatalf("Unexpected organization ids %v", ids)
+	}
+	if role := user.OrganizationRole("abcdef0123"); role != ORGANIZATION_ROLE_MEMBER {
+		t.Fatalf("Unexpected role %s", role)
+	}
+	if role := user.OrganizationRole("unknown"); role != "" {
+		t.Fatalf("Unexpected role %s", role)
+	}
+}
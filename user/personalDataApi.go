@@ -0,0 +1,73 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/mdblp/shoreline/audit"
+)
+
+// PersonalDataExport is everything shoreline holds on a user, for the subject-access requests.
+// The secrets (password and private hashes, session token ids) are never exported.
+type PersonalDataExport struct {
+	ExportedTime string                 `json:"exportedTime"`
+	Profile      map[string]interface{} `json:"profile"`
+	// RolesHistory are the audit events which set the roles of the user, with the roles set and the previous ones
+	RolesHistory []*audit.Event `json:"rolesHistory"`
+	// LoginHistory are the audit events of the logins into the account, failed ones included
+	LoginHistory []*audit.Event      `json:"loginHistory"`
+	Sessions     []ExportedSession   `json:"sessions"`
+	FailedLogin  ExportedFailedLogin `json:"failedLogin"`
+	Consents     []Consent           `json:"consents"`
+	// AuditEvents are all the audit events targeting the user, the histories above are part of them
+	AuditEvents []*audit.Event `json:"auditEvents"`
+	// AuditAvailable is false when the audit events cannot be queried back, the histories are then empty
+	AuditAvailable bool `json:"auditAvailable"`
+}
+
+// ExportedSession is an active session of the user, its token excluded
+type ExportedSession struct {
+	CreatedTime string `json:"createdTime"`
+	ExpiresTime string `json:"expiresTime"`
+}
+
+// ExportedFailedLogin are the failed login stats of the user
+type ExportedFailedLogin struct {
+	Count                int    `json:"count"`
+	Total                int    `json:"total"`
+	NextLoginAttemptTime string `json:"nextLoginAttemptTime,omitempty"`
+}
+
+// @Summary Export personal data
+// @Description Export everything shoreline holds on the user as a JSON archive, to answer the GDPR subject-access requests: the profile, the roles and login histories, the active sessions, the failed login stats and the audit events targeting the user. The password and private hashes are excluded.
+// @ID shoreline-user-api-exportuserdata
+// @Produce json
+// @Param userid path string true "user id"
+// @Security TidepoolAuth
+// @Success 200 {object} user.PersonalDataExport
+// @Failure 500 {object} status.Status "message returned:\"Error finding user\" or \"Error finding audit events\" "
+// @Failure 404 {object} status.Status "message returned:\"User not found\" "
+// @Failure 401 {object} status.Status "message returned:\"Not authorized for requested operation\" "
+// @Router /user/{userid}/export [get]
+func (a *Api) ExportUserData(res http.ResponseWriter, req *http.Request, vars map[string]string) {
+	ctx := requestContext(req)
+	tokenData, err := a.authenticateSessionToken(ctx, req.Header.Get(TP_SESSION_TOKEN))
+	if err != nil {
+		a.sendError(res, req, errUnauthorized, err)
+		return
+	}
+	export, opErr := a.exportUserData(ctx, tokenData, vars["userid"])
+	if opErr != nil {
+		a.sendOperationError(res, req, opErr)
+		return
+	}
+	sendModelAsRes(res, export)
+}
+
+func hasAuditField(event *audit.Event, field string) bool {
+	for _, name := range event.Fields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
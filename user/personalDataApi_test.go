@@ -0,0 +1,214 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdblp/shoreline/audit"
+	"github.com/mdblp/shoreline/token"
+)
+
+func initPersonalDataAPITest() *Api {
+	api := InitAPITest(FAKE_CONFIG, logger, responsableStore)
+	api.auditLogger = audit.NewLogger(false, audit.NewMemorySink())
+	ctx := context.Background()
+	api.auditLogger.Log(ctx, &audit.Event{Action: "CreateUser", ActorType: audit.ActorAnonymous, TargetUserID: "abcdef1234", Fields: []string{"username", "password", "roles"}})
+	api.auditLogger.Log(ctx, &audit.Event{Action: "Login", ActorType: audit.ActorAnonymous, TargetUserID: "abcdef1234", Outcome: audit.OutcomeFailure, Reason: "wrong password"})
+	api.auditLogger.Log(ctx, &audit.Event{Action: "Login", ActorType: audit.ActorUser, ActorID: "abcdef1234", TargetUserID: "abcdef1234"})
+	api.auditLogger.Log(ctx, &audit.Event{Action: "UpdateUser", ActorType: audit.ActorServer, ActorID: "portal", TargetUserID: "abcdef1234", Fields: []string{"emails"}})
+	api.auditLogger.Log(ctx, &audit.Event{Action: "Login", ActorType: audit.ActorUser, ActorID: "5678", TargetUserID: "5678"})
+	return api
+}
+
+func Test_ExportUserData_Error_Unauthorized(t *testing.T) {
+	api := initPersonalDataAPITest()
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/user/5678/export", false)
+	T_ExpectErrorResponse(t, response, 401, STATUS_UNAUTHORIZED)
+}
+
+func Test_ExportUserData_Error_NotFound(t *testing.T) {
+	api := initPersonalDataAPITest()
+	responsableStore.FindUserResponses = []FindUserResponse{{nil, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/user/5678/export", true)
+	T_ExpectErrorResponse(t, response, 404, STATUS_USER_NOT_FOUND)
+}
+
+func Test_ExportUserData_Error_Sessions(t *testing.T) {
+	api := initPersonalDataAPITest()
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "abcdef1234"}, nil}}
+	responsableStore.FindTokensByUserIDResponses = []FindTokensByUserIDResponse{{nil, errors.New("ERROR")}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/user/abcdef1234/export", false)
+	T_ExpectErrorResponse(t, response, 500, STATUS_ERR_FINDING_USR)
+}
+
+func Test_ExportUserData(t *testing.T) {
+	api := initPersonalDataAPITest()
+	user := &User{
+		Id: "abcdef1234", Username: "a@z.co", Emails: []string{"a@z.co"}, Roles: []string{"patient"}, PwHash: "pwsecret", Hash: "usersecret",
+		Private:     map[string]*IdHashPair{"meta": {Id: "privatesecret", Hash: "privatesecret"}},
+		FailedLogin: &FailedLoginInfos{Count: 1, Total: 3}, CreatedTime: "2021-01-01T10:00:00Z", ModifiedUserID: "portal",
+	}
+	now := time.Now()
+	sessionTokens := []*token.SessionToken{{ID: "tokensecret", UserID: user.Id, CreatedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}}
+	responsableStore.FindUserResponses = []FindUserResponse{{user, nil}}
+	responsableStore.FindTokensByUserIDResponses = []FindTokensByUserIDResponse{{sessionTokens, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/user/abcdef1234/export", false)
+	if response.Code != 200 {
+		t.Fatalf("Unexpected status %d", response.Code)
+	}
+	export := &PersonalDataExport{}
+	if err := json.Unmarshal(response.Body.Bytes(), export); err != nil {
+		t.Fatalf("Invalid export: %v", err)
+	}
+	if export.Profile["userid"] != user.Id || export.Profile["createdTime"] != user.CreatedTime || export.Profile["modifiedUserId"] != "portal" {
+		t.Fatalf("Unexpected profile %v", export.Profile)
+	}
+	for _, secret := range []string{"pwsecret", "usersecret", "privatesecret", "tokensecret"} {
+		if strings.Contains(response.Body.String(), secret) {
+			t.Fatalf("The export should not contain %s: %s", secret, response.Body.String())
+		}
+	}
+	if len(export.Sessions) != 1 || export.FailedLogin.Count != 1 || export.FailedLogin.Total != 3 {
+		t.Fatalf("Unexpected sessions %v or failed logins %v", export.Sessions, export.FailedLogin)
+	}
+	if !export.AuditAvailable || len(export.AuditEvents) != 4 || len(export.LoginHistory) != 2 || len(export.RolesHistory) != 1 || export.RolesHistory[0].Action != "CreateUser" {
+		t.Fatalf("Unexpected audit events %v, logins %v and roles history %v", export.AuditEvents, export.LoginHistory, export.RolesHistory)
+	}
+}
+
+func Test_ExportUserData_AuditUnavailable(t *testing.T) {
+	api := InitAPITest(FAKE_CONFIG, logger, responsableStore)
+	responsableStore.FindUserResponses = []FindUserResponse{{&User{Id: "5678"}, nil}}
+	responsableStore.FindTokensByUserIDResponses = []FindTokensByUserIDResponse{{nil, nil}}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformAuditRequest(t, api, "/user/5678/export", true)
+	body := T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	if body["auditAvailable"] != false || len(body["auditEvents"].([]interface{})) != 0 || len(body["sessions"].([]interface{})) != 0 {
+		t.Fatalf("Unexpected export %v", body)
+	}
+}
+
+func Test_DeleteUser_Anonymize(t *testing.T) {
+	user := &User{Id: "abcdef1234", Username: "a@z.co", Emails: []string{"a@z.co"}, PwHash: "pwsecret"}
+	responsableStore.FindUserResponses = []FindUserResponse{{user, nil}}
+	responsableStore.ReplaceUserResponses = []error{nil}
+	responsableStore.RemoveTokensByUserIDResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "DELETE", "/user/abcdef1234", `{"password": "123youknoWm3"}`, T_ServerTokenHeaders(t))
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d", response.Code)
+	}
+	if user.Username == "a@z.co" || user.PwHash == "pwsecret" || user.DeletedTime == "" || user.DeletedUserID != "shoreline" {
+		t.Fatalf("The user should have been anonymized and marked deleted: %+v", user)
+	}
+}
+
+func Test_DeleteUser_Anonymize_Errors(t *testing.T) {
+	responsableStore.FindUserResponses = []FindUserResponse{{nil, nil}, {&User{Id: "abcdef1234"}, nil}, {&User{Id: "abcdef1234"}, nil}}
+	responsableStore.ReplaceUserResponses = []error{errors.New("ERROR"), nil}
+	responsableStore.RemoveTokensByUserIDResponses = []error{errors.New("ERROR")}
+	defer T_ExpectResponsablesEmpty(t)
+
+	response := T_PerformRequestBodyHeaders(t, "DELETE", "/user/abcdef1234", `{"password": "123youknoWm3"}`, T_ServerTokenHeaders(t))
+	T_ExpectErrorResponse(t, response, 404, STATUS_USER_NOT_FOUND)
+	response = T_PerformRequestBodyHeaders(t, "DELETE", "/user/abcdef1234", `{"password": "123youknoWm3"}`, T_ServerTokenHeaders(t))
+	T_ExpectErrorResponse(t, response, 500, STATUS_ERR_UPDATING_USR)
+	response = T_PerformRequestBodyHeaders(t, "DELETE", "/user/abcdef1234", `{"password": "123youknoWm3"}`, T_ServerTokenHeaders(t))
+	T_ExpectErrorResponse(t, response, 500, STATUS_ERR_UPDATING_TOKEN)
+}
+
+func Test_DeleteUser_PasswordMismatch(t *testing.T) {
+	store := NewMemoryStoreClient()
+	api := InitAPITest(FAKE_CONFIG, logger, store)
+	ctx := context.Background()
+	username, password := "a@z.co", "123youknoWm3"
+	user, _ := NewUser(&NewUserDetails{Username: &username, Password: &password, Emails: []string{username}}, FAKE_CONFIG.Salt)
+	if err := store.UpsertUser(ctx, user); err != nil {
+		t.Fatalf("Failed to store the user: %v", err)
+	}
+
+	userData := &token.TokenData{UserId: user.Id}
+	if err := api.deleteUser(ctx, userData, "", "wrong password"); err == nil || err.apiErr != errPasswordMismatch {
+		t.Fatalf("Expected a password mismatch, got %v", err)
+	}
+	if found, _ := store.FindUser(ctx, &User{Id: user.Id}); found == nil || found.IsDeleted() {
+		t.Fatalf("The user should not have been deleted")
+	}
+	if err := api.deleteUser(ctx, userData, "", password); err != nil {
+		t.Fatalf("Failed to delete the user: %v", err)
+	}
+}
+
+func Test_DeleteUser_AlreadyDeleted(t *testing.T) {
+	sink := audit.NewMemorySink()
+	store := NewMemoryStoreClient()
+	api := InitAPITest(FAKE_CONFIG, logger, store)
+	api.auditLogger = audit.NewLogger(false, sink)
+	ctx := context.Background()
+	if err := store.UpsertUser(ctx, &User{Id: "abcdef1234", Username: "a@z.co", Emails: []string{"a@z.co"}}); err != nil {
+		t.Fatalf("Failed to store the user: %v", err)
+	}
+
+	serverData := &token.TokenData{UserId: "portal", IsServer: true}
+	for i := 0; i < 2; i++ {
+		if err := api.deleteUser(ctx, serverData, "abcdef1234", "123youknoWm3"); err != nil {
+			t.Fatalf("Failed to delete the user: %v", err)
+		}
+	}
+	anonymized := 0
+	for _, event := range sink.Events() {
+		if event.Action == "AnonymizeUser" {
+			anonymized++
+		}
+	}
+	if anonymized != 1 {
+		t.Fatalf("Expected the user to be anonymized once, got %d events", anonymized)
+	}
+}
+
+func Test_UpdateUser_AuditRoles(t *testing.T) {
+	sink := audit.NewMemorySink()
+	store := NewMemoryStoreClient()
+	api := InitAPITest(FAKE_CONFIG, logger, store)
+	api.auditLogger = audit.NewLogger(false, sink)
+	ctx := context.Background()
+	if err := store.UpsertUser(ctx, &User{Id: "abcdef1234", Username: "a@z.co", Emails: []string{"a@z.co"}, Roles: []string{"caregiver"}}); err != nil {
+		t.Fatalf("Failed to store the user: %v", err)
+	}
+
+	serverData := &token.TokenData{UserId: "portal", IsServer: true}
+	details, _ := ParseUpdateUserDetails(strings.NewReader(`{"updates": {"roles": ["hcp"]}}`))
+	if _, err := api.updateUser(ctx, serverData, "abcdef1234", details); err != nil {
+		t.Fatalf("Failed to update the user: %v", err)
+	}
+	events := sink.Events()
+	last := events[len(events)-1]
+	if last.Action != "UpdateUser" || len(last.Roles) != 1 || last.Roles[0] != "hcp" || len(last.PreviousRoles) != 1 || last.PreviousRoles[0] != "caregiver" {
+		t.Fatalf("Expected the roles and previous roles to be audited, got %#v", last)
+	}
+
+	// the roles are only audited when they are updated
+	details, _ = ParseUpdateUserDetails(strings.NewReader(`{"updates": {"emails": ["b@z.co"]}}`))
+	if _, err := api.updateUser(ctx, serverData, "abcdef1234", details); err != nil {
+		t.Fatalf("Failed to update the user: %v", err)
+	}
+	events = sink.Events()
+	if last := events[len(events)-1]; last.Roles != nil || last.PreviousRoles != nil {
+		t.Fatalf("Unexpected roles audited %#v", last)
+	}
+}
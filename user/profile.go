@@ -0,0 +1,170 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Profile holds the optional personal attributes of the user, displayed by the front-ends
+type Profile struct {
+	FirstName string `json:"firstName,omitempty" bson:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty" bson:"lastName,omitempty"`
+	// Language preferred by the user, as a language tag (e.g. "fr" or "en-GB")
+	Language string `json:"language,omitempty" bson:"language,omitempty"`
+	// Timezone of the user, as an IANA time zone name (e.g. "Europe/Paris")
+	Timezone string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	// Country of the user, as an ISO 3166-1 alpha-2 code (e.g. "FR")
+	Country string `json:"country,omitempty" bson:"country,omitempty"`
+}
+
+/*
+ * Incoming profile details used to update the `Profile` of a user,
+ * an empty string clears the attribute
+ */
+type ProfileDetails struct {
+	FirstName *string
+	LastName  *string
+	Language  *string
+	Timezone  *string
+	Country   *string
+}
+
+const profileNameMaxLength = 100
+
+var (
+	Profile_error_invalid          = errors.New("Profile is invalid")
+	Profile_error_name_invalid     = errors.New("Profile name is invalid")
+	Profile_error_language_invalid = errors.New("Profile language is invalid")
+	Profile_error_timezone_invalid = errors.New("Profile timezone is invalid")
+	Profile_error_country_invalid  = errors.New("Profile country is invalid")
+
+	languageRegexp = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+	countryRegexp  = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+func IsValidProfileName(name string) bool {
+	if len(name) > profileNameMaxLength || strings.TrimSpace(name) != name {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func IsValidLanguage(language string) bool {
+	return languageRegexp.MatchString(language)
+}
+
+func IsValidTimezone(timezone string) bool {
+	// LoadLocation accepts "" and "Local" which are not time zones of the user
+	if timezone == "" || timezone == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(timezone)
+	return err == nil
+}
+
+func IsValidCountry(country string) bool {
+	return countryRegexp.MatchString(country)
+}
+
+func (details *ProfileDetails) ExtractFromMap(decoded map[string]interface{}) error {
+	var ok bool
+	if details.FirstName, ok = ExtractString(decoded, "firstName"); !ok {
+		return Profile_error_name_invalid
+	}
+	if details.LastName, ok = ExtractString(decoded, "lastName"); !ok {
+		return Profile_error_name_invalid
+	}
+	if details.Language, ok = ExtractString(decoded, "language"); !ok {
+		return Profile_error_language_invalid
+	}
+	if details.Timezone, ok = ExtractString(decoded, "timezone"); !ok {
+		return Profile_error_timezone_invalid
+	}
+	if details.Country, ok = ExtractString(decoded, "country"); !ok {
+		return Profile_error_country_invalid
+	}
+	return nil
+}
+
+func (details *ProfileDetails) Validate() error {
+	if details.FirstName != nil && !IsValidProfileName(*details.FirstName) {
+		return Profile_error_name_invalid
+	}
+	if details.LastName != nil && !IsValidProfileName(*details.LastName) {
+		return Profile_error_name_invalid
+	}
+	if details.Language != nil && *details.Language != "" && !IsValidLanguage(*details.Language) {
+		return Profile_error_language_invalid
+	}
+	if details.Timezone != nil && *details.Timezone != "" && !IsValidTimezone(*details.Timezone) {
+		return Profile_error_timezone_invalid
+	}
+	if details.Country != nil && *details.Country != "" && !IsValidCountry(*details.Country) {
+		return Profile_error_country_invalid
+	}
+	return nil
+}
+
+// Apply returns the profile updated with the given details.
+// It is never nil, so that the store updates clear the profile whose attributes are all cleared.
+func (details *ProfileDetails) Apply(profile *Profile) *Profile {
+	updated := Profile{}
+	if profile != nil {
+		updated = *profile
+	}
+	for _, field := range []struct {
+		value  *string
+		target *string
+	}{
+		{details.FirstName, &updated.FirstName},
+		{details.LastName, &updated.LastName},
+		{details.Language, &updated.Language},
+		{details.Timezone, &updated.Timezone},
+		{details.Country, &updated.Country},
+	} {
+		if field.value != nil {
+			*field.target = *field.value
+		}
+	}
+	return &updated
+}
+
+// IsEmpty returns true when the user has no profile attribute
+func (p *Profile) IsEmpty() bool {
+	return p == nil || *p == Profile{}
+}
+
+// DisplayName returns the full name of the user, empty when it has none
+func (p *Profile) DisplayName() string {
+	if p == nil {
+		return ""
+	}
+	return strings.TrimSpace(p.FirstName + " " + p.LastName)
+}
+
+// Locale returns the language of the user, completed with its country when the language has no region
+func (p *Profile) Locale() string {
+	if p == nil || p.Language == "" {
+		return ""
+	}
+	if p.Country != "" && !strings.Contains(p.Language, "-") {
+		return p.Language + "-" + p.Country
+	}
+	return p.Language
+}
+
+// DisplayName returns the full name of the user from its profile, its username otherwise
+func (u *User) DisplayName() string {
+	if name := u.Profile.DisplayName(); name != "" {
+		return name
+	}
+	return u.Username
+}
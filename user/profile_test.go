@@ -0,0 +1,74 @@
+package user
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ParseUpdateUserDetails_Profile(t *testing.T) {
+	details, err := ParseUpdateUserDetails(strings.NewReader(`{"updates": {"profile": {"firstName": "Jane", "lastName": "", "language": "fr", "timezone": "Europe/Paris", "country": "FR"}}}`))
+	if err != nil || details.Profile == nil || details.nFields != 1 {
+		t.Fatalf("Unexpected details %v %v", details, err)
+	}
+	if err := details.Validate(); err != nil {
+		t.Fatalf("The profile should be valid: %v", err)
+	}
+	if *details.Profile.FirstName != "Jane" || *details.Profile.LastName != "" || details.Profile.Language == nil || details.Profile.Timezone == nil || details.Profile.Country == nil {
+		t.Fatalf("Unexpected profile details %+v", details.Profile)
+	}
+
+	for body, expectedErr := range map[string]error{
+		`{"updates": {"profile": "Jane"}}`:                                            Profile_error_invalid,
+		`{"updates": {"profile": {"firstName": 1}}}`:                                  Profile_error_name_invalid,
+		`{"updates": {"profile": {"lastName": " Doe"}}}`:                              Profile_error_name_invalid,
+		`{"updates": {"profile": {"lastName": "Do\ne"}}}`:                             Profile_error_name_invalid,
+		`{"updates": {"profile": {"language": "french"}}}`:                            Profile_error_language_invalid,
+		`{"updates": {"profile": {"timezone": "Europe/Nowhere"}}}`:                    Profile_error_timezone_invalid,
+		`{"updates": {"profile": {"timezone": "Local"}}}`:                             Profile_error_timezone_invalid,
+		`{"updates": {"profile": {"country": "fr"}}}`:                                 Profile_error_country_invalid,
+		`{"updates": {"profile": {"firstName": "` + strings.Repeat("a", 101) + `"}}}`: Profile_error_name_invalid,
+	} {
+		details, err := ParseUpdateUserDetails(strings.NewReader(body))
+		if err == nil {
+			err = details.Validate()
+		}
+		if err != expectedErr {
+			t.Fatalf("%s: expected %v, got %v", body, expectedErr, err)
+		}
+	}
+}
+
+func Test_ProfileDetails_Apply(t *testing.T) {
+	firstName, empty, language := "Jane", "", "en-GB"
+	profile := &Profile{FirstName: "John", LastName: "Doe", Country: "FR"}
+
+	updated := (&ProfileDetails{FirstName: &firstName, Language: &language}).Apply(profile)
+	if *updated != (Profile{FirstName: "Jane", LastName: "Doe", Language: "en-GB", Country: "FR"}) || profile.FirstName != "John" {
+		t.Fatalf("Unexpected updated profile %+v, the original one should be left unchanged %+v", updated, profile)
+	}
+
+	updated = (&ProfileDetails{FirstName: &empty, LastName: &empty, Country: &empty}).Apply(profile)
+	if updated == nil || !updated.IsEmpty() {
+		t.Fatalf("The cleared profile should be empty but not nil: %+v", updated)
+	}
+	if updated := (&ProfileDetails{FirstName: &firstName}).Apply(nil); updated.FirstName != "Jane" {
+		t.Fatalf("Unexpected new profile %+v", updated)
+	}
+}
+
+func Test_User_DisplayName_Locale(t *testing.T) {
+	user := &User{Username: "a@z.co"}
+	if user.DisplayName() != "a@z.co" || user.Profile.Locale() != "" {
+		t.Fatalf("Unexpected display name %s or locale %s without profile", user.DisplayName(), user.Profile.Locale())
+	}
+
+	user.Profile = &Profile{LastName: "Doe", Language: "fr", Country: "BE"}
+	if user.DisplayName() != "Doe" || user.Profile.Locale() != "fr-BE" {
+		t.Fatalf("Unexpected display name %s or locale %s", user.DisplayName(), user.Profile.Locale())
+	}
+
+	user.Profile = &Profile{FirstName: "Jane", LastName: "Doe", Language: "en-GB", Country: "FR"}
+	if user.DisplayName() != "Jane Doe" || user.Profile.Locale() != "en-GB" {
+		t.Fatalf("Unexpected display name %s or locale %s", user.DisplayName(), user.Profile.Locale())
+	}
+}
@@ -22,6 +22,17 @@ type FindUsersWithIdsResponse struct {
 	Error error
 }
 
+type SearchUsersResponse struct {
+	Users []*User
+	Next  string
+	Error error
+}
+
+type ExportUsersResponse struct {
+	Users []*User
+	Error error
+}
+
 type FindUserResponse struct {
 	User  *User
 	Error error
@@ -32,17 +43,53 @@ type FindTokenByIDResponse struct {
 	Error        error
 }
 
+type FindTokensByUserIDResponse struct {
+	SessionTokens []*token.SessionToken
+	Error         error
+}
+
+type FindOrganizationResponse struct {
+	Organization *Organization
+	Error        error
+}
+
+type FindOrganizationsResponse struct {
+	Organizations []*Organization
+	Error         error
+}
+
+type FindUsersByOrganizationResponse struct {
+	Users []*User
+	Error error
+}
+
 type ResponsableMockStoreClient struct {
 	PingResponses             []error
 	UpsertUserResponses       []error
+	InsertUsersResponses      []error
 	FindUsersResponses        []FindUsersResponse
 	FindUsersByRoleResponses  []FindUsersByRoleResponse
 	FindUsersWithIdsResponses []FindUsersWithIdsResponse
+	SearchUsersResponses      []SearchUsersResponse
+	ExportUsersResponses      []ExportUsersResponse
 	FindUserResponses         []FindUserResponse
 	RemoveUserResponses       []error
 	AddTokenResponses         []error
 	FindTokenByIDResponses    []FindTokenByIDResponse
 	RemoveTokenByIDResponses  []error
+
+	FindTokensByUserIDResponses   []FindTokensByUserIDResponse
+	RemoveTokensByUserIDResponses []error
+	ReplaceUserResponses          []error
+
+	UpsertOrganizationResponses       []error
+	FindOrganizationResponses         []FindOrganizationResponse
+	FindOrganizationsResponses        []FindOrganizationsResponse
+	RemoveOrganizationResponses       []error
+	FindUsersByOrganizationResponses  []FindUsersByOrganizationResponse
+	UpsertOrganizationMemberResponses []error
+	RemoveOrganizationMemberResponses []error
+	AddUserConsentResponses           []error
 }
 
 func NewResponsableMockStoreClient() *ResponsableMockStoreClient {
@@ -52,27 +99,55 @@ func NewResponsableMockStoreClient() *ResponsableMockStoreClient {
 func (r *ResponsableMockStoreClient) HasResponses() bool {
 	return len(r.PingResponses) > 0 ||
 		len(r.UpsertUserResponses) > 0 ||
+		len(r.InsertUsersResponses) > 0 ||
 		len(r.FindUsersResponses) > 0 ||
 		len(r.FindUsersByRoleResponses) > 0 ||
 		len(r.FindUsersWithIdsResponses) > 0 ||
+		len(r.SearchUsersResponses) > 0 ||
+		len(r.ExportUsersResponses) > 0 ||
 		len(r.FindUserResponses) > 0 ||
 		len(r.RemoveUserResponses) > 0 ||
 		len(r.AddTokenResponses) > 0 ||
 		len(r.FindTokenByIDResponses) > 0 ||
-		len(r.RemoveTokenByIDResponses) > 0
+		len(r.RemoveTokenByIDResponses) > 0 ||
+		len(r.FindTokensByUserIDResponses) > 0 ||
+		len(r.RemoveTokensByUserIDResponses) > 0 ||
+		len(r.ReplaceUserResponses) > 0 ||
+		len(r.UpsertOrganizationResponses) > 0 ||
+		len(r.FindOrganizationResponses) > 0 ||
+		len(r.FindOrganizationsResponses) > 0 ||
+		len(r.RemoveOrganizationResponses) > 0 ||
+		len(r.FindUsersByOrganizationResponses) > 0 ||
+		len(r.UpsertOrganizationMemberResponses) > 0 ||
+		len(r.RemoveOrganizationMemberResponses) > 0 ||
+		len(r.AddUserConsentResponses) > 0
 }
 
 func (r *ResponsableMockStoreClient) Reset() {
 	r.PingResponses = nil
 	r.UpsertUserResponses = nil
+	r.InsertUsersResponses = nil
 	r.FindUsersResponses = nil
 	r.FindUsersByRoleResponses = nil
 	r.FindUsersWithIdsResponses = nil
+	r.SearchUsersResponses = nil
+	r.ExportUsersResponses = nil
 	r.FindUserResponses = nil
 	r.RemoveUserResponses = nil
 	r.AddTokenResponses = nil
 	r.FindTokenByIDResponses = nil
 	r.RemoveTokenByIDResponses = nil
+	r.FindTokensByUserIDResponses = nil
+	r.RemoveTokensByUserIDResponses = nil
+	r.ReplaceUserResponses = nil
+	r.UpsertOrganizationResponses = nil
+	r.FindOrganizationResponses = nil
+	r.FindOrganizationsResponses = nil
+	r.RemoveOrganizationResponses = nil
+	r.FindUsersByOrganizationResponses = nil
+	r.UpsertOrganizationMemberResponses = nil
+	r.RemoveOrganizationMemberResponses = nil
+	r.AddUserConsentResponses = nil
 }
 
 func (r *ResponsableMockStoreClient) Close() error {
@@ -139,6 +214,38 @@ func (r *ResponsableMockStoreClient) FindUsersWithIds(ctx context.Context, ids [
 	panic("FindUsersWithIdsResponses unavailable")
 }
 
+func (r *ResponsableMockStoreClient) SearchUsers(ctx context.Context, search *UserSearch) (found []*User, next string, err error) {
+	if len(r.SearchUsersResponses) > 0 {
+		var response SearchUsersResponse
+		response, r.SearchUsersResponses = r.SearchUsersResponses[0], r.SearchUsersResponses[1:]
+		return response.Users, response.Next, response.Error
+	}
+	panic("SearchUsersResponses unavailable")
+}
+
+// ExportUsers calls fn with the users of the response, then returns its error
+func (r *ResponsableMockStoreClient) ExportUsers(ctx context.Context, search *UserSearch, fn func(*User) error) error {
+	if len(r.ExportUsersResponses) > 0 {
+		var response ExportUsersResponse
+		response, r.ExportUsersResponses = r.ExportUsersResponses[0], r.ExportUsersResponses[1:]
+		for _, user := range response.Users {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		return response.Error
+	}
+	panic("ExportUsersResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) InsertUsers(ctx context.Context, users []*User) (err error) {
+	if len(r.InsertUsersResponses) > 0 {
+		err, r.InsertUsersResponses = r.InsertUsersResponses[0], r.InsertUsersResponses[1:]
+		return err
+	}
+	panic("InsertUsersResponses unavailable")
+}
+
 func (r *ResponsableMockStoreClient) FindUser(ctx context.Context, user *User) (found *User, err error) {
 	if len(r.FindUserResponses) > 0 {
 		var response FindUserResponse
@@ -148,6 +255,14 @@ func (r *ResponsableMockStoreClient) FindUser(ctx context.Context, user *User) (
 	panic("FindUserResponses unavailable")
 }
 
+func (r *ResponsableMockStoreClient) ReplaceUser(ctx context.Context, user *User) (err error) {
+	if len(r.ReplaceUserResponses) > 0 {
+		err, r.ReplaceUserResponses = r.ReplaceUserResponses[0], r.ReplaceUserResponses[1:]
+		return err
+	}
+	panic("ReplaceUserResponses unavailable")
+}
+
 func (r *ResponsableMockStoreClient) RemoveUser(ctx context.Context, user *User) (err error) {
 	if len(r.RemoveUserResponses) > 0 {
 		err, r.RemoveUserResponses = r.RemoveUserResponses[0], r.RemoveUserResponses[1:]
@@ -180,3 +295,87 @@ func (r *ResponsableMockStoreClient) RemoveTokenByID(ctx context.Context, id str
 	}
 	panic("RemoveTokenByIDResponses unavailable")
 }
+
+func (r *ResponsableMockStoreClient) FindTokensByUserID(ctx context.Context, userID string) ([]*token.SessionToken, error) {
+	if len(r.FindTokensByUserIDResponses) > 0 {
+		var response FindTokensByUserIDResponse
+		response, r.FindTokensByUserIDResponses = r.FindTokensByUserIDResponses[0], r.FindTokensByUserIDResponses[1:]
+		return response.SessionTokens, response.Error
+	}
+	panic("FindTokensByUserIDResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) RemoveTokensByUserID(ctx context.Context, userID string) (err error) {
+	if len(r.RemoveTokensByUserIDResponses) > 0 {
+		err, r.RemoveTokensByUserIDResponses = r.RemoveTokensByUserIDResponses[0], r.RemoveTokensByUserIDResponses[1:]
+		return err
+	}
+	panic("RemoveTokensByUserIDResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) UpsertOrganization(ctx context.Context, organization *Organization) (err error) {
+	if len(r.UpsertOrganizationResponses) > 0 {
+		err, r.UpsertOrganizationResponses = r.UpsertOrganizationResponses[0], r.UpsertOrganizationResponses[1:]
+		return err
+	}
+	panic("UpsertOrganizationResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) FindOrganization(ctx context.Context, id string) (*Organization, error) {
+	if len(r.FindOrganizationResponses) > 0 {
+		var response FindOrganizationResponse
+		response, r.FindOrganizationResponses = r.FindOrganizationResponses[0], r.FindOrganizationResponses[1:]
+		return response.Organization, response.Error
+	}
+	panic("FindOrganizationResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) FindOrganizations(ctx context.Context) ([]*Organization, error) {
+	if len(r.FindOrganizationsResponses) > 0 {
+		var response FindOrganizationsResponse
+		response, r.FindOrganizationsResponses = r.FindOrganizationsResponses[0], r.FindOrganizationsResponses[1:]
+		return response.Organizations, response.Error
+	}
+	panic("FindOrganizationsResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) RemoveOrganization(ctx context.Context, id string) (err error) {
+	if len(r.RemoveOrganizationResponses) > 0 {
+		err, r.RemoveOrganizationResponses = r.RemoveOrganizationResponses[0], r.RemoveOrganizationResponses[1:]
+		return err
+	}
+	panic("RemoveOrganizationResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) FindUsersByOrganization(ctx context.Context, organizationID string) ([]*User, error) {
+	if len(r.FindUsersByOrganizationResponses) > 0 {
+		var response FindUsersByOrganizationResponse
+		response, r.FindUsersByOrganizationResponses = r.FindUsersByOrganizationResponses[0], r.FindUsersByOrganizationResponses[1:]
+		return response.Users, response.Error
+	}
+	panic("FindUsersByOrganizationResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) UpsertOrganizationMember(ctx context.Context, userID string, member *OrganizationMember) (err error) {
+	if len(r.UpsertOrganizationMemberResponses) > 0 {
+		err, r.UpsertOrganizationMemberResponses = r.UpsertOrganizationMemberResponses[0], r.UpsertOrganizationMemberResponses[1:]
+		return err
+	}
+	panic("UpsertOrganizationMemberResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) RemoveOrganizationMember(ctx context.Context, userID string, organizationID string) (err error) {
+	if len(r.RemoveOrganizationMemberResponses) > 0 {
+		err, r.RemoveOrganizationMemberResponses = r.RemoveOrganizationMemberResponses[0], r.RemoveOrganizationMemberResponses[1:]
+		return err
+	}
+	panic("RemoveOrganizationMemberResponses unavailable")
+}
+
+func (r *ResponsableMockStoreClient) AddUserConsent(ctx context.Context, userID string, consent *Consent) (err error) {
+	if len(r.AddUserConsentResponses) > 0 {
+		err, r.AddUserConsentResponses = r.AddUserConsentResponses[0], r.AddUserConsentResponses[1:]
+		return err
+	}
+	panic("AddUserConsentResponses unavailable")
+}
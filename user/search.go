@@ -0,0 +1,281 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 1000
+	// USERS_NEXT_CURSOR response header holding the cursor of the next page of users
+	USERS_NEXT_CURSOR = "x-users-next-cursor"
+)
+
+// UserSearch selects users, the criteria given are combined
+type UserSearch struct {
+	Role string
+	IDs  []string
+	// EmailPrefix matches the start of any of the user emails, case insensitive
+	EmailPrefix   string
+	EmailVerified *bool
+	// CreatedFrom (inclusive) and CreatedTo (exclusive) filter on the creation time
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// ModifiedFrom (inclusive) and ModifiedTo (exclusive) filter on the last modification time
+	ModifiedFrom time.Time
+	ModifiedTo   time.Time
+	// ModifiedSince selects the users created, modified or deleted since then, for the incremental exports
+	ModifiedSince time.Time
+	// Deleted selects only the deleted users when true, only the others when false, all of them when nil
+	Deleted *bool
+	// Sort field, one of userid (the default), username, createdTime and modifiedTime.
+	// The user id breaks the ties.
+	Sort       string
+	Descending bool
+	// Cursor returned by a previous search, to get the next page
+	Cursor string
+	Limit  int
+}
+
+// searchCursor is the position of the last returned user, for a given sort field
+type searchCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"i"`
+}
+
+var (
+	ErrInvalidSearchSort   = errors.New("invalid sort field")
+	ErrInvalidSearchCursor = errors.New("invalid cursor")
+
+	errUnknownSearchParameter = errors.New("unknown parameter")
+)
+
+// Validate checks the sort field and the cursor
+func (s *UserSearch) Validate() error {
+	switch s.sortField() {
+	case "userid", "username", "createdTime", "modifiedTime":
+	default:
+		return ErrInvalidSearchSort
+	}
+	_, err := s.after()
+	return err
+}
+
+func (s *UserSearch) limit() int {
+	if s.Limit <= 0 {
+		return DefaultSearchLimit
+	}
+	if s.Limit > MaxSearchLimit {
+		return MaxSearchLimit
+	}
+	return s.Limit
+}
+
+func (s *UserSearch) sortField() string {
+	if s.Sort == "" {
+		return "userid"
+	}
+	return s.Sort
+}
+
+// sortValue of the user, the same name is used for the json and bson fields
+func (s *UserSearch) sortValue(u *User) string {
+	switch s.sortField() {
+	case "username":
+		return u.Username
+	case "createdTime":
+		return u.CreatedTime
+	case "modifiedTime":
+		return u.ModifiedTime
+	default:
+		return u.Id
+	}
+}
+
+// after returns the decoded cursor, nil on the first page
+func (s *UserSearch) after() (*searchCursor, error) {
+	if s.Cursor == "" {
+		return nil, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(s.Cursor)
+	if err != nil {
+		return nil, ErrInvalidSearchCursor
+	}
+	cursor := &searchCursor{}
+	if err := json.Unmarshal(decoded, cursor); err != nil || cursor.ID == "" || cursor.Sort != s.sortField() {
+		return nil, ErrInvalidSearchCursor
+	}
+	return cursor, nil
+}
+
+func (s *UserSearch) encodeCursor(u *User) string {
+	value, _ := json.Marshal(&searchCursor{Sort: s.sortField(), Value: s.sortValue(u), ID: u.Id})
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+// searchTime formats a time bound as the times stored with the users, which are compared as strings
+func searchTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func matchTimeRange(value string, from time.Time, to time.Time) bool {
+	if !from.IsZero() && (value == "" || value < searchTime(from)) {
+		return false
+	}
+	if !to.IsZero() && (value == "" || value >= searchTime(to)) {
+		return false
+	}
+	return true
+}
+
+// Match tells whether the user matches the search criteria, regardless of the cursor
+func (s *UserSearch) Match(u *User) bool {
+	if s.Role != "" && !u.HasRole(s.Role) {
+		return false
+	}
+	if len(s.IDs) > 0 {
+		found := false
+		for _, id := range s.IDs {
+			found = found || id == u.Id
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.EmailPrefix != "" {
+		found := false
+		for _, email := range u.Emails {
+			found = found || strings.HasPrefix(strings.ToLower(email), strings.ToLower(s.EmailPrefix))
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.EmailVerified != nil && u.EmailVerified != *s.EmailVerified {
+		return false
+	}
+	if s.Deleted != nil && u.IsDeleted() != *s.Deleted {
+		return false
+	}
+	if !s.ModifiedSince.IsZero() && !matchTimeRange(u.CreatedTime, s.ModifiedSince, time.Time{}) &&
+		!matchTimeRange(u.ModifiedTime, s.ModifiedSince, time.Time{}) && !matchTimeRange(u.DeletedTime, s.ModifiedSince, time.Time{}) {
+		return false
+	}
+	return matchTimeRange(u.CreatedTime, s.CreatedFrom, s.CreatedTo) && matchTimeRange(u.ModifiedTime, s.ModifiedFrom, s.ModifiedTo)
+}
+
+// less compares two users in the search order
+func (s *UserSearch) less(a, b *User) bool {
+	valueA, valueB := s.sortValue(a), s.sortValue(b)
+	if valueA == valueB {
+		valueA, valueB = a.Id, b.Id
+	}
+	if s.Descending {
+		return valueA > valueB
+	}
+	return valueA < valueB
+}
+
+// Apply runs the search on a list of users, for the stores without query support.
+// It returns the page of matching users and the cursor of the next one (empty on the last page).
+func (s *UserSearch) Apply(users []*User) ([]*User, string, error) {
+	after, err := s.after()
+	if err != nil {
+		return nil, "", err
+	}
+	var last *User
+	if after != nil {
+		last = &User{Id: after.ID}
+		switch s.sortField() {
+		case "username":
+			last.Username = after.Value
+		case "createdTime":
+			last.CreatedTime = after.Value
+		case "modifiedTime":
+			last.ModifiedTime = after.Value
+		}
+	}
+
+	candidates := make([]*User, 0, len(users))
+	for _, u := range users {
+		if s.Match(u) && (last == nil || s.less(last, u)) {
+			candidates = append(candidates, u)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return s.less(candidates[i], candidates[j])
+	})
+
+	limit := s.limit()
+	if len(candidates) > limit {
+		return candidates[:limit], s.encodeCursor(candidates[limit-1]), nil
+	}
+	return candidates, "", nil
+}
+
+// parseUserSearch extracts the user search from the query parameters
+func parseUserSearch(query url.Values) (*UserSearch, error) {
+	search := &UserSearch{}
+	var err error
+	for key, values := range query {
+		value := values[0]
+		switch key {
+		case "role":
+			search.Role = value
+		case "id":
+			for _, id := range strings.Split(value, ",") {
+				if id != "" {
+					search.IDs = append(search.IDs, id)
+				}
+			}
+		case "email":
+			search.EmailPrefix = value
+		case "emailVerified":
+			search.EmailVerified, err = parseSearchBool(value)
+		case "deleted":
+			search.Deleted, err = parseSearchBool(value)
+		case "createdFrom":
+			search.CreatedFrom, err = time.Parse(time.RFC3339, value)
+		case "createdTo":
+			search.CreatedTo, err = time.Parse(time.RFC3339, value)
+		case "modifiedFrom":
+			search.ModifiedFrom, err = time.Parse(time.RFC3339, value)
+		case "modifiedTo":
+			search.ModifiedTo, err = time.Parse(time.RFC3339, value)
+		case "modifiedSince":
+			search.ModifiedSince, err = time.Parse(time.RFC3339, value)
+		case "sort":
+			search.Descending = strings.HasPrefix(value, "-")
+			search.Sort = strings.TrimPrefix(value, "-")
+		case "cursor":
+			search.Cursor = value
+		case "limit":
+			search.Limit, err = strconv.Atoi(value)
+		default:
+			return nil, errUnknownSearchParameter
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := search.Validate(); err != nil {
+		return nil, err
+	}
+	return search, nil
+}
+
+func parseSearchBool(value string) (*bool, error) {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
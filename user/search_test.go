@@ -0,0 +1,178 @@
+package user
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// searchTestUsers are shared by the user search tests of the stores
+func searchTestUsers() []*User {
+	return []*User{
+		{Id: "0000000001", Username: "b.patient@foo.bar", Emails: []string{"b.patient@foo.bar"}, Roles: []string{"patient"}, EmailVerified: true, CreatedTime: "2021-01-01T10:00:00Z"},
+		{Id: "0000000002", Username: "a.hcp@foo.bar", Emails: []string{"A.hcp@foo.bar"}, Roles: []string{"hcp"}, EmailVerified: true, CreatedTime: "2021-02-01T10:00:00Z", ModifiedTime: "2021-03-01T10:00:00Z"},
+		{Id: "0000000003", Username: "c.hcp@other.bar", Emails: []string{"c.hcp@other.bar"}, Roles: []string{"hcp"}, CreatedTime: "2021-03-01T10:00:00Z"},
+		{Id: "0000000004", Roles: []string{"patient"}, CreatedTime: "2021-02-01T10:00:00Z"},
+		{Id: "0000000005", Username: "d.caregiver@foo.bar", Emails: []string{"d.caregiver@foo.bar"}, Roles: []string{"caregiver"}, EmailVerified: true, DeletedTime: "2021-04-01T10:00:00Z"},
+	}
+}
+
+func userIDs(users []*User) []string {
+	ids := []string{}
+	for _, u := range users {
+		ids = append(ids, u.Id)
+	}
+	return ids
+}
+
+func expectUserIDs(t *testing.T, users []*User, expected ...string) {
+	ids := userIDs(users)
+	if len(ids) != len(expected) {
+		t.Fatalf("Expected the users %v, got %v", expected, ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("Expected the users %v, got %v", expected, ids)
+		}
+	}
+}
+
+func Test_UserSearch_Filters(t *testing.T) {
+	yes, no := true, false
+	date := func(value string) time.Time {
+		parsed, _ := time.Parse(time.RFC3339, value)
+		return parsed
+	}
+	tests := []struct {
+		description string
+		search      UserSearch
+		expected    []string
+	}{
+		{"all", UserSearch{}, []string{"0000000001", "0000000002", "0000000003", "0000000004", "0000000005"}},
+		{"role", UserSearch{Role: "hcp"}, []string{"0000000002", "0000000003"}},
+		{"ids", UserSearch{IDs: []string{"0000000003", "0000000001", "unknown"}}, []string{"0000000001", "0000000003"}},
+		{"email prefix case insensitive", UserSearch{EmailPrefix: "a.HCP@"}, []string{"0000000002"}},
+		{"verified", UserSearch{EmailVerified: &yes}, []string{"0000000001", "0000000002", "0000000005"}},
+		{"not verified", UserSearch{EmailVerified: &no}, []string{"0000000003", "0000000004"}},
+		{"deleted", UserSearch{Deleted: &yes}, []string{"0000000005"}},
+		{"created range", UserSearch{CreatedFrom: date("2021-02-01T10:00:00Z"), CreatedTo: date("2021-03-01T10:00:00Z")}, []string{"0000000002", "0000000004"}},
+		{"created range with offset", UserSearch{CreatedFrom: date("2021-03-01T11:00:00+01:00")}, []string{"0000000003"}},
+		{"modified range", UserSearch{ModifiedFrom: date("2021-01-01T00:00:00Z")}, []string{"0000000002"}},
+		{"modified since", UserSearch{ModifiedSince: date("2021-02-15T00:00:00Z")}, []string{"0000000002", "0000000003", "0000000005"}},
+		{"combined", UserSearch{Role: "patient", Deleted: &no, EmailVerified: &no}, []string{"0000000004"}},
+		{"sort username", UserSearch{Sort: "username"}, []string{"0000000004", "0000000002", "0000000001", "0000000003", "0000000005"}},
+		{"sort created descending", UserSearch{Sort: "createdTime", Descending: true}, []string{"0000000003", "0000000004", "0000000002", "0000000001", "0000000005"}},
+	}
+	for _, test := range tests {
+		users, next, err := test.search.Apply(searchTestUsers())
+		if err != nil || next != "" {
+			t.Fatalf("%s: unexpected result %v %q", test.description, err, next)
+		}
+		ids := userIDs(users)
+		if len(ids) != len(test.expected) {
+			t.Fatalf("%s: expected %v, got %v", test.description, test.expected, ids)
+		}
+		for i := range ids {
+			if ids[i] != test.expected[i] {
+				t.Fatalf("%s: expected %v, got %v", test.description, test.expected, ids)
+			}
+		}
+	}
+}
+
+func Test_UserSearch_Pagination(t *testing.T) {
+	for _, sort := range []string{"userid", "username", "createdTime", "modifiedTime"} {
+		for _, descending := range []bool{false, true} {
+			all, _, _ := (&UserSearch{Sort: sort, Descending: descending}).Apply(searchTestUsers())
+
+			search := &UserSearch{Sort: sort, Descending: descending, Limit: 2}
+			pages := []*User{}
+			for i := 0; i < 3; i++ {
+				users, next, err := search.Apply(searchTestUsers())
+				if err != nil {
+					t.Fatalf("%s: unexpected error %v", sort, err)
+				}
+				pages = append(pages, users...)
+				if (next == "") != (i == 2) {
+					t.Fatalf("%s: unexpected cursor %q on page %d", sort, next, i)
+				}
+				search.Cursor = next
+			}
+			expectUserIDs(t, pages, userIDs(all)...)
+		}
+	}
+}
+
+func Test_UserSearch_Validate(t *testing.T) {
+	if err := (&UserSearch{Sort: "emails"}).Validate(); err != ErrInvalidSearchSort {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := (&UserSearch{Cursor: "not a cursor"}).Validate(); err != ErrInvalidSearchCursor {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	search := &UserSearch{Sort: "username", Limit: 1}
+	_, next, _ := search.Apply(searchTestUsers())
+	if err := (&UserSearch{Sort: "username", Cursor: next}).Validate(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := (&UserSearch{Sort: "createdTime", Cursor: next}).Validate(); err != ErrInvalidSearchCursor {
+		t.Fatalf("A cursor should not be reused with another sort: %v", err)
+	}
+	if limit := (&UserSearch{Limit: 5000}).limit(); limit != MaxSearchLimit {
+		t.Fatalf("Unexpected limit %d", limit)
+	}
+}
+
+func Test_parseUserSearch(t *testing.T) {
+	request, _ := http.NewRequest("GET", "/users?role=hcp&id=1,2,&email=a@&emailVerified=true&deleted=false&createdFrom=2021-01-01T00:00:00Z&modifiedTo=2021-02-01T00:00:00Z&sort=-createdTime&limit=10", nil)
+	search, err := parseUserSearch(request.URL.Query())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if search.Role != "hcp" || len(search.IDs) != 2 || search.EmailPrefix != "a@" || !*search.EmailVerified || *search.Deleted ||
+		search.CreatedFrom.IsZero() || search.ModifiedTo.IsZero() || search.Sort != "createdTime" || !search.Descending || search.Limit != 10 {
+		t.Fatalf("Unexpected search %#v", search)
+	}
+
+	for _, query := range []string{"emailVerified=maybe", "createdTo=yesterday", "limit=ten", "sort=emails", "cursor=abc"} {
+		request, _ = http.NewRequest("GET", "/users?"+query, nil)
+		if _, err := parseUserSearch(request.URL.Query()); err == nil || err == errUnknownSearchParameter {
+			t.Fatalf("%s: unexpected error %v", query, err)
+		}
+	}
+	request, _ = http.NewRequest("GET", "/users?yolo=swag", nil)
+	if _, err := parseUserSearch(request.URL.Query()); err != errUnknownSearchParameter {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestUserSearch_LocalTimeZone(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*3600)
+	defer func() { time.Local = local }()
+
+	username, password := "tz@foo.bar", "password1"
+	user, err := NewUser(&NewUserDetails{Username: &username, Emails: []string{username}, Password: &password}, "salt")
+	if err != nil {
+		t.Fatalf("Failed to create the user: %v", err)
+	}
+	if !strings.HasSuffix(user.CreatedTime, "Z") {
+		t.Errorf("The times should be stored in UTC, got %s", user.CreatedTime)
+	}
+	// created within the last hour
+	search := &UserSearch{CreatedFrom: time.Now().Add(-time.Hour), CreatedTo: time.Now().Add(time.Hour)}
+	if !search.Match(user) {
+		t.Errorf("The user created now should match the search of the last hour")
+	}
+
+	// the failed logins are locked until their time, whatever its zone
+	user.FailedLogin = &FailedLoginInfos{Count: 5, NextLoginAttemptTime: time.Now().Add(time.Minute).Format(time.RFC3339)}
+	if user.CanPerformALogin(5) {
+		t.Errorf("The user should be locked")
+	}
+	user.FailedLogin.NextLoginAttemptTime = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	if !user.CanPerformALogin(5) {
+		t.Errorf("The user should be unlocked")
+	}
+}
@@ -7,15 +7,46 @@ import (
 	goComMgo "github.com/tidepool-org/go-common/clients/mongo"
 )
 
+// Storage is the store of the users, tokens and organizations.
+// The implementations are checked by the conformance suite of the storagetest package.
 type Storage interface {
 	goComMgo.Storage
+	// UpsertUser creates the user or sets its fields which are not empty, its roles are sorted
 	UpsertUser(ctx context.Context, user *User) error
+	// FindUser returns the user with the id, nil without error when it does not exist
 	FindUser(ctx context.Context, user *User) (*User, error)
+	// FindUsers returns the users matching the id, the username (case insensitive) or any of the emails
 	FindUsers(ctx context.Context, user *User) ([]*User, error)
 	FindUsersByRole(ctx context.Context, role string) ([]*User, error)
 	FindUsersWithIds(ctx context.Context, role []string) ([]*User, error)
+	// SearchUsers returns a page of the users matching the search, and the cursor of the next one (empty on the last page)
+	SearchUsers(ctx context.Context, search *UserSearch) ([]*User, string, error)
+	// ExportUsers calls fn for each user matching the search criteria (regardless of its cursor, limit and sort)
+	// and stops at the first error
+	ExportUsers(ctx context.Context, search *UserSearch, fn func(*User) error) error
+	// InsertUsers creates the users in bulk, an *InsertUsersError lists the ones which could not be inserted (e.g. their id is taken)
+	InsertUsers(ctx context.Context, users []*User) error
+	// ReplaceUser replaces the whole stored user, so that its empty fields are removed
+	ReplaceUser(ctx context.Context, user *User) error
 	RemoveUser(ctx context.Context, user *User) error
 	AddToken(ctx context.Context, token *token.SessionToken) error
+	// FindTokenByID fails when the token does not exist
 	FindTokenByID(ctx context.Context, id string) (*token.SessionToken, error)
 	RemoveTokenByID(ctx context.Context, id string) error
+	// FindTokensByUserID returns the session tokens of the user which have not expired
+	FindTokensByUserID(ctx context.Context, userID string) ([]*token.SessionToken, error)
+	// RemoveTokensByUserID revokes all the session tokens of the user
+	RemoveTokensByUserID(ctx context.Context, userID string) error
+	UpsertOrganization(ctx context.Context, organization *Organization) error
+	// FindOrganization returns nil when the organization does not exist
+	FindOrganization(ctx context.Context, id string) (*Organization, error)
+	FindOrganizations(ctx context.Context) ([]*Organization, error)
+	// RemoveOrganization also removes the memberships of the organization
+	RemoveOrganization(ctx context.Context, id string) error
+	FindUsersByOrganization(ctx context.Context, organizationID string) ([]*User, error)
+	// UpsertOrganizationMember adds the membership to the user, or updates its role
+	UpsertOrganizationMember(ctx context.Context, userID string, member *OrganizationMember) error
+	RemoveOrganizationMember(ctx context.Context, userID string, organizationID string) error
+	// AddUserConsent appends the consent to the ones of the user
+	AddUserConsent(ctx context.Context, userID string, consent *Consent) error
 }
@@ -0,0 +1,103 @@
+package user_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mdblp/shoreline/user"
+	"github.com/mdblp/shoreline/user/storagetest"
+	"github.com/sirupsen/logrus"
+	"github.com/tidepool-org/go-common/clients/mongo"
+)
+
+func TestMemoryStore_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) user.Storage { return user.NewMemoryStoreClient() })
+}
+
+func TestBoltStore_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) user.Storage {
+		store, err := user.NewBoltStore(&user.BoltConfig{Path: filepath.Join(t.TempDir(), "shoreline.db"), Timeout: time.Second}, logrus.New())
+		if err != nil {
+			t.Fatalf("Failed to open the store: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+// TestMongoStore_Conformance runs against mongodb://127.0.0.1/user_conformance_test (or TIDEPOOL_STORE_* variables),
+// it is skipped when Mongo is not reachable
+func TestMongoStore_Conformance(t *testing.T) {
+	config := &mongo.Config{
+		Database:               "user_conformance_test",
+		Timeout:                2 * time.Second,
+		WaitConnectionInterval: 5 * time.Second,
+	}
+	if _, exist := os.LookupEnv("TIDEPOOL_STORE_ADDRESSES"); exist {
+		config.FromEnv()
+		config.Database = "user_conformance_test"
+	}
+	store, err := user.NewStore(config, logrus.New())
+	if err != nil {
+		t.Skipf("Mongo is not available: %v", err)
+	}
+	defer store.Close()
+	if err := store.Ping(); err != nil {
+		t.Skipf("Mongo is not available: %v", err)
+	}
+	store.Start()
+	store.WaitUntilStarted()
+
+	storagetest.Run(t, func(t *testing.T) user.Storage {
+		ctx := context.Background()
+		for _, name := range []string{user.USERS_COLLECTION, user.TOKENS_COLLECTION, user.ORGANIZATIONS_COLLECTION, user.MIGRATIONS_COLLECTION} {
+			if err := store.Collection(name).Drop(ctx); err != nil {
+				t.Fatalf("Failed to drop the collection %s: %v", name, err)
+			}
+		}
+		if err := store.Migrate(ctx); err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+		return store
+	})
+}
+
+// TestPostgresStore_Conformance runs in the user_conformance_test schema of the POSTGRES_URL database,
+// it is skipped when the variable is not set or the database is not reachable
+func TestPostgresStore_Conformance(t *testing.T) {
+	config := &user.PostgresConfig{Timeout: 2 * time.Second, WaitConnectionInterval: 5 * time.Second}
+	config.FromEnv()
+	if config.URL == "" {
+		t.Skip("POSTGRES_URL is not set")
+	}
+	client, err := user.NewPostgresStore(config, logrus.New())
+	if err != nil {
+		t.Fatalf("Failed to create the store: %v", err)
+	}
+	defer client.Close()
+	if err := client.Ping(); err != nil {
+		t.Skipf("PostgreSQL is not available: %v", err)
+	}
+	store := client.ForTenant("user_conformance_test", "")
+	// the driver is registered by the user package
+	db, err := sql.Open("postgres", config.URL)
+	if err != nil {
+		t.Fatalf("Failed to open the database: %v", err)
+	}
+	defer db.Close()
+
+	storagetest.Run(t, func(t *testing.T) user.Storage {
+		ctx := context.Background()
+		if _, err := db.ExecContext(ctx, "DROP SCHEMA IF EXISTS user_conformance_test CASCADE"); err != nil {
+			t.Fatalf("Failed to drop the schema: %v", err)
+		}
+		if err := store.Migrate(ctx); err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+		return store
+	})
+}
@@ -0,0 +1,576 @@
+// Package storagetest is the conformance suite of the user.Storage implementations.
+//
+// Every backend runs it from its tests, with a factory returning an empty store:
+//
+//	func TestMyStore_Conformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) user.Storage { return newEmptyStore(t) })
+//	}
+//
+// The mocks of the user package replay scripted responses, they are not expected to conform.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdblp/shoreline/token"
+	"github.com/mdblp/shoreline/user"
+)
+
+// NewStorage returns an empty store for a test, closing it is up to the factory (see testing.T.Cleanup)
+type NewStorage func(t *testing.T) user.Storage
+
+// concurrency is the number of goroutines of the concurrency tests
+const concurrency = 20
+
+// Run runs each conformance test on a new store
+func Run(t *testing.T, newStorage NewStorage) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, user.Storage)
+	}{
+		{"UserCRUD", testUserCRUD},
+		{"FindUsers", testFindUsers},
+		{"Duplicates", testDuplicates},
+		{"Roles", testRoles},
+		{"SearchUsers", testSearchUsers},
+		{"Tokens", testTokens},
+		{"Organizations", testOrganizations},
+		{"Concurrency", testConcurrency},
+	}
+	for _, test := range tests {
+		fn := test.fn
+		t.Run(test.name, func(t *testing.T) { fn(t, newStorage(t)) })
+	}
+}
+
+func newUser(id string, roles ...string) *user.User {
+	username := id + "@example.com"
+	return &user.User{Id: id, Username: username, Emails: []string{username}, Roles: roles, PwHash: "hash of " + id}
+}
+
+// findUser returns the stored user, nil when it does not exist
+func findUser(t *testing.T, store user.Storage, id string) *user.User {
+	t.Helper()
+	found, err := store.FindUser(context.Background(), &user.User{Id: id})
+	if err != nil {
+		t.Fatalf("FindUser(%q) failed: %v", id, err)
+	}
+	return found
+}
+
+func userIDs(users []*user.User) []string {
+	ids := make([]string, len(users))
+	for index, u := range users {
+		ids[index] = u.Id
+	}
+	return ids
+}
+
+// expectIDs checks the ids of the users, in any order
+func expectIDs(t *testing.T, description string, users []*user.User, expected ...string) {
+	t.Helper()
+	ids := userIDs(users)
+	found := map[string]bool{}
+	for _, id := range ids {
+		found[id] = true
+	}
+	if len(ids) != len(expected) || len(found) != len(expected) {
+		t.Errorf("%s: expected the users %v, got %v", description, expected, ids)
+		return
+	}
+	for _, id := range expected {
+		if !found[id] {
+			t.Errorf("%s: expected the users %v, got %v", description, expected, ids)
+			return
+		}
+	}
+}
+
+func testUserCRUD(t *testing.T, store user.Storage) {
+	ctx := context.Background()
+	if found := findUser(t, store, "unknown"); found != nil {
+		t.Errorf("An unknown user should not be found, got %v", found)
+	}
+	if found, err := store.FindUser(ctx, &user.User{Username: "unknown@example.com"}); found != nil || err != nil {
+		t.Errorf("FindUser without id should find nothing, got %v, %v", found, err)
+	}
+
+	original := newUser("crud0001", "patient", "caregiver")
+	original.EmailVerified = true
+	original.Profile = &user.Profile{FirstName: "John", LastName: "Doe"}
+	if err := store.UpsertUser(ctx, original); err != nil {
+		t.Fatalf("UpsertUser failed: %v", err)
+	}
+	// the store keeps its own copy
+	original.Username = "changed@example.com"
+
+	found := findUser(t, store, "crud0001")
+	if found == nil {
+		t.Fatalf("The upserted user should be found")
+	}
+	if found.Username != "crud0001@example.com" || len(found.Emails) != 1 || found.PwHash != "hash of crud0001" || !found.EmailVerified {
+		t.Errorf("Unexpected stored user %+v", found)
+	}
+	if len(found.Roles) != 2 || found.Roles[0] != "caregiver" || found.Roles[1] != "patient" {
+		t.Errorf("The roles should be sorted, got %v", found.Roles)
+	}
+	if found.Profile == nil || found.Profile.FirstName != "John" {
+		t.Errorf("Unexpected stored profile %+v", found.Profile)
+	}
+
+	// an upsert only sets the fields which are not empty
+	update := &user.User{Id: "crud0001", TermsAccepted: "2016-01-01T01:23:45-08:00", EmailVerified: true}
+	if err := store.UpsertUser(ctx, update); err != nil {
+		t.Fatalf("UpsertUser of an update failed: %v", err)
+	}
+	found = findUser(t, store, "crud0001")
+	if found.Username != "crud0001@example.com" || found.PwHash != "hash of crud0001" || found.TermsAccepted != "2016-01-01T01:23:45-08:00" {
+		t.Errorf("The upsert should keep the fields not set, got %+v", found)
+	}
+
+	// a replace removes the empty fields
+	replacement := newUser("crud0001", "hcp")
+	replacement.PwHash = ""
+	if err := store.ReplaceUser(ctx, replacement); err != nil {
+		t.Fatalf("ReplaceUser failed: %v", err)
+	}
+	found = findUser(t, store, "crud0001")
+	if found.PwHash != "" || found.TermsAccepted != "" || found.Profile != nil || len(found.Roles) != 1 || found.Roles[0] != "hcp" {
+		t.Errorf("The replace should remove the empty fields, got %+v", found)
+	}
+	if err := store.ReplaceUser(ctx, newUser("crud0002")); err != nil {
+		t.Fatalf("ReplaceUser of an unknown user failed: %v", err)
+	}
+	if found := findUser(t, store, "crud0002"); found != nil {
+		t.Errorf("ReplaceUser should not create a user, got %v", found)
+	}
+
+	if err := store.RemoveUser(ctx, &user.User{Id: "crud0001"}); err != nil {
+		t.Fatalf("RemoveUser failed: %v", err)
+	}
+	if found := findUser(t, store, "crud0001"); found != nil {
+		t.Errorf("The removed user should not be found, got %v", found)
+	}
+	if err := store.RemoveUser(ctx, &user.User{Id: "crud0001"}); err != nil {
+		t.Errorf("RemoveUser of an unknown user failed: %v", err)
+	}
+}
+
+func testFindUsers(t *testing.T, store user.Storage) {
+	ctx := context.Background()
+	first := &user.User{Id: "find0001", Username: "John.Doe@Example.com", Emails: []string{"john.doe@example.com", "jd@example.com"}}
+	second := &user.User{Id: "find0002", Username: "johnxdoe@example.com", Emails: []string{"johnxdoe@example.com"}}
+	for _, u := range []*user.User{first, second} {
+		if err := store.UpsertUser(ctx, u); err != nil {
+			t.Fatalf("UpsertUser failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		description string
+		search      *user.User
+		expected    []string
+	}{
+		{"by id", &user.User{Id: "find0002"}, []string{"find0002"}},
+		{"by username, case insensitive", &user.User{Username: "JOHN.DOE@EXAMPLE.COM"}, []string{"find0001"}},
+		{"by username, not as a regex", &user.User{Username: "john.doe@example.com"}, []string{"find0001"}},
+		{"by username, whole", &user.User{Username: "john"}, []string{}},
+		{"by any email", &user.User{Emails: []string{"unknown@example.com", "jd@example.com"}}, []string{"find0001"}},
+		{"by email, case sensitive", &user.User{Emails: []string{"JD@example.com"}}, []string{}},
+		{"by id, username or email", &user.User{Id: "find0001", Username: "unknown", Emails: []string{"johnxdoe@example.com"}}, []string{"find0001", "find0002"}},
+		{"without criteria", &user.User{}, []string{}},
+	}
+	for _, test := range tests {
+		found, err := store.FindUsers(ctx, test.search)
+		if err != nil {
+			t.Fatalf("FindUsers %s failed: %v", test.description, err)
+		}
+		if found == nil {
+			t.Errorf("FindUsers %s should return an empty list rather than nil", test.description)
+		}
+		expectIDs(t, "FindUsers "+test.description, found, test.expected...)
+	}
+
+	found, err := store.FindUsersWithIds(ctx, []string{"find0002", "unknown"})
+	if err != nil {
+		t.Fatalf("FindUsersWithIds failed: %v", err)
+	}
+	expectIDs(t, "FindUsersWithIds", found, "find0002")
+}
+
+func testDuplicates(t *testing.T, store user.Storage) {
+	ctx := context.Background()
+	if err := store.UpsertUser(ctx, newUser("dupl0001")); err != nil {
+		t.Fatalf("UpsertUser failed: %v", err)
+	}
+
+	// the api detects the usernames and emails already taken before creating a user
+	found, err := store.FindUsers(ctx, &user.User{Username: "DUPL0001@example.com", Emails: []string{"DUPL0001@example.com"}})
+	if err != nil {
+		t.Fatalf("FindUsers failed: %v", err)
+	}
+	expectIDs(t, "FindUsers of a taken username", found, "dupl0001")
+
+	// InsertUsers reports the ids already taken, and inserts the other users
+	duplicate := newUser("dupl0001")
+	duplicate.Username = "other@example.com"
+	err = store.InsertUsers(ctx, []*user.User{newUser("dupl0002"), duplicate, newUser("dupl0003"), newUser("dupl0003")})
+	insertErr, ok := err.(*user.InsertUsersError)
+	if !ok {
+		t.Fatalf("InsertUsers should return an *InsertUsersError, got %v", err)
+	}
+	if len(insertErr.Errors) != 2 || insertErr.Errors[1] == nil || insertErr.Errors[3] == nil {
+		t.Errorf("InsertUsers should fail on the duplicates (1 and 3), got %v", insertErr.Errors)
+	}
+	if found := findUser(t, store, "dupl0001"); found == nil || found.Username != "dupl0001@example.com" {
+		t.Errorf("The existing user should be kept, got %v", found)
+	}
+	for _, id := range []string{"dupl0002", "dupl0003"} {
+		if found := findUser(t, store, id); found == nil {
+			t.Errorf("The user %s should be inserted", id)
+		}
+	}
+
+	if err := store.InsertUsers(ctx, []*user.User{newUser("dupl0004", "patient")}); err != nil {
+		t.Errorf("InsertUsers without duplicates failed: %v", err)
+	}
+
+	// the usernames and the emails are unique regardless of their case
+	sameUsername := newUser("dupl0005")
+	sameUsername.Username = "DUPL0001@Example.com"
+	sameEmail := newUser("dupl0006")
+	sameEmail.Emails = []string{"dupl0006@example.com", "Dupl0002@EXAMPLE.com"}
+	for _, duplicate := range []*user.User{sameUsername, sameEmail} {
+		if err := store.UpsertUser(ctx, duplicate); err == nil {
+			t.Errorf("UpsertUser of %s should fail on its username or email", duplicate.Id)
+		}
+		if found := findUser(t, store, duplicate.Id); found != nil {
+			t.Errorf("The user %s should not be stored, got %v", duplicate.Id, found)
+		}
+	}
+	err = store.InsertUsers(ctx, []*user.User{sameUsername, newUser("dupl0007"), sameEmail})
+	if insertErr, ok := err.(*user.InsertUsersError); !ok || len(insertErr.Errors) != 2 || insertErr.Errors[0] == nil || insertErr.Errors[2] == nil {
+		t.Errorf("InsertUsers should fail on the username and email taken (0 and 2), got %v", err)
+	}
+	if found := findUser(t, store, "dupl0007"); found == nil {
+		t.Errorf("The user dupl0007 should be inserted")
+	}
+	renamed := findUser(t, store, "dupl0004")
+	renamed.Username = "Dupl0003@example.com"
+	if err := store.ReplaceUser(ctx, renamed); err == nil {
+		t.Errorf("ReplaceUser should fail on the username taken")
+	}
+
+	// a user keeps its own username and emails, and the users may have none
+	if err := store.UpsertUser(ctx, &user.User{Id: "dupl0001", Username: "Dupl0001@example.com", Emails: []string{"dupl0001@example.com"}}); err != nil {
+		t.Errorf("UpsertUser of the same user failed: %v", err)
+	}
+	for _, id := range []string{"dupl0008", "dupl0009"} {
+		if err := store.UpsertUser(ctx, &user.User{Id: id, Roles: []string{"patient"}}); err != nil {
+			t.Errorf("UpsertUser of a user without username nor emails failed: %v", err)
+		}
+	}
+}
+
+func testRoles(t *testing.T, store user.Storage) {
+	ctx := context.Background()
+	users := []*user.User{
+		newUser("role0001", "patient"),
+		newUser("role0002", "hcp", "caregiver"),
+		newUser("role0003", "caregiver"),
+		newUser("role0004"),
+	}
+	for _, u := range users {
+		if err := store.UpsertUser(ctx, u); err != nil {
+			t.Fatalf("UpsertUser failed: %v", err)
+		}
+	}
+
+	for role, expected := range map[string][]string{
+		"patient":   {"role0001"},
+		"caregiver": {"role0002", "role0003"},
+		"clinic":    {},
+	} {
+		found, err := store.FindUsersByRole(ctx, role)
+		if err != nil {
+			t.Fatalf("FindUsersByRole(%q) failed: %v", role, err)
+		}
+		if found == nil {
+			t.Errorf("FindUsersByRole(%q) should return an empty list rather than nil", role)
+		}
+		expectIDs(t, fmt.Sprintf("FindUsersByRole(%q)", role), found, expected...)
+	}
+}
+
+func testSearchUsers(t *testing.T, store user.Storage) {
+	ctx := context.Background()
+	for _, u := range []*user.User{newUser("srch0003", "patient"), newUser("srch0001", "patient"), newUser("srch0002", "hcp"), newUser("srch0004", "patient")} {
+		if err := store.UpsertUser(ctx, u); err != nil {
+			t.Fatalf("UpsertUser failed: %v", err)
+		}
+	}
+
+	// the pages are ordered by id by default
+	search := &user.UserSearch{Role: "patient", Limit: 2}
+	page, next, err := store.SearchUsers(ctx, search)
+	if err != nil {
+		t.Fatalf("SearchUsers failed: %v", err)
+	}
+	if ids := userIDs(page); len(ids) != 2 || ids[0] != "srch0001" || ids[1] != "srch0003" || next == "" {
+		t.Fatalf("Unexpected first page %v, next %q", ids, next)
+	}
+	search.Cursor = next
+	page, next, err = store.SearchUsers(ctx, search)
+	if err != nil {
+		t.Fatalf("SearchUsers of the next page failed: %v", err)
+	}
+	if ids := userIDs(page); len(ids) != 1 || ids[0] != "srch0004" || next != "" {
+		t.Errorf("Unexpected last page %v, next %q", ids, next)
+	}
+
+	// the criteria are combined with the role
+	dated := []*user.User{newUser("srch0005", "caregiver"), newUser("srch0006", "caregiver"), newUser("srch0007", "caregiver")}
+	dated[0].CreatedTime, dated[0].EmailVerified = "2021-01-01T00:00:00Z", true
+	dated[1].CreatedTime, dated[1].ModifiedTime = "2021-02-01T00:00:00Z", "2021-03-01T00:00:00Z"
+	dated[2].CreatedTime, dated[2].DeletedTime = "2021-02-01T00:00:00Z", "2021-04-01T00:00:00Z"
+	dated[2].Emails = []string{"Other_0007@example.com"}
+	for _, u := range dated {
+		if err := store.UpsertUser(ctx, u); err != nil {
+			t.Fatalf("UpsertUser failed: %v", err)
+		}
+	}
+	verified, notVerified := true, false
+	february := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	searches := []struct {
+		description string
+		search      user.UserSearch
+		expected    []string
+	}{
+		{"email prefix", user.UserSearch{EmailPrefix: "SRCH000"}, []string{"srch0005", "srch0006"}},
+		{"email prefix with a wildcard", user.UserSearch{EmailPrefix: "other_"}, []string{"srch0007"}},
+		{"email prefix without wildcard", user.UserSearch{EmailPrefix: "other%"}, []string{}},
+		{"verified", user.UserSearch{EmailVerified: &verified}, []string{"srch0005"}},
+		{"not verified", user.UserSearch{EmailVerified: &notVerified}, []string{"srch0006", "srch0007"}},
+		{"deleted", user.UserSearch{Deleted: &verified}, []string{"srch0007"}},
+		{"not deleted", user.UserSearch{Deleted: &notVerified}, []string{"srch0005", "srch0006"}},
+		{"created from", user.UserSearch{CreatedFrom: february}, []string{"srch0006", "srch0007"}},
+		{"created to", user.UserSearch{CreatedTo: february}, []string{"srch0005"}},
+		{"modified from", user.UserSearch{ModifiedFrom: february}, []string{"srch0006"}},
+		{"modified since", user.UserSearch{ModifiedSince: february.AddDate(0, 1, 0)}, []string{"srch0006", "srch0007"}},
+	}
+	for _, test := range searches {
+		search := test.search
+		search.Role = "caregiver"
+		found, _, err := store.SearchUsers(ctx, &search)
+		if err != nil {
+			t.Fatalf("SearchUsers by %s failed: %v", test.description, err)
+		}
+		expectIDs(t, "SearchUsers by "+test.description, found, test.expected...)
+	}
+
+	// the pages follow the sort order, the id breaking the ties
+	search = &user.UserSearch{Role: "caregiver", Sort: "createdTime", Descending: true, Limit: 2}
+	ids := []string{}
+	for pages := 0; pages < 3; pages++ {
+		page, next, err = store.SearchUsers(ctx, search)
+		if err != nil {
+			t.Fatalf("SearchUsers sorted by creation time failed: %v", err)
+		}
+		ids = append(ids, userIDs(page)...)
+		if next == "" {
+			break
+		}
+		search.Cursor = next
+	}
+	if fmt.Sprint(ids) != "[srch0007 srch0006 srch0005]" {
+		t.Errorf("Unexpected pages sorted by descending creation time: %v", ids)
+	}
+
+	exported := []*user.User{}
+	err = store.ExportUsers(ctx, &user.UserSearch{Role: "patient", Limit: 1}, func(u *user.User) error {
+		exported = append(exported, u)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportUsers failed: %v", err)
+	}
+	expectIDs(t, "ExportUsers", exported, "srch0001", "srch0003", "srch0004")
+
+	stop := fmt.Errorf("stop")
+	calls := 0
+	err = store.ExportUsers(ctx, &user.UserSearch{}, func(u *user.User) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("ExportUsers should stop at the first error, got %v after %d calls", err, calls)
+	}
+}
+
+func testTokens(t *testing.T, store user.Storage) {
+	ctx := context.Background()
+	if found, err := store.FindTokenByID(ctx, "unknown"); found != nil || err == nil {
+		t.Errorf("FindTokenByID of an unknown token should fail, got %v, %v", found, err)
+	}
+
+	now := time.Now().Unix()
+	sessionTokens := []*token.SessionToken{
+		{ID: "token-later", UserID: "tokn0001", Duration: 3600, CreatedAt: now, ExpiresAt: now + 3600, Time: now},
+		{ID: "token-first", UserID: "tokn0001", Duration: 3600, CreatedAt: now - 60, ExpiresAt: now + 3540, Time: now - 60},
+		{ID: "token-expired", UserID: "tokn0001", Duration: 3600, CreatedAt: now - 7200, ExpiresAt: now - 3600, Time: now - 7200},
+		{ID: "token-other", UserID: "tokn0002", Duration: 3600, CreatedAt: now, ExpiresAt: now + 3600, Time: now},
+		{ID: "token-server", IsServer: true, ServerID: "server", Duration: 3600, CreatedAt: now, ExpiresAt: now + 3600, Time: now},
+	}
+	for _, sessionToken := range sessionTokens {
+		if err := store.AddToken(ctx, sessionToken); err != nil {
+			t.Fatalf("AddToken failed: %v", err)
+		}
+	}
+
+	found, err := store.FindTokenByID(ctx, "token-server")
+	if err != nil {
+		t.Fatalf("FindTokenByID failed: %v", err)
+	}
+	if *found != *sessionTokens[4] {
+		t.Errorf("Expected the token %+v, got %+v", sessionTokens[4], found)
+	}
+
+	userTokens, err := store.FindTokensByUserID(ctx, "tokn0001")
+	if err != nil {
+		t.Fatalf("FindTokensByUserID failed: %v", err)
+	}
+	if len(userTokens) != 2 || userTokens[0].ID != "token-first" || userTokens[1].ID != "token-later" {
+		t.Errorf("Expected the tokens which have not expired, by creation time, got %v", userTokens)
+	}
+	if userTokens, err := store.FindTokensByUserID(ctx, "unknown"); err != nil || userTokens == nil || len(userTokens) != 0 {
+		t.Errorf("FindTokensByUserID of an unknown user should return an empty list, got %v, %v", userTokens, err)
+	}
+
+	if err := store.RemoveTokenByID(ctx, "token-later"); err != nil {
+		t.Fatalf("RemoveTokenByID failed: %v", err)
+	}
+	if found, err := store.FindTokenByID(ctx, "token-later"); found != nil || err == nil {
+		t.Errorf("The removed token should not be found, got %v, %v", found, err)
+	}
+	if err := store.RemoveTokensByUserID(ctx, "tokn0001"); err != nil {
+		t.Fatalf("RemoveTokensByUserID failed: %v", err)
+	}
+	for _, id := range []string{"token-first", "token-expired"} {
+		if found, err := store.FindTokenByID(ctx, id); found != nil || err == nil {
+			t.Errorf("The token %s of the user should be removed, got %v, %v", id, found, err)
+		}
+	}
+	if _, err := store.FindTokenByID(ctx, "token-other"); err != nil {
+		t.Errorf("The tokens of the other users should be kept, got %v", err)
+	}
+}
+
+func testOrganizations(t *testing.T, store user.Storage) {
+	ctx := context.Background()
+	if found, err := store.FindOrganization(ctx, "unknown"); found != nil || err != nil {
+		t.Errorf("FindOrganization of an unknown organization should return nil, got %v, %v", found, err)
+	}
+	if organizations, err := store.FindOrganizations(ctx); err != nil || organizations == nil || len(organizations) != 0 {
+		t.Errorf("FindOrganizations should return an empty list, got %v, %v", organizations, err)
+	}
+
+	for _, organization := range []*user.Organization{{Id: "org2", Name: "Second"}, {Id: "org1", Name: "First"}} {
+		if err := store.UpsertOrganization(ctx, organization); err != nil {
+			t.Fatalf("UpsertOrganization failed: %v", err)
+		}
+	}
+	organizations, err := store.FindOrganizations(ctx)
+	if err != nil {
+		t.Fatalf("FindOrganizations failed: %v", err)
+	}
+	if len(organizations) != 2 || organizations[0].Id != "org1" || organizations[1].Id != "org2" {
+		t.Errorf("Expected the organizations by name, got %v", organizations)
+	}
+
+	if err := store.UpsertUser(ctx, newUser("orgs0001")); err != nil {
+		t.Fatalf("UpsertUser failed: %v", err)
+	}
+	members := []*user.OrganizationMember{
+		{OrganizationID: "org1", Role: "member"},
+		{OrganizationID: "org1", Role: "admin"},
+		{OrganizationID: "org2", Role: "member"},
+	}
+	for _, member := range members {
+		if err := store.UpsertOrganizationMember(ctx, "orgs0001", member); err != nil {
+			t.Fatalf("UpsertOrganizationMember failed: %v", err)
+		}
+	}
+	found := findUser(t, store, "orgs0001")
+	if len(found.Organizations) != 2 || found.OrganizationRole("org1") != "admin" || found.OrganizationRole("org2") != "member" {
+		t.Errorf("Expected a membership per organization, got %v", found.Organizations)
+	}
+	if users, err := store.FindUsersByOrganization(ctx, "org1"); err != nil {
+		t.Fatalf("FindUsersByOrganization failed: %v", err)
+	} else {
+		expectIDs(t, "FindUsersByOrganization", users, "orgs0001")
+	}
+
+	if err := store.RemoveOrganization(ctx, "org1"); err != nil {
+		t.Fatalf("RemoveOrganization failed: %v", err)
+	}
+	if found, err := store.FindOrganization(ctx, "org1"); found != nil || err != nil {
+		t.Errorf("The removed organization should not be found, got %v, %v", found, err)
+	}
+	if found := findUser(t, store, "orgs0001"); len(found.Organizations) != 1 || found.OrganizationRole("org2") != "member" {
+		t.Errorf("The memberships of the removed organization should be removed, got %v", found.Organizations)
+	}
+
+	if err := store.RemoveOrganizationMember(ctx, "orgs0001", "org2"); err != nil {
+		t.Fatalf("RemoveOrganizationMember failed: %v", err)
+	}
+	if found := findUser(t, store, "orgs0001"); len(found.Organizations) != 0 {
+		t.Errorf("The membership should be removed, got %v", found.Organizations)
+	}
+}
+
+// testConcurrency checks that concurrent writes are neither lost nor mixed up
+func testConcurrency(t *testing.T, store user.Storage) {
+	ctx := context.Background()
+	if err := store.UpsertUser(ctx, newUser("conc0000")); err != nil {
+		t.Fatalf("UpsertUser failed: %v", err)
+	}
+
+	now := time.Now().Unix()
+	errs := make(chan error, 3*concurrency)
+	var wg sync.WaitGroup
+	for index := 1; index <= concurrency; index++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			id := fmt.Sprintf("conc%04d", index)
+			errs <- store.UpsertUser(ctx, newUser(id, "caregiver"))
+			errs <- store.AddToken(ctx, &token.SessionToken{ID: "token-" + id, UserID: "conc0000", CreatedAt: now, ExpiresAt: now + 3600})
+			consent := &user.Consent{Type: "terms", Version: fmt.Sprint(index), AcceptedTime: "2016-01-01T01:23:45-08:00"}
+			errs <- store.AddUserConsent(ctx, "conc0000", consent)
+			if found, err := store.FindUser(ctx, &user.User{Id: id}); err != nil || found == nil || found.Id != id {
+				t.Errorf("The user %s should be found after its upsert, got %v, %v", id, found, err)
+			}
+		}(index)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("A concurrent write failed: %v", err)
+		}
+	}
+
+	if caregivers, err := store.FindUsersByRole(ctx, "caregiver"); err != nil || len(caregivers) != concurrency {
+		t.Errorf("Expected %d caregivers, got %d, %v", concurrency, len(caregivers), err)
+	}
+	if sessionTokens, err := store.FindTokensByUserID(ctx, "conc0000"); err != nil || len(sessionTokens) != concurrency {
+		t.Errorf("Expected %d tokens, got %d, %v", concurrency, len(sessionTokens), err)
+	}
+	if found := findUser(t, store, "conc0000"); len(found.Consents) != concurrency {
+		t.Errorf("Expected %d consents, got %d", concurrency, len(found.Consents))
+	}
+}
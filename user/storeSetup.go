@@ -0,0 +1,65 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errStoreNotSetUp is reported by GetStatus until the migrations of the stores are applied
+var errStoreNotSetUp = errors.New("the store migrations are not applied")
+
+// StoreSetup applies the migrations of the stores once they are reachable:
+// the service is not ready until they are, see GetStatus.
+type StoreSetup struct {
+	mut    sync.Mutex
+	setups []func(context.Context) error
+	err    error
+}
+
+// NewStoreSetup creates a StoreSetup, which is pending until run
+func NewStoreSetup() *StoreSetup {
+	return &StoreSetup{err: errStoreNotSetUp}
+}
+
+// Add registers the migrations of a store (e.g. Client.Migrate), applied in order
+func (s *StoreSetup) Add(setup func(context.Context) error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.setups = append(s.setups, setup)
+}
+
+// Run waits for the store to be reachable, then applies the migrations.
+// It stops at the first failure, which is then reported by Err.
+func (s *StoreSetup) Run(ctx context.Context, store Storage) error {
+	store.WaitUntilStarted()
+	s.mut.Lock()
+	setups := s.setups
+	s.mut.Unlock()
+	var err error
+	for _, setup := range setups {
+		if err = setup(ctx); err != nil {
+			break
+		}
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.err = err
+	return err
+}
+
+// Err returns nil once the migrations are applied, errStoreNotSetUp before, or the error of the failed migration.
+// There is nothing to wait for without StoreSetup.
+func (s *StoreSetup) Err() error {
+	if s == nil {
+		return nil
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.err
+}
+
+// SetStoreSetup makes GetStatus report the service as unavailable until the migrations of the stores are applied
+func (a *Api) SetStoreSetup(setup *StoreSetup) {
+	a.storeSetup = setup
+}
@@ -0,0 +1,48 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStoreSetup_Status(t *testing.T) {
+	api := InitApi(FAKE_CONFIG, logger, NewMemoryStoreClient(), nil)
+	setup := NewStoreSetup()
+	api.SetStoreSetup(setup)
+	getStatus := func() int {
+		response := httptest.NewRecorder()
+		api.GetStatus(response, httptest.NewRequest("GET", "/status", nil))
+		return response.Code
+	}
+	if code := getStatus(); code != http.StatusServiceUnavailable {
+		t.Errorf("The status should be unavailable until the migrations are applied, got %d", code)
+	}
+
+	applied := []int{}
+	failure := errors.New("migration failed")
+	setup.Add(func(context.Context) error { applied = append(applied, 1); return nil })
+	setup.Add(func(context.Context) error { applied = append(applied, 2); return failure })
+	setup.Add(func(context.Context) error { applied = append(applied, 3); return nil })
+	if err := setup.Run(context.Background(), api.Store); err != failure {
+		t.Errorf("Expected the failure of the migration, got %v", err)
+	}
+	if len(applied) != 2 {
+		t.Errorf("The migrations should stop at the first failure, got %v", applied)
+	}
+	if code := getStatus(); code != http.StatusServiceUnavailable || setup.Err() != failure {
+		t.Errorf("The status should be unavailable after a failed migration, got %d, %v", code, setup.Err())
+	}
+
+	setup = NewStoreSetup()
+	setup.Add(func(context.Context) error { return nil })
+	api.SetStoreSetup(setup)
+	if err := setup.Run(context.Background(), api.Store); err != nil {
+		t.Errorf("Failed to run the migrations: %v", err)
+	}
+	if code := getStatus(); code != http.StatusOK {
+		t.Errorf("The status should be OK once the migrations are applied, got %d", code)
+	}
+}
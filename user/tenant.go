@@ -0,0 +1,107 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mdblp/shoreline/token"
+)
+
+// TenantConfig is the configuration block of a tenant (e.g. a market) served by the same instance
+type TenantConfig struct {
+	// ID of the tenant, carried by its tokens
+	ID string `json:"id"`
+	// Hosts the requests of the tenant are sent to, without port
+	Hosts []string `json:"hosts"`
+	// User overrides the default "user" configuration block, the values not given are inherited
+	User json.RawMessage `json:"user"`
+	// Database of the tenant, the default Mongo database (or PostgreSQL schema) when empty
+	Database string `json:"database"`
+	// CollectionPrefix of the tenant collections (or PostgreSQL tables), e.g. "fr_" for "fr_users"
+	CollectionPrefix string `json:"collectionPrefix"`
+}
+
+var (
+	Tenant_error_id_missing   = errors.New("Tenant id is missing")
+	Tenant_error_id_duplicate = errors.New("Tenant id is already used")
+)
+
+// Merge returns the tenant configuration: the default one overridden by the tenant block
+func (t *TenantConfig) Merge(defaults ApiConfig) (ApiConfig, error) {
+	cfg := defaults
+	// the slices and maps would be shared with the default configuration otherwise
+	cfg.Secrets = append([]Secret(nil), defaults.Secrets...)
+	cfg.TokenSecrets = make(map[string]string, len(defaults.TokenSecrets))
+	for service, secret := range defaults.TokenSecrets {
+		cfg.TokenSecrets[service] = secret
+	}
+	cfg.ServerSecrets = nil
+	if len(t.User) > 0 {
+		if err := json.Unmarshal(t.User, &cfg); err != nil {
+			return cfg, fmt.Errorf("Tenant %s: invalid configuration: %v", t.ID, err)
+		}
+	}
+	return cfg, nil
+}
+
+// AddTenant registers a tenant with its configuration and store.
+// Its requests are found by host, or else by the tenant claim of their session token;
+// the other requests are served by the default tenant.
+func (a *Api) AddTenant(id string, hosts []string, cfg ApiConfig, store Storage) error {
+	if id == "" {
+		return Tenant_error_id_missing
+	}
+	for _, tenant := range a.tenants {
+		if tenant.tenantID == id {
+			return Tenant_error_id_duplicate
+		}
+	}
+	tenant := InitApi(cfg, a.logger, store, a.auditLogger)
+	tenant.tenantID = id
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if _, exists := a.tenantHosts[host]; exists {
+			return fmt.Errorf("Tenant %s: host %s is already used", id, host)
+		}
+		if a.tenantHosts == nil {
+			a.tenantHosts = make(map[string]*Api)
+		}
+		a.tenantHosts[host] = tenant
+	}
+	a.tenants = append(a.tenants, tenant)
+	return nil
+}
+
+// resolveTenant returns the api of the tenant the request is made to
+func (a *Api) resolveTenant(req *http.Request) *Api {
+	if len(a.tenants) == 0 {
+		return a
+	}
+	host := req.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if tenant, found := a.tenantHosts[strings.ToLower(host)]; found {
+		return tenant
+	}
+	// e.g. the services calling shoreline directly, with the tokens of their callers
+	if tenantID := token.UnverifiedTenantID(req.Header.Get(TP_SESSION_TOKEN)); tenantID != "" {
+		for _, tenant := range a.tenants {
+			if tenant.tenantID == tenantID {
+				return tenant
+			}
+		}
+	}
+	return a
+}
+
+func (a *Api) tenantMatcher(tenant *Api) mux.MatcherFunc {
+	return func(req *http.Request, match *mux.RouteMatch) bool {
+		return a.resolveTenant(req) == tenant
+	}
+}
@@ -0,0 +1,175 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mdblp/shoreline/audit"
+	"github.com/mdblp/shoreline/token"
+)
+
+func T_PerformTenantRequest(t *testing.T, api *Api, method string, url string, host string, headers http.Header) *httptest.ResponseRecorder {
+	request, err := http.NewRequest(method, url, strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Failed to create new request with error %#v", err)
+	}
+	request.Host = host
+	for key, values := range headers {
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+	response := httptest.NewRecorder()
+	router := mux.NewRouter()
+	api.SetHandlers("", router)
+	router.ServeHTTP(response, request)
+	return response
+}
+
+// initTenantAPITest returns an api with the "fr" and "de" tenants, sharing the default secret
+func initTenantAPITest(t *testing.T) (*Api, *ResponsableMockStoreClient, *ResponsableMockStoreClient) {
+	api := InitAPITest(FAKE_CONFIG, logger, responsableStore)
+	frStore, deStore := NewResponsableMockStoreClient(), NewResponsableMockStoreClient()
+	if err := api.AddTenant("fr", []string{"fr.example.com"}, FAKE_CONFIG, frStore); err != nil {
+		t.Fatalf("Unexpected error adding the tenant: %v", err)
+	}
+	if err := api.AddTenant("de", []string{"de.example.com", "DE.example.org"}, FAKE_CONFIG, deStore); err != nil {
+		t.Fatalf("Unexpected error adding the tenant: %v", err)
+	}
+	return api, frStore, deStore
+}
+
+func Test_TenantConfig_Merge(t *testing.T) {
+	defaults := FAKE_CONFIG
+	defaults.TokenSecrets = map[string]string{"zendesk": "zendeskSecret"}
+	tenant := &TenantConfig{ID: "fr", User: json.RawMessage(`{"tokenDurationSecs": 60, "maxFailedLogin": 3, "secrets": [{"secret": "default", "pass": "fr"}], "TokenSecrets": {"other": "otherSecret"}}`)}
+
+	cfg, err := tenant.Merge(defaults)
+	if err != nil {
+		t.Fatalf("Unexpected error merging the configuration: %v", err)
+	}
+	if cfg.TokenDurationSecs != 60 || cfg.MaxFailedLogin != 3 || len(cfg.Secrets) != 1 || cfg.Secrets[0].Pass != "fr" {
+		t.Fatalf("The tenant values should override the default ones: %#v", cfg)
+	}
+	if cfg.Salt != defaults.Salt || cfg.Secret != defaults.Secret || cfg.TokenSecrets["zendesk"] != "zendeskSecret" || cfg.TokenSecrets["other"] != "otherSecret" {
+		t.Fatalf("The values not given should be inherited: %#v", cfg)
+	}
+	if len(defaults.TokenSecrets) != 1 || defaults.Secrets[0].Pass != FAKE_CONFIG.Secrets[0].Pass {
+		t.Fatalf("The default configuration should not be modified: %#v", defaults)
+	}
+
+	if _, err := (&TenantConfig{ID: "fr", User: json.RawMessage(`{"salt": 1}`)}).Merge(defaults); err == nil {
+		t.Fatalf("An invalid configuration should be rejected")
+	}
+}
+
+func Test_AddTenant_Error(t *testing.T) {
+	api, _, _ := initTenantAPITest(t)
+	if err := api.AddTenant("", nil, FAKE_CONFIG, responsableStore); err != Tenant_error_id_missing {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := api.AddTenant("fr", nil, FAKE_CONFIG, responsableStore); err != Tenant_error_id_duplicate {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := api.AddTenant("it", []string{"de.example.org"}, FAKE_CONFIG, responsableStore); err == nil {
+		t.Fatalf("A host should not be used by two tenants")
+	}
+}
+
+func Test_Tenant_Login(t *testing.T) {
+	api, frStore, _ := initTenantAPITest(t)
+	frStore.FindUsersResponses = []FindUsersResponse{{[]*User{&User{Id: "1111111111", Username: "a@z.co", PwHash: "d1fef52139b0d120100726bcb43d5cc13d41e4b5", EmailVerified: true}}, nil}}
+	frStore.AddTokenResponses = []error{nil}
+	defer T_ExpectResponsablesEmpty(t)
+
+	headers := http.Header{}
+	headers.Add("Authorization", T_CreateAuthorization(t, "a@b.co", "password"))
+	response := T_PerformTenantRequest(t, api, "POST", "/login", "fr.example.com:8009", headers)
+	T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+
+	if frStore.HasResponses() {
+		t.Fatalf("The tenant store should have been used")
+	}
+	tokenData, err := token.UnpackSessionTokenAndVerify(response.Header().Get(TP_SESSION_TOKEN), FAKE_CONFIG.Secret)
+	if err != nil || tokenData.TenantID != "fr" {
+		t.Fatalf("The session token should carry the tenant id: %#v %v", tokenData, err)
+	}
+}
+
+func Test_Tenant_SessionToken(t *testing.T) {
+	api, frStore, deStore := initTenantAPITest(t)
+	frToken, _ := token.CreateSessionToken(&token.TokenData{UserId: "1111111111", DurationSecs: TOKEN_DURATION, TenantID: "fr"}, TOKEN_CONFIG)
+	headers := http.Header{}
+	headers.Add(TP_SESSION_TOKEN, frToken.ID)
+
+	// resolved by the tenant claim of the token when the host is not a tenant one
+	frStore.FindTokenByIDResponses = []FindTokenByIDResponse{{frToken, nil}}
+	frStore.FindUsersResponses = []FindUsersResponse{{[]*User{&User{Id: "1111111111", Username: "a@z.co"}}, nil}}
+	response := T_PerformTenantRequest(t, api, "GET", "/user", "shoreline:9107", headers)
+	T_ExpectSuccessResponseWithJSONMap(t, response, 200)
+	if frStore.HasResponses() {
+		t.Fatalf("The tenant store should have been used")
+	}
+
+	// rejected by another tenant, even when signed with the same secret
+	response = T_PerformTenantRequest(t, api, "GET", "/user", "de.example.org", headers)
+	T_ExpectErrorResponse(t, response, 401, STATUS_UNAUTHORIZED)
+	if deStore.HasResponses() {
+		t.Fatalf("The other tenant store should not have been used")
+	}
+
+	// and by the default tenant
+	defaultToken, _ := token.CreateSessionToken(&token.TokenData{UserId: "1111111111", DurationSecs: TOKEN_DURATION}, TOKEN_CONFIG)
+	headers.Set(TP_SESSION_TOKEN, defaultToken.ID)
+	response = T_PerformTenantRequest(t, api, "GET", "/user", "fr.example.com", headers)
+	T_ExpectErrorResponse(t, response, 401, STATUS_UNAUTHORIZED)
+	T_ExpectResponsablesEmpty(t)
+}
+
+func Test_Tenant_AuditEvents(t *testing.T) {
+	sink := audit.NewMemorySink()
+	api := InitAPITest(FAKE_CONFIG, logger, responsableStore)
+	api.auditLogger = audit.NewLogger(false, sink)
+	frStore, deStore := NewResponsableMockStoreClient(), NewResponsableMockStoreClient()
+	if err := api.AddTenant("fr", []string{"fr.example.com"}, FAKE_CONFIG, frStore); err != nil {
+		t.Fatalf("Unexpected error adding the tenant: %v", err)
+	}
+	if err := api.AddTenant("de", []string{"de.example.com"}, FAKE_CONFIG, deStore); err != nil {
+		t.Fatalf("Unexpected error adding the tenant: %v", err)
+	}
+	ctx := context.Background()
+	api.logAuditContext(ctx, nil, &audit.Event{Action: "Login", TargetUserID: "0000000000"})
+	api.tenants[0].logAuditContext(ctx, nil, &audit.Event{Action: "Login", TargetUserID: "1111111111"})
+	api.tenants[1].logAuditContext(ctx, nil, &audit.Event{Action: "Login", TargetUserID: "2222222222"})
+
+	stores := map[string]*ResponsableMockStoreClient{"fr": frStore, "de": deStore}
+	for _, tenantID := range []string{"fr", "de"} {
+		tenantStore := stores[tenantID]
+		serverToken, _ := token.CreateSessionToken(&token.TokenData{UserId: "shoreline", IsServer: true, DurationSecs: TOKEN_DURATION, TenantID: tenantID}, TOKEN_CONFIG)
+		tenantStore.FindTokenByIDResponses = []FindTokenByIDResponse{{serverToken, nil}}
+		headers := http.Header{}
+		headers.Add(TP_SESSION_TOKEN, serverToken.ID)
+		response := T_PerformTenantRequest(t, api, "GET", "/audit?action=Login", tenantID+".example.com", headers)
+		if response.Code != http.StatusOK {
+			t.Fatalf("Unexpected response code %d", response.Code)
+		}
+		var page auditEventsPage
+		if err := json.Unmarshal(response.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Unable to decode the response: %v", err)
+		}
+		if len(page.Events) != 1 || page.Events[0].TenantID != tenantID {
+			t.Errorf("Expected only the events of the tenant %s, got %v", tenantID, page.Events)
+		}
+	}
+
+	// the query is audited in the tenant
+	if last := sink.Events()[len(sink.Events())-1]; last.Action != "GetAuditEvents" || last.TenantID != "de" {
+		t.Errorf("Expected the query to be audited in the tenant, got %#v", last)
+	}
+	T_ExpectResponsablesEmpty(t)
+}
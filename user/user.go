@@ -26,6 +26,12 @@ type User struct {
 	ModifiedUserID string                 `json:"modifiedUserId,omitempty" bson:"modifiedUserId,omitempty"`
 	DeletedTime    string                 `json:"deletedTime,omitempty" bson:"deletedTime,omitempty"`
 	DeletedUserID  string                 `json:"deletedUserId,omitempty" bson:"deletedUserId,omitempty"`
+	// Organizations the (hcp) user is a member of, updated with the organization members store functions
+	Organizations []OrganizationMember `json:"organizations,omitempty" bson:"organizations,omitempty"`
+	// Consents given by the user, updated with the AddUserConsent store function
+	Consents []Consent `json:"consents,omitempty" bson:"consents,omitempty"`
+	// Profile of the user, nil when it has none
+	Profile *Profile `json:"profile,omitempty" bson:"profile,omitempty"`
 }
 
 // FailedLoginInfos monitor the failed login of an user account.
@@ -53,6 +59,13 @@ type NewCustodialUserDetails struct {
 	Emails   []string
 }
 
+// ClaimUserDetails are given by the patient taking over a custodial account
+type ClaimUserDetails struct {
+	ClaimToken string
+	Email      string
+	Password   string
+}
+
 type UpdateUserDetails struct {
 	Username        *string
 	Emails          []string
@@ -61,9 +74,15 @@ type UpdateUserDetails struct {
 	Roles           []string
 	TermsAccepted   *string
 	EmailVerified   *bool
+	Profile         *ProfileDetails
 	nFields         int
 }
 
+const (
+	// ANONYMIZED_EMAIL_DOMAIN of the pseudonyms of the anonymized users, reserved so that nothing is delivered to them
+	ANONYMIZED_EMAIL_DOMAIN = "@anonymized.invalid"
+)
+
 var (
 	User_error_details_missing          = errors.New("User details are missing")
 	User_error_username_missing         = errors.New("Username is missing")
@@ -259,6 +278,24 @@ func ParseNewUserDetails(reader io.Reader) (*NewUserDetails, error) {
 	}
 }
 
+// fields returns the name of the fields given to create the user
+func (details *NewUserDetails) fields() []string {
+	fields := []string{}
+	if details.Username != nil {
+		fields = append(fields, "username")
+	}
+	if details.Emails != nil {
+		fields = append(fields, "emails")
+	}
+	if details.Password != nil {
+		fields = append(fields, "password")
+	}
+	if details.Roles != nil {
+		fields = append(fields, "roles")
+	}
+	return fields
+}
+
 func NewUser(details *NewUserDetails, salt string) (user *User, err error) {
 	if details == nil {
 		return nil, errors.New("New user details is nil")
@@ -266,7 +303,7 @@ func NewUser(details *NewUserDetails, salt string) (user *User, err error) {
 		return nil, err
 	}
 
-	user = &User{Username: *details.Username, Emails: details.Emails, Roles: details.Roles}
+	user = &User{Username: *details.Username, Emails: details.Emails, Roles: details.Roles, CreatedTime: time.Now().UTC().Format(time.RFC3339)}
 
 	if user.Id, err = generateUniqueHash([]string{*details.Username, *details.Password}, 10); err != nil {
 		return nil, errors.New("User: error generating id")
@@ -328,6 +365,18 @@ func (details *NewCustodialUserDetails) Validate() error {
 	return nil
 }
 
+// fields returns the name of the fields given to create the custodial user
+func (details *NewCustodialUserDetails) fields() []string {
+	fields := []string{}
+	if details.Username != nil {
+		fields = append(fields, "username")
+	}
+	if details.Emails != nil {
+		fields = append(fields, "emails")
+	}
+	return fields
+}
+
 func ParseNewCustodialUserDetails(reader io.Reader) (*NewCustodialUserDetails, error) {
 	details := &NewCustodialUserDetails{}
 	if err := details.ExtractFromJSON(reader); err != nil {
@@ -361,6 +410,60 @@ func NewCustodialUser(details *NewCustodialUserDetails, salt string) (user *User
 	return user, nil
 }
 
+func (details *ClaimUserDetails) ExtractFromJSON(reader io.Reader) error {
+	if reader == nil {
+		return User_error_details_missing
+	}
+
+	var decoded map[string]interface{}
+	if err := json.NewDecoder(reader).Decode(&decoded); err != nil {
+		return err
+	}
+
+	var (
+		claimToken *string
+		email      *string
+		password   *string
+		ok         bool
+	)
+
+	if claimToken, ok = ExtractString(decoded, "claimToken"); !ok || claimToken == nil {
+		return User_error_details_missing
+	}
+	if email, ok = ExtractString(decoded, "email"); !ok || email == nil {
+		return User_error_emails_missing
+	}
+	if password, ok = ExtractString(decoded, "password"); !ok || password == nil {
+		return User_error_password_missing
+	}
+
+	details.ClaimToken = *claimToken
+	details.Email = *email
+	details.Password = *password
+	return nil
+}
+
+func (details *ClaimUserDetails) Validate() error {
+	if !IsValidEmail(details.Email) {
+		return User_error_emails_invalid
+	}
+	if !IsValidPassword(details.Password) {
+		return User_error_password_invalid
+	}
+	return nil
+}
+
+func ParseClaimUserDetails(reader io.Reader) (*ClaimUserDetails, error) {
+	details := &ClaimUserDetails{}
+	if err := details.ExtractFromJSON(reader); err != nil {
+		return nil, err
+	} else if err := details.Validate(); err != nil {
+		return nil, err
+	} else {
+		return details, nil
+	}
+}
+
 func (details *UpdateUserDetails) ExtractFromJSON(reader io.Reader) error {
 	if reader == nil {
 		return User_error_details_missing
@@ -379,6 +482,7 @@ func (details *UpdateUserDetails) ExtractFromJSON(reader io.Reader) error {
 		roles           []string
 		termsAccepted   *string
 		emailVerified   *bool
+		profile         map[string]interface{}
 		ok              bool
 	)
 
@@ -408,6 +512,15 @@ func (details *UpdateUserDetails) ExtractFromJSON(reader io.Reader) error {
 	if emailVerified, ok = ExtractBool(decoded, "emailVerified"); !ok {
 		return User_error_email_verified_invalid
 	}
+	if profile, ok = ExtractStringMap(decoded, "profile"); !ok {
+		return Profile_error_invalid
+	}
+	if profile != nil {
+		details.Profile = &ProfileDetails{}
+		if err := details.Profile.ExtractFromMap(profile); err != nil {
+			return err
+		}
+	}
 
 	details.Username = username
 	details.Emails = emails
@@ -456,6 +569,12 @@ func (details *UpdateUserDetails) Validate() error {
 		}
 	}
 
+	if details.Profile != nil {
+		if err := details.Profile.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -464,7 +583,13 @@ func ParseUpdateUserDetails(reader io.Reader) (*UpdateUserDetails, error) {
 	if err := details.ExtractFromJSON(reader); err != nil {
 		return nil, err
 	}
+	details.countFields()
+	return details, nil
+}
 
+// countFields counts the fields to update, see Api.updateUser
+func (details *UpdateUserDetails) countFields() {
+	details.nFields = 0
 	if details.EmailVerified != nil {
 		details.nFields += 1
 	}
@@ -483,14 +608,72 @@ func ParseUpdateUserDetails(reader io.Reader) (*UpdateUserDetails, error) {
 	if details.Username != nil {
 		details.nFields += 1
 	}
+	if details.Profile != nil {
+		details.nFields += 1
+	}
+}
 
-	return details, nil
+// fields returns the name of the fields to update
+func (details *UpdateUserDetails) fields() []string {
+	fields := []string{}
+	if details.Username != nil {
+		fields = append(fields, "username")
+	}
+	if details.Emails != nil {
+		fields = append(fields, "emails")
+	}
+	if details.Password != nil {
+		fields = append(fields, "password")
+	}
+	if details.Roles != nil {
+		fields = append(fields, "roles")
+	}
+	if details.TermsAccepted != nil {
+		fields = append(fields, "termsAccepted")
+	}
+	if details.EmailVerified != nil {
+		fields = append(fields, "emailVerified")
+	}
+	if details.Profile != nil {
+		fields = append(fields, "profile")
+	}
+	return fields
 }
 
 func (u *User) IsDeleted() bool {
 	return u.DeletedTime != ""
 }
 
+// Anonymize scrubs the personal data of the user, for the right to erasure.
+// The username, emails and hashes are replaced by random pseudonyms (see IdHashPair), which cannot
+// be traced back to the user, the other personal fields are cleared. The id and the roles are kept,
+// so that the other services still find the user, and the deletion metadata is left to the caller.
+func (u *User) Anonymize(salt string) {
+	pseudonym := NewIdHashPair([]string{u.Id, salt}, nil)
+	u.Username = pseudonym.Id + ANONYMIZED_EMAIL_DOMAIN
+	u.Emails = []string{u.Username}
+	u.Hash = pseudonym.Hash
+	// no password hashes to this pseudonym, so the account can no longer be logged into (nor claimed)
+	u.PwHash = NewIdHashPair([]string{u.Id, salt, "password"}, nil).Hash
+	for name := range u.Private {
+		u.Private[name] = NewIdHashPair([]string{u.Id, salt, name}, nil)
+	}
+	u.TermsAccepted = ""
+	u.EmailVerified = false
+	u.FailedLogin = nil
+	u.Organizations = nil
+	u.Profile = nil
+	// the consents are kept as the evidence of what was accepted, without where from
+	for index := range u.Consents {
+		u.Consents[index].IP = ""
+	}
+}
+
+// IsCustodial returns true for the accounts created on behalf of a patient, which have no password yet
+func (u *User) IsCustodial() bool {
+	return u.PwHash == ""
+}
+
 func (u *User) Email() string {
 	return u.Username
 }
@@ -504,12 +687,12 @@ func (u *User) HasRole(role string) bool {
 	return false
 }
 
-// IsClinic returns true for hcp, caregiver or clinic user
+// IsClinic returns true for hcp or caregiver user, the clinics themselves are organizations
 //
 // Deprecated use HasRole() instead
 func (u *User) IsClinic() bool {
 	for _, userRole := range u.Roles {
-		if userRole == "hcp" || userRole == "caregiver" || userRole == "clinic" {
+		if userRole == "hcp" || userRole == "caregiver" {
 			return true
 		}
 	}
@@ -551,12 +734,18 @@ func (u *User) IsEmailVerified(secret string) bool {
 
 func (u *User) DeepClone() *User {
 	clonedUser := &User{
-		Id:            u.Id,
-		Username:      u.Username,
-		TermsAccepted: u.TermsAccepted,
-		EmailVerified: u.EmailVerified,
-		PwHash:        u.PwHash,
-		Hash:          u.Hash,
+		Id:             u.Id,
+		Username:       u.Username,
+		TermsAccepted:  u.TermsAccepted,
+		EmailVerified:  u.EmailVerified,
+		PwHash:         u.PwHash,
+		Hash:           u.Hash,
+		CreatedTime:    u.CreatedTime,
+		CreatedUserID:  u.CreatedUserID,
+		ModifiedTime:   u.ModifiedTime,
+		ModifiedUserID: u.ModifiedUserID,
+		DeletedTime:    u.DeletedTime,
+		DeletedUserID:  u.DeletedUserID,
 	}
 	if u.Emails != nil {
 		clonedUser.Emails = make([]string, len(u.Emails))
@@ -572,6 +761,18 @@ func (u *User) DeepClone() *User {
 			clonedUser.Private[k] = &IdHashPair{Id: v.Id, Hash: v.Hash}
 		}
 	}
+	if u.Organizations != nil {
+		clonedUser.Organizations = make([]OrganizationMember, len(u.Organizations))
+		copy(clonedUser.Organizations, u.Organizations)
+	}
+	if u.Profile != nil {
+		profile := *u.Profile
+		clonedUser.Profile = &profile
+	}
+	if u.Consents != nil {
+		clonedUser.Consents = make([]Consent, len(u.Consents))
+		copy(clonedUser.Consents, u.Consents)
+	}
 	if u.FailedLogin != nil {
 		clonedUser.FailedLogin = &FailedLoginInfos{
 			Count:                u.FailedLogin.Count,
@@ -591,8 +792,9 @@ func (u *User) CanPerformALogin(maxFailedLogin int) bool {
 		return true
 	}
 
-	now := time.Now().Format(time.RFC3339)
-	if u.FailedLogin.NextLoginAttemptTime < now {
+	// parsed, as the times stored before were not all in UTC
+	nextLoginAttemptTime, err := time.Parse(time.RFC3339, u.FailedLogin.NextLoginAttemptTime)
+	if err != nil || nextLoginAttemptTime.Before(time.Now()) {
 		return true
 	}
 